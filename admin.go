@@ -0,0 +1,381 @@
+// Copyright 2015-2018 trivago N.V.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net"
+	"net/http"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/trivago/gollum/core"
+)
+
+// adminControlTimeout is the time to wait for a plugin to accept a control
+// command sent by the admin API.
+var adminControlTimeout = 5 * time.Second
+
+// adminTokenEnv is the environment variable used to pass the admin API token
+// if the "-at" flag is not set. This keeps the token out of process listings.
+const adminTokenEnv = "GOLLUM_ADMIN_TOKEN"
+
+// adminPluginInfo is the JSON representation of a plugin returned by the
+// admin API.
+type adminPluginInfo struct {
+	ID         string   `json:"id"`
+	Type       string   `json:"type"`
+	Kind       string   `json:"kind"`
+	State      string   `json:"state,omitempty"`
+	Paused     bool     `json:"paused"`
+	Streams    []string `json:"streams,omitempty"`
+	Fallback   string   `json:"fallback,omitempty"`
+	Modulators []string `json:"modulators,omitempty"`
+	Producers  []string `json:"producers,omitempty"`
+	Queued     int      `json:"queued"`
+	Capacity   int      `json:"capacity"`
+}
+
+// adminStreamInfo is the JSON representation of a stream returned by the
+// admin API.
+type adminStreamInfo struct {
+	Name      string   `json:"name"`
+	Router    string   `json:"router"`
+	Type      string   `json:"type"`
+	Producers []string `json:"producers"`
+}
+
+type pluginWithStreams interface {
+	Streams() []core.MessageStreamID
+}
+
+type pluginWithFallback interface {
+	GetFallbackStreamID() core.MessageStreamID
+}
+
+type pluginWithModulators interface {
+	GetModulators() core.ModulatorArray
+}
+
+type pluginWithFilters interface {
+	GetFilters() core.FilterArray
+}
+
+type pluginWithPause interface {
+	IsPaused() bool
+}
+
+type pluginWithQueue interface {
+	GetNumQueued() int
+	GetQueueCapacity() int
+}
+
+type pluginWithProducers interface {
+	GetProducers() []core.Producer
+}
+
+type pluginWithControl interface {
+	Control() chan<- core.PluginControl
+}
+
+// startAdminService creates the admin HTTP API if requested.
+// The returned function should be deferred if not nil.
+func startAdminService(coordinator *Coordinator) func() {
+	if *flagAdminAddress == "" {
+		return nil
+	}
+
+	address, err := parseAdminAddress(*flagAdminAddress)
+	if err != nil {
+		logrus.WithError(err).Error("Failed to parse admin address")
+		return nil
+	}
+
+	token := *flagAdminToken
+	if token == "" {
+		token = os.Getenv(adminTokenEnv)
+	}
+	if token == "" {
+		logrus.Warning("Admin API is running without a token. Everybody who can reach it is able to pause plugins and reload the configuration")
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/plugins", handleAdminPlugins)
+	mux.HandleFunc("/plugins/", handleAdminPlugin)
+	mux.HandleFunc("/streams", handleAdminStreams)
+	mux.HandleFunc("/reload", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		coordinator.RequestReload()
+		w.WriteHeader(http.StatusAccepted)
+	})
+
+	srv := &http.Server{Addr: address, Handler: requireAdminToken(token, mux)}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logrus.WithError(err).Error("Failed to start admin http server")
+		}
+	}()
+
+	logrus.WithField("address", address).Info("Started admin service")
+
+	return func() {
+		if err := srv.Shutdown(context.Background()); err != nil {
+			logrus.WithError(err).Error("Failed to shutdown admin http server")
+		}
+	}
+}
+
+// parseAdminAddress works like parseAddress but binds to the loopback
+// interface if no host is given. Listening on all interfaces has to be
+// requested explicitly, e.g. by passing "0.0.0.0:8081".
+func parseAdminAddress(address string) (string, error) {
+	address, err := parseAddress(address)
+	if err != nil {
+		return address, err
+	}
+
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return address, err
+	}
+	if host == "" {
+		host = "127.0.0.1"
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// requireAdminToken wraps the given handler so that every request has to
+// pass "Authorization: Bearer <token>". If token is empty all requests are
+// passed on.
+func requireAdminToken(token string, handler http.Handler) http.Handler {
+	if token == "" {
+		return handler // ### return, no authentication ###
+	}
+
+	expected := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		given := []byte(r.Header.Get("Authorization"))
+		if subtle.ConstantTimeCompare(given, expected) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// handleAdminPlugins lists all registered plugins.
+//   GET /plugins
+func handleAdminPlugins(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	plugins := []adminPluginInfo{}
+	core.PluginRegistry.ForEachPlugin(func(ID string, plugin core.Plugin) {
+		plugins = append(plugins, getAdminPluginInfo(ID, plugin))
+	})
+	sort.Slice(plugins, func(i, j int) bool { return plugins[i].ID < plugins[j].ID })
+
+	writeAdminJSON(w, plugins)
+}
+
+// handleAdminPlugin returns a single plugin or sends a control command to it.
+//   GET  /plugins/<id>
+//   POST /plugins/<id>/pause
+//   POST /plugins/<id>/resume
+//   POST /plugins/<id>/roll
+func handleAdminPlugin(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/plugins/")
+	pluginID, command := path, ""
+	if idx := strings.LastIndex(path, "/"); idx >= 0 {
+		pluginID, command = path[:idx], path[idx+1:]
+	}
+
+	plugin := core.PluginRegistry.GetPlugin(pluginID)
+	if plugin == nil {
+		http.Error(w, "plugin not found", http.StatusNotFound)
+		return
+	}
+
+	if command == "" {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		writeAdminJSON(w, getAdminPluginInfo(pluginID, plugin))
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var control core.PluginControl
+	switch command {
+	case "pause":
+		control = core.PluginControlPause
+	case "resume":
+		control = core.PluginControlResume
+	case "roll":
+		control = core.PluginControlRoll
+	default:
+		http.Error(w, "unknown command", http.StatusNotFound)
+		return
+	}
+
+	controllable, isControllable := plugin.(pluginWithControl)
+	if !isControllable {
+		http.Error(w, "plugin does not support control commands", http.StatusBadRequest)
+		return
+	}
+
+	logrus.Infof("Admin API sends '%s' to '%s'", command, pluginID)
+	select {
+	case controllable.Control() <- control:
+		w.WriteHeader(http.StatusAccepted)
+	case <-time.After(adminControlTimeout):
+		logrus.Warningf("Plugin '%s' did not accept '%s' in time", pluginID, command)
+		http.Error(w, "plugin did not accept the command", http.StatusServiceUnavailable)
+	}
+}
+
+// handleAdminStreams lists all registered streams and their routers.
+//   GET /streams
+func handleAdminStreams(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	streams := []adminStreamInfo{}
+	core.StreamRegistry.ForEachStream(func(streamID core.MessageStreamID, router core.Router) {
+		info := adminStreamInfo{
+			Name:      streamID.GetName(),
+			Router:    router.GetID(),
+			Type:      getPluginTypeName(router),
+			Producers: []string{},
+		}
+		if withProducers, hasProducers := router.(pluginWithProducers); hasProducers {
+			for _, prod := range withProducers.GetProducers() {
+				info.Producers = append(info.Producers, prod.GetID())
+			}
+		}
+		streams = append(streams, info)
+	})
+	sort.Slice(streams, func(i, j int) bool { return streams[i].Name < streams[j].Name })
+
+	writeAdminJSON(w, streams)
+}
+
+func getAdminPluginInfo(ID string, plugin core.Plugin) adminPluginInfo {
+	info := adminPluginInfo{
+		ID:   ID,
+		Type: getPluginTypeName(plugin),
+	}
+
+	switch plugin.(type) {
+	case core.Consumer:
+		info.Kind = "consumer"
+	case core.Producer:
+		info.Kind = "producer"
+	case core.Router:
+		info.Kind = "router"
+		info.Streams = []string{plugin.(core.Router).GetStreamID().GetName()}
+	default:
+		info.Kind = "plugin"
+	}
+
+	if withState, hasState := plugin.(core.PluginWithState); hasState {
+		info.State = withState.GetState().String()
+	}
+	if withPause, hasPause := plugin.(pluginWithPause); hasPause {
+		info.Paused = withPause.IsPaused()
+	}
+	if withStreams, hasStreams := plugin.(pluginWithStreams); hasStreams {
+		for _, streamID := range withStreams.Streams() {
+			info.Streams = append(info.Streams, streamID.GetName())
+		}
+	}
+	if withFallback, hasFallback := plugin.(pluginWithFallback); hasFallback {
+		if streamID := withFallback.GetFallbackStreamID(); streamID != core.InvalidStreamID {
+			info.Fallback = streamID.GetName()
+		}
+	}
+	if withModulators, hasModulators := plugin.(pluginWithModulators); hasModulators {
+		for _, modulator := range withModulators.GetModulators() {
+			info.Modulators = append(info.Modulators, getModulatorTypeName(modulator))
+		}
+	}
+	if withFilters, hasFilters := plugin.(pluginWithFilters); hasFilters {
+		for _, filter := range withFilters.GetFilters() {
+			info.Modulators = append(info.Modulators, getPluginTypeName(filter))
+		}
+	}
+	if withProducers, hasProducers := plugin.(pluginWithProducers); hasProducers {
+		for _, prod := range withProducers.GetProducers() {
+			info.Producers = append(info.Producers, prod.GetID())
+		}
+	}
+	if withQueue, hasQueue := plugin.(pluginWithQueue); hasQueue {
+		info.Queued = withQueue.GetNumQueued()
+		info.Capacity = withQueue.GetQueueCapacity()
+	}
+
+	return info
+}
+
+// getPluginTypeName returns the registered type name of a plugin, e.g.
+// "consumer.Console".
+func getPluginTypeName(plugin interface{}) string {
+	pluginType := reflect.TypeOf(plugin)
+	for pluginType.Kind() == reflect.Ptr {
+		pluginType = pluginType.Elem()
+	}
+	return pluginType.String()
+}
+
+// getModulatorTypeName returns the type name of a modulator. Filters and
+// formatters wrapped as modulators are resolved to their own type name.
+func getModulatorTypeName(modulator core.Modulator) string {
+	switch wrapper := modulator.(type) {
+	case *core.FilterModulator:
+		return getPluginTypeName(wrapper.Filter)
+	case *core.FormatterModulator:
+		return getPluginTypeName(wrapper.Formatter)
+	default:
+		return getPluginTypeName(modulator)
+	}
+}
+
+func writeAdminJSON(w http.ResponseWriter, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(value); err != nil {
+		logrus.WithError(err).Error("Failed to write admin response")
+	}
+}
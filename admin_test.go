@@ -0,0 +1,119 @@
+// Copyright 2015-2018 trivago N.V.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/trivago/gollum/core"
+	"github.com/trivago/tgo/ttesting"
+)
+
+func TestAdminPlugin(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	conf := core.NewPluginConfig("adminTestOut", "producer.Console")
+	conf.Override("Streams", "adminTest")
+	_, err := core.NewPluginWithConfig(conf)
+	expect.NoError(err)
+	defer core.PluginRegistry.Unregister("adminTestOut")
+
+	request := func(method, path string) *httptest.ResponseRecorder {
+		resp := httptest.NewRecorder()
+		handleAdminPlugin(resp, httptest.NewRequest(method, path, nil))
+		return resp
+	}
+
+	resp := request("GET", "/plugins/adminTestOut")
+	expect.Equal(http.StatusOK, resp.Code)
+
+	info := adminPluginInfo{}
+	expect.NoError(json.Unmarshal(resp.Body.Bytes(), &info))
+	expect.Equal("adminTestOut", info.ID)
+	expect.Equal("producer.Console", info.Type)
+	expect.Equal("producer", info.Kind)
+	expect.Equal([]string{"adminTest"}, info.Streams)
+
+	expect.Equal(http.StatusNotFound, request("GET", "/plugins/unknown").Code)
+	expect.Equal(http.StatusNotFound, request("POST", "/plugins/adminTestOut/unknown").Code)
+	expect.Equal(http.StatusMethodNotAllowed, request("GET", "/plugins/adminTestOut/roll").Code)
+}
+
+func TestAdminPluginControlTimeout(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	conf := core.NewPluginConfig("adminTestBlocked", "producer.Console")
+	conf.Override("Streams", "adminTest")
+	_, err := core.NewPluginWithConfig(conf)
+	expect.NoError(err)
+	defer core.PluginRegistry.Unregister("adminTestBlocked")
+
+	prevTimeout := adminControlTimeout
+	adminControlTimeout = 50 * time.Millisecond
+	defer func() { adminControlTimeout = prevTimeout }()
+
+	roll := func() int {
+		resp := httptest.NewRecorder()
+		handleAdminPlugin(resp, httptest.NewRequest("POST", "/plugins/adminTestBlocked/roll", nil))
+		return resp.Code
+	}
+
+	// The control channel is buffered but nobody is reading from it
+	expect.Equal(http.StatusAccepted, roll())
+	expect.Equal(http.StatusServiceUnavailable, roll())
+}
+
+func TestAdminAddress(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	address, err := parseAdminAddress("8081")
+	expect.NoError(err)
+	expect.Equal("127.0.0.1:8081", address)
+
+	address, err = parseAdminAddress(":8081")
+	expect.NoError(err)
+	expect.Equal("127.0.0.1:8081", address)
+
+	address, err = parseAdminAddress("0.0.0.0:8081")
+	expect.NoError(err)
+	expect.Equal("0.0.0.0:8081", address)
+}
+
+func TestAdminToken(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	handler := requireAdminToken("secret", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	}))
+
+	request := func(auth string) int {
+		req := httptest.NewRequest("POST", "/reload", nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+		return resp.Code
+	}
+
+	expect.Equal(http.StatusUnauthorized, request(""))
+	expect.Equal(http.StatusUnauthorized, request("Bearer wrong"))
+	expect.Equal(http.StatusUnauthorized, request("secret"))
+	expect.Equal(http.StatusAccepted, request("Bearer secret"))
+}
@@ -18,6 +18,8 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"os"
+	"os/exec"
 	"path/filepath"
 	"regexp"
 	"strconv"
@@ -28,13 +30,15 @@ import (
 	"github.com/sirupsen/logrus"
 	"github.com/trivago/gollum/core"
 	"github.com/trivago/tgo"
+	"github.com/trivago/tgo/tcontainer"
 	"github.com/trivago/tgo/tio"
 )
 
 const (
-	fileBufferGrowSize = 1024
-	fileOffsetStart    = "oldest"
-	fileOffsetEnd      = "newest"
+	fileBufferGrowSize          = 1024
+	fileOffsetStart             = "oldest"
+	fileOffsetEnd               = "newest"
+	fileOffsetDBCleanupInterval = time.Minute
 )
 
 const (
@@ -47,6 +51,12 @@ const (
 	retryIfNotExist = false
 )
 
+const (
+	fileCompleteNone   = "none"
+	fileCompleteDelete = "delete"
+	fileCompleteMove   = "move"
+)
+
 // File consumer plugin
 //
 // The File consumer reads messages from a file, looking for a customizable
@@ -76,9 +86,25 @@ const (
 // file offsets are stored. The filename will the name and extension of the
 // source file plus the extension ".offset". If the consumer is restarted,
 // these offset files are used to continue reading from the previous position.
-// To disable this setting, set it to "".
+// To disable this setting, set it to "". This setting is ignored if
+// OffsetDB/Path is set.
+// By default this parameter is set to "".
+//
+// - OffsetDB/Path: The file to store the offsets of all files read by this
+// consumer in. Files are identified by device, inode and fingerprint (see
+// FingerprintSize), so offsets are kept when files are renamed. Updates are
+// written atomically. Use "gollum offsets" to inspect or reset the stored
+// offsets. To disable this setting, set it to "".
 // By default this parameter is set to "".
 //
+// - OffsetDB/FlushIntervalMs: The interval in milliseconds in which changed
+// offsets are written to OffsetDB/Path.
+// By default this parameter is set to "1000".
+//
+// - OffsetDB/RetentionHours: The number of hours to keep offsets of files
+// that do not exist anymore.
+// By default this parameter is set to "24".
+//
 // - Delimiter: This value defines the delimiter sequence to expect at the
 // end of each message in the file.
 // By default this parameter is set to "\n".
@@ -127,6 +153,77 @@ const (
 // filename. The path checked is the one before symlink evaluation.
 // By default this parameter is set to "".
 //
+// - CommitOnAck: When set to true, the offset file is only updated for messages
+// that have been acknowledged by all producers, i.e. messages that have been
+// delivered, passed to a fallback or discarded on purpose. A message that
+// could not be delivered stops the offset from advancing, so it will be read
+// again after a restart. This setting requires OffsetFilePath or
+// OffsetDB/Path to be set.
+// By default this parameter is set to false.
+//
+// - Multiline/Start: A regular expression matching the first line of a
+// multiline record. If set, all lines up to the next matching line are sent
+// as one message, joined by Delimiter. Cannot be used with Multiline/Continue.
+// By default this parameter is set to "".
+//
+// - Multiline/Continue: A regular expression matching lines that belong to
+// the previous line, e.g. "^\s" for indented stack trace lines. All other
+// lines start a new record. Cannot be used with Multiline/Start.
+// By default this parameter is set to "".
+//
+// - Multiline/MaxLines: The maximum number of lines of a multiline record.
+// Longer records are split. Set to 0 to disable this limit.
+// By default this parameter is set to "500".
+//
+// - Multiline/MaxSizeKB: The maximum size of a multiline record in KB.
+// Larger records are split. Set to 0 to disable this limit.
+// By default this parameter is set to "1024".
+//
+// - Multiline/TimeoutMs: The number of milliseconds to wait for further lines
+// before a record is sent. This is required to send the last record of a file
+// that is not followed by a new record yet.
+// By default this parameter is set to "1000".
+//
+// When using multiline records, the offset file only advances past records
+// that have been sent, so incomplete records are read again after a restart.
+//
+// - Decompress: When set to true, files ending in ".gz", ".bz2" or ".zst" are
+// decompressed while reading. Compressed files are always read from the start
+// and are considered complete when reaching the end of the file.
+// Zstandard files require the zstd command line tool to be installed. If it
+// is not installed and Files may match files ending in ".zst", the plugin
+// fails to start.
+// By default this parameter is set to true.
+//
+// - FingerprintSize: The number of bytes at the start of a file used to
+// identify it in addition to its device and inode. This allows detecting
+// files that have been renamed, moved or compressed by a log rotation, so
+// that they are continued where they have been left instead of being read
+// again. Fingerprints of compressed files are generated from the decompressed
+// data. Files smaller than this size are identified by inode only until
+// they have grown large enough. Set to 0 to identify files by inode only.
+// By default this parameter is set to "1024".
+//
+// - Completed/Action: Defines what to do with a file that has been read
+// completely. Set to "none" to keep the file, "delete" to remove it or
+// "move" to move it to Completed/MoveTo. If CommitOnAck is set, files are only
+// completed after all messages have been acknowledged.
+// By default this parameter is set to "none".
+//
+// - Completed/MoveTo: The directory to move completed files to if
+// Completed/Action is set to "move". The directory is created if it does not
+// exist.
+// By default this parameter is set to "".
+//
+// - Completed/IdleSec: The number of seconds an uncompressed file needs to be
+// unchanged after reaching its end to be considered complete. This setting is
+// only used if Completed/Action is not set to "none".
+// By default this parameter is set to "60".
+//
+// Files are tracked by their identity instead of their name. Files that appear
+// after the consumer has been started are read from the start, so lines
+// written between a rotation and the next directory scan are not lost.
+//
 // Examples
 //
 // This example will read all the `.log` files `/var/log/` into one stream and
@@ -142,11 +239,46 @@ const (
 //    ObserveMode: poll
 //    PollingDelay: 100
 //
+// This example sends Java stack traces as one message. Each record starts with
+// a timestamp, all other lines belong to the previous record.
+//
+//  JavaLogIn:
+//    Type: consumer.File
+//    File: /var/log/app/app.log
+//    OffsetFilePath: /var/lib/gollum
+//    Multiline:
+//      Start: '^\d{4}-\d{2}-\d{2} '
+//      TimeoutMs: 500
+//
+// This example reads rotated and compressed log files from a spool directory
+// and deletes them after they have been read.
+//
+//  SpoolIn:
+//    Type: consumer.File
+//    File: /var/spool/logs/*.log*
+//    DefaultOffset: oldest
+//    Completed:
+//      Action: delete
+//      IdleSec: 300
+//
+// This example reads all files of a directory and stores their offsets in a
+// single offset database.
+//
+//  AppLogsIn:
+//    Type: consumer.File
+//    File: /var/log/app/*.log
+//    CommitOnAck: true
+//    OffsetDB:
+//      Path: /var/lib/gollum/applogs.offsets
+//
 type File struct {
 	core.SimpleConsumer `gollumdoc:"embed_type"`
 
 	fileName         string        `config:"Files" default:"/var/log/*.log"`
 	offsetFilePath   string        `config:"OffsetFilePath"`
+	offsetDBPath     string        `config:"OffsetDB/Path"`
+	offsetDBFlush    time.Duration `config:"OffsetDB/FlushIntervalMs" default:"1000" metric:"ms"`
+	offsetDBKeep     time.Duration `config:"OffsetDB/RetentionHours" default:"24" metric:"h"`
 	pollingDelay     time.Duration `config:"PollingDelayMs" default:"100" metric:"ms"`
 	retryDelay       time.Duration `config:"RetryDelaySec" default:"3" metric:"s"`
 	dirScanInterval  time.Duration `config:"DirScanIntervalSec" default:"10" metric:"s"`
@@ -156,10 +288,23 @@ type File struct {
 	defaultOffset    string        `config:"DefaultOffset" default:"newest"`
 	blackListString  string        `config:"BlackList"`
 	whiteListString  string        `config:"WhiteList"`
+	commitOnAck      bool          `config:"CommitOnAck" default:"false"`
+	multilineStart   string        `config:"Multiline/Start"`
+	multilineCont    string        `config:"Multiline/Continue"`
+	multilineLines   int           `config:"Multiline/MaxLines" default:"500"`
+	multilineSize    int           `config:"Multiline/MaxSizeKB" default:"1024" metric:"kb"`
+	multilineTimeout time.Duration `config:"Multiline/TimeoutMs" default:"1000" metric:"ms"`
+	decompress       bool          `config:"Decompress" default:"true"`
+	fingerprintSize  int           `config:"FingerprintSize" default:"1024"`
+	completeAction   string        `config:"Completed/Action" default:"none"`
+	completeMoveTo   string        `config:"Completed/MoveTo"`
+	completeIdle     time.Duration `config:"Completed/IdleSec" default:"60" metric:"s"`
 
 	observedFiles *sync.Map
+	registry      *fileRegistry
 	done          chan struct{}
 	isBlackListed func(string) bool
+	multiline     *fileMultiline
 }
 
 func init() {
@@ -170,6 +315,7 @@ func init() {
 func (cons *File) Configure(conf core.PluginConfigReader) {
 	cons.done = make(chan struct{})
 	cons.observedFiles = new(sync.Map)
+	cons.registry = newFileRegistry()
 
 	// TODO: support manual roll again
 	//cons.SetRollCallback(cons.onRoll)
@@ -184,6 +330,112 @@ func (cons *File) Configure(conf core.PluginConfigReader) {
 	}
 
 	cons.configureBlacklist(conf)
+	cons.configureDecompress(conf)
+	cons.configureMultiline(conf)
+	cons.configureCompleted(conf)
+	cons.configureOffsetDB(conf)
+}
+
+func (cons *File) configureOffsetDB(conf core.PluginConfigReader) {
+	if cons.offsetDBPath == "" {
+		return // ### return, offset database disabled ###
+	}
+
+	db, err := core.OpenOffsetDB(cons.offsetDBPath)
+	if err != nil {
+		conf.Errors.Pushf("Failed to open offset database %s: %s", cons.offsetDBPath, err.Error())
+		return // ### return, database not readable ###
+	}
+
+	cons.offsetFilePath = ""
+	cons.registry.attach(db)
+}
+
+// persistsOffsets returns true if offsets are stored in an offset database
+// or offset files.
+func (cons *File) persistsOffsets() bool {
+	return cons.offsetFilePath != "" || cons.registry.isPersistent()
+}
+
+// flushOffsets writes changed offsets to the offset database and removes
+// entries of deleted files until the consumer is stopped.
+func (cons *File) flushOffsets() {
+	defer cons.WorkerDone()
+
+	flush := time.NewTicker(cons.offsetDBFlush)
+	defer flush.Stop()
+	lastCleanup := time.Time{}
+
+	for {
+		select {
+		case <-flush.C:
+		case <-cons.done:
+			if err := cons.registry.flush(); err != nil {
+				cons.Logger.WithError(err).Error("Failed to write offset database")
+			}
+			return // ### return, consumer stopped ###
+		}
+
+		if time.Since(lastCleanup) > fileOffsetDBCleanupInterval {
+			if removed := cons.registry.cleanup(cons.offsetDBKeep); len(removed) > 0 {
+				cons.Logger.Debugf("Removed %d offsets of deleted files", len(removed))
+			}
+			lastCleanup = time.Now()
+		}
+
+		if err := cons.registry.flush(); err != nil {
+			cons.Logger.WithError(err).Error("Failed to write offset database")
+		}
+	}
+}
+
+func (cons *File) configureCompleted(conf core.PluginConfigReader) {
+	cons.completeAction = strings.ToLower(cons.completeAction)
+
+	switch cons.completeAction {
+	case fileCompleteNone:
+		cons.completeIdle = 0
+
+	case fileCompleteDelete:
+
+	case fileCompleteMove:
+		if cons.completeMoveTo == "" {
+			conf.Errors.Pushf("Completed/MoveTo must be set when moving completed files")
+			return // ### return, invalid config ###
+		}
+		conf.Errors.Push(os.MkdirAll(cons.completeMoveTo, 0755))
+
+	default:
+		conf.Errors.Pushf("Unknown value '%s' for Completed/Action", cons.completeAction)
+	}
+}
+
+func (cons *File) configureMultiline(conf core.PluginConfigReader) {
+	var err error
+
+	switch {
+	case cons.multilineStart == "" && cons.multilineCont == "":
+		return // ### return, multiline disabled ###
+
+	case cons.multilineStart != "" && cons.multilineCont != "":
+		conf.Errors.Pushf("Multiline/Start and Multiline/Continue cannot be used together")
+		return // ### return, invalid config ###
+	}
+
+	// This is a template copied for every observed file
+	cons.multiline = &fileMultiline{
+		delimiter: []byte(cons.delimiter),
+		maxLines:  cons.multilineLines,
+		maxSize:   cons.multilineSize,
+		timeout:   cons.multilineTimeout,
+	}
+
+	if cons.multilineStart != "" {
+		cons.multiline.start, err = regexp.Compile(cons.multilineStart)
+	} else {
+		cons.multiline.continued, err = regexp.Compile(cons.multilineCont)
+	}
+	conf.Errors.Push(err)
 }
 
 func (cons *File) configureBlacklist(conf core.PluginConfigReader) {
@@ -220,7 +472,30 @@ func (cons *File) configureBlacklist(conf core.PluginConfigReader) {
 	}
 }
 
-func (cons *File) newObservedFile(name string, stopIfNotExist bool) *observableFile {
+// configureDecompress makes sure zstd compressed files can be read. If the
+// zstd command line tool is not installed, configuration fails if the files
+// observed may end in ".zst".
+func (cons *File) configureDecompress(conf core.PluginConfigReader) {
+	if !cons.decompress {
+		return // ### return, decompression disabled ###
+	}
+	if _, err := exec.LookPath("zstd"); err == nil {
+		return // ### return, zstd installed ###
+	}
+
+	if !strings.ContainsAny(cons.fileName, "*?") {
+		if isZstdFile(cons.fileName) {
+			conf.Errors.Pushf("Reading %s requires the zstd command line tool to be installed", cons.fileName)
+		}
+		return // ### return, single file ###
+	}
+
+	if globMatchesZstd(cons.fileName) {
+		conf.Errors.Pushf("%s may match files ending in %s, which requires the zstd command line tool to be installed. Install zstd, narrow the pattern or set Decompress to false", cons.fileName, zstdExtension)
+	}
+}
+
+func (cons *File) newObservedFile(name string, stopIfNotExist bool, fromStart bool) *observableFile {
 	logger := cons.Logger.WithFields(logrus.Fields{
 		"File": name,
 	})
@@ -242,6 +517,7 @@ func (cons *File) newObservedFile(name string, stopIfNotExist bool) *observableF
 			}
 		}
 
+	case fromStart:
 	case defaultOffset == fileOffsetEnd:
 		cursor.whence = io.SeekEnd
 
@@ -251,63 +527,148 @@ func (cons *File) newObservedFile(name string, stopIfNotExist bool) *observableF
 
 	logger.Info("Starting file scraper")
 
+	var decompress decompressFunc
+	if cons.decompress {
+		decompress = getDecompressor(name)
+	}
+
 	return &observableFile{
-		fileName:       name,
-		offsetFileName: offsetFileName,
-		cursor:         cursor,
-		stopIfNotExist: stopIfNotExist,
-		retryDelay:     cons.retryDelay,
-		pollDelay:      cons.pollingDelay,
-		buffer:         tio.NewBufferedReader(fileBufferGrowSize, tio.BufferedReaderFlagDelimiter, 0, cons.delimiter),
-		log:            logger,
+		fileName:        name,
+		offsetFileName:  offsetFileName,
+		cursor:          cursor,
+		stopIfNotExist:  stopIfNotExist,
+		retryDelay:      cons.retryDelay,
+		pollDelay:       cons.pollingDelay,
+		buffer:          tio.NewBufferedReader(fileBufferGrowSize, tio.BufferedReaderFlagDelimiter, 0, cons.delimiter),
+		delimiter:       cons.delimiter,
+		registry:        cons.registry,
+		fingerprintSize: cons.fingerprintSize,
+		decompress:      decompress,
+		completeIdle:    cons.completeIdle,
+		onComplete:      cons.onFileComplete,
+		log:             logger,
 	}
 }
 
-func (cons *File) observeFile(name string, stopIfNotExist bool) {
+// onFileComplete runs the configured action on a file that has been read
+// completely.
+func (cons *File) onFileComplete(fileName string, id fileIdentity) {
+	logger := cons.Logger.WithField("File", fileName)
+
+	switch cons.completeAction {
+	case fileCompleteDelete:
+		if err := os.Remove(fileName); err != nil {
+			logger.WithError(err).Error("Failed to delete completed file")
+			return // ### return, file still exists ###
+		}
+		cons.registry.forgetInode(id)
+		logger.Info("Deleted completed file")
+
+	case fileCompleteMove:
+		target := filepath.Join(cons.completeMoveTo, filepath.Base(fileName))
+		if err := os.Rename(fileName, target); err != nil {
+			logger.WithError(err).Errorf("Failed to move completed file to %s", target)
+			return // ### return, file not moved ###
+		}
+		logger.Infof("Moved completed file to %s", target)
+	}
+}
+
+func (cons *File) observeFile(name string, stopIfNotExist bool, fromStart bool) {
 	defer cons.WorkerDone()
 
-	file := cons.newObservedFile(name, stopIfNotExist)
+	file := cons.newObservedFile(name, stopIfNotExist, fromStart)
 	defer file.close()
 
 	cons.observedFiles.Store(name, file)
 	defer cons.observedFiles.Delete(name)
 
-	enqueue := cons.Enqueue
+	enqueue := func(data []byte) {
+		cons.EnqueueWithMetadata(data, cons.newMetadata(name))
+	}
 
-	if cons.hasToSetMetadata {
-		dirName, fileName := filepath.Split(name)
+	switch {
+	case cons.persistsOffsets() && cons.commitOnAck:
 		enqueue = func(data []byte) {
-			metaData := core.NewMetadata()
-			metaData.Set("file", fileName)
-			metaData.Set("dir", dirName)
-			cons.EnqueueWithMetadata(data, metaData)
+			onAck := file.trackOffset(len(data) + len(cons.delimiter))
+			cons.EnqueueWithAcknowledge(data, cons.newMetadata(name), onAck)
 		}
-	}
 
-	if cons.offsetFilePath != "" {
+	case cons.persistsOffsets():
 		enqueue = func(data []byte) {
-			cons.Enqueue(data)
+			cons.EnqueueWithMetadata(data, cons.newMetadata(name))
 			file.storeOffset()
 		}
 	}
 
+	if cons.multiline != nil {
+		enqueue = cons.newMultilineEnqueue(file, name)
+	}
+
 	switch cons.observeMode {
 	case observeModeWatch:
 		file.observeFSNotify(enqueue, cons.done)
 	default:
 		file.observePoll(enqueue, cons.done)
 	}
+
+	// Incomplete records are read again after a restart if offsets are stored
+	if !cons.persistsOffsets() {
+		file.flushMultiline()
+	}
+}
+
+// newMultilineEnqueue attaches a multiline assembler to the given file and
+// returns the function passing lines to it. Offsets are advanced by the size
+// of complete records only.
+func (cons *File) newMultilineEnqueue(file *observableFile, name string) func([]byte) {
+	multiline := *cons.multiline
+	multiline.emit = func(data []byte, numBytes int) {
+		cons.EnqueueWithMetadata(data, cons.newMetadata(name))
+	}
+
+	switch {
+	case cons.persistsOffsets() && cons.commitOnAck:
+		multiline.emit = func(data []byte, numBytes int) {
+			onAck := file.trackOffset(numBytes)
+			cons.EnqueueWithAcknowledge(data, cons.newMetadata(name), onAck)
+		}
+
+	case cons.persistsOffsets():
+		multiline.emit = func(data []byte, numBytes int) {
+			cons.EnqueueWithMetadata(data, cons.newMetadata(name))
+			file.advanceOffset(numBytes)
+		}
+	}
+
+	file.multiline = &multiline
+	return multiline.add
+}
+
+func (cons *File) newMetadata(name string) tcontainer.MarshalMap {
+	if !cons.hasToSetMetadata {
+		return nil
+	}
+
+	dirName, fileName := filepath.Split(name)
+	metaData := core.NewMetadata()
+	metaData.Set("file", fileName)
+	metaData.Set("dir", dirName)
+	return metaData
 }
 
 func (cons *File) observeFiles() {
 	defer cons.WorkerDone()
 
 	if !strings.ContainsAny(cons.fileName, "*?") {
-		cons.observeFile(cons.fileName, retryIfNotExist) // blocking
+		cons.AddWorker()
+		cons.observeFile(cons.fileName, retryIfNotExist, false) // blocking
 		return
 	}
 
 	// Glob needs to be re-evaluated to find new files.
+	// Files found after the first scan are new and read from the start.
+	fromStart := false
 	for {
 		fileNames, err := filepath.Glob(cons.fileName)
 		if err != nil {
@@ -321,11 +682,17 @@ func (cons *File) observeFiles() {
 				continue
 			}
 
-			if _, ok := cons.observedFiles.Load(fileNames[i]); !ok {
-				cons.AddWorker()
-				go cons.observeFile(fileNames[i], stopIfNotExist)
+			if _, ok := cons.observedFiles.Load(fileNames[i]); ok {
+				continue
 			}
+			if cons.registry.isKnown(fileNames[i], cons.fingerprintSize) {
+				continue // read under a different name or already complete
+			}
+
+			cons.AddWorker()
+			go cons.observeFile(fileNames[i], stopIfNotExist, fromStart)
 		}
+		fromStart = true
 
 		select {
 		case <-time.After(cons.dirScanInterval):
@@ -339,6 +706,10 @@ func (cons *File) observeFiles() {
 func (cons *File) Consume(workers *sync.WaitGroup) {
 	go tgo.WithRecoverShutdown(func() {
 		cons.AddMainWorker(workers)
+		if cons.registry.isPersistent() {
+			cons.AddWorker()
+			go tgo.WithRecoverShutdown(cons.flushOffsets)
+		}
 		cons.observeFiles()
 	})
 
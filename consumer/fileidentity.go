@@ -0,0 +1,495 @@
+// Copyright 2015-2018 trivago N.V.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consumer
+
+import (
+	"compress/bzip2"
+	"compress/gzip"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/trivago/gollum/core"
+)
+
+// decompressFunc wraps a compressed stream into a reader returning the
+// decompressed data.
+type decompressFunc func(io.Reader) (io.ReadCloser, error)
+
+// zstdExtension is the extension of files decoded by the zstd command line
+// tool, which needs to be installed.
+const zstdExtension = ".zst"
+
+// fileDecompressors maps file extensions to decompressors.
+var fileDecompressors = map[string]decompressFunc{
+	".gz": func(reader io.Reader) (io.ReadCloser, error) {
+		return gzip.NewReader(reader)
+	},
+	".bz2": func(reader io.Reader) (io.ReadCloser, error) {
+		return ioutil.NopCloser(bzip2.NewReader(reader)), nil
+	},
+	zstdExtension: newZstdReader,
+}
+
+// getDecompressor returns the decompressor to use for the given file or nil
+// if the file is not compressed.
+func getDecompressor(fileName string) decompressFunc {
+	return fileDecompressors[strings.ToLower(filepath.Ext(fileName))]
+}
+
+// isZstdFile returns true if the given file has to be decoded by the zstd
+// command line tool.
+func isZstdFile(fileName string) bool {
+	return strings.ToLower(filepath.Ext(fileName)) == zstdExtension
+}
+
+// globMatchesZstd returns true if the given glob pattern can match files that
+// have to be decoded by the zstd command line tool.
+func globMatchesZstd(pattern string) bool {
+	tokens := []string{}
+	base := filepath.Base(pattern)
+	for i := 0; i < len(base); i++ {
+		switch {
+		case base[i] == '[':
+			if end := strings.IndexByte(base[i+1:], ']'); end >= 0 {
+				tokens = append(tokens, base[i:i+end+2])
+				i += end + 1
+				continue
+			}
+		case base[i] == '\\' && filepath.Separator != '\\' && i+1 < len(base):
+			i++
+		}
+		tokens = append(tokens, base[i:i+1])
+	}
+	return globTokensMatchSuffix(tokens, zstdExtension)
+}
+
+// globTokensMatchSuffix returns true if the given glob tokens can match a
+// string ending in suffix. Letters are compared case insensitive.
+func globTokensMatchSuffix(tokens []string, suffix string) bool {
+	if len(suffix) == 0 {
+		return true // ### return, suffix matched ###
+	}
+	if len(tokens) == 0 {
+		return false // ### return, pattern too short ###
+	}
+
+	token := tokens[len(tokens)-1]
+	char := suffix[len(suffix)-1:]
+
+	switch {
+	case token == "*":
+		return true // ### return, matches any suffix ###
+	case token == "?":
+	case len(token) > 1 && token[0] == '[':
+		lower, _ := filepath.Match(token, char)
+		upper, _ := filepath.Match(token, strings.ToUpper(char))
+		if !lower && !upper {
+			return false
+		}
+	case !strings.EqualFold(token, char):
+		return false
+	}
+
+	return globTokensMatchSuffix(tokens[:len(tokens)-1], suffix[:len(suffix)-1])
+}
+
+type zstdReader struct {
+	io.ReadCloser
+	cmd *exec.Cmd
+}
+
+func newZstdReader(reader io.Reader) (io.ReadCloser, error) {
+	cmd := exec.Command("zstd", "-dcq")
+	cmd.Stdin = reader
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &zstdReader{stdout, cmd}, nil
+}
+
+// Close stops the zstd process. Errors caused by closing the process before
+// all data has been read are ignored.
+func (zstd *zstdReader) Close() error {
+	zstd.ReadCloser.Close()
+	zstd.cmd.Process.Kill()
+	zstd.cmd.Wait()
+	return nil
+}
+
+type fileInode struct {
+	device uint64
+	inode  uint64
+}
+
+// fileStamp is used to detect if a file has been changed.
+type fileStamp struct {
+	size    int64
+	modTime int64
+}
+
+func newFileStamp(info os.FileInfo) fileStamp {
+	return fileStamp{
+		size:    info.Size(),
+		modTime: info.ModTime().UnixNano(),
+	}
+}
+
+// fileIdentity identifies a file independent of its name. The fingerprint is
+// a hash of the first bytes of the (decompressed) content. It is empty if the
+// file is too small to be fingerprinted yet.
+type fileIdentity struct {
+	fileInode
+	fingerprint string
+}
+
+// newFileIdentity returns the identity of the given file. If decompress is
+// set, the fingerprint is generated from the decompressed content so that
+// compressed copies of a file share the fingerprint of the original.
+func newFileIdentity(handle *os.File, decompress decompressFunc, fingerprintSize int) fileIdentity {
+	id := fileIdentity{}
+	if info, err := handle.Stat(); err == nil {
+		id.device, id.inode = getDeviceAndInode(info)
+	}
+	if fingerprintSize <= 0 {
+		return id // ### return, fingerprinting disabled ###
+	}
+
+	var head io.Reader = io.NewSectionReader(handle, 0, 1<<62)
+	if decompress != nil {
+		reader, err := decompress(head)
+		if err != nil {
+			return id // ### return, not readable ###
+		}
+		defer reader.Close()
+		head = reader
+	}
+
+	buffer := make([]byte, fingerprintSize)
+	if _, err := io.ReadFull(head, buffer); err == nil {
+		id.fingerprint = fmt.Sprintf("%x", sha256.Sum256(buffer))
+	}
+	return id
+}
+
+// parseFileIdentity converts a key created by fileIdentity.key back into an
+// identity.
+func parseFileIdentity(key string) (fileIdentity, bool) {
+	parts := strings.SplitN(key, ":", 3)
+	if len(parts) != 3 {
+		return fileIdentity{}, false
+	}
+
+	id := fileIdentity{fingerprint: parts[2]}
+	var errDevice, errInode error
+	id.device, errDevice = strconv.ParseUint(parts[0], 10, 64)
+	id.inode, errInode = strconv.ParseUint(parts[1], 10, 64)
+	return id, errDevice == nil && errInode == nil
+}
+
+// key returns a string representation of this identity in the format
+// "device:inode:fingerprint".
+func (id fileIdentity) key() string {
+	return fmt.Sprintf("%d:%d:%s", id.device, id.inode, id.fingerprint)
+}
+
+// isValid returns true if the identity can be used to find a file again.
+func (id fileIdentity) isValid() bool {
+	return id.hasInode() || id.fingerprint != ""
+}
+
+func (id fileIdentity) hasInode() bool {
+	return id.inode != 0
+}
+
+// matches returns true if both identities can belong to the same file.
+// Missing fingerprints are treated as matching.
+func (id fileIdentity) matches(other fileIdentity) bool {
+	return id.fingerprint == "" || other.fingerprint == "" || id.fingerprint == other.fingerprint
+}
+
+// fileState stores how far a file has been read.
+type fileState struct {
+	identity fileIdentity
+	offset   int64
+	done     bool
+}
+
+// fileRegistry keeps track of the files read by a consumer, so that a file is
+// not read twice after being renamed, moved or compressed.
+// If an offset database is attached, committed offsets are persisted so
+// that files are continued after a restart.
+type fileRegistry struct {
+	guard         sync.Mutex
+	byInode       map[fileInode]*fileState
+	byFingerprint map[string]*fileState
+	active        map[fileInode]string
+	verified      map[fileInode]fileStamp
+	db            *core.OffsetDB
+	dbKeys        map[fileInode]string
+}
+
+func newFileRegistry() *fileRegistry {
+	return &fileRegistry{
+		byInode:       make(map[fileInode]*fileState),
+		byFingerprint: make(map[string]*fileState),
+		active:        make(map[fileInode]string),
+		verified:      make(map[fileInode]fileStamp),
+		dbKeys:        make(map[fileInode]string),
+	}
+}
+
+// attach loads all entries from the given offset database and persists
+// committed offsets to it.
+func (reg *fileRegistry) attach(db *core.OffsetDB) {
+	for _, key := range db.Keys() {
+		id, valid := parseFileIdentity(key)
+		if !valid {
+			continue // ### continue, not a file entry ###
+		}
+		entry, _ := db.Get(key)
+		reg.store(id, entry.Offset, entry.Done)
+		if id.hasInode() {
+			reg.dbKeys[id.fileInode] = key
+		}
+	}
+	reg.db = db
+}
+
+// isPersistent returns true if an offset database is attached.
+func (reg *fileRegistry) isPersistent() bool {
+	return reg.db != nil
+}
+
+// commit persists the offset of the given file.
+func (reg *fileRegistry) commit(id fileIdentity, path string, offset int64, done bool) {
+	if reg.db == nil || !id.isValid() {
+		return // ### return, nothing to persist ###
+	}
+
+	key := id.key()
+	if id.hasInode() {
+		// The fingerprint is set once a file is large enough, so the key of
+		// a file can change.
+		reg.guard.Lock()
+		oldKey, known := reg.dbKeys[id.fileInode]
+		reg.dbKeys[id.fileInode] = key
+		reg.guard.Unlock()
+
+		if known && oldKey != key {
+			reg.db.Delete(oldKey)
+		}
+	}
+
+	reg.db.Set(key, core.OffsetEntry{
+		Path:   path,
+		Offset: offset,
+		Done:   done,
+	})
+}
+
+// flush writes the offset database to disk.
+func (reg *fileRegistry) flush() error {
+	if reg.db == nil {
+		return nil
+	}
+	return reg.db.Flush()
+}
+
+// cleanup removes all entries of files that do not exist anymore and have
+// not been updated for the given duration.
+func (reg *fileRegistry) cleanup(retention time.Duration) []string {
+	if reg.db == nil {
+		return nil
+	}
+
+	removed := reg.db.Cleanup(func(key string, entry core.OffsetEntry) bool {
+		if time.Since(entry.Updated) < retention {
+			return false
+		}
+		id, valid := parseFileIdentity(key)
+		return !valid || !fileExists(entry.Path, id)
+	})
+
+	reg.guard.Lock()
+	defer reg.guard.Unlock()
+
+	for _, key := range removed {
+		id, _ := parseFileIdentity(key)
+		if reg.dbKeys[id.fileInode] == key {
+			delete(reg.dbKeys, id.fileInode)
+			delete(reg.byInode, id.fileInode)
+			delete(reg.verified, id.fileInode)
+		}
+		if state, known := reg.byFingerprint[id.fingerprint]; known && state.identity == id {
+			delete(reg.byFingerprint, id.fingerprint)
+		}
+	}
+	return removed
+}
+
+// fileExists returns true if the given path still points to the file with
+// the given identity.
+func fileExists(path string, id fileIdentity) bool {
+	info, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	if !id.hasInode() {
+		return true
+	}
+
+	device, inode := getDeviceAndInode(info)
+	return device == id.device && inode == id.inode
+}
+
+// lookup returns the last known state of the given file. Files are matched by
+// inode first, then by fingerprint.
+func (reg *fileRegistry) lookup(id fileIdentity) (fileState, bool) {
+	reg.guard.Lock()
+	defer reg.guard.Unlock()
+
+	if id.hasInode() {
+		if state, known := reg.byInode[id.fileInode]; known && state.identity.matches(id) {
+			return *state, true
+		}
+	}
+	if id.fingerprint != "" {
+		if state, known := reg.byFingerprint[id.fingerprint]; known {
+			return *state, true
+		}
+	}
+	return fileState{}, false
+}
+
+// store sets the state of the given file.
+func (reg *fileRegistry) store(id fileIdentity, offset int64, done bool) {
+	reg.guard.Lock()
+	defer reg.guard.Unlock()
+
+	state := &fileState{
+		identity: id,
+		offset:   offset,
+		done:     done,
+	}
+
+	if id.hasInode() {
+		reg.byInode[id.fileInode] = state
+		delete(reg.verified, id.fileInode)
+	}
+	if id.fingerprint != "" {
+		reg.byFingerprint[id.fingerprint] = state
+	}
+}
+
+// forgetInode removes the inode of a deleted file, as the inode may be
+// reused by a new file.
+func (reg *fileRegistry) forgetInode(id fileIdentity) {
+	reg.guard.Lock()
+	defer reg.guard.Unlock()
+	delete(reg.byInode, id.fileInode)
+	delete(reg.verified, id.fileInode)
+}
+
+// acquire marks the given file as being read from the given file name.
+// False is returned if the file is already read under a different name.
+func (reg *fileRegistry) acquire(id fileIdentity, name string) bool {
+	if !id.hasInode() {
+		return true // ### return, cannot be tracked ###
+	}
+
+	reg.guard.Lock()
+	defer reg.guard.Unlock()
+
+	if activeName, isActive := reg.active[id.fileInode]; isActive && activeName != name {
+		return false
+	}
+	reg.active[id.fileInode] = name
+	return true
+}
+
+// release marks the given file as not being read anymore.
+func (reg *fileRegistry) release(id fileIdentity, name string) {
+	reg.guard.Lock()
+	defer reg.guard.Unlock()
+
+	if reg.active[id.fileInode] == name {
+		delete(reg.active, id.fileInode)
+	}
+}
+
+// isKnown returns true if the given file name points to a file that is
+// currently being read or has been read completely. The fingerprint of a
+// completed file is only generated again if the file has been changed since
+// it has been verified the last time.
+func (reg *fileRegistry) isKnown(name string, fingerprintSize int) bool {
+	info, err := os.Stat(name)
+	if err != nil {
+		return false
+	}
+
+	inode := fileInode{}
+	if inode.device, inode.inode = getDeviceAndInode(info); inode.inode == 0 {
+		return false // ### return, cannot be tracked ###
+	}
+	stamp := newFileStamp(info)
+
+	reg.guard.Lock()
+	_, isActive := reg.active[inode]
+	state, known := reg.byInode[inode]
+	verifiedStamp, isVerified := reg.verified[inode]
+	reg.guard.Unlock()
+
+	switch {
+	case isActive:
+		return true
+	case !known || !state.done:
+		return false
+	case state.identity.fingerprint == "":
+		return true
+	case isVerified && verifiedStamp == stamp:
+		return true
+	}
+
+	// Make sure the inode has not been reused by a different file
+	handle, err := os.Open(name)
+	if err != nil {
+		return false
+	}
+	defer handle.Close()
+	if !state.identity.matches(newFileIdentity(handle, getDecompressor(name), fingerprintSize)) {
+		return false
+	}
+
+	reg.guard.Lock()
+	defer reg.guard.Unlock()
+	if reg.byInode[inode] == state {
+		reg.verified[inode] = stamp
+	}
+	return true
+}
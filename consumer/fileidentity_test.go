@@ -0,0 +1,289 @@
+// Copyright 2015-2018 trivago N.V.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consumer
+
+import (
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/trivago/gollum/core"
+	"github.com/trivago/tgo/tio"
+	"github.com/trivago/tgo/ttesting"
+)
+
+func writeGzipFile(fileName string, data string) error {
+	file, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := gzip.NewWriter(file)
+	if _, err := writer.Write([]byte(data)); err != nil {
+		return err
+	}
+	return writer.Close()
+}
+
+func TestFileIdentityCompressed(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+	dir, err := ioutil.TempDir("", "gollum-file")
+	expect.NoError(err)
+	defer os.RemoveAll(dir)
+
+	content := strings.Repeat("0123456789\n", 10)
+	plainName := filepath.Join(dir, "app.log.1")
+	gzipName := filepath.Join(dir, "app.log.1.gz")
+	expect.NoError(ioutil.WriteFile(plainName, []byte(content), 0644))
+	expect.NoError(writeGzipFile(gzipName, content))
+
+	plain, err := os.Open(plainName)
+	expect.NoError(err)
+	defer plain.Close()
+	compressed, err := os.Open(gzipName)
+	expect.NoError(err)
+	defer compressed.Close()
+
+	plainID := newFileIdentity(plain, getDecompressor(plainName), 32)
+	gzipID := newFileIdentity(compressed, getDecompressor(gzipName), 32)
+	expect.Neq("", plainID.fingerprint)
+	expect.Equal(plainID.fingerprint, gzipID.fingerprint)
+	expect.Neq(plainID.inode, gzipID.inode)
+
+	// Files smaller than the fingerprint size have no fingerprint
+	expect.Equal("", newFileIdentity(plain, nil, len(content)+1).fingerprint)
+}
+
+func TestFileRegistry(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+	registry := newFileRegistry()
+
+	original := fileIdentity{fileInode{1, 10}, "abc"}
+	renamed := fileIdentity{fileInode{1, 10}, ""}
+	compressed := fileIdentity{fileInode{1, 11}, "abc"}
+	reused := fileIdentity{fileInode{1, 10}, "def"}
+
+	registry.store(original, 42, false)
+
+	state, known := registry.lookup(renamed)
+	expect.True(known)
+	expect.Equal(int64(42), state.offset)
+
+	state, known = registry.lookup(compressed)
+	expect.True(known)
+	expect.Equal(int64(42), state.offset)
+
+	_, known = registry.lookup(reused)
+	expect.False(known)
+
+	expect.True(registry.acquire(original, "app.log"))
+	expect.False(registry.acquire(renamed, "app.log.1"))
+	registry.release(original, "app.log")
+	expect.True(registry.acquire(renamed, "app.log.1"))
+
+	registry.forgetInode(original)
+	_, known = registry.lookup(renamed)
+	expect.False(known)
+}
+
+func TestFileRegistryVerified(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+	dir, err := ioutil.TempDir("", "gollum-file")
+	expect.NoError(err)
+	defer os.RemoveAll(dir)
+
+	// Count how often the fingerprint is generated
+	fingerprints := 0
+	fileDecompressors[".counted"] = func(reader io.Reader) (io.ReadCloser, error) {
+		fingerprints++
+		return ioutil.NopCloser(reader), nil
+	}
+	defer delete(fileDecompressors, ".counted")
+
+	fileName := filepath.Join(dir, "app.counted")
+	expect.NoError(ioutil.WriteFile(fileName, []byte("line1\nline2\n"), 0644))
+	handle, err := os.Open(fileName)
+	expect.NoError(err)
+	defer handle.Close()
+
+	registry := newFileRegistry()
+	registry.store(newFileIdentity(handle, getDecompressor(fileName), 4), 12, true)
+	expect.Equal(1, fingerprints)
+
+	expect.True(registry.isKnown(fileName, 4))
+	expect.True(registry.isKnown(fileName, 4))
+	expect.Equal(2, fingerprints)
+
+	// Changed files are verified again
+	expect.NoError(ioutil.WriteFile(fileName, []byte("line1\nline2\nline3\n"), 0644))
+	expect.True(registry.isKnown(fileName, 4))
+	expect.Equal(3, fingerprints)
+
+	expect.NoError(ioutil.WriteFile(fileName, []byte("other\nline2\n"), 0644))
+	expect.False(registry.isKnown(fileName, 4))
+	expect.Equal(4, fingerprints)
+}
+
+func TestFileRequireZstd(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+	dir, err := ioutil.TempDir("", "gollum-file")
+	expect.NoError(err)
+	defer os.RemoveAll(dir)
+
+	path := os.Getenv("PATH")
+	defer os.Setenv("PATH", path)
+	os.Setenv("PATH", dir)
+
+	conf := core.NewPluginConfig("", "consumer.File")
+	conf.Override("Files", filepath.Join(dir, "*.log"))
+	_, err = core.NewPluginWithConfig(conf)
+	expect.NoError(err)
+
+	conf.Override("Files", filepath.Join(dir, "*.log*"))
+	_, err = core.NewPluginWithConfig(conf)
+	expect.NotNil(err)
+
+	conf.Override("Files", filepath.Join(dir, "app.log.zst"))
+	_, err = core.NewPluginWithConfig(conf)
+	expect.NotNil(err)
+
+	conf.Override("Decompress", false)
+	_, err = core.NewPluginWithConfig(conf)
+	expect.NoError(err)
+}
+
+func TestFileGlobMatchesZstd(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	expect.True(globMatchesZstd("/var/log/*"))
+	expect.True(globMatchesZstd("/var/log/app.log.*"))
+	expect.True(globMatchesZstd("/var/log/*.zst"))
+	expect.True(globMatchesZstd("/var/log/*.ZST"))
+	expect.True(globMatchesZstd("/var/log/*.zs?"))
+	expect.True(globMatchesZstd("/var/log/*.[sz]st"))
+	expect.True(globMatchesZstd("/var/log/*.log.[0-9].zst"))
+
+	expect.False(globMatchesZstd("/var/log/*.log"))
+	expect.False(globMatchesZstd("/var/log/*.gz"))
+	expect.False(globMatchesZstd("/var/log/*.[gb]z"))
+	expect.False(globMatchesZstd("/var/log/*/app.log"))
+	expect.False(globMatchesZstd("/var/log/zst"))
+}
+
+func TestObservableFileCompressed(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+	dir, err := ioutil.TempDir("", "gollum-file")
+	expect.NoError(err)
+	defer os.RemoveAll(dir)
+
+	fileName := filepath.Join(dir, "app.log.gz")
+	expect.NoError(writeGzipFile(fileName, "line1\nline2\nline3\nline4"))
+
+	completed := ""
+	registry := newFileRegistry()
+	file := &observableFile{
+		fileName:        fileName,
+		stopIfNotExist:  true,
+		buffer:          tio.NewBufferedReader(fileBufferGrowSize, tio.BufferedReaderFlagDelimiter, 0, "\n"),
+		delimiter:       "\n",
+		registry:        registry,
+		fingerprintSize: 8,
+		decompress:      getDecompressor(fileName),
+		retryDelay:      time.Millisecond,
+		onComplete:      func(name string, id fileIdentity) { completed = name },
+		log:             logrus.StandardLogger(),
+	}
+
+	// Start after the first line, e.g. after the uncompressed file has been
+	// read partially
+	handle, err := os.Open(fileName)
+	expect.NoError(err)
+	registry.store(newFileIdentity(handle, file.decompress, 8), 6, false)
+	handle.Close()
+
+	lines := []string{}
+	enqueue := func(data []byte) {
+		lines = append(lines, string(data))
+	}
+
+	file.scrape(fileName, enqueue, func() {})
+	expect.Equal([]string{"line2", "line3", "line4"}, lines)
+	expect.True(file.finished)
+	expect.Equal(fileName, completed)
+	expect.Nil(file.handle)
+
+	// Completed files are not read again
+	file.scrape(fileName, enqueue, func() {})
+	expect.Equal(3, len(lines))
+	expect.True(registry.isKnown(fileName, 8))
+}
+
+func TestFileRegistryOffsetDB(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+	dir, err := ioutil.TempDir("", "gollum-file")
+	expect.NoError(err)
+	defer os.RemoveAll(dir)
+
+	fileName := filepath.Join(dir, "app.log")
+	expect.NoError(ioutil.WriteFile(fileName, []byte("line1\nline2\n"), 0644))
+	handle, err := os.Open(fileName)
+	expect.NoError(err)
+	defer handle.Close()
+
+	dbPath := filepath.Join(dir, "offsets.db")
+	db, err := core.OpenOffsetDB(dbPath)
+	expect.NoError(err)
+
+	registry := newFileRegistry()
+	registry.attach(db)
+
+	// The key changes once the file is large enough to be fingerprinted
+	small := newFileIdentity(handle, nil, 100)
+	registry.commit(small, fileName, 6, false)
+	id := newFileIdentity(handle, nil, 4)
+	registry.commit(id, fileName, 12, false)
+	registry.commit(fileIdentity{fileInode{1, 2}, ""}, filepath.Join(dir, "deleted.log"), 5, true)
+	expect.NoError(registry.flush())
+
+	parsed, valid := parseFileIdentity(id.key())
+	expect.True(valid)
+	expect.Equal(id, parsed)
+
+	db, err = core.OpenOffsetDB(dbPath)
+	expect.NoError(err)
+	expect.Equal(2, len(db.Keys()))
+
+	registry = newFileRegistry()
+	registry.attach(db)
+	state, known := registry.lookup(id)
+	expect.True(known)
+	expect.Equal(int64(12), state.offset)
+
+	// Entries of deleted files are removed after the retention time
+	expect.Equal(0, len(registry.cleanup(time.Hour)))
+	expect.Equal([]string{"1:2:"}, registry.cleanup(0))
+
+	_, known = registry.lookup(fileIdentity{fileInode{1, 2}, ""})
+	expect.False(known)
+	_, known = registry.lookup(id)
+	expect.True(known)
+}
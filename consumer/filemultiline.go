@@ -0,0 +1,96 @@
+// Copyright 2015-2018 trivago N.V.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consumer
+
+import (
+	"regexp"
+	"time"
+)
+
+// fileMultiline assembles lines read by consumer.File into multiline records.
+// A record ends when a line starting a new record is read, when the maximum
+// number of lines or bytes is reached or when no new line arrived for a given
+// time.
+type fileMultiline struct {
+	start     *regexp.Regexp
+	continued *regexp.Regexp
+	delimiter []byte
+	maxLines  int
+	maxSize   int
+	timeout   time.Duration
+	emit      func(data []byte, numBytes int)
+
+	record   []byte
+	numLines int
+	numBytes int
+	lastLine time.Time
+}
+
+// isStart returns true if the given line starts a new record.
+func (ml *fileMultiline) isStart(line []byte) bool {
+	if ml.start != nil {
+		return ml.start.Match(line)
+	}
+	return !ml.continued.Match(line)
+}
+
+// add adds a line to the current record. Records that are complete are
+// passed to emit, along with the number of bytes they used in the file.
+func (ml *fileMultiline) add(line []byte) {
+	if ml.numLines > 0 {
+		switch {
+		case ml.isStart(line):
+			ml.flush()
+		case ml.maxLines > 0 && ml.numLines >= ml.maxLines:
+			ml.flush()
+		case ml.maxSize > 0 && len(ml.record)+len(ml.delimiter)+len(line) > ml.maxSize:
+			ml.flush()
+		}
+	}
+
+	if ml.numLines > 0 {
+		ml.record = append(ml.record, ml.delimiter...)
+	}
+	ml.record = append(ml.record, line...)
+	ml.numLines++
+	ml.numBytes += len(line) + len(ml.delimiter)
+	ml.lastLine = time.Now()
+}
+
+// flush emits the current record, even if it might not be complete.
+func (ml *fileMultiline) flush() {
+	if ml.numLines == 0 {
+		return // ### return, nothing to flush ###
+	}
+
+	record, numBytes := ml.record, ml.numBytes
+	ml.reset()
+	ml.emit(record, numBytes)
+}
+
+// flushIfExpired emits the current record if no line has been added for
+// longer than the configured timeout.
+func (ml *fileMultiline) flushIfExpired() {
+	if ml.numLines > 0 && time.Since(ml.lastLine) >= ml.timeout {
+		ml.flush()
+	}
+}
+
+// reset drops the current record.
+func (ml *fileMultiline) reset() {
+	ml.record = nil
+	ml.numLines = 0
+	ml.numBytes = 0
+}
@@ -0,0 +1,89 @@
+// Copyright 2015-2018 trivago N.V.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consumer
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/trivago/tgo/ttesting"
+)
+
+type multilineRecords struct {
+	records  []string
+	numBytes []int
+}
+
+func (mr *multilineRecords) emit(data []byte, numBytes int) {
+	mr.records = append(mr.records, string(data))
+	mr.numBytes = append(mr.numBytes, numBytes)
+}
+
+func TestFileMultilineStart(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+	result := &multilineRecords{}
+	ml := fileMultiline{
+		start:     regexp.MustCompile(`^\d{4}-`),
+		delimiter: []byte("\n"),
+		maxLines:  3,
+		timeout:   time.Hour,
+		emit:      result.emit,
+	}
+
+	for _, line := range []string{"trailing", "2018-01 error", "  at a", "  at b", "2018-02 info", "2018-03 error", "  at c", "  at d", "  at e"} {
+		ml.add([]byte(line))
+	}
+
+	expect.Equal(4, len(result.records))
+	expect.Equal("trailing", result.records[0])
+	expect.Equal("2018-01 error\n  at a\n  at b", result.records[1])
+	expect.Equal(len("2018-01 error\n  at a\n  at b\n"), result.numBytes[1])
+	expect.Equal("2018-02 info", result.records[2])
+	expect.Equal("2018-03 error\n  at c\n  at d", result.records[3])
+
+	// The last record is only sent after the timeout
+	ml.flushIfExpired()
+	expect.Equal(4, len(result.records))
+
+	ml.timeout = 0
+	ml.flushIfExpired()
+	expect.Equal(5, len(result.records))
+	expect.Equal("  at e", result.records[4])
+	expect.Equal(len("  at e\n"), result.numBytes[4])
+}
+
+func TestFileMultilineContinue(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+	result := &multilineRecords{}
+	ml := fileMultiline{
+		continued: regexp.MustCompile(`^\s`),
+		delimiter: []byte("\r\n"),
+		maxSize:   20,
+		timeout:   time.Hour,
+		emit:      result.emit,
+	}
+
+	for _, line := range []string{"Traceback", " line 1", " line 2", "ValueError", " line 3"} {
+		ml.add([]byte(line))
+	}
+	ml.flush()
+
+	expect.Equal(3, len(result.records))
+	expect.Equal("Traceback\r\n line 1", result.records[0])
+	expect.Equal(" line 2", result.records[1])
+	expect.Equal("ValueError\r\n line 3", result.records[2])
+	expect.Equal(len("ValueError\r\n line 3\r\n"), result.numBytes[2])
+}
@@ -20,26 +20,46 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/fsnotify/fsnotify"
 	"github.com/sirupsen/logrus"
+	"github.com/trivago/gollum/core"
 	"github.com/trivago/tgo/tio"
 	"github.com/trivago/tgo/tsync"
 )
 
 type observableFile struct {
 	handle         *os.File
+	reader         io.Reader
+	decompressed   io.ReadCloser
 	fileName       string
 	offsetFileName string
 	cursor         fileCursor
 	buffer         *tio.BufferedReader
+	delimiter      string
 	stopIfNotExist bool
+	position       int64
+	finished       bool
+
+	registry        *fileRegistry
+	identity        fileIdentity
+	fingerprintSize int
+	decompress      decompressFunc
+	completeIdle    time.Duration
+	onComplete      func(fileName string, id fileIdentity)
 
 	lastStatCheck time.Time
 	retryDelay    time.Duration
 	pollDelay     time.Duration
 	log           logrus.FieldLogger
+
+	ackGuard   sync.Mutex
+	tracker    *core.OffsetTracker
+	readOffset int64
+	committed  int64
+	multiline  *fileMultiline
 }
 
 type fileCursor struct {
@@ -48,10 +68,141 @@ type fileCursor struct {
 }
 
 func (fs *observableFile) close() error {
-	if fs.handle != nil {
-		return fs.handle.Close()
+	if fs.handle == nil {
+		return nil
+	}
+
+	fs.registry.release(fs.identity, fs.fileName)
+	if fs.decompressed != nil {
+		fs.decompressed.Close()
+		fs.decompressed = nil
+	}
+	err := fs.handle.Close()
+	fs.handle = nil
+	return err
+}
+
+// open opens the given file and moves to the offset to continue reading from.
+// Files that have been read before, e.g. under a different name, continue
+// at the last known offset. False is returned if the file cannot be read or
+// does not need to be read.
+func (fs *observableFile) open(fileName string) bool {
+	handle, err := os.OpenFile(fileName, os.O_RDONLY, 0444)
+	if err != nil {
+		fs.log.Warning("Failed to open file")
+		return false
+	}
+
+	fs.finished = false
+	fs.handle = handle
+	fs.reader = handle
+	fs.identity = newFileIdentity(handle, fs.decompress, fs.fingerprintSize)
+
+	if !fs.registry.acquire(fs.identity, fs.fileName) {
+		fs.log.Debug("File is read under a different name")
+		fs.handle = nil
+		handle.Close()
+		fs.finished = true
+		return false
+	}
+
+	cursor := fs.cursor
+	if state, known := fs.registry.lookup(fs.identity); known {
+		if state.done {
+			fs.log.Debug("File has already been read")
+			fs.registry.store(fs.identity, state.offset, true)
+			fs.close()
+			fs.finished = true
+			return false
+		}
+		cursor = fileCursor{whence: io.SeekStart, offset: state.offset}
+	}
+
+	if fs.decompress != nil {
+		return fs.openDecompressed(cursor)
+	}
+
+	// Start over if a file has been truncated
+	if info, err := handle.Stat(); err == nil && cursor.whence == io.SeekStart && cursor.offset > info.Size() {
+		fs.log.Warning("File is smaller than the stored offset, reading from start")
+		cursor.offset = 0
+	}
+
+	if fs.cursor.offset, err = handle.Seek(cursor.offset, cursor.whence); err != nil {
+		fs.log.WithError(err).Warning("Failed to seek to given offset")
+	}
+	fs.cursor.whence = io.SeekStart
+	fs.position = fs.cursor.offset
+	fs.resetTracker(fs.cursor.offset)
+	return true
+}
+
+// openDecompressed prepares reading a compressed file. Offsets are given in
+// decompressed bytes, so the data up to the offset has to be skipped.
+// Compressed files are not expected to change, so they are read from the
+// start if no offset is known.
+func (fs *observableFile) openDecompressed(cursor fileCursor) bool {
+	reader, err := fs.decompress(fs.handle)
+	if err != nil {
+		fs.log.WithError(err).Error("Failed to decompress file")
+		fs.close()
+		return false
+	}
+	fs.decompressed = reader
+	fs.reader = reader
+
+	offset := int64(0)
+	if cursor.whence == io.SeekStart {
+		offset = cursor.offset
+	}
+	if fs.position, err = io.CopyN(ioutil.Discard, reader, offset); err != nil && err != io.EOF {
+		fs.log.WithError(err).Warning("Failed to seek to given offset")
+	}
+
+	fs.cursor = fileCursor{whence: io.SeekStart, offset: fs.position}
+	fs.resetTracker(fs.position)
+	return true
+}
+
+// isIdle returns true if no more data is expected to be written to the
+// file. Compressed files are idle after reaching EOF, other files need to
+// be unchanged for the configured duration.
+func (fs *observableFile) isIdle() bool {
+	switch {
+	case fs.decompress != nil:
+		return true
+	case fs.completeIdle <= 0:
+		return false
+	}
+
+	info, err := fs.handle.Stat()
+	return err == nil && time.Since(info.ModTime()) >= fs.completeIdle
+}
+
+// hasPendingAcks returns true if messages read from this file have not been
+// acknowledged yet.
+func (fs *observableFile) hasPendingAcks() bool {
+	fs.ackGuard.Lock()
+	tracker := fs.tracker
+	fs.ackGuard.Unlock()
+	return tracker != nil && tracker.GetNumPending() > 0
+}
+
+// complete stores the file as being read and runs the completion action.
+func (fs *observableFile) complete(fileName string) {
+	fs.log.Info("File has been read completely")
+	fs.registry.store(fs.identity, fs.position, true)
+	fs.registry.commit(fs.identity, fs.fileName, fs.position, true)
+
+	identity := fs.identity
+	fs.close()
+	fs.buffer.Reset(0)
+	fs.resetTracker(-1)
+	fs.finished = true
+
+	if fs.onComplete != nil {
+		fs.onComplete(fileName, identity)
 	}
-	return nil
 }
 
 func (fs *observableFile) getActualFilename() string {
@@ -86,11 +237,106 @@ func (fs *observableFile) hasRotated(currentName string) bool {
 }
 
 func (fs *observableFile) storeOffset() {
-	fs.cursor.offset, _ = fs.handle.Seek(0, io.SeekCurrent)
+	if fs.decompressed != nil {
+		fs.cursor.offset = fs.position
+	} else {
+		fs.cursor.offset, _ = fs.handle.Seek(0, io.SeekCurrent)
+	}
 	fs.saveOffset(fs.cursor.offset)
 }
 
+// resetTracker starts tracking acknowledged offsets at the given offset.
+// Acknowledgements of messages read before this call are ignored.
+// A nil tracker is set if offset is negative.
+func (fs *observableFile) resetTracker(offset int64) {
+	fs.ackGuard.Lock()
+	defer fs.ackGuard.Unlock()
+
+	if offset < 0 {
+		fs.tracker = nil
+		return
+	}
+
+	fs.tracker = core.NewOffsetTracker(offset)
+	fs.readOffset = offset
+	fs.committed = offset
+}
+
+// trackOffset advances the read offset by the given number of bytes and
+// returns a callback that stores the new offset once the message ending at
+// this offset and all messages read before have been delivered.
+func (fs *observableFile) trackOffset(numBytes int) core.AcknowledgeFunc {
+	fs.ackGuard.Lock()
+	fs.readOffset += int64(numBytes)
+	offset := fs.readOffset
+	tracker := fs.tracker
+	fs.ackGuard.Unlock()
+	identity := fs.identity
+
+	if tracker == nil {
+		return func(bool) {} // ### return, file not open ###
+	}
+	tracker.Track(offset)
+
+	return func(success bool) {
+		if !success {
+			fs.log.Warningf("Message ending at offset %d was not delivered", offset)
+		}
+
+		commit, advanced := tracker.Done(offset, success)
+		if !advanced {
+			return // ### return, nothing to commit ###
+		}
+
+		fs.ackGuard.Lock()
+		defer fs.ackGuard.Unlock()
+
+		// Ignore messages from before a rotation or reopen
+		if fs.tracker == tracker && commit > fs.committed {
+			fs.committed = commit
+			fs.saveOffsetOf(identity, commit)
+		}
+	}
+}
+
+// advanceOffset moves the read offset by the given number of bytes and stores
+// the new offset.
+func (fs *observableFile) advanceOffset(numBytes int) {
+	fs.ackGuard.Lock()
+	fs.readOffset += int64(numBytes)
+	offset := fs.readOffset
+	fs.ackGuard.Unlock()
+
+	fs.cursor.offset = offset
+	fs.saveOffset(offset)
+}
+
+// flushMultiline sends a pending multiline record.
+func (fs *observableFile) flushMultiline() {
+	if fs.multiline != nil {
+		fs.multiline.flush()
+	}
+}
+
+// flushMultilineIfExpired sends a pending multiline record if no new line
+// has been read for the configured timeout.
+func (fs *observableFile) flushMultilineIfExpired() {
+	if fs.multiline != nil {
+		fs.multiline.flushIfExpired()
+	}
+}
+
 func (fs *observableFile) saveOffset(offset int64) {
+	fs.saveOffsetOf(fs.identity, offset)
+}
+
+// saveOffsetOf stores the offset of the given file in the offset database
+// if one is used, or in the offset file otherwise.
+func (fs *observableFile) saveOffsetOf(id fileIdentity, offset int64) {
+	if fs.registry.isPersistent() {
+		fs.registry.commit(id, fs.fileName, offset, false)
+		return
+	}
 	if len(fs.offsetFileName) == 0 {
 		return
 	}
@@ -102,43 +348,75 @@ func (fs *observableFile) saveOffset(offset int64) {
 
 func (fs *observableFile) scrape(fileName string, enqueue func([]byte), onRotate func()) {
 	// Try to open the current file
-	if fs.handle == nil {
-		handle, err := os.OpenFile(fileName, os.O_RDONLY, 0444)
-		if err != nil {
-			fs.log.Warning("Failed to open file")
+	if fs.handle == nil && !fs.open(fileName) {
+		if !fs.finished || !fs.stopIfNotExist {
 			time.Sleep(fs.retryDelay)
-			return // wait between retries
 		}
-
-		if fs.cursor.offset, err = handle.Seek(fs.cursor.offset, fs.cursor.whence); err != nil {
-			fs.log.WithError(err).Warning("Failed to seek to given offset")
-		}
-		fs.handle = handle
+		return // wait between retries
 	}
 
 	// Try to scrape the file
-	err := fs.buffer.ReadAll(fs.handle, enqueue)
+	read := func(data []byte) {
+		fs.position += int64(len(data) + len(fs.delimiter))
+		enqueue(data)
+	}
+	err := fs.buffer.ReadAll(fs.reader, read)
+
+	// Compressed files do not change, so they can be read until EOF
+	for err == nil && fs.decompressed != nil {
+		err = fs.buffer.ReadAll(fs.reader, read)
+	}
+
+	if fs.identity.fingerprint == "" && fs.position >= int64(fs.fingerprintSize) {
+		fs.identity = newFileIdentity(fs.handle, fs.decompress, fs.fingerprintSize)
+	}
 
 	switch err {
 	case nil:
+		fs.registry.store(fs.identity, fs.position, false)
+
 	case io.EOF:
 		if fs.hasRotated(fileName) {
 			fs.log.Info("File rotated")
-			fs.handle.Close()
-			fs.handle = nil
+			fs.flushMultiline()
+			fs.registry.store(fs.identity, fs.position, false)
+			fs.close()
 			fs.buffer.Reset(0)
 
 			fs.cursor.whence = io.SeekStart
 			fs.cursor.offset = 0
-			fs.saveOffset(fs.cursor.offset)
-			fs.log.Info("Offset file reseted")
+			fs.resetTracker(-1)
+			if !fs.registry.isPersistent() {
+				fs.saveOffset(fs.cursor.offset)
+				fs.log.Info("Offset file reseted")
+			}
 			onRotate()
+			return
+		}
+
+		if fs.isIdle() {
+			// The last message is not followed by a delimiter
+			if fs.buffer.HasIncompleteData() {
+				data := fs.buffer.ResetGetIncomplete()
+				fs.position += int64(len(data))
+				enqueue(data)
+			}
+			fs.flushMultiline()
+			if !fs.hasPendingAcks() {
+				fs.complete(fileName)
+				onRotate()
+				return
+			}
 		}
+		fs.registry.store(fs.identity, fs.position, false)
+
 	default:
 		fs.log.WithError(err).Error("Failed to read file")
-		fs.handle.Close()
-		fs.handle = nil
+		fs.flushMultiline()
+		fs.registry.store(fs.identity, fs.position, false)
+		fs.close()
 		fs.buffer.Reset(0)
+		fs.resetTracker(-1)
 	}
 }
 
@@ -171,6 +449,10 @@ func (fs *observableFile) observePoll(enqueue func([]byte), done <-chan struct{}
 		}
 
 		fs.scrape(actualFileName, enqueue, spin.Reset)
+		if fs.finished && fs.stopIfNotExist {
+			return // file has been read
+		}
+		fs.flushMultilineIfExpired()
 		spin.Yield()
 	}
 }
@@ -184,6 +466,22 @@ func (fs *observableFile) observeFSNotify(enqueue func([]byte), done <-chan stru
 	defer notify.Close()
 	logger := fs.log
 
+	// Pending multiline records need to be flushed without file events
+	var flushTick <-chan time.Time
+	if fs.multiline != nil {
+		ticker := time.NewTicker(fs.multiline.timeout/2 + time.Millisecond)
+		defer ticker.Stop()
+		flushTick = ticker.C
+	}
+
+	// Files need to be checked for being complete without file events
+	var idleTick <-chan time.Time
+	if fs.completeIdle > 0 {
+		ticker := time.NewTicker(fs.completeIdle / 2)
+		defer ticker.Stop()
+		idleTick = ticker.C
+	}
+
 	for {
 		select {
 		default:
@@ -191,6 +489,10 @@ func (fs *observableFile) observeFSNotify(enqueue func([]byte), done <-chan stru
 			return // exit requested
 		}
 
+		if fs.finished && fs.stopIfNotExist {
+			return // file has been read
+		}
+
 		// Try to attach file to fsnotify
 		actualFileName := fs.getActualFilename()
 		fs.log = logger.WithFields(logrus.Fields{
@@ -212,12 +514,22 @@ func (fs *observableFile) observeFSNotify(enqueue func([]byte), done <-chan stru
 			continue // retry
 		}
 
+		// Read data written before the watcher was attached
 		rotated := false
+		fs.scrape(actualFileName, enqueue, func() {
+			rotated = true
+			notify.Remove(actualFileName)
+		})
+		if fs.finished && !rotated {
+			notify.Remove(actualFileName)
+			continue // file does not need to be read
+		}
+
 		for !rotated {
 			select {
 			case event := <-notify.Events:
 				containsMoveEvent := event.Op&fsnotify.Rename != 0 || event.Op&fsnotify.Remove != 0
-				containsWriteEvent := event.Op&fsnotify.Write == 0
+				containsWriteEvent := event.Op&fsnotify.Write != 0
 
 				switch {
 				case containsMoveEvent:
@@ -234,6 +546,15 @@ func (fs *observableFile) observeFSNotify(enqueue func([]byte), done <-chan stru
 					})
 				}
 
+			case <-flushTick:
+				fs.flushMultilineIfExpired()
+
+			case <-idleTick:
+				fs.scrape(actualFileName, enqueue, func() {
+					rotated = true
+					notify.Remove(actualFileName)
+				})
+
 			case err := <-notify.Errors:
 				fs.log.WithError(err).Error("Fsnotify reported an error")
 				rotated = true
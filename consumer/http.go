@@ -16,23 +16,49 @@ package consumer
 
 import (
 	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
 	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"os"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
 	auth "github.com/abbot/go-http-auth"
 	"github.com/trivago/gollum/core"
+	"github.com/trivago/tgo/tcontainer"
 	"github.com/trivago/tgo/tnet"
 )
 
+const (
+	httpSplitNone  = "none"
+	httpSplitLines = "lines"
+	httpSplitJSON  = "json"
+)
+
 // HTTP consumer plugin
 //
 // This consumer opens up an HTTP 1.1 server and processes the contents of any
 // incoming HTTP request.
 //
+// Metadata
+//
+// - traceparent: Contains the W3C trace context header of the request (if
+// present).
+//
 // Parameters
 //
 // - Address: Defines the TCP port and optional IP address to listen on.
@@ -48,18 +74,71 @@ import (
 // Gollum message. If false, relays only the HTTP request body and ignores
 // headers.
 //
+// - Routes: Maps URL paths to stream names. A route can either be given as
+// a stream name or as a map with the keys "Stream" and "Methods", where
+// "Methods" restricts the route to the given HTTP methods. Paths follow the
+// rules of http.ServeMux, i.e. paths ending with "/" match all sub paths.
+// Requests to unknown paths are answered with 404. If no routes are set, all
+// paths are accepted and sent to the streams configured for this consumer.
+// By default this parameter is set to an empty map.
+//
+// - Methods: Defines the HTTP methods accepted by all routes that do not
+// define their own list. Other methods are answered with 405. If empty, all
+// methods are accepted.
+// By default this parameter is set to an empty list.
+//
+// - Split: Defines how a request body is split into messages. Can be set to
+// "none" (one message per request), "lines" (one message per non-empty line,
+// e.g. for NDJSON) or "json" (one message per element of a JSON array, a
+// single JSON object is sent as one message). Requires WithHeaders to be false.
+// By default this parameter is set to "none".
+//
+// - MaxBodySizeKB: Defines the maximum size of a request body in KB, checked
+// before and after decompression. Larger requests are answered with 413.
+// Set to 0 to disable this limit.
+// By default this parameter is set to "0".
+//
+// - Response/Success: Defines the HTTP status code sent when a request
+// has been accepted.
+// By default this parameter is set to "200".
+//
+// - Response/Blocked: Defines the HTTP status code sent when a producer of a
+// target stream is blocked. The request is rejected in this case so that the
+// client can retry later.
+// By default this parameter is set to "429".
+//
 // - Htpasswd: Path to an htpasswd-formatted password file. If defined, turns
 // on HTTP Basic Authentication in the server.
 //
 // - BasicRealm: Defines the Authentication Realm for HTTP Basic Authentication.
 // Meaningful only in conjunction with Htpasswd.
 //
+// - BearerTokens: Defines a list of tokens accepted in an "Authorization:
+// Bearer" header. If Htpasswd is set, too, either of both is accepted.
+// By default this parameter is set to an empty list.
+//
+// - HMAC/Secret: If set, every request must be signed with a hex encoded
+// HMAC of the (compressed) request body using this secret. This check is done
+// in addition to htpasswd or bearer token authentication.
+// By default this parameter is set to "".
+//
+// - HMAC/Header: Defines the header containing the signature. The signature
+// may be prefixed with the algorithm name followed by "=", e.g. "sha256=...".
+// By default this parameter is set to "X-Signature".
+//
+// - HMAC/Algorithm: Defines the hash function used for HMAC signatures. Can
+// be set to "sha1", "sha256" or "sha512".
+// By default this parameter is set to "sha256".
+//
 // - Certificate: Path to an X509 formatted certificate file. If defined, turns on
 // SSL/TLS  support in the HTTP server. Requires PrivateKey to be set.
 //
 // - PrivateKey: Path to an X509 formatted private key file. Meaningful only in
 // conjunction with Certificate.
 //
+// Request bodies with a Content-Encoding of "gzip" or "deflate" are
+// decompressed before being processed. Other encodings are answered with 415.
+//
 // Examples
 //
 // This example listens on port 9090 and writes to the stream "http_in_00".
@@ -70,6 +149,27 @@ import (
 //     Address: "localhost:9090"
 //     WithHeaders: false
 //
+// This example accepts NDJSON batches signed by the sender. Events posted to
+// /events are split into one message per line, audit data is only accepted via
+// PUT and sent to a separate stream.
+//
+//   "HttpIn01":
+//     Type: "consumer.HTTP"
+//     Streams: "http_events"
+//     Address: ":9090"
+//     WithHeaders: false
+//     Split: "lines"
+//     MaxBodySizeKB: 1024
+//     Methods: ["POST"]
+//     Routes:
+//       "/events": "http_events"
+//       "/audit":
+//         Stream: "http_audit"
+//         Methods: ["PUT"]
+//     HMAC:
+//       Secret: "s3cr3t"
+//       Header: "X-Hub-Signature-256"
+//
 type HTTP struct {
 	core.SimpleConsumer `gollumdoc:"embed_type"`
 	address             string        `config:"Address" default:":80"`
@@ -77,11 +177,27 @@ type HTTP struct {
 	withHeaders         bool          `config:"WithHeaders" default:"true"`
 	htpasswd            string        `config:"Htpasswd"`
 	basicRealm          string        `config:"BasicRealm"`
+	methods             []string      `config:"Methods"`
+	split               string        `config:"Split" default:"none"`
+	maxBodySize         int64         `config:"MaxBodySizeKB" default:"0" metric:"kb"`
+	successCode         int           `config:"Response/Success" default:"200"`
+	blockedCode         int           `config:"Response/Blocked" default:"429"`
+	bearerTokens        []string      `config:"BearerTokens"`
+	hmacSecret          string        `config:"HMAC/Secret"`
+	hmacHeader          string        `config:"HMAC/Header" default:"X-Signature"`
+	hmacAlgorithm       string        `config:"HMAC/Algorithm" default:"sha256"`
+	hmacHash            func() hash.Hash
+	routes              map[string]httpRoute
 	secrets             auth.SecretProvider
 	listen              *tnet.StopListener
 	certificate         *tls.Config
 }
 
+type httpRoute struct {
+	streamID core.MessageStreamID
+	methods  []string
+}
+
 func init() {
 	core.TypeRegistry.Register(HTTP{})
 }
@@ -96,6 +212,70 @@ func (cons *HTTP) Configure(conf core.PluginConfigReader) {
 		cons.secrets = auth.HtpasswdFileProvider(cons.htpasswd)
 	}
 
+	cons.split = strings.ToLower(cons.split)
+	switch cons.split {
+	case httpSplitNone:
+	case httpSplitLines, httpSplitJSON:
+		if cons.withHeaders {
+			conf.Errors.Pushf("Split \"%s\" cannot be used when WithHeaders is enabled", cons.split)
+		}
+	default:
+		conf.Errors.Pushf("Unknown Split mode \"%s\"", cons.split)
+	}
+
+	if cons.hmacSecret != "" {
+		switch strings.ToLower(cons.hmacAlgorithm) {
+		case "sha1":
+			cons.hmacHash = sha1.New
+		case "sha256":
+			cons.hmacHash = sha256.New
+		case "sha512":
+			cons.hmacHash = sha512.New
+		default:
+			conf.Errors.Pushf("Unknown HMAC algorithm \"%s\"", cons.hmacAlgorithm)
+		}
+	}
+
+	cons.methods = normalizeHTTPMethods(cons.methods)
+	cons.routes = make(map[string]httpRoute)
+	for path, value := range conf.GetMap("Routes", tcontainer.NewMarshalMap()) {
+		route := httpRoute{methods: cons.methods}
+
+		switch target := value.(type) {
+		case string:
+			route.streamID = core.GetStreamID(target)
+		default:
+			routeConfig, err := tcontainer.ConvertToMarshalMap(value, nil)
+			if err != nil {
+				conf.Errors.Pushf("Route \"%s\" must be a stream name or a map", path)
+				continue
+			}
+			stream, err := routeConfig.String("Stream")
+			if err != nil || stream == "" {
+				conf.Errors.Pushf("Route \"%s\" requires a string \"Stream\"", path)
+				continue
+			}
+			route.streamID = core.GetStreamID(stream)
+			if _, hasMethods := routeConfig.Value("Methods"); hasMethods {
+				methods, err := routeConfig.StringArray("Methods")
+				if err != nil {
+					conf.Errors.Pushf("Route \"%s\": Methods must be a list of strings", path)
+					continue
+				}
+				route.methods = normalizeHTTPMethods(methods)
+			}
+		}
+
+		if !strings.HasPrefix(path, "/") {
+			conf.Errors.Pushf("Route \"%s\" must start with \"/\"", path)
+			continue
+		}
+
+		// Make sure the target stream exists before the first request arrives
+		core.StreamRegistry.GetRouterOrFallback(route.streamID)
+		cons.routes[path] = route
+	}
+
 	certificateFile := conf.GetString("Certificate", "")
 	keyFile := conf.GetString("PrivateKey", "")
 
@@ -115,49 +295,281 @@ func (cons *HTTP) Configure(conf core.PluginConfigReader) {
 	}
 }
 
+// Streams returns the streams this consumer is bound to, including all
+// streams used by routes.
+func (cons *HTTP) Streams() []core.MessageStreamID {
+	streams := cons.SimpleConsumer.Streams()
+	for _, route := range cons.routes {
+		streams = append(streams, route.streamID)
+	}
+	return streams
+}
+
+func normalizeHTTPMethods(methods []string) []string {
+	normalized := make([]string, 0, len(methods))
+	for _, method := range methods {
+		normalized = append(normalized, strings.ToUpper(strings.TrimSpace(method)))
+	}
+	sort.Strings(normalized)
+	return normalized
+}
+
 func (cons *HTTP) checkAuth(r *http.Request) bool {
 	a := &auth.BasicAuth{Realm: cons.basicRealm, Secrets: cons.secrets}
 	return a.CheckAuth(r) != ""
 }
 
-// requestHandler will handle a single web request.
-func (cons *HTTP) requestHandler(resp http.ResponseWriter, req *http.Request) {
-	if cons.htpasswd != "" {
-		if !cons.checkAuth(req) {
-			resp.WriteHeader(http.StatusUnauthorized)
-			return
+func (cons *HTTP) checkBearerToken(r *http.Request) bool {
+	header := r.Header.Get("Authorization")
+	if len(header) < 7 || !strings.EqualFold(header[:7], "bearer ") {
+		return false // ### return, no bearer token ###
+	}
+
+	token := []byte(strings.TrimSpace(header[7:]))
+	for _, accepted := range cons.bearerTokens {
+		if subtle.ConstantTimeCompare(token, []byte(accepted)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+func (cons *HTTP) isAuthorized(r *http.Request) bool {
+	if cons.htpasswd == "" && len(cons.bearerTokens) == 0 {
+		return true // ### return, no authentication required ###
+	}
+	if cons.htpasswd != "" && cons.checkAuth(r) {
+		return true
+	}
+	return len(cons.bearerTokens) > 0 && cons.checkBearerToken(r)
+}
+
+func (cons *HTTP) checkSignature(r *http.Request, body []byte) bool {
+	signature := strings.TrimSpace(r.Header.Get(cons.hmacHeader))
+	if idx := strings.IndexByte(signature, '='); idx >= 0 {
+		signature = signature[idx+1:]
+	}
+
+	expected, err := hex.DecodeString(signature)
+	if err != nil || len(expected) == 0 {
+		return false // ### return, missing or malformed signature ###
+	}
+
+	mac := hmac.New(cons.hmacHash, []byte(cons.hmacSecret))
+	mac.Write(body)
+	return hmac.Equal(mac.Sum(nil), expected)
+}
+
+func isMethodAllowed(method string, methods []string) bool {
+	if len(methods) == 0 {
+		return true
+	}
+	idx := sort.SearchStrings(methods, method)
+	return idx < len(methods) && methods[idx] == method
+}
+
+// isBlocked returns true if any producer listening to the target streams of
+// the given route is blocked.
+func (cons *HTTP) isBlocked(route httpRoute) bool {
+	streams := []core.MessageStreamID{route.streamID}
+	if route.streamID == core.InvalidStreamID {
+		streams = cons.SimpleConsumer.Streams()
+	}
+
+	for _, streamID := range streams {
+		router, hasProducers := core.StreamRegistry.GetRouter(streamID).(interface {
+			GetProducers() []core.Producer
+		})
+		if !hasProducers {
+			continue // ### continue, unknown stream or router type ###
+		}
+		for _, prod := range router.GetProducers() {
+			if prod.IsBlocked() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// readBody reads and decompresses the request body while respecting the
+// configured size limit. The raw body is returned for signature checks.
+func (cons *HTTP) readBody(resp http.ResponseWriter, req *http.Request) (raw []byte, body []byte, status int, err error) {
+	if req.Body == nil {
+		return nil, nil, http.StatusBadRequest, fmt.Errorf("missing request body")
+	}
+	defer req.Body.Close()
+
+	var reader io.Reader = req.Body
+	if cons.maxBodySize > 0 {
+		reader = http.MaxBytesReader(resp, req.Body, cons.maxBodySize)
+	}
+
+	if raw, err = ioutil.ReadAll(reader); err != nil {
+		if cons.maxBodySize > 0 && int64(len(raw)) >= cons.maxBodySize {
+			return nil, nil, http.StatusRequestEntityTooLarge, err
 		}
+		return nil, nil, http.StatusBadRequest, err
+	}
+
+	var decoder io.ReadCloser
+	switch encoding := strings.ToLower(strings.TrimSpace(req.Header.Get("Content-Encoding"))); encoding {
+	case "", "identity":
+		return raw, raw, 0, nil // ### return, not compressed ###
+	case "gzip", "x-gzip":
+		decoder, err = gzip.NewReader(bytes.NewReader(raw))
+	case "deflate":
+		decoder, err = zlib.NewReader(bytes.NewReader(raw))
+	default:
+		return nil, nil, http.StatusUnsupportedMediaType, fmt.Errorf("unsupported content encoding \"%s\"", encoding)
+	}
+	if err != nil {
+		return nil, nil, http.StatusBadRequest, err
+	}
+	defer decoder.Close()
+
+	reader = decoder
+	if cons.maxBodySize > 0 {
+		reader = io.LimitReader(decoder, cons.maxBodySize+1)
+	}
+	if body, err = ioutil.ReadAll(reader); err != nil {
+		return nil, nil, http.StatusBadRequest, err
 	}
+	if cons.maxBodySize > 0 && int64(len(body)) > cons.maxBodySize {
+		return nil, nil, http.StatusRequestEntityTooLarge, fmt.Errorf("decompressed body exceeds %d bytes", cons.maxBodySize)
+	}
+	return raw, body, 0, nil
+}
+
+// splitBody splits the body into message payloads as configured by Split.
+func (cons *HTTP) splitBody(body []byte) ([][]byte, error) {
+	switch cons.split {
+	case httpSplitLines:
+		messages := [][]byte{}
+		for _, line := range bytes.Split(body, []byte{'\n'}) {
+			line = bytes.TrimRight(line, "\r")
+			if len(bytes.TrimSpace(line)) > 0 {
+				messages = append(messages, line)
+			}
+		}
+		return messages, nil
+
+	case httpSplitJSON:
+		trimmed := bytes.TrimSpace(body)
+		if len(trimmed) == 0 || trimmed[0] != '[' {
+			if !json.Valid(trimmed) {
+				return nil, fmt.Errorf("request body is not valid JSON")
+			}
+			return [][]byte{trimmed}, nil // ### return, single object ###
+		}
 
+		elements := []json.RawMessage{}
+		if err := json.Unmarshal(trimmed, &elements); err != nil {
+			return nil, err
+		}
+		messages := make([][]byte, 0, len(elements))
+		for _, element := range elements {
+			messages = append(messages, []byte(element))
+		}
+		return messages, nil
+
+	default:
+		return [][]byte{body}, nil
+	}
+}
+
+// newRouteHandler returns a request handler for the given route. A route
+// without a stream sends messages to the streams configured for this consumer.
+func (cons *HTTP) newRouteHandler(route httpRoute) http.HandlerFunc {
+	return func(resp http.ResponseWriter, req *http.Request) {
+		cons.requestHandler(resp, req, route)
+	}
+}
+
+// requestHandler will handle a single web request.
+func (cons *HTTP) requestHandler(resp http.ResponseWriter, req *http.Request, route httpRoute) {
+	if !isMethodAllowed(req.Method, route.methods) {
+		resp.Header().Set("Allow", strings.Join(route.methods, ", "))
+		resp.WriteHeader(http.StatusMethodNotAllowed)
+		return // ### return, method not allowed ###
+	}
+
+	if !cons.isAuthorized(req) {
+		resp.WriteHeader(http.StatusUnauthorized)
+		return // ### return, not authorized ###
+	}
+
+	raw, body, status, err := cons.readBody(resp, req)
+	if err != nil {
+		resp.WriteHeader(status)
+		cons.Logger.Warning(err)
+		return // ### return, missing body or bad read ###
+	}
+
+	if cons.hmacHash != nil && !cons.checkSignature(req, raw) {
+		resp.WriteHeader(http.StatusUnauthorized)
+		return // ### return, invalid signature ###
+	}
+
+	if cons.isBlocked(route) {
+		resp.WriteHeader(cons.blockedCode)
+		return // ### return, target stream is blocked ###
+	}
+
+	var messages [][]byte
 	if cons.withHeaders {
-		// Read the whole package
+		// Relay the whole request with the decoded body
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+		req.ContentLength = int64(len(body))
+		req.Header.Del("Content-Encoding")
+
 		requestBuffer := bytes.NewBuffer(nil)
 		if err := req.Write(requestBuffer); err != nil {
 			resp.WriteHeader(http.StatusBadRequest)
 			cons.Logger.Error(err)
-			return // ### return, missing body or bad write ###
+			return // ### return, bad write ###
 		}
+		messages = [][]byte{requestBuffer.Bytes()}
+	} else if messages, err = cons.splitBody(body); err != nil {
+		resp.WriteHeader(http.StatusBadRequest)
+		cons.Logger.Warning(err)
+		return // ### return, body cannot be split ###
+	}
 
-		cons.Enqueue(requestBuffer.Bytes())
-		resp.WriteHeader(http.StatusOK)
-	} else {
-		// Read only the message body
-		if req.Body == nil {
-			resp.WriteHeader(http.StatusBadRequest)
-			return // ### return, missing body ###
+	for _, data := range messages {
+		if route.streamID != core.InvalidStreamID {
+			cons.EnqueueToStream(route.streamID, data, cons.newMetadata(req))
+		} else {
+			cons.EnqueueWithMetadata(data, cons.newMetadata(req))
 		}
+	}
+	resp.WriteHeader(cons.successCode)
+}
 
-		body, err := ioutil.ReadAll(req.Body)
-		if err != nil {
-			resp.WriteHeader(http.StatusBadRequest)
-			cons.Logger.Error(err)
-			return // ### return, missing body or bad write ###
-		}
-		defer req.Body.Close()
+// newMetadata returns the metadata for a request. The W3C traceparent header
+// is passed on to continue traces of the sender.
+func (cons *HTTP) newMetadata(req *http.Request) tcontainer.MarshalMap {
+	traceParent := req.Header.Get(core.TraceParentMetadataKey)
+	if traceParent == "" {
+		return nil
+	}
+
+	metaData := core.NewMetadata()
+	metaData.Set(core.TraceParentMetadataKey, traceParent)
+	return metaData
+}
+
+// newHandler returns the handler serving all configured routes.
+func (cons *HTTP) newHandler() http.Handler {
+	if len(cons.routes) == 0 {
+		return cons.newRouteHandler(httpRoute{methods: cons.methods})
+	}
 
-		cons.Enqueue(body)
-		resp.WriteHeader(http.StatusOK)
+	mux := http.NewServeMux()
+	for path, route := range cons.routes {
+		mux.Handle(path, cons.newRouteHandler(route))
 	}
+	return mux
 }
 
 func (cons *HTTP) serve() {
@@ -165,7 +577,7 @@ func (cons *HTTP) serve() {
 
 	srv := http.Server{
 		Addr:        cons.address,
-		Handler:     http.HandlerFunc(cons.requestHandler),
+		Handler:     cons.newHandler(),
 		ReadTimeout: cons.readTimeout,
 		TLSConfig:   cons.certificate,
 	}
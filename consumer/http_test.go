@@ -0,0 +1,138 @@
+// Copyright 2015-2018 trivago N.V.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consumer
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/trivago/gollum/core"
+	"github.com/trivago/tgo/ttesting"
+)
+
+func TestHTTPSplitBody(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+	conf := core.NewPluginConfig("", "consumer.HTTP")
+
+	conf.Override("WithHeaders", false)
+	conf.Override("Split", "lines")
+	plugin, err := core.NewPluginWithConfig(conf)
+	expect.NoError(err)
+
+	cons, casted := plugin.(*HTTP)
+	expect.True(casted)
+
+	messages, err := cons.splitBody([]byte("{\"a\":1}\r\n\n{\"b\":2}\n"))
+	expect.NoError(err)
+	expect.Equal(2, len(messages))
+	expect.Equal("{\"a\":1}", string(messages[0]))
+	expect.Equal("{\"b\":2}", string(messages[1]))
+
+	conf.Override("Split", "json")
+	plugin, err = core.NewPluginWithConfig(conf)
+	expect.NoError(err)
+	cons = plugin.(*HTTP)
+
+	messages, err = cons.splitBody([]byte(" [{\"a\":1}, \"b\", 3] "))
+	expect.NoError(err)
+	expect.Equal(3, len(messages))
+	expect.Equal("{\"a\":1}", string(messages[0]))
+	expect.Equal("\"b\"", string(messages[1]))
+	expect.Equal("3", string(messages[2]))
+
+	messages, err = cons.splitBody([]byte("{\"a\":1}"))
+	expect.NoError(err)
+	expect.Equal(1, len(messages))
+
+	_, err = cons.splitBody([]byte("[{\"a\":1"))
+	expect.NotNil(err)
+}
+
+func TestHTTPRequestHandling(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+	conf := core.NewPluginConfig("", "consumer.HTTP")
+
+	conf.Override("WithHeaders", false)
+	conf.Override("Methods", []string{"post"})
+	conf.Override("MaxBodySizeKB", 1)
+	conf.Override("BearerTokens", []string{"token"})
+	conf.Override("HMAC/Secret", "secret")
+	conf.Override("Routes", map[string]interface{}{
+		"/events": "httpTestEvents",
+		"/audit": map[string]interface{}{
+			"Stream":  "httpTestAudit",
+			"Methods": []string{"PUT"},
+		},
+	})
+	plugin, err := core.NewPluginWithConfig(conf)
+	expect.NoError(err)
+
+	cons, casted := plugin.(*HTTP)
+	expect.True(casted)
+	handler := cons.newHandler()
+
+	sign := func(body []byte) string {
+		mac := hmac.New(sha256.New, []byte("secret"))
+		mac.Write(body)
+		return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	}
+
+	send := func(method, path string, body []byte, header map[string]string) int {
+		req := httptest.NewRequest(method, path, bytes.NewReader(body))
+		for key, value := range header {
+			req.Header.Set(key, value)
+		}
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+		return resp.Code
+	}
+
+	body := []byte("data")
+	auth := map[string]string{"Authorization": "Bearer token", "X-Signature": sign(body)}
+
+	expect.Equal(http.StatusNotFound, send("POST", "/unknown", body, auth))
+	expect.Equal(http.StatusMethodNotAllowed, send("GET", "/events", body, auth))
+	expect.Equal(http.StatusMethodNotAllowed, send("POST", "/audit", body, auth))
+	expect.Equal(http.StatusUnauthorized, send("POST", "/events", body, map[string]string{"X-Signature": sign(body)}))
+	expect.Equal(http.StatusUnauthorized, send("POST", "/events", body, map[string]string{"Authorization": "Bearer token", "X-Signature": sign([]byte("other"))}))
+	expect.Equal(http.StatusOK, send("POST", "/events", body, auth))
+	expect.Equal(http.StatusOK, send("PUT", "/audit", body, auth))
+
+	large := []byte(strings.Repeat("x", 2048))
+	expect.Equal(http.StatusRequestEntityTooLarge, send("POST", "/events", large, map[string]string{"Authorization": "Bearer token", "X-Signature": sign(large)}))
+
+	compressed := bytes.NewBuffer(nil)
+	writer := gzip.NewWriter(compressed)
+	writer.Write(large)
+	writer.Close()
+	expect.Equal(http.StatusRequestEntityTooLarge, send("POST", "/events", compressed.Bytes(), map[string]string{
+		"Authorization":    "Bearer token",
+		"X-Signature":      sign(compressed.Bytes()),
+		"Content-Encoding": "gzip",
+	}))
+
+	expect.Equal(http.StatusUnsupportedMediaType, send("POST", "/events", body, map[string]string{
+		"Authorization":    "Bearer token",
+		"X-Signature":      sign(body),
+		"Content-Encoding": "br",
+	}))
+}
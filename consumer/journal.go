@@ -0,0 +1,571 @@
+// Copyright 2015-2018 trivago N.V.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consumer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/trivago/gollum/core"
+	"github.com/trivago/gollum/core/components"
+	"github.com/trivago/tgo"
+	"github.com/trivago/tgo/tcontainer"
+	"github.com/trivago/tgo/tnet"
+)
+
+const (
+	journalOffsetStart   = "oldest"
+	journalOffsetEnd     = "newest"
+	journalContentType   = "application/vnd.fdo.journal"
+	journalMessageField  = "MESSAGE"
+	journalCursorField   = "__CURSOR"
+	journalUploadPath    = "/upload"
+	journalStderrMaxSize = 4096
+	// journalMaxFieldSize is the upper limit for binary fields, regardless of
+	// the MaxFieldSizeKB setting.
+	journalMaxFieldSize = 64 << 20
+	// journalCursorInterval is the minimum time between two cursor file writes
+	journalCursorInterval = time.Second
+)
+
+// Journal consumer plugin
+//
+// The Journal consumer reads systemd journal entries in the journal export
+// format without linking against libsystemd. Entries are either read from the
+// output of "journalctl --output=export --follow" or received via HTTP from
+// systemd-journal-upload, which posts entries to "/upload" using the content
+// type "application/vnd.fdo.journal".
+//
+// The MESSAGE field of an entry is used as the message payload. All other
+// fields are stored as metadata using the journal field names as keys.
+//
+// Metadata
+//
+// - <FIELD>: The value of the journal field <FIELD>, e.g. _SYSTEMD_UNIT,
+// PRIORITY, _HOSTNAME, SYSLOG_IDENTIFIER or __CURSOR. Binary fields are
+// stored as-is.
+//
+// Parameters
+//
+// - Address: Defines the address to listen on for journal uploads. If set,
+// the consumer accepts HTTP uploads instead of running Command.
+// By default this parameter is set to "".
+//
+// - Command: Defines the command used to read the journal. The arguments
+// "--output=export" and "--follow" are added automatically, as well as
+// "--after-cursor" to resume from a stored cursor. The command is restarted
+// after RetryDelaySec if it exits.
+// By default this parameter is set to "journalctl".
+//
+// - Matches: Defines a list of journal matches passed on to Command, e.g.
+// "_SYSTEMD_UNIT=sshd.service".
+// By default this parameter is set to an empty list.
+//
+// - DefaultOffset: Defines where to start reading if no cursor is stored.
+// Valid values are "oldest" and "newest". Only used with Command.
+// By default this parameter is set to "newest".
+//
+// - CursorFile: Defines the path to a file storing the cursor of the last
+// processed entry. If the consumer is restarted, reading continues after this
+// cursor. When using Address, the file records the last received entry;
+// resuming uploads is done by systemd-journal-upload in this case.
+// The file is written at most once per second and when the consumer stops.
+// Set to "" to disable the cursor file.
+// By default this parameter is set to "".
+//
+// - CommitOnAck: When set to true, the cursor file is only updated for entries
+// that have been acknowledged by all producers. See consumer.File for details.
+// This setting requires CursorFile to be set.
+// By default this parameter is set to "false".
+//
+// - Fields: Defines the list of journal fields stored as metadata. If empty,
+// all fields but MESSAGE are stored.
+// By default this parameter is set to an empty list.
+//
+// - MaxFieldSizeKB: Defines the maximum size of a single binary field in KB.
+// Entries with larger fields cause the input to be rejected. Fields are never
+// allowed to be larger than 64 MB, so setting this to 0 uses this limit.
+// By default this parameter is set to "1024".
+//
+// - RetryDelaySec: Defines the number of seconds to wait before restarting
+// Command.
+// By default this parameter is set to "3".
+//
+// Examples
+//
+// This example reads all new sshd entries and resumes after restarts.
+//
+//  JournalIn:
+//    Type: consumer.Journal
+//    Streams: journal
+//    Matches:
+//      - "_SYSTEMD_UNIT=sshd.service"
+//    CursorFile: /var/lib/gollum/journal.cursor
+//    CommitOnAck: true
+//
+// This example receives entries from systemd-journal-upload, started with
+// "systemd-journal-upload --url=https://gollum.example.com:19532".
+//
+//  JournalRemote:
+//    Type: consumer.Journal
+//    Streams: journal
+//    Address: ":19532"
+//    TLS:
+//      Enable: true
+//      Certificate: /etc/gollum/server.crt
+//      PrivateKey: /etc/gollum/server.key
+//
+type Journal struct {
+	core.SimpleConsumer `gollumdoc:"embed_type"`
+	TLSConfig           components.TLSConfig `gollumdoc:"embed_type"`
+	address             string               `config:"Address"`
+	command             string               `config:"Command" default:"journalctl"`
+	matches             []string             `config:"Matches"`
+	defaultOffset       string               `config:"DefaultOffset" default:"newest"`
+	cursorFile          string               `config:"CursorFile"`
+	commitOnAck         bool                 `config:"CommitOnAck" default:"false"`
+	fields              []string             `config:"Fields"`
+	maxFieldSize        int64                `config:"MaxFieldSizeKB" default:"1024" metric:"kb"`
+	retryDelay          time.Duration        `config:"RetryDelaySec" default:"3" metric:"sec"`
+
+	listen     *tnet.StopListener
+	done       chan struct{}
+	process    *os.Process
+	guard      sync.Mutex
+	readCursor string
+	tracker    *core.OffsetTracker
+	sequence   int64
+	committed  int64
+	pending    map[int64]string
+	unstored   string
+	storedAt   time.Time
+}
+
+func init() {
+	core.TypeRegistry.Register(Journal{})
+}
+
+// Configure initializes this consumer with values from a plugin config.
+func (cons *Journal) Configure(conf core.PluginConfigReader) {
+	cons.done = make(chan struct{})
+	cons.SetStopCallback(cons.close)
+
+	cons.defaultOffset = strings.ToLower(cons.defaultOffset)
+	if cons.defaultOffset != journalOffsetStart && cons.defaultOffset != journalOffsetEnd {
+		conf.Errors.Pushf("DefaultOffset must be \"%s\" or \"%s\"", journalOffsetStart, journalOffsetEnd)
+	}
+
+	if cons.commitOnAck && cons.cursorFile == "" {
+		conf.Errors.Pushf("CommitOnAck requires CursorFile to be set")
+	}
+
+	if cons.address == "" && len(strings.Fields(cons.command)) == 0 {
+		conf.Errors.Pushf("Either Address or Command must be set")
+	}
+
+	if cons.TLSConfig.IsEnabled() {
+		switch {
+		case cons.address == "":
+			conf.Errors.Pushf("TLS can only be used together with Address")
+		case !cons.TLSConfig.HasCertificate():
+			conf.Errors.Pushf("TLS requires TLS/Certificate and TLS/PrivateKey to be set.")
+		}
+		cons.SetRollCallback(cons.reloadTLS)
+	}
+
+	sort.Strings(cons.fields)
+	cons.pending = make(map[int64]string)
+	cons.tracker = core.NewOffsetTracker(0)
+}
+
+func (cons *Journal) reloadTLS() {
+	if err := cons.TLSConfig.Reload(); err != nil {
+		cons.Logger.WithError(err).Error("Failed to reload TLS certificates")
+	}
+}
+
+// readJournalEntry reads a single entry in journal export format. Text fields
+// are stored as "KEY=value\n", binary fields as "KEY\n" followed by the data
+// size as 64 bit little endian integer, the data and a newline. Entries are
+// separated by an empty line. io.EOF is returned if no more entries exist.
+func readJournalEntry(reader *bufio.Reader, maxFieldSize int64) (map[string]string, error) {
+	entry := make(map[string]string)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF && len(line) == 0 {
+			if len(entry) == 0 {
+				return nil, io.EOF // ### return, no more entries ###
+			}
+			return entry, nil // ### return, last entry without separator ###
+		}
+		if err != nil {
+			return nil, io.ErrUnexpectedEOF
+		}
+
+		line = line[:len(line)-1]
+		if len(line) == 0 {
+			if len(entry) == 0 {
+				continue // ### continue, skip additional separators ###
+			}
+			return entry, nil // ### return, entry complete ###
+		}
+
+		if idx := bytes.IndexByte(line, '='); idx >= 0 {
+			entry[string(line[:idx])] = string(line[idx+1:])
+			continue // ### continue, text field ###
+		}
+
+		if maxFieldSize <= 0 || maxFieldSize > journalMaxFieldSize {
+			maxFieldSize = journalMaxFieldSize
+		}
+
+		var size uint64
+		if err := binary.Read(reader, binary.LittleEndian, &size); err != nil {
+			return nil, io.ErrUnexpectedEOF
+		}
+		if size > uint64(maxFieldSize) {
+			return nil, fmt.Errorf("field %s exceeds the maximum size of %d bytes", string(line), maxFieldSize)
+		}
+
+		data := make([]byte, size+1)
+		if _, err := io.ReadFull(reader, data); err != nil {
+			return nil, io.ErrUnexpectedEOF
+		}
+		if data[size] != '\n' {
+			return nil, fmt.Errorf("binary field %s is not terminated by a newline", string(line))
+		}
+		entry[string(line)] = string(data[:size])
+	}
+}
+
+func (cons *Journal) newMetadata(entry map[string]string) tcontainer.MarshalMap {
+	metaData := core.NewMetadata()
+	for key, value := range entry {
+		if key == journalMessageField {
+			continue
+		}
+		if len(cons.fields) > 0 {
+			idx := sort.SearchStrings(cons.fields, key)
+			if idx == len(cons.fields) || cons.fields[idx] != key {
+				continue
+			}
+		}
+		metaData.Set(key, value)
+	}
+	return metaData
+}
+
+// readEntries enqueues all entries read from the given reader.
+func (cons *Journal) readEntries(reader io.Reader) error {
+	bufferedReader := bufio.NewReader(reader)
+	for {
+		entry, err := readJournalEntry(bufferedReader, cons.maxFieldSize)
+		if err != nil {
+			cons.flushCursor()
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		cons.enqueueEntry(entry)
+	}
+}
+
+func (cons *Journal) enqueueEntry(entry map[string]string) {
+	cursor := entry[journalCursorField]
+	data := []byte(entry[journalMessageField])
+	metaData := cons.newMetadata(entry)
+
+	switch {
+	case cons.cursorFile != "" && cons.commitOnAck && cursor != "":
+		cons.EnqueueWithAcknowledge(data, metaData, cons.trackCursor(cursor))
+
+	case cons.cursorFile != "" && cursor != "":
+		cons.EnqueueWithMetadata(data, metaData)
+		cons.guard.Lock()
+		cons.storeCursor(cursor)
+		cons.guard.Unlock()
+
+	default:
+		cons.EnqueueWithMetadata(data, metaData)
+	}
+
+	if cursor != "" {
+		cons.guard.Lock()
+		cons.readCursor = cursor
+		cons.guard.Unlock()
+	}
+}
+
+// trackCursor returns a callback that stores the given cursor once the
+// entry and all entries read before have been delivered.
+func (cons *Journal) trackCursor(cursor string) core.AcknowledgeFunc {
+	cons.guard.Lock()
+	cons.sequence++
+	sequence := cons.sequence
+	cons.pending[sequence] = cursor
+	cons.tracker.Track(sequence)
+	cons.guard.Unlock()
+
+	return func(success bool) {
+		if !success {
+			cons.Logger.Warningf("Journal entry %s was not delivered", cursor)
+		}
+
+		commit, advanced := cons.tracker.Done(sequence, success)
+		if !advanced {
+			return // ### return, nothing to commit ###
+		}
+
+		cons.guard.Lock()
+		defer cons.guard.Unlock()
+
+		if commit <= cons.committed {
+			return // ### return, already committed ###
+		}
+		commitCursor := cons.pending[commit]
+		for seq := cons.committed + 1; seq <= commit; seq++ {
+			delete(cons.pending, seq)
+		}
+		cons.committed = commit
+		cons.storeCursor(commitCursor)
+	}
+}
+
+// storeCursor writes the given cursor to the cursor file. Writes are
+// throttled to journalCursorInterval, skipped cursors are written by
+// flushCursor. The caller is expected to hold the guard.
+func (cons *Journal) storeCursor(cursor string) {
+	cons.unstored = cursor
+	if time.Since(cons.storedAt) < journalCursorInterval {
+		return // ### return, written later ###
+	}
+
+	cons.unstored = ""
+	cons.storedAt = time.Now()
+	if err := core.WriteFileAtomic(cons.cursorFile, []byte(cursor)); err != nil {
+		cons.Logger.WithError(err).Error("Failed to store cursor")
+	}
+}
+
+// flushCursor writes a cursor that has been skipped by storeCursor.
+func (cons *Journal) flushCursor() {
+	cons.guard.Lock()
+	defer cons.guard.Unlock()
+
+	if cons.unstored != "" {
+		cons.storedAt = time.Time{}
+		cons.storeCursor(cons.unstored)
+	}
+}
+
+func (cons *Journal) loadCursor() {
+	if cons.cursorFile == "" {
+		return // ### return, no cursor file ###
+	}
+
+	data, err := ioutil.ReadFile(cons.cursorFile)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		cons.Logger.WithError(err).Errorf("Failed to read cursor file %s", cons.cursorFile)
+	default:
+		cons.readCursor = strings.TrimSpace(string(data))
+	}
+}
+
+// getCommandArgs returns the command and arguments used to follow the journal.
+func (cons *Journal) getCommandArgs() (string, []string) {
+	command := strings.Fields(cons.command)
+	args := append(command[1:], "--output=export", "--follow")
+
+	cons.guard.Lock()
+	cursor := cons.readCursor
+	cons.guard.Unlock()
+
+	switch {
+	case cursor != "":
+		args = append(args, "--after-cursor="+cursor)
+	case cons.defaultOffset == journalOffsetStart:
+		args = append(args, "--lines=all")
+	default:
+		args = append(args, "--lines=0")
+	}
+
+	return command[0], append(args, cons.matches...)
+}
+
+func (cons *Journal) runCommand() error {
+	name, args := cons.getCommandArgs()
+	cmd := exec.Command(name, args...)
+	stderr := bytes.NewBuffer(nil)
+	cmd.Stderr = stderr
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	cons.guard.Lock()
+	cons.process = cmd.Process
+	cons.guard.Unlock()
+
+	readErr := cons.readEntries(stdout)
+	if readErr != nil {
+		cmd.Process.Kill()
+	}
+	waitErr := cmd.Wait()
+
+	cons.guard.Lock()
+	cons.process = nil
+	cons.guard.Unlock()
+
+	if stderr.Len() > 0 {
+		message := stderr.Bytes()
+		if len(message) > journalStderrMaxSize {
+			message = message[:journalStderrMaxSize]
+		}
+		cons.Logger.Warningf("%s: %s", name, strings.TrimSpace(string(message)))
+	}
+
+	if readErr != nil {
+		return readErr
+	}
+	return waitErr
+}
+
+func (cons *Journal) readCommand() {
+	defer cons.WorkerDone()
+
+	for {
+		err := cons.runCommand()
+		select {
+		case <-cons.done:
+			return // ### return, stopped ###
+		default:
+		}
+
+		if err != nil {
+			cons.Logger.WithError(err).Errorf("Reading the journal failed, retrying in %v", cons.retryDelay)
+		} else {
+			cons.Logger.Warningf("Journal command exited, restarting in %v", cons.retryDelay)
+		}
+
+		select {
+		case <-cons.done:
+			return // ### return, stopped ###
+		case <-time.After(cons.retryDelay):
+		}
+	}
+}
+
+// uploadHandler handles uploads sent by systemd-journal-upload.
+func (cons *Journal) uploadHandler(resp http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		resp.Header().Set("Allow", http.MethodPost)
+		resp.WriteHeader(http.StatusMethodNotAllowed)
+		return // ### return, wrong method ###
+	}
+
+	if !strings.HasPrefix(req.Header.Get("Content-Type"), journalContentType) {
+		resp.WriteHeader(http.StatusUnsupportedMediaType)
+		return // ### return, wrong content type ###
+	}
+
+	defer req.Body.Close()
+	if err := cons.readEntries(req.Body); err != nil {
+		cons.Logger.WithError(err).Warning("Failed to read journal upload")
+		resp.WriteHeader(http.StatusBadRequest)
+		return // ### return, malformed upload ###
+	}
+
+	resp.WriteHeader(http.StatusAccepted)
+	resp.Write([]byte("OK.\n"))
+}
+
+func (cons *Journal) serve() {
+	defer cons.WorkerDone()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(journalUploadPath, cons.uploadHandler)
+	srv := http.Server{
+		Addr:    cons.address,
+		Handler: mux,
+	}
+
+	var listener net.Listener = cons.listen
+	if cons.TLSConfig.IsEnabled() {
+		listener = cons.TLSConfig.NewListener(listener)
+	}
+
+	err := srv.Serve(listener)
+	if _, isStopRequest := err.(tnet.StopRequestError); err != nil && !isStopRequest {
+		cons.Logger.Error(err)
+	}
+}
+
+func (cons *Journal) close() {
+	close(cons.done)
+	defer cons.flushCursor()
+
+	cons.guard.Lock()
+	defer cons.guard.Unlock()
+
+	if cons.process != nil {
+		cons.process.Kill()
+	}
+	if cons.listen != nil {
+		cons.listen.Close()
+	}
+}
+
+// Consume starts reading the journal
+func (cons *Journal) Consume(workers *sync.WaitGroup) {
+	cons.loadCursor()
+
+	if cons.address != "" {
+		listen, err := tnet.NewStopListener(cons.address)
+		if err != nil {
+			cons.Logger.Error(err)
+			return // ### return, could not listen ###
+		}
+		cons.guard.Lock()
+		cons.listen = listen
+		cons.guard.Unlock()
+
+		cons.AddMainWorker(workers)
+		go cons.serve()
+	} else {
+		cons.AddMainWorker(workers)
+		go tgo.WithRecoverShutdown(cons.readCommand)
+	}
+
+	cons.ControlLoop()
+}
@@ -0,0 +1,148 @@
+// Copyright 2015-2018 trivago N.V.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consumer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/trivago/gollum/core"
+	"github.com/trivago/tgo/ttesting"
+)
+
+func newJournalExport(entries ...[]string) []byte {
+	buffer := bytes.NewBuffer(nil)
+	for _, entry := range entries {
+		for _, field := range entry {
+			if idx := strings.IndexByte(field, '='); idx >= 0 && !strings.Contains(field, "\n") {
+				buffer.WriteString(field + "\n")
+				continue
+			}
+			idx := strings.IndexByte(field, '=')
+			buffer.WriteString(field[:idx] + "\n")
+			binary.Write(buffer, binary.LittleEndian, uint64(len(field)-idx-1))
+			buffer.WriteString(field[idx+1:] + "\n")
+		}
+		buffer.WriteString("\n")
+	}
+	return buffer.Bytes()
+}
+
+func TestJournalExportFormat(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	data := newJournalExport(
+		[]string{"__CURSOR=s=1", "_SYSTEMD_UNIT=sshd.service", "MESSAGE=hello"},
+		[]string{"__CURSOR=s=2", "PRIORITY=3", "MESSAGE=multi\nline"},
+	)
+	reader := bufio.NewReader(bytes.NewReader(data))
+
+	entry, err := readJournalEntry(reader, 1024)
+	expect.NoError(err)
+	expect.MapEqual(entry, "__CURSOR", "s=1")
+	expect.MapEqual(entry, "_SYSTEMD_UNIT", "sshd.service")
+	expect.MapEqual(entry, "MESSAGE", "hello")
+
+	entry, err = readJournalEntry(reader, 1024)
+	expect.NoError(err)
+	expect.MapEqual(entry, "PRIORITY", "3")
+	expect.MapEqual(entry, "MESSAGE", "multi\nline")
+
+	_, err = readJournalEntry(reader, 1024)
+	expect.Equal(io.EOF, err)
+
+	// Binary fields larger than the limit are rejected
+	reader = bufio.NewReader(bytes.NewReader(data))
+	readJournalEntry(reader, 4)
+	_, err = readJournalEntry(reader, 4)
+	expect.NotNil(err)
+
+	// Field sizes are always limited, even if no limit is configured
+	huge := bytes.NewBufferString("__CURSOR=s=3\nDATA\n")
+	binary.Write(huge, binary.LittleEndian, ^uint64(0))
+	huge.WriteString("x\n\n")
+	_, err = readJournalEntry(bufio.NewReader(huge), 0)
+	expect.NotNil(err)
+
+	// Truncated input
+	reader = bufio.NewReader(bytes.NewReader(data[:len(data)-8]))
+	readJournalEntry(reader, 1024)
+	_, err = readJournalEntry(reader, 1024)
+	expect.Equal(io.ErrUnexpectedEOF, err)
+}
+
+func TestJournalUpload(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	dir, err := ioutil.TempDir("", "gollum-journal")
+	expect.NoError(err)
+	defer os.RemoveAll(dir)
+	cursorFile := filepath.Join(dir, "journal.cursor")
+
+	conf := core.NewPluginConfig("", "consumer.Journal")
+	conf.Override("Streams", "journalTest")
+	conf.Override("Address", ":0")
+	conf.Override("CursorFile", cursorFile)
+	conf.Override("Fields", []string{"_HOSTNAME"})
+	plugin, err := core.NewPluginWithConfig(conf)
+	expect.NoError(err)
+	cons := plugin.(*Journal)
+
+	expect.Equal(1, len(cons.newMetadata(map[string]string{"_HOSTNAME": "a", "PRIORITY": "3", "MESSAGE": "b"})))
+
+	upload := func(contentType string, body []byte) int {
+		req := httptest.NewRequest("POST", "/upload", bytes.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		resp := httptest.NewRecorder()
+		cons.uploadHandler(resp, req)
+		return resp.Code
+	}
+
+	data := newJournalExport(
+		[]string{"__CURSOR=s=1", "_HOSTNAME=web1", "MESSAGE=hello"},
+		[]string{"__CURSOR=s=2", "_HOSTNAME=web1", "MESSAGE=world"},
+	)
+
+	expect.Equal(http.StatusUnsupportedMediaType, upload("text/plain", data))
+	expect.Equal(http.StatusAccepted, upload(journalContentType, data))
+
+	cursor, err := ioutil.ReadFile(cursorFile)
+	expect.NoError(err)
+	expect.Equal("s=2", string(cursor))
+
+	cons.readCursor = ""
+	cons.loadCursor()
+	name, args := cons.getCommandArgs()
+	expect.Equal("journalctl", name)
+	expect.Equal("--after-cursor=s=2", args[len(args)-1])
+
+	// Cursor writes are throttled until the next flush
+	cons.storeCursor("s=3")
+	cursor, _ = ioutil.ReadFile(cursorFile)
+	expect.Equal("s=2", string(cursor))
+
+	cons.flushCursor()
+	cursor, _ = ioutil.ReadFile(cursorFile)
+	expect.Equal("s=3", string(cursor))
+}
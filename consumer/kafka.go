@@ -21,6 +21,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path"
+	"regexp"
 	"strconv"
 	"strings"
 	"sync"
@@ -29,7 +30,9 @@ import (
 
 	kafka "github.com/Shopify/sarama"
 	cluster "github.com/bsm/sarama-cluster"
+	"github.com/sirupsen/logrus"
 	"github.com/trivago/gollum/core"
+	"github.com/trivago/tgo/tcontainer"
 	"github.com/trivago/tgo/tsync"
 )
 
@@ -38,6 +41,12 @@ const (
 	kafkaOffsetOldest = "oldest"
 )
 
+// kafkaPartition identifies a partition of a topic
+type kafkaPartition struct {
+	topic     string
+	partition int32
+}
+
 // Kafka consumer
 //
 // This consumer reads data from a kafka topic. It is based on the sarama
@@ -51,6 +60,22 @@ const (
 //
 // - key: Contains the key of the kafka message
 //
+// - partition: Contains the partition the message was read from
+//
+// - offset: Contains the offset of the message within its partition
+//
+// - timestamp: Contains the timestamp of the record in milliseconds since
+// epoch. This field requires Kafka version >= 0.10 and is not set if the record
+// does not carry a timestamp.
+//
+// - <HeaderPrefix><name>: Contains the value of each record header. The name of
+// the field is the header name prefixed by HeaderPrefix. Record headers require
+// Kafka version >= 0.11.
+//
+// - traceparent: Contains the W3C trace context passed as record header (if
+// present). This field is set regardless of `SetMetadata` and requires Kafka
+// version >= 0.11.
+//
 // Parameters
 //
 // - Servers: Defines the list of all kafka brokers to initially connect to when
@@ -61,13 +86,53 @@ const (
 // - Topic: Defines the kafka topic to read from.
 // By default this parameter is set to "default".
 //
+// - Topics: Defines a list of kafka topics to read from. If this setting or
+// TopicRegex is set, Topic is ignored. Reading multiple topics requires
+// GroupId to be set.
+// By default this parameter is set to an empty list.
+//
+// - TopicRegex: Defines a regular expression matching additional topics to
+// read from. New topics matching the expression are picked up when the
+// metadata is refreshed (see MetadataRefreshMs). This setting requires
+// GroupId to be set.
+// By default this parameter is set to "".
+//
 // - ClientId: Sets the client id used in requests by this consumer.
 // By default this parameter is set to "gollum".
 //
 // - GroupId: Sets the consumer group of this consumer. If empty, consumer
-// groups are not used. This setting requires Kafka version >= 0.9.
+// groups are not used. Consumers of a group share the partitions of all
+// topics read and commit their offsets to kafka, so multiple instances can
+// read the same topics. Partitions are reassigned when consumers join or leave
+// the group. How partitions are reassigned depends on GroupStrategy. This
+// setting requires Kafka version >= 0.9.
 // By default this parameter is set to "".
 //
+// - GroupSessionTimeoutMs: Defines the number of milliseconds after which a
+// consumer is removed from the group if no heartbeat has been received.
+// By default this parameter is set to 30000.
+//
+// - GroupHeartbeatMs: Defines the interval in milliseconds in which
+// heartbeats are sent to the group coordinator. This value should be lower
+// than a third of GroupSessionTimeoutMs.
+// By default this parameter is set to 3000.
+//
+// - GroupStrategy: Defines how partitions are assigned to the consumers of a
+// group. Valid values are "range", "roundrobin" and "cooperative". When set to
+// "range" or "roundrobin", all partitions are revoked from all consumers
+// before they are assigned again. When set to "cooperative", partitions are
+// rebalanced incrementally: consumers keep reading their partitions during a
+// rebalance and only stop reading the partitions that move to another
+// consumer. Moving partitions are assigned in a second rebalance after their
+// previous owner committed their offsets. All consumers of a group must use
+// the same strategy, i.e. a group can only be switched to "cooperative" by
+// stopping all of its consumers first.
+// By default this parameter is set to "range".
+//
+// - GroupCommitIntervalMs: Defines the interval in milliseconds in which
+// offsets are committed to kafka.
+// By default this parameter is set to 1000.
+//
 // - Version: Defines the kafka protocol version to use. Common values are 0.8.2,
 // 0.9.0 or 0.10.0. Values of the form "A.B" are allowed as well as "A.B.C"
 // and "A.B.C.D". If the version given is not known, the closest possible
@@ -79,6 +144,10 @@ const (
 // performance impact on systems with high throughput.
 // By default this parameter is set to "false".
 //
+// - HeaderPrefix: Defines the prefix added to the name of a record header when
+// it is stored as metadata field.
+// By default this parameter is set to "header_".
+//
 // - DefaultOffset: Defines the initial offest when starting to read the topic.
 // Valid values are "oldest" and "newest". If OffsetFile
 // is defined and the file exists, the DefaultOffset parameter is ignored.
@@ -133,6 +202,13 @@ const (
 // controls the pause time after receiving errors.
 // By default this parameter is set to 5000.
 //
+// - CommitOnAck: When set to true, offsets are only stored in the OffsetFile
+// or committed to the consumer group after a message has been acknowledged by
+// all producers, i.e. it has been delivered, passed to a fallback or discarded
+// on purpose. A message that could not be delivered stops the offset of its
+// partition from advancing, so it will be read again after a restart.
+// By default this parameter is set to false.
+//
 // - ElectRetries: Defines how many times to retry fetching the new master
 // partition during a leader election.
 // By default this parameter is set to 3.
@@ -181,6 +257,21 @@ const (
 //
 // Examples
 //
+// This config reads all topics starting with "logs-" as part of the consumer
+// group "gollum". Offsets are committed after messages have been delivered.
+//
+//  kafkaGroupIn:
+//    Type: consumer.Kafka
+//    Streams: logs
+//    GroupId: gollum
+//    Version: "0.10.2"
+//    TopicRegex: "^logs-"
+//    CommitOnAck: true
+//    DefaultOffset: oldest
+//    Servers:
+//      - "kafka0:9092"
+//      - "kafka1:9092"
+//
 // This config reads the topic "logs" from a cluster with 4 brokers.
 //
 //  kafkaIn:
@@ -201,27 +292,42 @@ type Kafka struct {
 	consumer            kafka.Consumer
 	config              *kafka.Config
 	groupClient         *cluster.Client
+	groupClosed         *int32
 	groupConfig         *cluster.Config
+	groupStrategy       string
+	groupMember         *kafkaGroupMember
+	topicFilter         *regexp.Regexp
 	offsets             map[int32]*int64
 	servers             []string `config:"Servers"`
 	topic               string   `config:"Topic" default:"default"`
+	topics              []string `config:"Topics"`
+	topicRegex          string   `config:"TopicRegex"`
 	group               string   `config:"GroupId"`
 	offsetFile          string   `config:"OffsetFile"`
 	defaultOffset       int64
 	persistTimeout      time.Duration `config:"PresistTimoutMs" default:"5000" metric:"ms"`
 	folderPermissions   os.FileMode   `config:"FolderPermissions" default:"0755"`
 	MaxPartitionID      int32
-	orderedRead         bool `config:"Ordered"`
-	hasToSetMetadata    bool `config:"SetMetadata" default:"false"`
+	orderedRead         bool   `config:"Ordered"`
+	hasToSetMetadata    bool   `config:"SetMetadata" default:"false"`
+	headerPrefix        string `config:"HeaderPrefix" default:"header_"`
+	commitOnAck         bool   `config:"CommitOnAck" default:"false"`
+	offsetTrackers      *sync.Map
 }
 
 func init() {
 	core.TypeRegistry.Register(Kafka{})
+
+	// The sarama logger is global and read by sarama's goroutines without
+	// synchronization, so it must not be changed once a client is running.
+	kafka.Logger = logrus.WithField("Scope", "Sarama")
 }
 
 // Configure initializes this consumer with values from a plugin config.
 func (cons *Kafka) Configure(conf core.PluginConfigReader) {
 	cons.offsets = make(map[int32]*int64)
+	cons.offsetTrackers = new(sync.Map)
+	cons.groupClosed = new(int32)
 	cons.MaxPartitionID = 0
 
 	cons.config = kafka.NewConfig()
@@ -319,18 +425,6 @@ func (cons *Kafka) Configure(conf core.PluginConfigReader) {
 	cons.config.Consumer.Fetch.Default = int32(conf.GetInt("DefaultFetchSizeByte", 32768))
 	cons.config.Consumer.MaxWaitTime = time.Duration(conf.GetInt("FetchTimeoutMs", 250)) * time.Millisecond
 
-	if cons.group != "" {
-		cons.offsetFile = "" // forcibly ignore this option
-		switch cons.config.Version {
-		case kafka.V0_8_2_0, kafka.V0_8_2_1, kafka.V0_8_2_2:
-			cons.Logger.Warning("Invalid kafka version 0.8.x given, minimum is 0.9 for consumer groups, defaulting to 0.9.0.1")
-			cons.config.Version = kafka.V0_9_0_1
-		}
-
-		cons.groupConfig = cluster.NewConfig()
-		cons.groupConfig.Config = *cons.config
-	}
-
 	offsetValue := strings.ToLower(conf.GetString("DefaultOffset", kafkaOffsetNewest))
 	switch offsetValue {
 	case kafkaOffsetNewest:
@@ -343,6 +437,19 @@ func (cons *Kafka) Configure(conf core.PluginConfigReader) {
 		cons.defaultOffset, _ = strconv.ParseInt(offsetValue, 10, 64)
 	}
 
+	if len(cons.topics) == 0 && cons.topicRegex == "" {
+		cons.topics = []string{cons.topic}
+	}
+
+	if cons.group != "" {
+		cons.offsetFile = "" // forcibly ignore this option
+		cons.configureGroup(conf)
+	} else if len(cons.topics) > 1 || cons.topicRegex != "" {
+		conf.Errors.Pushf("Topics and TopicRegex require GroupId to be set")
+	} else {
+		cons.topic = cons.topics[0]
+	}
+
 	if cons.offsetFile != "" {
 		fileContents, err := ioutil.ReadFile(cons.offsetFile)
 		if err != nil {
@@ -366,7 +473,53 @@ func (cons *Kafka) Configure(conf core.PluginConfigReader) {
 		}
 	}
 
-	kafka.Logger = cons.Logger.WithField("Scope", "Sarama")
+}
+
+func (cons *Kafka) configureGroup(conf core.PluginConfigReader) {
+	switch cons.config.Version {
+	case kafka.V0_8_2_0, kafka.V0_8_2_1, kafka.V0_8_2_2:
+		cons.Logger.Warning("Invalid kafka version 0.8.x given, minimum is 0.9 for consumer groups, defaulting to 0.9.0.1")
+		cons.config.Version = kafka.V0_9_0_1
+	}
+
+	cons.groupConfig = cluster.NewConfig()
+	cons.groupConfig.Config = *cons.config
+	cons.groupConfig.Consumer.Return.Errors = true
+	cons.groupConfig.Consumer.Offsets.CommitInterval = time.Duration(conf.GetInt("GroupCommitIntervalMs", 1000)) * time.Millisecond
+	cons.groupConfig.Group.Session.Timeout = time.Duration(conf.GetInt("GroupSessionTimeoutMs", 30000)) * time.Millisecond
+	cons.groupConfig.Group.Heartbeat.Interval = time.Duration(conf.GetInt("GroupHeartbeatMs", 3000)) * time.Millisecond
+	cons.groupConfig.Group.Return.Notifications = true
+
+	if cons.defaultOffset == kafka.OffsetOldest || cons.defaultOffset == kafka.OffsetNewest {
+		cons.groupConfig.Consumer.Offsets.Initial = cons.defaultOffset
+	}
+
+	switch cons.groupStrategy = strings.ToLower(conf.GetString("GroupStrategy", "range")); cons.groupStrategy {
+	case kafkaGroupStrategyCooperative:
+	case "range":
+		cons.groupConfig.Group.PartitionStrategy = cluster.StrategyRange
+	case "roundrobin":
+		cons.groupConfig.Group.PartitionStrategy = cluster.StrategyRoundRobin
+	default:
+		conf.Errors.Pushf("Unknown GroupStrategy '%s'", cons.groupStrategy)
+	}
+
+	if cons.topicRegex != "" {
+		whitelist, err := regexp.Compile(cons.topicRegex)
+		if conf.Errors.Push(err) {
+			return
+		}
+		cons.topicFilter = whitelist
+		cons.groupConfig.Group.Topics.Whitelist = whitelist
+		cons.groupConfig.Metadata.Full = true
+	}
+}
+
+// isGroupClosed returns true once closeClients has been called for the group
+// client. cluster.Client.Closed cannot be used by workers as it is not
+// synchronized with Close.
+func (cons *Kafka) isGroupClosed() bool {
+	return atomic.LoadInt32(cons.groupClosed) != 0
 }
 
 func (cons *Kafka) restartGroup() {
@@ -376,17 +529,21 @@ func (cons *Kafka) restartGroup() {
 
 // Main fetch loop for kafka events
 func (cons *Kafka) readFromGroup() {
-	consumer, err := cluster.NewConsumerFromClient(cons.groupClient, cons.group, []string{cons.topic})
+	if cons.isGroupClosed() {
+		return // ### return, consumer stopped ###
+	}
+
+	consumer, err := cluster.NewConsumerFromClient(cons.groupClient, cons.group, cons.topics)
 	if err != nil {
 		defer cons.restartGroup()
-		cons.Logger.Errorf("Restarting kafka consumer (%s:%s) - %s", cons.topic, cons.group, err.Error())
+		cons.Logger.Errorf("Restarting kafka consumer (%s:%s) - %s", strings.Join(cons.topics, ","), cons.group, err.Error())
 		return // ### return, stop and retry ###
 	}
 
 	// Make sure we wait for all consumers to end
 	cons.AddWorker()
 	defer func() {
-		if !cons.groupClient.Closed() {
+		if !cons.isGroupClosed() {
 			consumer.Close()
 		}
 		cons.WorkerDone()
@@ -395,14 +552,26 @@ func (cons *Kafka) readFromGroup() {
 	// Loop over worker
 	spin := tsync.NewSpinner(tsync.SpinPriorityLow)
 
-	for !cons.groupClient.Closed() {
+	for !cons.isGroupClosed() {
 		select {
 		case event, ok := <-consumer.Messages():
-			if ok {
+			if !ok {
+				continue
+			}
+			if cons.commitOnAck {
+				cons.enqueueAcknowledged(event, func(offset int64) {
+					consumer.MarkPartitionOffset(event.Topic, event.Partition, offset, "")
+				})
+			} else {
 				cons.enqueueEvent(event)
 				consumer.MarkOffset(event, "")
 			}
 
+		case notification, ok := <-consumer.Notifications():
+			if ok {
+				cons.onRebalance(notification)
+			}
+
 		case err := <-consumer.Errors():
 			defer cons.restartGroup()
 			cons.Logger.Error("Kafka consumer error:", err)
@@ -414,6 +583,27 @@ func (cons *Kafka) readFromGroup() {
 	}
 }
 
+// onRebalance logs changes of the partitions assigned to this consumer.
+// Pending acknowledgements of released partitions are dropped, as offsets
+// of these partitions are committed by their new owner.
+func (cons *Kafka) onRebalance(notification *cluster.Notification) {
+	switch notification.Type {
+	case cluster.RebalanceStart:
+		cons.Logger.Info("Consumer group rebalance started")
+
+	case cluster.RebalanceError:
+		cons.Logger.Warning("Consumer group rebalance failed")
+
+	case cluster.RebalanceOK:
+		cons.Logger.WithField("Partitions", notification.Current).Info("Consumer group rebalance done")
+		for topic, partitions := range notification.Released {
+			for _, partition := range partitions {
+				cons.offsetTrackers.Delete(kafkaPartition{topic, partition})
+			}
+		}
+	}
+}
+
 func (cons *Kafka) startConsumerForPartition(partitionID int32) kafka.PartitionConsumer {
 	for !cons.client.Closed() {
 		startOffset := atomic.LoadInt64(cons.offsets[partitionID])
@@ -463,8 +653,7 @@ func (cons *Kafka) readFromPartition(partitionID int32) {
 				continue
 			}
 
-			atomic.StoreInt64(cons.offsets[partitionID], event.Offset)
-			cons.enqueueEvent(event)
+			cons.enqueueAndStoreOffset(event)
 
 		case err := <-partCons.Errors():
 			cons.Logger.Error("Kafka consumer error:", err)
@@ -501,8 +690,7 @@ func (cons *Kafka) readPartitions(partitions []int32) {
 
 			select {
 			case event := <-consumer.Messages():
-				atomic.StoreInt64(cons.offsets[partition], event.Offset)
-				cons.enqueueEvent(event)
+				cons.enqueueAndStoreOffset(event)
 
 			case err := <-consumer.Errors():
 				cons.Logger.Error("Kafka consumer error:", err)
@@ -521,16 +709,87 @@ func (cons *Kafka) readPartitions(partitions []int32) {
 }
 
 func (cons *Kafka) enqueueEvent(event *kafka.ConsumerMessage) {
-	if cons.hasToSetMetadata {
-		metaData := core.NewMetadata()
+	cons.EnqueueWithMetadata(event.Value, cons.newMetadata(event))
+}
 
+func (cons *Kafka) newMetadata(event *kafka.ConsumerMessage) tcontainer.MarshalMap {
+	var metaData tcontainer.MarshalMap
+	if cons.hasToSetMetadata {
+		metaData = core.NewMetadata()
 		metaData.Set("topic", event.Topic)
 		metaData.Set("key", event.Key)
+		metaData.Set("partition", event.Partition)
+		metaData.Set("offset", event.Offset)
+		if !event.Timestamp.IsZero() {
+			metaData.Set("timestamp", event.Timestamp.UnixNano()/int64(time.Millisecond))
+		}
+		for _, header := range event.Headers {
+			if header != nil {
+				metaData.Set(cons.headerPrefix+string(header.Key), string(header.Value))
+			}
+		}
+	}
 
-		cons.EnqueueWithMetadata(event.Value, metaData)
-	} else {
-		cons.SimpleConsumer.Enqueue(event.Value)
+	// Continue traces passed via record headers
+	for _, header := range event.Headers {
+		if header != nil && string(header.Key) == core.TraceParentMetadataKey {
+			if metaData == nil {
+				metaData = core.NewMetadata()
+			}
+			metaData.Set(core.TraceParentMetadataKey, string(header.Value))
+		}
 	}
+	return metaData
+}
+
+// enqueueAndStoreOffset enqueues an event read from a partition and updates
+// the offset of this partition. If CommitOnAck is set, the offset is updated
+// after the message has been acknowledged.
+func (cons *Kafka) enqueueAndStoreOffset(event *kafka.ConsumerMessage) {
+	offset := cons.offsets[event.Partition]
+	if !cons.commitOnAck {
+		atomic.StoreInt64(offset, event.Offset)
+		cons.enqueueEvent(event)
+		return // ### return, no acknowledge ###
+	}
+
+	cons.enqueueAcknowledged(event, func(commit int64) {
+		// Commits may be reported out of order, so only move forward
+		for current := atomic.LoadInt64(offset); commit > current; current = atomic.LoadInt64(offset) {
+			if atomic.CompareAndSwapInt64(offset, current, commit) {
+				return
+			}
+		}
+	})
+}
+
+// enqueueAcknowledged enqueues an event and calls commit with the highest
+// offset of the event's partition that has been delivered, including all
+// offsets before it.
+func (cons *Kafka) enqueueAcknowledged(event *kafka.ConsumerMessage, commit func(offset int64)) {
+	tracker := cons.getOffsetTracker(event.Topic, event.Partition, event.Offset)
+	tracker.Track(event.Offset)
+
+	offset := event.Offset
+	partition := event.Partition
+
+	cons.EnqueueWithAcknowledge(event.Value, cons.newMetadata(event), func(success bool) {
+		if !success {
+			cons.Logger.Warningf("Message at offset %d of partition %d was not delivered", offset, partition)
+		}
+		if commitOffset, advanced := tracker.Done(offset, success); advanced {
+			commit(commitOffset)
+		}
+	})
+}
+
+func (cons *Kafka) getOffsetTracker(topic string, partition int32, offset int64) *core.OffsetTracker {
+	key := kafkaPartition{topic, partition}
+	if tracker, exists := cons.offsetTrackers.Load(key); exists {
+		return tracker.(*core.OffsetTracker)
+	}
+	tracker, _ := cons.offsetTrackers.LoadOrStore(key, core.NewOffsetTracker(offset-1))
+	return tracker.(*core.OffsetTracker)
 }
 
 func (cons *Kafka) startReadTopic(topic string) {
@@ -564,6 +823,22 @@ func (cons *Kafka) startReadTopic(topic string) {
 func (cons *Kafka) startAllConsumers() error {
 	var err error
 
+	if cons.group != "" && cons.groupStrategy == kafkaGroupStrategyCooperative {
+		cons.client, err = kafka.NewClient(cons.servers, &cons.groupConfig.Config)
+		if err != nil {
+			return err
+		}
+
+		cons.groupMember, err = newKafkaGroupMember(cons, cons.client)
+		if err != nil {
+			cons.client.Close()
+			return err
+		}
+
+		go cons.groupMember.run()
+		return nil // ### return, group processing ###
+	}
+
 	if cons.group != "" {
 		cons.groupClient, err = cluster.NewClient(cons.servers, cons.groupConfig)
 		if err != nil {
@@ -626,9 +901,23 @@ func (cons *Kafka) Consume(workers *sync.WaitGroup) {
 	}
 
 	defer func() {
-		cons.client.Close()
+		cons.closeClients()
 		cons.dumpIndex()
 	}()
 
 	cons.TickerControlLoop(cons.persistTimeout, cons.dumpIndex)
 }
+
+// closeClients leaves the consumer group and closes all kafka connections.
+func (cons *Kafka) closeClients() {
+	switch {
+	case cons.groupMember != nil:
+		cons.groupMember.close()
+		cons.client.Close()
+	case cons.groupClient != nil:
+		atomic.StoreInt32(cons.groupClosed, 1)
+		cons.groupClient.Close()
+	default:
+		cons.client.Close()
+	}
+}
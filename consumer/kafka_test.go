@@ -0,0 +1,233 @@
+// Copyright 2015-2018 trivago N.V.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consumer
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	kafka "github.com/Shopify/sarama"
+	"github.com/trivago/gollum/core"
+	"github.com/trivago/tgo/ttesting"
+)
+
+type kafkaTestRouter struct {
+	core.SimpleRouter
+	messages chan *core.Message
+}
+
+func (router *kafkaTestRouter) Enqueue(msg *core.Message) error {
+	router.messages <- msg
+	return nil
+}
+
+func (router *kafkaTestRouter) Start() error {
+	return nil
+}
+
+func newKafkaTestRouter(stream string) *kafkaTestRouter {
+	streamID := core.StreamRegistry.GetStreamID(stream)
+	router := &kafkaTestRouter{messages: make(chan *core.Message, 16)}
+	core.StreamRegistry.Unregister(streamID)
+	core.StreamRegistry.Register(router, streamID)
+	return router
+}
+
+// newKafkaGroupBroker starts a mock broker acting as group coordinator that
+// assigns partition 0 of the given topics to the member "member". Responses
+// use the protocol versions of kafka 0.10.0.
+func newKafkaGroupBroker(t *testing.T, group string, topics ...string) *kafka.MockBroker {
+	broker := kafka.NewMockBroker(t, 1)
+	broker.SetHandlerByMap(newKafkaGroupHandlers(t, broker, group, topics...))
+	return broker
+}
+
+// newKafkaGroupHandlers returns the handlers used by newKafkaGroupBroker.
+func newKafkaGroupHandlers(t *testing.T, broker *kafka.MockBroker, group string, topics ...string) map[string]kafka.MockResponse {
+	assignment := &kafka.ConsumerGroupMemberAssignment{
+		Version: 1,
+		Topics:  make(map[string][]int32),
+	}
+	metadata := kafka.NewMockMetadataResponse(t).SetBroker(broker.Addr(), broker.BrokerID())
+	offsets := kafka.NewMockOffsetResponse(t)
+	committed := kafka.NewMockOffsetFetchResponse(t)
+
+	for _, topic := range topics {
+		assignment.Topics[topic] = []int32{0}
+		metadata.SetLeader(topic, 0, broker.BrokerID())
+		offsets.SetOffset(topic, 0, kafka.OffsetOldest, 0).SetOffset(topic, 0, kafka.OffsetNewest, 2)
+		committed.SetOffset(group, topic, 0, -1, "", kafka.ErrNoError)
+	}
+
+	sync := &kafka.SyncGroupRequest{}
+	sync.AddGroupAssignmentMember("member", assignment)
+
+	return map[string]kafka.MockResponse{
+		"MetadataRequest":        metadata,
+		"FindCoordinatorRequest": kafka.NewMockFindCoordinatorResponse(t).SetCoordinator(kafka.CoordinatorGroup, group, broker),
+		"JoinGroupRequest": kafka.NewMockWrapper(&kafka.JoinGroupResponse{
+			GenerationId:  1,
+			GroupProtocol: "range",
+			LeaderId:      "leader",
+			MemberId:      "member",
+		}),
+		"SyncGroupRequest":    kafka.NewMockWrapper(&kafka.SyncGroupResponse{MemberAssignment: sync.GroupAssignments["member"]}),
+		"HeartbeatRequest":    kafka.NewMockWrapper(&kafka.HeartbeatResponse{}),
+		"LeaveGroupRequest":   kafka.NewMockWrapper(&kafka.LeaveGroupResponse{}),
+		"OffsetFetchRequest":  committed,
+		"OffsetRequest":       offsets,
+		"OffsetCommitRequest": kafka.NewMockOffsetCommitResponse(t),
+		"FetchRequest": kafka.NewMockFetchResponse(t, 1).SetVersion(2).
+			SetMessage(topics[0], 0, 0, kafka.StringEncoder("first")).
+			SetMessage(topics[0], 0, 1, kafka.StringEncoder("second")).
+			SetHighWaterMark(topics[0], 0, 2),
+	}
+}
+
+// getKafkaCommits returns the last offsets committed to partition 0 of the
+// given topics.
+func getKafkaCommits(broker *kafka.MockBroker, topics ...string) map[string]int64 {
+	commits := make(map[string]int64)
+	for _, call := range broker.History() {
+		if req, isCommit := call.Request.(*kafka.OffsetCommitRequest); isCommit {
+			for _, topic := range topics {
+				if offset, _, err := req.Offset(topic, 0); err == nil {
+					commits[topic] = offset
+				}
+			}
+		}
+	}
+	return commits
+}
+
+func TestKafkaConsumerGroup(t *testing.T) {
+	for _, strategy := range []string{"cooperative", "range"} {
+		t.Run(strategy, func(t *testing.T) {
+			testKafkaConsumerGroup(t, strategy)
+		})
+	}
+}
+
+func testKafkaConsumerGroup(t *testing.T, strategy string) {
+	expect := ttesting.NewExpect(t)
+	router := newKafkaTestRouter("kafkaGroupTest")
+	broker := newKafkaGroupBroker(t, "gollumTest", "topicA", "topicB")
+	defer broker.Close()
+
+	conf := core.NewPluginConfig("", "consumer.Kafka")
+	conf.Override("Streams", "kafkaGroupTest")
+	conf.Override("Servers", []string{broker.Addr()})
+	conf.Override("GroupId", "gollumTest")
+	conf.Override("GroupStrategy", strategy)
+	conf.Override("Topics", []string{"topicA", "topicB"})
+	conf.Override("Version", "0.10.0")
+	conf.Override("DefaultOffset", "oldest")
+	conf.Override("CommitOnAck", true)
+	conf.Override("SetMetadata", true)
+	conf.Override("GroupCommitIntervalMs", 50)
+	conf.Override("FetchTimeoutMs", 10)
+
+	plugin, err := core.NewPluginWithConfig(conf)
+	expect.NoError(err)
+	cons := plugin.(*Kafka)
+	cons.SetWorkerWaitGroup(new(sync.WaitGroup))
+	expect.NoError(cons.startAllConsumers())
+	defer cons.closeClients()
+
+	messages := []*core.Message{}
+	for len(messages) < 2 {
+		select {
+		case msg := <-router.messages:
+			messages = append(messages, msg)
+		case <-time.After(5 * time.Second):
+			t.Fatal("No message received")
+		}
+	}
+
+	expect.Equal("first", messages[0].String())
+	expect.Equal("second", messages[1].String())
+	metadata := messages[1].GetMetadata()
+	topic, _ := metadata.String("topic")
+	expect.Equal("topicA", topic)
+	partition, _ := metadata.Int("partition")
+	expect.Equal(int64(0), partition)
+	offset, _ := metadata.Int("offset")
+	expect.Equal(int64(1), offset)
+
+	// Only delivered offsets are committed
+	messages[1].Ack()
+	time.Sleep(200 * time.Millisecond)
+	expect.Equal(0, len(getKafkaCommits(broker, "topicA")))
+
+	messages[0].Ack()
+	for start := time.Now(); len(getKafkaCommits(broker, "topicA")) == 0 && time.Since(start) < 5*time.Second; {
+		time.Sleep(10 * time.Millisecond)
+	}
+	expect.Equal(int64(2), getKafkaCommits(broker, "topicA")["topicA"])
+}
+
+func TestKafkaTopicsRequireGroup(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	conf := core.NewPluginConfig("", "consumer.Kafka")
+	conf.Override("Topics", []string{"topicA", "topicB"})
+	_, err := core.NewPluginWithConfig(conf)
+	expect.NotNil(err)
+
+	conf = core.NewPluginConfig("", "consumer.Kafka")
+	conf.Override("GroupId", "test")
+	conf.Override("TopicRegex", "^logs-")
+	plugin, err := core.NewPluginWithConfig(conf)
+	expect.NoError(err)
+
+	// The cooperative protocol is not compatible with existing groups
+	expect.Equal("range", plugin.(*Kafka).groupStrategy)
+}
+
+func TestKafkaHeaderMetadata(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	conf := core.NewPluginConfig("", "consumer.Kafka")
+	conf.Override("SetMetadata", true)
+	conf.Override("HeaderPrefix", "h_")
+	plugin, err := core.NewPluginWithConfig(conf)
+	expect.NoError(err)
+	cons := plugin.(*Kafka)
+
+	event := &kafka.ConsumerMessage{
+		Topic:     "test",
+		Timestamp: time.Unix(1500000000, 123*int64(time.Millisecond)),
+		Headers: []*kafka.RecordHeader{
+			{Key: []byte("schema"), Value: []byte("42")},
+			{Key: []byte(core.TraceParentMetadataKey), Value: []byte("00-trace")},
+		},
+	}
+
+	metadata := cons.newMetadata(event)
+	timestamp, _ := metadata.Int("timestamp")
+	expect.Equal(int64(1500000000123), timestamp)
+	schema, _ := metadata.String("h_schema")
+	expect.Equal("42", schema)
+	trace, _ := metadata.String(core.TraceParentMetadataKey)
+	expect.Equal("00-trace", trace)
+
+	cons.hasToSetMetadata = false
+	metadata = cons.newMetadata(event)
+	_, hasHeader := metadata.Value("h_schema")
+	expect.False(hasHeader)
+	_, hasTimestamp := metadata.Value("timestamp")
+	expect.False(hasTimestamp)
+}
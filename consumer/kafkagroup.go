@@ -0,0 +1,800 @@
+// Copyright 2015-2018 trivago N.V.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consumer
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	kafka "github.com/Shopify/sarama"
+)
+
+const (
+	// kafkaGroupStrategyCooperative selects the cooperative group protocol
+	// implemented by kafkaGroupMember.
+	kafkaGroupStrategyCooperative = "cooperative"
+	// kafkaGroupProtocol is the name of the partition assignment protocol
+	// announced when joining a group with the cooperative strategy.
+	kafkaGroupProtocol = "gollum-cooperative"
+)
+
+// kafkaGroupMember implements consumer group membership with incremental
+// cooperative rebalancing. Members keep reading their partitions while a
+// rebalance is in progress and only stop reading partitions that have been
+// assigned to another member. Partitions moving between members are revoked
+// from their owner in a first rebalance and assigned to the new owner in a
+// second one, so a partition is never read by two members at the same time.
+type kafkaGroupMember struct {
+	cons       *Kafka
+	client     kafka.Client
+	consumer   kafka.Consumer
+	memberID   string
+	generation int32
+	topics     []string
+	counts     map[string]int
+	owned      map[kafkaPartition]*kafkaGroupPartition
+	guard      sync.Mutex
+	done       chan struct{}
+	stopped    chan struct{}
+}
+
+// kafkaGroupPartition is a partition owned by a kafkaGroupMember.
+type kafkaGroupPartition struct {
+	consumer  kafka.PartitionConsumer
+	next      int64
+	committed int64
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+// kafkaGroupSubscription is sent as user data when joining a group. It
+// contains the partitions owned by a member and the generation they have been
+// assigned in. The subscribed topics are part of the group protocol metadata.
+type kafkaGroupSubscription struct {
+	Generation int32              `json:"generation"`
+	Owned      map[string][]int32 `json:"owned"`
+	topics     []string
+}
+
+func newKafkaGroupMember(cons *Kafka, client kafka.Client) (*kafkaGroupMember, error) {
+	consumer, err := kafka.NewConsumerFromClient(client)
+	if err != nil {
+		return nil, err
+	}
+
+	return &kafkaGroupMember{
+		cons:     cons,
+		client:   client,
+		consumer: consumer,
+		owned:    make(map[kafkaPartition]*kafkaGroupPartition),
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}, nil
+}
+
+// run joins the group and reads the assigned partitions until close is called.
+func (member *kafkaGroupMember) run() {
+	member.cons.AddWorker()
+	defer member.cons.WorkerDone()
+	defer close(member.stopped)
+	defer member.leave()
+
+	for !member.isClosed() {
+		rejoin, err := member.join()
+		switch {
+		case member.isClosed():
+			return // ### return, stopped ###
+
+		case err != nil:
+			member.cons.Logger.WithError(err).Errorf("Failed to join consumer group %s", member.cons.group)
+			select {
+			case <-member.done:
+			case <-time.After(member.cons.persistTimeout):
+			}
+
+		case !rejoin:
+			member.heartbeatLoop()
+		}
+	}
+}
+
+// close stops reading all partitions, commits their offsets and leaves the
+// group. This function blocks until the member has left the group.
+func (member *kafkaGroupMember) close() {
+	close(member.done)
+	<-member.stopped
+}
+
+func (member *kafkaGroupMember) isClosed() bool {
+	select {
+	case <-member.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// getCoordinator returns the coordinator of the group.
+func (member *kafkaGroupMember) getCoordinator() (*kafka.Broker, error) {
+	return member.client.Coordinator(member.cons.group)
+}
+
+// resetCoordinator makes sure the coordinator is looked up again if a
+// request failed because of the coordinator.
+func (member *kafkaGroupMember) resetCoordinator(coordinator *kafka.Broker, err error) {
+	switch err {
+	case kafka.ErrConsumerCoordinatorNotAvailable, kafka.ErrNotCoordinatorForConsumer:
+		member.client.RefreshCoordinator(member.cons.group)
+	default:
+		if _, isKafkaError := err.(kafka.KError); !isKafkaError {
+			coordinator.Close()
+			member.client.RefreshCoordinator(member.cons.group)
+		}
+	}
+}
+
+// getTopics returns the sorted list of topics this member subscribes to.
+func (member *kafkaGroupMember) getTopics() []string {
+	topics := append([]string{}, member.cons.topics...)
+	if member.cons.topicFilter != nil {
+		if allTopics, err := member.client.Topics(); err == nil {
+			for _, topic := range allTopics {
+				if member.cons.topicFilter.MatchString(topic) {
+					topics = append(topics, topic)
+				}
+			}
+		}
+	}
+
+	sort.Strings(topics)
+	unique := topics[:0]
+	for i, topic := range topics {
+		if i == 0 || topics[i-1] != topic {
+			unique = append(unique, topic)
+		}
+	}
+	return unique
+}
+
+// getPartitionCounts returns the number of partitions of the given topics.
+func (member *kafkaGroupMember) getPartitionCounts(topics []string) map[string]int {
+	counts := make(map[string]int)
+	for _, topic := range topics {
+		partitions, _ := member.client.Partitions(topic)
+		counts[topic] = len(partitions)
+	}
+	return counts
+}
+
+// subscriptionChanged returns true if topics matching TopicRegex or the
+// number of partitions of a subscribed topic changed since the last join.
+func (member *kafkaGroupMember) subscriptionChanged() bool {
+	topics := member.getTopics()
+	if len(topics) != len(member.topics) {
+		return true
+	}
+	counts := member.getPartitionCounts(topics)
+	for i, topic := range topics {
+		if member.topics[i] != topic || member.counts[topic] != counts[topic] {
+			return true
+		}
+	}
+	return false
+}
+
+// join joins the group, i.e. it takes part in a rebalance. Partitions owned
+// by this member are read during the rebalance. The return value is true if
+// partitions have been revoked and the group has to be rebalanced again so
+// that these partitions can be assigned to their new owner.
+func (member *kafkaGroupMember) join() (bool, error) {
+	coordinator, err := member.getCoordinator()
+	if err != nil {
+		return false, err
+	}
+
+	member.topics = member.getTopics()
+	member.counts = member.getPartitionCounts(member.topics)
+
+	groupConfig := member.cons.groupConfig.Group
+	joinRequest := &kafka.JoinGroupRequest{
+		GroupId:        member.cons.group,
+		SessionTimeout: int32(groupConfig.Session.Timeout / time.Millisecond),
+		MemberId:       member.memberID,
+		ProtocolType:   "consumer",
+	}
+	if member.cons.groupConfig.Version.IsAtLeast(kafka.V0_10_1_0) {
+		joinRequest.Version = 1
+		joinRequest.RebalanceTimeout = joinRequest.SessionTimeout
+	}
+
+	userData, err := json.Marshal(member.getSubscription())
+	if err != nil {
+		return false, err
+	}
+	if err := joinRequest.AddGroupProtocolMetadata(kafkaGroupProtocol, &kafka.ConsumerGroupMemberMetadata{
+		Version:  1,
+		Topics:   member.topics,
+		UserData: userData,
+	}); err != nil {
+		return false, err
+	}
+
+	joinResponse, err := coordinator.JoinGroup(joinRequest)
+	if err != nil {
+		member.resetCoordinator(coordinator, err)
+		return false, err
+	}
+	switch joinResponse.Err {
+	case kafka.ErrNoError:
+	case kafka.ErrUnknownMemberId:
+		member.memberID = ""
+		return true, nil // ### return, join again without member id ###
+	default:
+		member.resetCoordinator(coordinator, joinResponse.Err)
+		return false, joinResponse.Err
+	}
+
+	member.memberID = joinResponse.MemberId
+	member.generation = joinResponse.GenerationId
+
+	syncRequest := &kafka.SyncGroupRequest{
+		GroupId:      member.cons.group,
+		GenerationId: member.generation,
+		MemberId:     member.memberID,
+	}
+	if joinResponse.LeaderId == joinResponse.MemberId {
+		if err := member.assign(joinResponse, syncRequest); err != nil {
+			return false, err
+		}
+	}
+
+	syncResponse, err := coordinator.SyncGroup(syncRequest)
+	if err != nil {
+		member.resetCoordinator(coordinator, err)
+		return false, err
+	}
+	switch syncResponse.Err {
+	case kafka.ErrNoError:
+	case kafka.ErrRebalanceInProgress:
+		return true, nil // ### return, another rebalance started ###
+	case kafka.ErrUnknownMemberId, kafka.ErrIllegalGeneration:
+		member.lose(syncResponse.Err)
+		return true, nil // ### return, join again ###
+	default:
+		member.resetCoordinator(coordinator, syncResponse.Err)
+		return false, syncResponse.Err
+	}
+
+	assigned := make(map[kafkaPartition]bool)
+	if len(syncResponse.MemberAssignment) > 0 {
+		assignment, err := syncResponse.GetMemberAssignment()
+		if err != nil {
+			return false, err
+		}
+		for topic, partitions := range assignment.Topics {
+			for _, partition := range partitions {
+				assigned[kafkaPartition{topic, partition}] = true
+			}
+		}
+	}
+
+	return member.apply(assigned)
+}
+
+// getSubscription returns the user data sent when joining the group.
+func (member *kafkaGroupMember) getSubscription() kafkaGroupSubscription {
+	member.guard.Lock()
+	defer member.guard.Unlock()
+
+	subscription := kafkaGroupSubscription{
+		Generation: member.generation,
+		Owned:      make(map[string][]int32),
+	}
+	for key := range member.owned {
+		subscription.Owned[key.topic] = append(subscription.Owned[key.topic], key.partition)
+	}
+	return subscription
+}
+
+// assign is called if this member is the group leader. It computes the
+// assignment for all members and adds it to the given sync request.
+func (member *kafkaGroupMember) assign(joinResponse *kafka.JoinGroupResponse, syncRequest *kafka.SyncGroupRequest) error {
+	members, err := joinResponse.GetMembers()
+	if err != nil {
+		return err
+	}
+
+	subscriptions := make(map[string]kafkaGroupSubscription)
+	topics := []string{}
+	for memberID, metadata := range members {
+		subscription := kafkaGroupSubscription{}
+		if len(metadata.UserData) > 0 {
+			if err := json.Unmarshal(metadata.UserData, &subscription); err != nil {
+				member.cons.Logger.WithError(err).Warningf("Ignoring owned partitions of group member %s", memberID)
+				subscription = kafkaGroupSubscription{}
+			}
+		}
+		subscription.topics = metadata.Topics
+		subscriptions[memberID] = subscription
+		topics = append(topics, metadata.Topics...)
+	}
+
+	member.client.RefreshMetadata(topics...)
+	partitions := make(map[string][]int32)
+	for _, topic := range topics {
+		if topicPartitions, err := member.client.Partitions(topic); err == nil {
+			partitions[topic] = topicPartitions
+		}
+	}
+
+	for memberID, assignment := range assignKafkaPartitions(subscriptions, partitions) {
+		if err := syncRequest.AddGroupAssignmentMember(memberID, &kafka.ConsumerGroupMemberAssignment{
+			Version: 1,
+			Topics:  assignment,
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// apply starts reading newly assigned partitions and stops reading
+// partitions that have been revoked. Partitions assigned before and after
+// the rebalance are not touched. True is returned if partitions have been
+// revoked.
+func (member *kafkaGroupMember) apply(assigned map[kafkaPartition]bool) (bool, error) {
+	member.guard.Lock()
+	defer member.guard.Unlock()
+
+	revoked := make(map[kafkaPartition]*kafkaGroupPartition)
+	for key, part := range member.owned {
+		if !assigned[key] {
+			revoked[key] = part
+		}
+	}
+	for _, part := range revoked {
+		part.close()
+	}
+	member.commitPartitions(revoked)
+	for key := range revoked {
+		delete(member.owned, key)
+		member.cons.offsetTrackers.Delete(key)
+	}
+
+	added := []kafkaPartition{}
+	for key := range assigned {
+		if _, isOwned := member.owned[key]; !isOwned {
+			added = append(added, key)
+		}
+	}
+
+	err := member.startPartitions(added)
+
+	member.cons.Logger.WithField("Partitions", member.getOwnedNames()).
+		WithField("Generation", member.generation).
+		Infof("Consumer group rebalance done (%d added, %d revoked)", len(added), len(revoked))
+
+	return len(revoked) > 0, err
+}
+
+// getOwnedNames returns the owned partitions in the form "topic:partition".
+// The caller is expected to hold the guard.
+func (member *kafkaGroupMember) getOwnedNames() []string {
+	names := make([]string, 0, len(member.owned))
+	for key := range member.owned {
+		names = append(names, fmt.Sprintf("%s:%d", key.topic, key.partition))
+	}
+	sort.Strings(names)
+	return names
+}
+
+// startPartitions fetches the committed offsets of the given partitions and
+// starts reading them. The caller is expected to hold the guard.
+func (member *kafkaGroupMember) startPartitions(partitions []kafkaPartition) error {
+	if len(partitions) == 0 {
+		return nil // ### return, nothing to do ###
+	}
+
+	coordinator, err := member.getCoordinator()
+	if err != nil {
+		return err
+	}
+
+	request := &kafka.OffsetFetchRequest{
+		Version:       1,
+		ConsumerGroup: member.cons.group,
+	}
+	for _, key := range partitions {
+		request.AddPartition(key.topic, key.partition)
+	}
+
+	response, err := coordinator.FetchOffset(request)
+	if err != nil {
+		member.resetCoordinator(coordinator, err)
+		return err
+	}
+
+	initialOffset := member.cons.groupConfig.Consumer.Offsets.Initial
+	for _, key := range partitions {
+		offset := int64(-1)
+		if block := response.GetBlock(key.topic, key.partition); block != nil && block.Err == kafka.ErrNoError {
+			offset = block.Offset
+		}
+		if offset < 0 {
+			offset = initialOffset
+		}
+
+		consumer, err := member.consumer.ConsumePartition(key.topic, key.partition, offset)
+		if err == kafka.ErrOffsetOutOfRange {
+			consumer, err = member.consumer.ConsumePartition(key.topic, key.partition, initialOffset)
+		}
+		if err != nil {
+			return err
+		}
+
+		part := &kafkaGroupPartition{
+			consumer:  consumer,
+			next:      -1,
+			committed: -1,
+			stop:      make(chan struct{}),
+			done:      make(chan struct{}),
+		}
+		member.owned[key] = part
+		go member.readPartition(part)
+	}
+	return nil
+}
+
+// readPartition enqueues all messages read from the given partition until
+// the partition is closed.
+func (member *kafkaGroupMember) readPartition(part *kafkaGroupPartition) {
+	member.cons.AddWorker()
+	defer member.cons.WorkerDone()
+	defer close(part.done)
+
+	for {
+		select {
+		case <-part.stop:
+			return // ### return, partition revoked ###
+
+		case event, ok := <-part.consumer.Messages():
+			if !ok {
+				return // ### return, consumer closed ###
+			}
+			if member.cons.commitOnAck {
+				member.cons.enqueueAcknowledged(event, part.mark)
+			} else {
+				member.cons.enqueueEvent(event)
+				part.mark(event.Offset)
+			}
+
+		case err, ok := <-part.consumer.Errors():
+			if ok {
+				member.cons.Logger.Error("Kafka consumer error:", err)
+			}
+		}
+	}
+}
+
+// mark marks the given offset and all offsets before it as processed.
+func (part *kafkaGroupPartition) mark(offset int64) {
+	for next := atomic.LoadInt64(&part.next); offset+1 > next; next = atomic.LoadInt64(&part.next) {
+		if atomic.CompareAndSwapInt64(&part.next, next, offset+1) {
+			return
+		}
+	}
+}
+
+// close stops reading the partition.
+func (part *kafkaGroupPartition) close() {
+	close(part.stop)
+	<-part.done
+	part.consumer.Close()
+}
+
+// commit commits the offsets of all owned partitions.
+func (member *kafkaGroupMember) commit() {
+	member.guard.Lock()
+	defer member.guard.Unlock()
+	member.commitPartitions(member.owned)
+}
+
+// commitPartitions commits the offsets of the given partitions if they
+// changed since the last commit. The caller is expected to hold the guard.
+func (member *kafkaGroupMember) commitPartitions(partitions map[kafkaPartition]*kafkaGroupPartition) {
+	request := &kafka.OffsetCommitRequest{
+		Version:                 2,
+		ConsumerGroup:           member.cons.group,
+		ConsumerGroupGeneration: member.generation,
+		ConsumerID:              member.memberID,
+		RetentionTime:           -1,
+	}
+
+	offsets := make(map[kafkaPartition]int64)
+	for key, part := range partitions {
+		if next := atomic.LoadInt64(&part.next); next > part.committed {
+			request.AddBlock(key.topic, key.partition, next, 0, "")
+			offsets[key] = next
+		}
+	}
+	if len(offsets) == 0 {
+		return // ### return, nothing to commit ###
+	}
+
+	coordinator, err := member.getCoordinator()
+	if err != nil {
+		member.cons.Logger.WithError(err).Warning("Failed to commit offsets")
+		return // ### return, retry with next commit ###
+	}
+
+	response, err := coordinator.CommitOffset(request)
+	if err != nil {
+		member.resetCoordinator(coordinator, err)
+		member.cons.Logger.WithError(err).Warning("Failed to commit offsets")
+		return // ### return, retry with next commit ###
+	}
+
+	for key, offset := range offsets {
+		if kerr, exists := response.Errors[key.topic][key.partition]; exists && kerr != kafka.ErrNoError {
+			member.cons.Logger.WithError(kerr).Warningf("Failed to commit offset of %s:%d", key.topic, key.partition)
+			continue
+		}
+		partitions[key].committed = offset
+	}
+}
+
+// heartbeatLoop sends heartbeats and commits offsets until a rebalance is
+// required or the member is closed.
+func (member *kafkaGroupMember) heartbeatLoop() {
+	groupConfig := member.cons.groupConfig
+	heartbeat := time.NewTicker(groupConfig.Group.Heartbeat.Interval)
+	defer heartbeat.Stop()
+	commit := time.NewTicker(groupConfig.Consumer.Offsets.CommitInterval)
+	defer commit.Stop()
+
+	var refresh <-chan time.Time
+	if interval := member.cons.groupConfig.Metadata.RefreshFrequency; interval > 0 {
+		refreshTicker := time.NewTicker(interval)
+		defer refreshTicker.Stop()
+		refresh = refreshTicker.C
+	}
+
+	lastHeartbeat := time.Now()
+	for {
+		select {
+		case <-member.done:
+			return // ### return, stopped ###
+
+		case <-commit.C:
+			member.commit()
+
+		case <-refresh:
+			if member.subscriptionChanged() {
+				member.cons.Logger.Info("Consumer group subscription changed")
+				return // ### return, rebalance ###
+			}
+
+		case <-heartbeat.C:
+			switch err := member.heartbeat(); err {
+			case nil:
+				lastHeartbeat = time.Now()
+
+			case kafka.ErrRebalanceInProgress:
+				member.cons.Logger.Info("Consumer group rebalance started")
+				member.commit()
+				return // ### return, rebalance ###
+
+			case kafka.ErrUnknownMemberId, kafka.ErrIllegalGeneration:
+				member.lose(err)
+				return // ### return, rejoin ###
+
+			default:
+				member.cons.Logger.WithError(err).Warning("Consumer group heartbeat failed")
+				if time.Since(lastHeartbeat) > groupConfig.Group.Session.Timeout {
+					member.lose(err)
+					return // ### return, rejoin ###
+				}
+			}
+		}
+	}
+}
+
+// heartbeat sends a heartbeat to the group coordinator.
+func (member *kafkaGroupMember) heartbeat() error {
+	coordinator, err := member.getCoordinator()
+	if err != nil {
+		return err
+	}
+
+	response, err := coordinator.Heartbeat(&kafka.HeartbeatRequest{
+		GroupId:      member.cons.group,
+		GenerationId: member.generation,
+		MemberId:     member.memberID,
+	})
+	if err != nil {
+		member.resetCoordinator(coordinator, err)
+		return err
+	}
+	if response.Err != kafka.ErrNoError {
+		return response.Err
+	}
+	return nil
+}
+
+// lose stops reading all partitions without committing their offsets. This
+// is done if the membership has been lost, as the partitions might have been
+// assigned to other members already.
+func (member *kafkaGroupMember) lose(err error) {
+	member.cons.Logger.WithError(err).Warning("Consumer group membership lost")
+
+	member.guard.Lock()
+	defer member.guard.Unlock()
+
+	for key, part := range member.owned {
+		part.close()
+		delete(member.owned, key)
+		member.cons.offsetTrackers.Delete(key)
+	}
+	if err == kafka.ErrUnknownMemberId {
+		member.memberID = ""
+	}
+}
+
+// leave stops reading all partitions, commits their offsets and leaves the
+// group.
+func (member *kafkaGroupMember) leave() {
+	member.guard.Lock()
+	for _, part := range member.owned {
+		part.close()
+	}
+	member.commitPartitions(member.owned)
+	member.owned = make(map[kafkaPartition]*kafkaGroupPartition)
+	member.guard.Unlock()
+
+	if member.memberID == "" {
+		return // ### return, not a member ###
+	}
+
+	coordinator, err := member.getCoordinator()
+	if err == nil {
+		_, err = coordinator.LeaveGroup(&kafka.LeaveGroupRequest{
+			GroupId:  member.cons.group,
+			MemberId: member.memberID,
+		})
+	}
+	if err != nil {
+		member.cons.Logger.WithError(err).Warning("Failed to leave consumer group")
+	}
+}
+
+// assignKafkaPartitions assigns the given partitions to the members of a
+// group. Partitions stay with their current owner as long as the assignment
+// is balanced. Partitions that have to move to another member are not
+// assigned at all. Their owner will revoke them and trigger another
+// rebalance, in which they are assigned to the new member.
+func assignKafkaPartitions(subscriptions map[string]kafkaGroupSubscription, partitions map[string][]int32) map[string]map[string][]int32 {
+	memberIDs := make([]string, 0, len(subscriptions))
+	subscribers := make(map[string][]string)
+	for memberID := range subscriptions {
+		memberIDs = append(memberIDs, memberID)
+	}
+	sort.Strings(memberIDs)
+
+	for _, memberID := range memberIDs {
+		for _, topic := range subscriptions[memberID].topics {
+			subscribers[topic] = append(subscribers[topic], memberID)
+		}
+	}
+
+	allPartitions := []kafkaPartition{}
+	exists := make(map[kafkaPartition]bool)
+	for topic, topicPartitions := range partitions {
+		for _, partition := range topicPartitions {
+			key := kafkaPartition{topic, partition}
+			allPartitions = append(allPartitions, key)
+			exists[key] = true
+		}
+	}
+	sort.Slice(allPartitions, func(i, j int) bool {
+		if allPartitions[i].topic != allPartitions[j].topic {
+			return allPartitions[i].topic < allPartitions[j].topic
+		}
+		return allPartitions[i].partition < allPartitions[j].partition
+	})
+
+	// Resolve the current owners. If two members claim the same partition,
+	// the claim of the most recent generation wins.
+	owners := make(map[kafkaPartition]string)
+	generations := make(map[kafkaPartition]int32)
+	for _, memberID := range memberIDs {
+		subscription := subscriptions[memberID]
+		for topic, owned := range subscription.Owned {
+			for _, partition := range owned {
+				key := kafkaPartition{topic, partition}
+				if generation, claimed := generations[key]; exists[key] && (!claimed || subscription.Generation > generation) {
+					owners[key] = memberID
+					generations[key] = subscription.Generation
+				}
+			}
+		}
+	}
+
+	counts := make(map[string]int)
+	isSubscribed := func(memberID, topic string) bool {
+		for _, subscriber := range subscribers[topic] {
+			if subscriber == memberID {
+				return true
+			}
+		}
+		return false
+	}
+	leastLoaded := func(topic string) string {
+		best := ""
+		for _, memberID := range subscribers[topic] {
+			if best == "" || counts[memberID] < counts[best] {
+				best = memberID
+			}
+		}
+		return best
+	}
+
+	// Keep current owners, assign the rest to the least loaded members and
+	// move partitions from overloaded members afterwards.
+	targets := make(map[kafkaPartition]string)
+	for _, key := range allPartitions {
+		if owner, isOwned := owners[key]; isOwned && isSubscribed(owner, key.topic) {
+			targets[key] = owner
+			counts[owner]++
+		}
+	}
+	for _, key := range allPartitions {
+		if _, isAssigned := targets[key]; !isAssigned {
+			if memberID := leastLoaded(key.topic); memberID != "" {
+				targets[key] = memberID
+				counts[memberID]++
+			}
+		}
+	}
+	for moved := true; moved; {
+		moved = false
+		for _, key := range allPartitions {
+			current, isAssigned := targets[key]
+			best := leastLoaded(key.topic)
+			if isAssigned && counts[current] > counts[best]+1 {
+				targets[key] = best
+				counts[current]--
+				counts[best]++
+				moved = true
+			}
+		}
+	}
+
+	assignment := make(map[string]map[string][]int32)
+	for _, memberID := range memberIDs {
+		assignment[memberID] = make(map[string][]int32)
+	}
+	for _, key := range allPartitions {
+		target, isAssigned := targets[key]
+		if owner, isOwned := owners[key]; !isAssigned || (isOwned && owner != target) {
+			continue // ### continue, has to be revoked first ###
+		}
+		assignment[target][key.topic] = append(assignment[target][key.topic], key.partition)
+	}
+	return assignment
+}
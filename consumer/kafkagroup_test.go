@@ -0,0 +1,249 @@
+// Copyright 2015-2018 trivago N.V.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consumer
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	kafka "github.com/Shopify/sarama"
+	"github.com/trivago/gollum/core"
+	"github.com/trivago/tgo/ttesting"
+)
+
+func newKafkaGroupSubscription(generation int32, owned map[string][]int32, topics ...string) kafkaGroupSubscription {
+	return kafkaGroupSubscription{
+		Generation: generation,
+		Owned:      owned,
+		topics:     topics,
+	}
+}
+
+func TestKafkaAssignSingleMember(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	assignment := assignKafkaPartitions(map[string]kafkaGroupSubscription{
+		"a": newKafkaGroupSubscription(0, nil, "topicA", "topicB"),
+	}, map[string][]int32{
+		"topicA": {0, 1},
+		"topicB": {0},
+	})
+
+	expect.Equal(1, len(assignment))
+	expect.Equal([]int32{0, 1}, assignment["a"]["topicA"])
+	expect.Equal([]int32{0}, assignment["a"]["topicB"])
+}
+
+func TestKafkaAssignMemberJoins(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+	partitions := map[string][]int32{"topic": {0, 1, 2, 3}}
+
+	// Partitions moving to "b" are withheld in the first rebalance
+	assignment := assignKafkaPartitions(map[string]kafkaGroupSubscription{
+		"a": newKafkaGroupSubscription(1, map[string][]int32{"topic": {0, 1, 2, 3}}, "topic"),
+		"b": newKafkaGroupSubscription(0, nil, "topic"),
+	}, partitions)
+
+	expect.Equal(2, len(assignment))
+	expect.Equal([]int32{2, 3}, assignment["a"]["topic"])
+	expect.Equal(0, len(assignment["b"]["topic"]))
+
+	// After "a" revoked them, they are assigned to "b"
+	assignment = assignKafkaPartitions(map[string]kafkaGroupSubscription{
+		"a": newKafkaGroupSubscription(2, map[string][]int32{"topic": {2, 3}}, "topic"),
+		"b": newKafkaGroupSubscription(2, nil, "topic"),
+	}, partitions)
+
+	expect.Equal([]int32{2, 3}, assignment["a"]["topic"])
+	expect.Equal([]int32{0, 1}, assignment["b"]["topic"])
+}
+
+func TestKafkaAssignMemberLeaves(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	assignment := assignKafkaPartitions(map[string]kafkaGroupSubscription{
+		"a": newKafkaGroupSubscription(3, map[string][]int32{"topic": {0}}, "topic"),
+		"c": newKafkaGroupSubscription(3, map[string][]int32{"topic": {2}}, "topic"),
+	}, map[string][]int32{"topic": {0, 1, 2}})
+
+	expect.Equal([]int32{0, 1}, assignment["a"]["topic"])
+	expect.Equal([]int32{2}, assignment["c"]["topic"])
+}
+
+func TestKafkaAssignConflicts(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	// "a" missed a rebalance, its claim is outdated
+	assignment := assignKafkaPartitions(map[string]kafkaGroupSubscription{
+		"a": newKafkaGroupSubscription(1, map[string][]int32{"topic": {0, 1}}, "topic"),
+		"b": newKafkaGroupSubscription(2, map[string][]int32{"topic": {1}}, "topic"),
+	}, map[string][]int32{"topic": {0, 1}})
+
+	expect.Equal([]int32{0}, assignment["a"]["topic"])
+	expect.Equal([]int32{1}, assignment["b"]["topic"])
+
+	// Partitions of topics not subscribed anymore are assigned to others
+	assignment = assignKafkaPartitions(map[string]kafkaGroupSubscription{
+		"a": newKafkaGroupSubscription(1, map[string][]int32{"topicA": {0}, "topicB": {0}}, "topicA"),
+		"b": newKafkaGroupSubscription(1, nil, "topicB"),
+	}, map[string][]int32{"topicA": {0}, "topicB": {0}})
+
+	expect.Equal([]int32{0}, assignment["a"]["topicA"])
+	expect.Equal(0, len(assignment["a"]["topicB"]))
+	expect.Equal(0, len(assignment["b"]["topicB"]))
+}
+
+func encodeKafkaGroupMetadata(t *testing.T, subscription kafkaGroupSubscription) []byte {
+	userData, err := json.Marshal(subscription)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := &kafka.JoinGroupRequest{}
+	if err := request.AddGroupProtocolMetadata(kafkaGroupProtocol, &kafka.ConsumerGroupMemberMetadata{
+		Version:  1,
+		Topics:   subscription.topics,
+		UserData: userData,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return request.OrderedGroupProtocols[0].Metadata
+}
+
+func TestKafkaCooperativeLeader(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+	broker := kafka.NewMockBroker(t, 1)
+	defer broker.Close()
+
+	broker.SetHandlerByMap(map[string]kafka.MockResponse{
+		"MetadataRequest": kafka.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetLeader("topic", 0, broker.BrokerID()).
+			SetLeader("topic", 1, broker.BrokerID()),
+		"FindCoordinatorRequest": kafka.NewMockFindCoordinatorResponse(t).SetCoordinator(kafka.CoordinatorGroup, "gollumTest", broker),
+		"JoinGroupRequest": kafka.NewMockWrapper(&kafka.JoinGroupResponse{
+			GenerationId:  2,
+			GroupProtocol: kafkaGroupProtocol,
+			LeaderId:      "member",
+			MemberId:      "member",
+			Members: map[string][]byte{
+				"member": encodeKafkaGroupMetadata(t, newKafkaGroupSubscription(0, nil, "topic")),
+				"other":  encodeKafkaGroupMetadata(t, newKafkaGroupSubscription(1, map[string][]int32{"topic": {0}}, "topic")),
+			},
+		}),
+		"SyncGroupRequest":  kafka.NewMockWrapper(&kafka.SyncGroupResponse{}),
+		"HeartbeatRequest":  kafka.NewMockWrapper(&kafka.HeartbeatResponse{}),
+		"LeaveGroupRequest": kafka.NewMockWrapper(&kafka.LeaveGroupResponse{}),
+	})
+
+	conf := core.NewPluginConfig("", "consumer.Kafka")
+	conf.Override("Servers", []string{broker.Addr()})
+	conf.Override("GroupId", "gollumTest")
+	conf.Override("GroupStrategy", "cooperative")
+	conf.Override("Topic", "topic")
+	conf.Override("Version", "0.10.0")
+
+	plugin, err := core.NewPluginWithConfig(conf)
+	expect.NoError(err)
+	cons := plugin.(*Kafka)
+	cons.SetWorkerWaitGroup(new(sync.WaitGroup))
+	expect.NoError(cons.startAllConsumers())
+	defer cons.closeClients()
+
+	var request *kafka.SyncGroupRequest
+	for start := time.Now(); request == nil && time.Since(start) < 5*time.Second; {
+		time.Sleep(10 * time.Millisecond)
+		for _, call := range broker.History() {
+			if syncRequest, isSync := call.Request.(*kafka.SyncGroupRequest); isSync {
+				request = syncRequest
+			}
+		}
+	}
+	if request == nil {
+		t.Fatal("No assignment sent")
+	}
+
+	// The partition owned by "other" stays, the free one goes to "member"
+	assignments := make(map[string][]int32)
+	for memberID, data := range request.GroupAssignments {
+		assignment, err := (&kafka.SyncGroupResponse{MemberAssignment: data}).GetMemberAssignment()
+		expect.NoError(err)
+		assignments[memberID] = assignment.Topics["topic"]
+	}
+	expect.Equal([]int32{1}, assignments["member"])
+	expect.Equal([]int32{0}, assignments["other"])
+}
+
+func TestKafkaCooperativeRebalance(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+	router := newKafkaTestRouter("kafkaCooperativeTest")
+	broker := kafka.NewMockBroker(t, 1)
+	defer broker.Close()
+
+	handlers := newKafkaGroupHandlers(t, broker, "gollumTest", "topic")
+	handlers["HeartbeatRequest"] = kafka.NewMockSequence(
+		&kafka.HeartbeatResponse{Err: kafka.ErrRebalanceInProgress},
+		&kafka.HeartbeatResponse{},
+	)
+	broker.SetHandlerByMap(handlers)
+
+	conf := core.NewPluginConfig("", "consumer.Kafka")
+	conf.Override("Streams", "kafkaCooperativeTest")
+	conf.Override("Servers", []string{broker.Addr()})
+	conf.Override("GroupId", "gollumTest")
+	conf.Override("GroupStrategy", "cooperative")
+	conf.Override("Topic", "topic")
+	conf.Override("Version", "0.10.0")
+	conf.Override("DefaultOffset", "oldest")
+	conf.Override("GroupHeartbeatMs", 20)
+	conf.Override("FetchTimeoutMs", 10)
+
+	plugin, err := core.NewPluginWithConfig(conf)
+	expect.NoError(err)
+	cons := plugin.(*Kafka)
+	cons.SetWorkerWaitGroup(new(sync.WaitGroup))
+	expect.NoError(cons.startAllConsumers())
+	defer cons.closeClients()
+
+	select {
+	case <-router.messages:
+	case <-time.After(5 * time.Second):
+		t.Fatal("No message received")
+	}
+
+	cons.groupMember.guard.Lock()
+	part := cons.groupMember.owned[kafkaPartition{"topic", 0}]
+	cons.groupMember.guard.Unlock()
+	expect.NotNil(part)
+
+	// Wait for the second join, the partition must not be restarted
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(10 * time.Millisecond) {
+		joins := 0
+		for _, call := range broker.History() {
+			if _, isJoin := call.Request.(*kafka.JoinGroupRequest); isJoin {
+				joins++
+			}
+		}
+		if joins >= 2 {
+			break
+		}
+	}
+
+	cons.groupMember.guard.Lock()
+	defer cons.groupMember.guard.Unlock()
+	expect.Equal(1, len(cons.groupMember.owned))
+	expect.True(part == cons.groupMember.owned[kafkaPartition{"topic", 0}])
+}
@@ -0,0 +1,322 @@
+// Copyright 2015-2018 trivago N.V.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consumer
+
+import (
+	"encoding/base64"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/trivago/gollum/core"
+	"github.com/trivago/tgo"
+	"github.com/trivago/tgo/tio"
+)
+
+// Replay consumer plugin
+//
+// The Replay consumer reads messages stored by producer.Spooling and routes
+// them back to the stream they failed on. Together with the FallbackMetadata
+// setting of producers and producer.Spooling with Respool set to false, this
+// consumer can be used to implement a dead-letter queue that is replayed on
+// demand. Files are replayed once on startup and again whenever a roll
+// command is received, e.g. via SIGHUP or the admin API.
+//
+// Messages are routed to the stream stored in the "deadletter_stream" metadata
+// field. If this field is not set, the name of the directory containing the
+// spool file is used. Messages that cannot be assigned to a stream are sent
+// to the streams configured for this consumer.
+//
+// Parameters
+//
+// - Path: Defines the directory to read spool files from. Files matching
+// "<path>/*.spl" and "<path>/*/*.spl" are read in alphabetical order.
+// By default this parameter is set to "/var/run/gollum/spooling".
+//
+// - MaxAttempts: Defines the number of failed delivery attempts after which
+// a message is not replayed anymore. These messages are sent to the streams
+// configured for this consumer instead. Set this parameter to 0 to always
+// replay messages.
+// By default this parameter is set to 0.
+//
+// - MaxMessagesSec: Sets the maximum number of messages that will be replayed
+// per second. Setting this value to 0 will cause replaying to send as fast as
+// possible.
+// By default this parameter is set to 0.
+//
+// - MinFileAgeSec: Defines the number of seconds a spool file must not have
+// been modified before it is replayed. This value should be larger than the
+// MaxFileAgeMin setting of the producer writing the files, so that only
+// rotated files are read.
+// By default this parameter is set to 120.
+//
+// - RemoveFiles: When set to true, files are removed after they have been
+// replayed. Otherwise files are renamed to "<name>.replayed". Files containing
+// messages that could not be decoded are never removed but renamed to
+// "<name>.corrupt" after all other messages have been replayed.
+// By default this parameter is set to true.
+//
+// - BufferSizeByte: Defines the initial size of the buffer that is used to read
+// messages from a spool file. If a message is larger than this size, the buffer
+// will be resized.
+// By default this parameter is set to 8192.
+//
+// Examples
+//
+// This example stores messages that could not be delivered by the kafka
+// producer in "/var/gollum/deadletter" and replays them on SIGHUP. Messages
+// that failed 5 times are written to a separate file.
+//
+//  kafkaOut:
+//    Type: producer.Kafka
+//    Streams: logs
+//    FallbackStream: deadletter
+//    FallbackMetadata: true
+//
+//  deadLetterOut:
+//    Type: producer.Spooling
+//    Streams: deadletter
+//    Path: /var/gollum/deadletter
+//    Respool: false
+//
+//  replayIn:
+//    Type: consumer.Replay
+//    Streams: exhausted
+//    Path: /var/gollum/deadletter
+//    MaxAttempts: 5
+//
+//  exhaustedOut:
+//    Type: producer.File
+//    Streams: exhausted
+//    File: /var/gollum/exhausted.log
+//
+type Replay struct {
+	core.SimpleConsumer `gollumdoc:"embed_type"`
+
+	path           string        `config:"Path" default:"/var/run/gollum/spooling"`
+	maxAttempts    int           `config:"MaxAttempts" default:"0"`
+	minFileAge     time.Duration `config:"MinFileAgeSec" default:"120" metric:"sec"`
+	removeFiles    bool          `config:"RemoveFiles" default:"true"`
+	bufferSizeByte int           `config:"BufferSizeByte" default:"8192"`
+
+	readDelay time.Duration
+	reader    *tio.BufferedReader
+	trigger   chan struct{}
+	done      chan struct{}
+}
+
+func init() {
+	core.TypeRegistry.Register(Replay{})
+}
+
+// Configure initializes this consumer with values from a plugin config.
+func (cons *Replay) Configure(conf core.PluginConfigReader) {
+	cons.done = make(chan struct{})
+	cons.trigger = make(chan struct{}, 1)
+	cons.reader = tio.NewBufferedReader(cons.bufferSizeByte, tio.BufferedReaderFlagDelimiter, 0, "\n")
+
+	if maxMsgSec := time.Duration(conf.GetInt("MaxMessagesSec", 0)); maxMsgSec > 0 {
+		cons.readDelay = time.Second / maxMsgSec
+	}
+
+	cons.SetRollCallback(cons.onRoll)
+	cons.SetStopCallback(func() {
+		close(cons.done)
+	})
+}
+
+func (cons *Replay) onRoll() {
+	select {
+	case cons.trigger <- struct{}{}:
+	default:
+		// A replay is already pending
+	}
+}
+
+func (cons *Replay) getSpoolFiles() []string {
+	files, _ := filepath.Glob(filepath.Join(cons.path, "*.spl"))
+	subFiles, _ := filepath.Glob(filepath.Join(cons.path, "*", "*.spl"))
+	files = append(files, subFiles...)
+	sort.Strings(files)
+
+	minModTime := time.Now().Add(-cons.minFileAge)
+	readyFiles := files[:0]
+	for _, name := range files {
+		info, err := os.Stat(name)
+		if err != nil || info.Size() == 0 || info.ModTime().After(minModTime) {
+			continue // ### continue, not ready yet ###
+		}
+		readyFiles = append(readyFiles, name)
+	}
+	return readyFiles
+}
+
+// replayResult is returned by replayFile to signal what happened to a file.
+type replayResult int
+
+const (
+	// replayDone is returned if a file has been read completely
+	replayDone = replayResult(iota)
+	// replayStopped is returned if the consumer is stopping
+	replayStopped = replayResult(iota)
+	// replayFailed is returned if a file could not be read completely
+	replayFailed = replayResult(iota)
+	// replayCorrupt is returned if a file has been read completely but
+	// contained messages that could not be decoded
+	replayCorrupt = replayResult(iota)
+)
+
+func (cons *Replay) replayFiles() {
+	for _, name := range cons.getSpoolFiles() {
+		switch cons.replayFile(name) {
+		case replayStopped:
+			return // ### return, stop requested ###
+		case replayFailed:
+			cons.Logger.Warning("file ", name, " is kept and will be replayed again")
+			continue // ### continue, keep file for next scan ###
+		case replayCorrupt:
+			cons.Logger.Error("file ", name, " contains messages that could not be decoded and is moved to ", name+".corrupt")
+			if err := os.Rename(name, name+".corrupt"); err != nil {
+				cons.Logger.WithError(err).Error("failed to rename ", name)
+			}
+			continue // ### continue, quarantined ###
+		}
+
+		if cons.removeFiles {
+			cons.Logger.Debug("removing ", name)
+			if err := os.Remove(name); err != nil {
+				cons.Logger.WithError(err).Error("failed to remove ", name)
+			}
+		} else {
+			cons.Logger.Debug("renaming ", name)
+			if err := os.Rename(name, name+".replayed"); err != nil {
+				cons.Logger.WithError(err).Error("failed to rename ", name)
+			}
+		}
+	}
+}
+
+// replayFile routes all messages from the given file. Files are only
+// considered done if they have been read completely and all messages could
+// be decoded.
+func (cons *Replay) replayFile(name string) replayResult {
+	file, err := os.Open(name)
+	if err != nil {
+		cons.Logger.WithError(err).Error("failed to open ", name)
+		return replayFailed // ### return, try next file ###
+	}
+	defer file.Close()
+
+	cons.Logger.Debug("Opened ", name, " for replay")
+	streamName := filepath.Base(filepath.Dir(name))
+	if filepath.Clean(filepath.Dir(name)) == filepath.Clean(cons.path) {
+		streamName = ""
+	}
+
+	corrupt := 0
+	cons.reader.Reset(0)
+	for more := true; more; {
+		var data []byte
+		data, more, err = cons.reader.ReadOne(file)
+		if err != nil && err != io.EOF {
+			cons.Logger.WithError(err).Error("failed to read ", name)
+			return replayFailed // ### return, read error ###
+		}
+
+		// len(data) == 0 may be an incomplete message, i.e. we need to read once more to get the rest
+		if len(data) > 0 {
+			if err := cons.replay(data, streamName); err != nil {
+				cons.Logger.WithError(err).Error("failed to decode replayed message from ", name)
+				corrupt++
+			}
+			if cons.readDelay > 0 {
+				time.Sleep(cons.readDelay)
+			}
+		}
+
+		select {
+		case <-cons.done:
+			cons.Logger.Warning("file ", name, " will be replayed again after restart")
+			return replayStopped // ### return, stop requested ###
+		default:
+		}
+	}
+
+	if corrupt > 0 {
+		return replayCorrupt
+	}
+	return replayDone
+}
+
+// replay routes a single message read from a spool file. An error is
+// returned if the message could not be decoded.
+func (cons *Replay) replay(data []byte, streamName string) error {
+	decoded := make([]byte, base64.StdEncoding.DecodedLen(len(data)))
+	size, err := base64.StdEncoding.Decode(decoded, data)
+	if err != nil {
+		return err // ### return, invalid base64 ###
+	}
+
+	msg, err := core.DeserializeMessage(decoded[:size])
+	if err != nil {
+		return err // ### return, invalid message ###
+	}
+
+	if metadata := msg.TryGetMetadata(); metadata != nil {
+		if name, err := metadata.String(core.MetadataKeyDeadLetterStream); err == nil && name != "" {
+			streamName = name
+		}
+	}
+
+	if streamName == "" || (cons.maxAttempts > 0 && core.GetDeadLetterAttempts(msg) >= cons.maxAttempts) {
+		cons.EnqueueWithMetadata(msg.GetPayload(), msg.TryGetMetadata())
+		return nil // ### return, not replayed ###
+	}
+
+	streamID := core.StreamRegistry.GetStreamID(streamName)
+	msg.SetlStreamIDAsOriginal(streamID)
+	if err := core.Route(msg, core.StreamRegistry.GetRouterOrFallback(streamID)); err != nil {
+		cons.Logger.WithError(err).Error("failed to replay message to ", streamName)
+	}
+	return nil
+}
+
+func (cons *Replay) replayLoop() {
+	defer cons.WorkerDone()
+
+	for {
+		cons.replayFiles()
+
+		select {
+		case <-cons.done:
+			return // ### return, stop requested ###
+		case <-cons.trigger:
+		}
+	}
+}
+
+// Consume replays all spool files and waits for roll commands to replay
+// files again.
+func (cons *Replay) Consume(workers *sync.WaitGroup) {
+	go tgo.WithRecoverShutdown(func() {
+		cons.AddMainWorker(workers)
+		cons.replayLoop()
+	})
+
+	cons.ControlLoop()
+}
@@ -0,0 +1,102 @@
+// Copyright 2015-2018 trivago N.V.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consumer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/trivago/gollum/core"
+	"github.com/trivago/tgo/ttesting"
+)
+
+func TestReplayKeepsFailedFiles(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	dir, err := ioutil.TempDir("", "gollum-replay")
+	expect.NoError(err)
+	defer os.RemoveAll(dir)
+
+	conf := core.NewPluginConfig("", "consumer.Replay")
+	conf.Override("Path", dir)
+	conf.Override("MinFileAgeSec", 0)
+	plugin, err := core.NewPluginWithConfig(conf)
+	expect.NoError(err)
+
+	cons, casted := plugin.(*Replay)
+	expect.True(casted)
+
+	// A directory matching the spool file pattern can be opened but not read
+	unreadable := filepath.Join(dir, "broken.spl")
+	expect.NoError(os.Mkdir(unreadable, 0755))
+
+	empty := filepath.Join(dir, "empty.spl")
+	expect.NoError(ioutil.WriteFile(empty, []byte("\n"), 0644))
+
+	past := time.Now().Add(-time.Minute)
+	expect.NoError(os.Chtimes(unreadable, past, past))
+	expect.NoError(os.Chtimes(empty, past, past))
+
+	expect.Equal(replayFailed, cons.replayFile(unreadable))
+	expect.Equal(replayFailed, cons.replayFile(filepath.Join(dir, "missing.spl")))
+	expect.Equal(replayDone, cons.replayFile(empty))
+
+	cons.replayFiles()
+
+	_, err = os.Stat(unreadable)
+	expect.NoError(err)
+	_, err = os.Stat(empty)
+	expect.True(os.IsNotExist(err))
+}
+
+func TestReplayQuarantinesCorruptFiles(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	dir, err := ioutil.TempDir("", "gollum-replay")
+	expect.NoError(err)
+	defer os.RemoveAll(dir)
+
+	conf := core.NewPluginConfig("", "consumer.Replay")
+	conf.Override("Path", dir)
+	conf.Override("MinFileAgeSec", 0)
+	plugin, err := core.NewPluginWithConfig(conf)
+	expect.NoError(err)
+
+	cons, casted := plugin.(*Replay)
+	expect.True(casted)
+
+	// The second line is valid base64 but not a serialized message
+	corrupt := filepath.Join(dir, "corrupt.spl")
+	expect.NoError(ioutil.WriteFile(corrupt, []byte("not base64!\nbm90IGEgbWVzc2FnZQ==\n"), 0644))
+
+	past := time.Now().Add(-time.Minute)
+	expect.NoError(os.Chtimes(corrupt, past, past))
+
+	expect.Equal(replayCorrupt, cons.replayFile(corrupt))
+
+	cons.replayFiles()
+
+	_, err = os.Stat(corrupt)
+	expect.True(os.IsNotExist(err))
+	data, err := ioutil.ReadFile(corrupt + ".corrupt")
+	expect.NoError(err)
+	expect.Equal("not base64!\nbm90IGEgbWVzc2FnZQ==\n", string(data))
+
+	// Quarantined files are not replayed again
+	expect.Equal(0, len(cons.getSpoolFiles()))
+}
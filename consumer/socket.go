@@ -15,6 +15,7 @@
 package consumer
 
 import (
+	"crypto/tls"
 	"fmt"
 	"io"
 	"net"
@@ -24,6 +25,7 @@ import (
 	"time"
 
 	"github.com/trivago/gollum/core"
+	"github.com/trivago/gollum/core/components"
 	"github.com/trivago/tgo"
 	"github.com/trivago/tgo/tio"
 	"github.com/trivago/tgo/tnet"
@@ -37,7 +39,9 @@ const (
 //
 // The socket consumer reads messages as-is from a given network or filesystem
 // socket. Messages are separated from the stream by using a specific partitioner
-// method.
+// method. TCP and UNIX domain sockets can be secured with TLS, see the TLS
+// parameters below. Certificates are reloaded when the consumer receives a
+// roll command, e.g. via SIGHUP.
 //
 // Parameters
 //
@@ -107,6 +111,19 @@ const (
 //    Partitioner: fixed
 //    Size: 256
 //
+// This example accepts TLS connections from clients with a certificate signed
+// by the given CA:
+//
+//  socketIn:
+//    Type: consumer.Socket
+//    Address: tcp://0.0.0.0:5880
+//    TLS:
+//      Enable: true
+//      Certificate: /etc/gollum/server.crt
+//      PrivateKey: /etc/gollum/server.key
+//      CA: /etc/gollum/ca.crt
+//      ClientAuth: verify
+//
 type Socket struct {
 	sync.Mutex
 	core.SimpleConsumer `gollumdoc:"embed_type"`
@@ -122,7 +139,8 @@ type Socket struct {
 	fileFlags     os.FileMode   `config:"Permissions" default:"0770"`
 	offset        int           `config:"Offset" default:"0"`
 	flags         tio.BufferedReaderFlags
-	clearSocket   bool `config:"RemoveOldSocket" default:"true"`
+	clearSocket   bool                 `config:"RemoveOldSocket" default:"true"`
+	TLSConfig     components.TLSConfig `gollumdoc:"embed_type"`
 }
 
 func init() {
@@ -139,6 +157,16 @@ func (cons *Socket) Configure(conf core.PluginConfigReader) {
 	cons.protocol, cons.address = tnet.ParseAddress(address, "tcp")
 	cons.flags = 0
 
+	if cons.TLSConfig.IsEnabled() {
+		switch {
+		case cons.protocol == "udp":
+			conf.Errors.Pushf("UDP sockets do not support TLS.")
+		case !cons.TLSConfig.HasCertificate():
+			conf.Errors.Pushf("TLS requires TLS/Certificate and TLS/PrivateKey to be set.")
+		}
+		cons.SetRollCallback(cons.reloadTLS)
+	}
+
 	partitioner := conf.GetString("Partitioner", "delimiter")
 	switch strings.ToLower(partitioner) {
 	case "binary_be":
@@ -228,6 +256,9 @@ func (cons *Socket) listen() {
 			}
 
 			if err == nil {
+				if cons.TLSConfig.IsEnabled() {
+					socket = cons.TLSConfig.NewListener(socket)
+				}
 				cons.listener = socket
 				forceClose = new(bool) // new trigger for all clients from this listener
 				cons.Logger.Debugf("Listening to %s", cons.address)
@@ -266,9 +297,27 @@ func (cons *Socket) readFromClientConnection(conn net.Conn, forceClose *bool) {
 		cons.Logger.Debugf("Closed client connection to %s on %s", conn.RemoteAddr(), cons.address)
 		cons.WorkerDone()
 	}()
+
+	if tlsConn, isTLS := conn.(*tls.Conn); isTLS {
+		tlsConn.SetDeadline(time.Now().Add(cons.readTimeout))
+		if err := tlsConn.Handshake(); err != nil {
+			cons.Logger.WithError(err).Warningf("TLS handshake with %s failed", conn.RemoteAddr())
+			return // ### return, handshake failed ###
+		}
+		tlsConn.SetDeadline(time.Time{})
+	}
+
 	cons.readFromConnection(conn, forceClose)
 }
 
+func (cons *Socket) reloadTLS() {
+	if err := cons.TLSConfig.Reload(); err != nil {
+		cons.Logger.WithError(err).Error("Failed to reload TLS certificates")
+		return
+	}
+	cons.Logger.Info("Reloaded TLS certificates")
+}
+
 func (cons *Socket) readFromConnection(conn net.Conn, forceClose *bool) {
 	buffer := tio.NewBufferedReader(socketBufferGrowSize, cons.flags, cons.offset, cons.delimiter)
 
@@ -0,0 +1,116 @@
+// Copyright 2015-2018 trivago N.V.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consumer
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/trivago/gollum/core"
+	"github.com/trivago/gollum/testing/tlstest"
+	"github.com/trivago/tgo/ttesting"
+)
+
+// dialSocketTLS connects to address with a TLS 1.2 client, so that a
+// rejected client certificate fails the client handshake.
+func dialSocketTLS(address, caFile, certFile, keyFile string) error {
+	caCerts, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return err
+	}
+	config := &tls.Config{
+		RootCAs:    x509.NewCertPool(),
+		ServerName: "localhost",
+		MaxVersion: tls.VersionTLS12,
+	}
+	config.RootCAs.AppendCertsFromPEM(caCerts)
+
+	if certFile != "" {
+		keypair, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return err
+		}
+		config.Certificates = []tls.Certificate{keypair}
+	}
+
+	conn, err := tls.Dial("tcp", address, config)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+func TestSocketTLS(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+	dir, err := ioutil.TempDir("", "gollum-socket")
+	expect.NoError(err)
+	defer os.RemoveAll(dir)
+
+	files, err := tlstest.WriteCertificates(dir)
+	expect.NoError(err)
+
+	conf := core.NewPluginConfig("", "consumer.Socket")
+	conf.Override("TLS/Enable", true)
+	conf.Override("TLS/Certificate", files.ServerCertificate)
+	conf.Override("TLS/PrivateKey", files.ServerKey)
+	conf.Override("TLS/CA", files.CA)
+	conf.Override("TLS/ClientAuth", "verify")
+	plugin, err := core.NewPluginWithConfig(conf)
+	expect.NoError(err)
+
+	cons, casted := plugin.(*Socket)
+	expect.True(casted)
+	workers := new(sync.WaitGroup)
+	cons.SetWorkerWaitGroup(workers)
+
+	socket, err := net.Listen("tcp", "127.0.0.1:0")
+	expect.NoError(err)
+	listener := cons.TLSConfig.NewListener(socket)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			cons.AddWorker()
+			go cons.readFromClientConnection(conn, nil)
+		}
+	}()
+	defer workers.Wait()
+	defer listener.Close()
+
+	address := socket.Addr().String()
+	expect.NoError(dialSocketTLS(address, files.CA, files.ClientCertificate, files.ClientKey))
+
+	// Clients without a certificate are rejected
+	expect.NotNil(dialSocketTLS(address, files.CA, "", ""))
+
+	// Replace all certificates with ones signed by a new CA
+	oldCA := files.CA + ".old"
+	expect.NoError(os.Rename(files.CA, oldCA))
+	_, err = tlstest.WriteCertificates(dir)
+	expect.NoError(err)
+
+	// The new certificates are used after a roll
+	expect.NotNil(dialSocketTLS(address, files.CA, files.ClientCertificate, files.ClientKey))
+	cons.reloadTLS()
+	expect.NoError(dialSocketTLS(address, files.CA, files.ClientCertificate, files.ClientKey))
+	expect.NotNil(dialSocketTLS(address, oldCA, files.ClientCertificate, files.ClientKey))
+}
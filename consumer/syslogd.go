@@ -15,7 +15,12 @@
 package consumer
 
 import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"fmt"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -23,6 +28,7 @@ import (
 	"github.com/trivago/tgo/tcontainer"
 
 	"github.com/trivago/gollum/core"
+	"github.com/trivago/gollum/core/components"
 	"github.com/trivago/tgo/tnet"
 	syslog "gopkg.in/mcuadros/go-syslog.v2"
 	"gopkg.in/mcuadros/go-syslog.v2/format"
@@ -32,6 +38,9 @@ import (
 //
 // The syslogd consumer creates a syslogd-compatible log server and
 // receives messages on a TCP or UDP port or a UNIX filesystem socket.
+// TCP connections can be secured with TLS (RFC5425), see the TLS parameters
+// below. Certificates are reloaded when the consumer receives a roll command,
+// e.g. via SIGHUP. Connections already established are not affected.
 //
 // Parameters
 //
@@ -46,11 +55,14 @@ import (
 // By default this parameter is set to "udp://0.0.0.0:514"
 //
 // - Format: Defines which syslog standard the server will support.
-// Three standards, listed below, are currently available.  All
+// Four standards, listed below, are currently available.  All
 // standards support listening to UDP and UNIX domain sockets.
-// RFC6587 additionally supports TCP sockets. Default: "RFC6587".
+// RFC6587 and RFC5425 additionally support TCP sockets. RFC6587 accepts
+// octet-counting as well as newline framing, RFC5425 requires octet-counting
+// framing and is meant to be used with TLS.
 // * RFC3164 (https://tools.ietf.org/html/rfc3164) - unix, udp
 // * RFC5424 (https://tools.ietf.org/html/rfc5424) - unix, udp
+// * RFC5425 (https://tools.ietf.org/html/rfc5425) - unix, upd, tcp
 // * RFC6587 (https://tools.ietf.org/html/rfc6587) - unix, upd, tcp
 // By default this parameter is set to "RFC6587".
 //
@@ -62,8 +74,9 @@ import (
 // - SetMetadata: When set to true, syslog based metadata will be attached to
 // the message. The metadata fields added depend on the protocol version used.
 // RFC3164 supports: tag, timestamp, hostname, priority, facility, severity.
-// RFC5424 and RFC6587 support: app_name, version, proc_id , msg_id, timestamp,
-// hostname, priority, facility, severity.
+// RFC5424, RFC5425 and RFC6587 support: app_name, version, proc_id , msg_id,
+// timestamp, hostname, priority, facility, severity. Connections secured by
+// TLS also set tls_peer to the common name of the client certificate.
 // By default this parameter is set to "false".
 //
 // - TimestampFormat: When using SetMetadata this string denotes the go time
@@ -88,20 +101,110 @@ import (
 //    Address: "tcp://0.0.0.0:5599"
 //    Format: "RFC6587"
 //
+// Accept syslog over TLS from clients with a certificate signed by a given CA
+//
+//  SyslogdTLSConsumer:
+//    Type: consumer.Syslogd
+//    Streams: "tls_syslog"
+//    Address: "tcp://0.0.0.0:6514"
+//    Format: "RFC5425"
+//    SetMetadata: true
+//    TLS:
+//      Enable: true
+//      Certificate: /etc/gollum/server.crt
+//      PrivateKey: /etc/gollum/server.key
+//      CA: /etc/gollum/ca.crt
+//      ClientAuth: verify
+//
 type Syslogd struct {
 	core.SimpleConsumer `gollumdoc:"embed_type"`
 	format              format.Format // RFC3164, RFC5424 or RFC6587?
 	protocol            string
 	address             string
-	withMetadata        bool        `config:"SetMetadata" default:"false"`
-	fileFlags           os.FileMode `config:"Permissions" default:"0770"`
-	timestampFormat     string      `config:"TimestampFormat" default:"2006-01-02T15:04:05.000 MST"`
+	withMetadata        bool                 `config:"SetMetadata" default:"false"`
+	fileFlags           os.FileMode          `config:"Permissions" default:"0770"`
+	timestampFormat     string               `config:"TimestampFormat" default:"2006-01-02T15:04:05.000 MST"`
+	TLSConfig           components.TLSConfig `gollumdoc:"embed_type"`
 }
 
+// syslogStreamFormat parses RFC5424 messages from stream based transports.
+// Frames can either be prefixed by their length (octet-counting) or be
+// terminated by a newline. See RFC6587 section 3.4.
+type syslogStreamFormat struct {
+	format.RFC5424
+	octetCountingOnly bool
+}
+
+var (
+	syslogRFC6587 = &syslogStreamFormat{}
+	syslogRFC5425 = &syslogStreamFormat{octetCountingOnly: true}
+)
+
 func init() {
 	core.TypeRegistry.Register(Syslogd{})
 }
 
+// GetSplitFunc returns the function used to split frames from a stream.
+func (f *syslogStreamFormat) GetSplitFunc() bufio.SplitFunc {
+	return f.split
+}
+
+func (f *syslogStreamFormat) split(data []byte, atEOF bool) (int, []byte, error) {
+	// Skip empty lines, e.g. newlines sent after octet-counting frames
+	skip := 0
+	for skip < len(data) && (data[skip] == '\n' || data[skip] == '\r') {
+		skip++
+	}
+	if skip == len(data) {
+		return skip, nil, nil // ### return, request more data ###
+	}
+
+	advance, frame, err := f.splitFrame(data[skip:], atEOF)
+	if advance == 0 {
+		return 0, nil, err // ### return, request more data or error ###
+	}
+	return skip + advance, frame, err
+}
+
+func (f *syslogStreamFormat) splitFrame(data []byte, atEOF bool) (int, []byte, error) {
+	if data[0] >= '1' && data[0] <= '9' {
+		lengthEnd := bytes.IndexByte(data, ' ')
+		if lengthEnd < 0 {
+			if atEOF || len(data) > 10 {
+				return 0, nil, fmt.Errorf("invalid octet-counting frame")
+			}
+			return 0, nil, nil // ### return, request more data ###
+		}
+
+		length, err := strconv.Atoi(string(data[:lengthEnd]))
+		if err != nil {
+			return 0, nil, fmt.Errorf("invalid octet-counting frame length: %s", err.Error())
+		}
+
+		end := lengthEnd + 1 + length
+		if len(data) < end {
+			if atEOF {
+				return 0, nil, fmt.Errorf("incomplete octet-counting frame")
+			}
+			return 0, nil, nil // ### return, request more data ###
+		}
+		return end, data[lengthEnd+1 : end], nil
+	}
+
+	if f.octetCountingOnly {
+		return 0, nil, fmt.Errorf("frame does not start with a message length")
+	}
+
+	end := bytes.IndexByte(data, '\n')
+	if end < 0 {
+		if atEOF {
+			return len(data), data, nil
+		}
+		return 0, nil, nil // ### return, request more data ###
+	}
+	return end + 1, bytes.TrimRight(data[:end], "\r"), nil
+}
+
 // Configure initializes this consumer with values from a plugin config.
 func (cons *Syslogd) Configure(conf core.PluginConfigReader) {
 	cons.protocol, cons.address = tnet.ParseAddress(
@@ -132,11 +235,33 @@ func (cons *Syslogd) Configure(conf core.PluginConfigReader) {
 
 	// https://tools.ietf.org/html/rfc6587
 	case "RFC6587":
-		cons.format = syslog.RFC6587
+		cons.format = syslogRFC6587
+
+	// https://tools.ietf.org/html/rfc5425
+	case "RFC5425":
+		cons.format = syslogRFC5425
 
 	default:
 		conf.Errors.Pushf("Format %s is not supported", syslogFormat)
 	}
+
+	if cons.TLSConfig.IsEnabled() {
+		switch {
+		case cons.protocol != "tcp":
+			conf.Errors.Pushf("TLS is only supported for TCP connections")
+		case !cons.TLSConfig.HasCertificate():
+			conf.Errors.Pushf("TLS requires TLS/Certificate and TLS/PrivateKey to be set")
+		}
+		cons.SetRollCallback(cons.reloadTLS)
+	}
+}
+
+func (cons *Syslogd) reloadTLS() {
+	if err := cons.TLSConfig.Reload(); err != nil {
+		cons.Logger.WithError(err).Error("Failed to reload TLS certificates")
+		return
+	}
+	cons.Logger.Info("Reloaded TLS certificates")
 }
 
 func parseCustomFields(data string, metadata *tcontainer.MarshalMap) {
@@ -232,7 +357,7 @@ func (cons *Syslogd) Handle(parts format.LogParts, code int64, err error) {
 			metaData.Set("severity", severity)
 		}
 
-	case syslog.RFC5424, syslog.RFC6587:
+	case syslog.RFC5424, syslogRFC6587, syslogRFC5425:
 		content, isString = parts["message"].(string)
 
 		if cons.withMetadata {
@@ -261,6 +386,10 @@ func (cons *Syslogd) Handle(parts format.LogParts, code int64, err error) {
 			metaData.Set("priority", priority)
 			metaData.Set("facility", facility)
 			metaData.Set("severity", severity)
+
+			if tlsPeer, _ := parts["tls_peer"].(string); tlsPeer != "" {
+				metaData.Set("tls_peer", tlsPeer)
+			}
 		}
 
 	default:
@@ -279,6 +408,14 @@ func (cons *Syslogd) Handle(parts format.LogParts, code int64, err error) {
 	}
 }
 
+func getSyslogTLSPeerName(conn *tls.Conn) (string, bool) {
+	state := conn.ConnectionState()
+	if len(state.PeerCertificates) == 0 {
+		return "", true
+	}
+	return state.PeerCertificates[0].Subject.CommonName, true
+}
+
 // Consume opens a new syslog socket.
 // Messages are expected to be separated by \n.
 func (cons *Syslogd) Consume(workers *sync.WaitGroup) {
@@ -310,7 +447,13 @@ func (cons *Syslogd) Consume(workers *sync.WaitGroup) {
 			cons.Logger.Error("Failed to open udp://", cons.address)
 		}
 	case "tcp":
-		if err := server.ListenTCP(cons.address); err != nil {
+		if cons.TLSConfig.IsEnabled() {
+			// Client certificates are optional unless required by TLS/ClientAuth
+			server.SetTlsPeerNameFunc(getSyslogTLSPeerName)
+			if err := server.ListenTCPTLS(cons.address, cons.TLSConfig.GetServerConfig()); err != nil {
+				cons.Logger.Error("Failed to open tls://", cons.address)
+			}
+		} else if err := server.ListenTCP(cons.address); err != nil {
 			cons.Logger.Error("Failed to open tcp://", cons.address)
 		}
 	}
@@ -15,6 +15,8 @@
 package consumer
 
 import (
+	"bufio"
+	"strings"
 	"testing"
 
 	"github.com/trivago/tgo/tcontainer"
@@ -39,3 +41,28 @@ func TestSyslogStructuredDataParser(t *testing.T) {
 	expect.MapEqual(metadata, "key", "value")
 	expect.MapEqual(metadata, "key2", "value")
 }
+
+func TestSyslogStreamFraming(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	data := "11 <13>1 - - x\n<14>1 - - y\n\n5 <15>1"
+	scanner := bufio.NewScanner(strings.NewReader(data))
+	scanner.Split(syslogRFC6587.GetSplitFunc())
+
+	frames := []string{}
+	for scanner.Scan() {
+		frames = append(frames, scanner.Text())
+	}
+	expect.NoError(scanner.Err())
+	expect.Equal([]string{"<13>1 - - x", "<14>1 - - y", "<15>1"}, frames)
+
+	scanner = bufio.NewScanner(strings.NewReader("<14>1 - - y\n"))
+	scanner.Split(syslogRFC5425.GetSplitFunc())
+	expect.False(scanner.Scan())
+	expect.NotNil(scanner.Err())
+
+	scanner = bufio.NewScanner(strings.NewReader("12 <13>1"))
+	scanner.Split(syslogRFC5425.GetSplitFunc())
+	expect.False(scanner.Scan())
+	expect.NotNil(scanner.Err())
+}
@@ -39,9 +39,10 @@ const (
 )
 
 const (
-	signalNone = signalType(iota)
-	signalExit = signalType(iota)
-	signalRoll = signalType(iota)
+	signalNone   = signalType(iota)
+	signalExit   = signalType(iota)
+	signalRoll   = signalType(iota)
+	signalReload = signalType(iota)
 )
 
 type coordinatorState byte
@@ -56,8 +57,10 @@ type Coordinator struct {
 	consumerWorker *sync.WaitGroup
 	producerWorker *sync.WaitGroup
 	logConsumer    *core.LogConsumer
+	config         *core.Config
 	state          coordinatorState
 	signal         chan os.Signal
+	reload         chan struct{}
 }
 
 // NewCoordinator creates a new multplexer
@@ -66,6 +69,7 @@ func NewCoordinator() Coordinator {
 		consumerWorker: new(sync.WaitGroup),
 		producerWorker: new(sync.WaitGroup),
 		state:          coordinatorStateConfigure,
+		reload:         make(chan struct{}, 1),
 	}
 }
 
@@ -83,13 +87,26 @@ func (co *Coordinator) Configure(conf *core.Config) error {
 	errors := tgo.NewErrorStack()
 	errors.SetFormat(tgo.ErrorStackFormatCSV)
 
-	if !co.configureRouters(conf) {
+	co.config = conf
+
+	routers, routersOk := co.configureRouters(conf.GetRouters())
+	co.routers = append(co.routers, routers...)
+	if !routersOk {
 		errors.Pushf("At least one router failed to be configured")
 	}
-	if !co.configureProducers(conf) {
+
+	co.state = coordinatorStateStartProducers
+	producers, producersOk := co.configureProducers(conf.GetProducers())
+	co.producers = append(co.producers, producers...)
+	if !producersOk {
 		errors.Pushf("At least one producer failed to be configured")
 	}
-	if !co.configureConsumers(conf) {
+
+	co.state = coordinatorStateStartConsumers
+	logConsumerOk := co.configureLogConsumer()
+	consumers, consumersOk := co.configureConsumers(conf.GetConsumers())
+	co.consumers = append(co.consumers, consumers...)
+	if !logConsumerOk || !consumersOk {
 		errors.Pushf("At least one consumer failed to be configured")
 	}
 	if len(co.producers) == 0 {
@@ -109,23 +126,10 @@ func (co *Coordinator) Configure(conf *core.Config) error {
 
 // StartPlugins starts all plugins in the correct order.
 func (co *Coordinator) StartPlugins() {
-	// Launch routers
-	for _, router := range co.routers {
-		logrus.Debug("Starting ", reflect.TypeOf(router))
-		if err := router.Start(); err != nil {
-			logrus.WithError(err).Errorf("Failed to start router of type '%s'", reflect.TypeOf(router))
-		}
-	}
+	co.startRouters(co.routers)
 
-	// Launch producers
 	co.state = coordinatorStateStartProducers
-	for _, producer := range co.producers {
-		producer := producer
-		go tgo.WithRecoverShutdown(func() {
-			logrus.Debug("Starting ", reflect.TypeOf(producer))
-			producer.Produce(co.producerWorker)
-		})
-	}
+	co.startProducers(co.producers)
 
 	// Set final log target and purge the intermediate buffer
 	if core.StreamRegistry.IsStreamRegistered(core.LogInternalStreamID) {
@@ -141,15 +145,8 @@ func (co *Coordinator) StartPlugins() {
 		logrusHookBuffer.Purge()
 	}
 
-	// Launch consumers
 	co.state = coordinatorStateStartConsumers
-	for _, consumer := range co.consumers {
-		consumer := consumer
-		go tgo.WithRecoverShutdown(func() {
-			logrus.Debug("Starting ", reflect.TypeOf(consumer))
-			consumer.Consume(co.consumerWorker)
-		})
-	}
+	co.startConsumers(co.consumers)
 }
 
 // Run is essentially the Coordinator main loop.
@@ -161,8 +158,15 @@ func (co *Coordinator) Run() {
 	logrus.Info("We be nice to them, if they be nice to us. (startup)")
 
 	for {
-		sig := <-co.signal
-		switch translateSignal(sig) {
+		var sig signalType
+		select {
+		case osSignal := <-co.signal:
+			sig = translateSignal(osSignal)
+		case <-co.reload:
+			sig = signalReload
+		}
+
+		switch sig {
 		case signalExit:
 			logrus.Info("Master betrayed us. Wicked. Tricksy, False. (signal)")
 			return // ### return, exit requested ###
@@ -175,11 +179,28 @@ func (co *Coordinator) Run() {
 				producer.Control() <- core.PluginControlRoll
 			}
 
+		case signalReload:
+			logrus.Info("Reloading configuration")
+			if config := readConfig(*flagConfigFile); config != nil {
+				co.Reload(config)
+			} else {
+				logrus.Error("Keeping previous configuration")
+			}
+
 		default:
 		}
 	}
 }
 
+// RequestReload asks the coordinator main loop to reload the configuration
+// file. Requests are ignored if a reload is already pending.
+func (co *Coordinator) RequestReload() {
+	select {
+	case co.reload <- struct{}{}:
+	default:
+	}
+}
+
 // Shutdown all consumers and producers in a clean way.
 // The internal log is flushed after the consumers have been shut down so that
 // consumer related messages are still in the tlog.
@@ -205,9 +226,9 @@ func (co *Coordinator) Shutdown() {
 	co.state = coordinatorStateStopped
 }
 
-func (co *Coordinator) configureRouters(conf *core.Config) bool {
+func (co *Coordinator) configureRouters(routerConfigs []core.PluginConfig) ([]core.Router, bool) {
 	allFine := true
-	routerConfigs := conf.GetRouters()
+	routers := []core.Router{}
 	for _, config := range routerConfigs {
 		if _, hasStreams := config.Settings.Value("Stream"); !hasStreams {
 			logrus.Errorf("Router '%s' has no stream set", config.ID)
@@ -224,24 +245,23 @@ func (co *Coordinator) configureRouters(conf *core.Config) bool {
 		}
 
 		routerPlugin := plugin.(core.Router)
-		co.routers = append(co.routers, routerPlugin)
+		routers = append(routers, routerPlugin)
 
 		logrus.Debugf("Instantiated '%s' (%s) as '%s'", config.ID, core.StreamRegistry.GetStreamName(routerPlugin.GetStreamID()), config.Typename)
 		core.StreamRegistry.Register(routerPlugin, routerPlugin.GetStreamID())
 	}
 
-	return allFine
+	return routers, allFine
 }
 
-func (co *Coordinator) configureProducers(conf *core.Config) bool {
-	co.state = coordinatorStateStartProducers
+func (co *Coordinator) configureProducers(producerConfigs []core.PluginConfig) ([]core.Producer, bool) {
 	allFine := true
+	producers := []core.Producer{}
 
 	// All producers are added to the wildcard stream so that consumers can send
 	// to all producers if required. The wildcard producer list is required
 	// to add producers listening to all routers to all streams that are used.
 	wildcardStream := core.StreamRegistry.GetRouterOrFallback(core.WildcardStreamID)
-	producerConfigs := conf.GetProducers()
 
 	for _, config := range producerConfigs {
 		if _, hasStreams := config.Settings.Value("Streams"); !hasStreams {
@@ -259,7 +279,7 @@ func (co *Coordinator) configureProducers(conf *core.Config) bool {
 		}
 
 		producer, _ := plugin.(core.Producer)
-		co.producers = append(co.producers, producer)
+		producers = append(producers, producer)
 		core.MetricProducers.Inc(1)
 
 		// Attach producer to streams
@@ -285,14 +305,12 @@ func (co *Coordinator) configureProducers(conf *core.Config) bool {
 		}
 	}
 
-	return allFine
+	return producers, allFine
 }
 
-func (co *Coordinator) configureConsumers(conf *core.Config) bool {
-	co.state = coordinatorStateStartConsumers
-	allFine := co.configureLogConsumer()
-
-	consumerConfigs := conf.GetConsumers()
+func (co *Coordinator) configureConsumers(consumerConfigs []core.PluginConfig) ([]core.Consumer, bool) {
+	allFine := true
+	consumers := []core.Consumer{}
 	for _, config := range consumerConfigs {
 		if _, hasStreams := config.Settings.Value("Streams"); !hasStreams {
 			logrus.Errorf("Consumer '%s' has no streams set", config.ID)
@@ -309,11 +327,11 @@ func (co *Coordinator) configureConsumers(conf *core.Config) bool {
 		}
 
 		consumer, _ := plugin.(core.Consumer)
-		co.consumers = append(co.consumers, consumer)
+		consumers = append(consumers, consumer)
 		core.MetricConsumers.Inc(1)
 	}
 
-	return allFine
+	return consumers, allFine
 }
 
 func (co *Coordinator) configureLogConsumer() bool {
@@ -329,6 +347,35 @@ func (co *Coordinator) configureLogConsumer() bool {
 	return false
 }
 
+func (co *Coordinator) startRouters(routers []core.Router) {
+	for _, router := range routers {
+		logrus.Debug("Starting ", reflect.TypeOf(router))
+		if err := router.Start(); err != nil {
+			logrus.WithError(err).Errorf("Failed to start router of type '%s'", reflect.TypeOf(router))
+		}
+	}
+}
+
+func (co *Coordinator) startProducers(producers []core.Producer) {
+	for _, producer := range producers {
+		producer := producer
+		go tgo.WithRecoverShutdown(func() {
+			logrus.Debug("Starting ", reflect.TypeOf(producer))
+			producer.Produce(co.producerWorker)
+		})
+	}
+}
+
+func (co *Coordinator) startConsumers(consumers []core.Consumer) {
+	for _, consumer := range consumers {
+		consumer := consumer
+		go tgo.WithRecoverShutdown(func() {
+			logrus.Debug("Starting ", reflect.TypeOf(consumer))
+			consumer.Consume(co.consumerWorker)
+		})
+	}
+}
+
 func (co *Coordinator) shutdownConsumers(stateAtShutdown coordinatorState) {
 	if stateAtShutdown >= coordinatorStateStartConsumers {
 		co.state = coordinatorStateStopConsumers
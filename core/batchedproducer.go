@@ -62,20 +62,29 @@ func (prod *BatchedProducer) Configure(conf PluginConfigReader) {
 
 // Enqueue will add the message to the internal channel so it can be processed
 // by the producer main loop. A timeout value != nil will overwrite the channel
-// timeout value for this call.
+// timeout value for this call. If the producer is paused, the message is sent
+// to the fallback if the producer is not resumed within timeout.
 func (prod *BatchedProducer) Enqueue(msg *Message, timeout time.Duration) {
 	defer prod.enqueuePanicHandling(msg)
 
 	// Don't accept messages if we are shutting down
 	if prod.GetState() >= PluginStateStopping {
-		prod.TryFallback(msg)
+		prod.TryFallbackWithError(msg, errProducerStopping)
 		return // ### return, closing down ###
 	}
 
+	StartMessageSpanUntilAck(msg, prod.GetID(), "produce", SpanKindProducer)
+
 	if !prod.HasContinueAfterModulate(msg) {
 		return
 	}
 
+	// Producers without a queue must not block their callers while paused
+	if !prod.runState.WaitWhilePausedTimeout(timeout) {
+		prod.TryFallbackWithError(msg, errProducerPaused)
+		return // ### return, paused ###
+	}
+
 	prod.appendMessage(msg)
 	MessageTrace(msg, prod.GetID(), "Enqueued by batched producer")
 }
@@ -220,3 +220,28 @@ func TestBatchedProducerClose(t *testing.T) {
 	// expect execution of flush method
 	expect.Equal(true, onBatchFlushExecuted)
 }
+
+func TestBatchedProducerPaused(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	fallback := getMockRouterMessageHelper("batchedPausedFallback")
+	mockProducer := getMockBatchedProducer()
+	mockProducer.Batch = NewMessageBatch(10)
+	mockProducer.fallbackStream = &fallback
+	mockProducer.setState(PluginStateActive)
+	mockProducer.runState.Pause()
+
+	// Paused producers do not block their callers longer than the timeout
+	start := time.Now()
+	mockProducer.Enqueue(NewMessage(nil, []byte("paused"), nil, InvalidStreamID), 20*time.Millisecond)
+	expect.True(time.Since(start) < time.Second)
+	expect.True(fallback.messageEnqued)
+	expect.Equal("paused", fallback.lastMessageData)
+	expect.Equal(0, mockProducer.Batch.getActiveBufferCount())
+
+	fallback.messageEnqued = false
+	mockProducer.runState.Resume()
+	mockProducer.Enqueue(NewMessage(nil, []byte("resumed"), nil, InvalidStreamID), 20*time.Millisecond)
+	expect.False(fallback.messageEnqued)
+	expect.Equal(1, mockProducer.Batch.getActiveBufferCount())
+}
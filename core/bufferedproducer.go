@@ -35,11 +35,32 @@ import (
 // parameter to 0.
 // By default this parameter is set to "0".
 //
+// - Journal/Path: This value defines a directory used to journal all buffered
+// messages to disk. Messages are acknowledged after the producer has finished
+// processing them, i.e. after they have been delivered or sent to the fallback.
+// Messages that have not been acknowledged, e.g. because of a crash, are
+// processed again upon the next start. Each producer requires its own
+// directory. Setting this parameter to "" disables the journal.
+// By default this parameter is set to "".
+//
+// - Journal/SegmentSizeMB: This value defines the size of a journal file in MB
+// before a new one is started. Journal files are removed as soon as all
+// messages stored in it have been acknowledged.
+// By default this parameter is set to "64".
+//
+// - Journal/Sync: When set to true, each message is flushed to disk before
+// it is buffered. This prevents message loss in case of power failures but
+// decreases throughput.
+// By default this parameter is set to "false".
 //
 type BufferedProducer struct {
-	DirectProducer `gollumdoc:"embed_type"`
-	messages       MessageQueue
-	channelTimeout time.Duration `config:"ChannelTimeoutMs" default:"0" metric:"ms"`
+	DirectProducer     `gollumdoc:"embed_type"`
+	messages           MessageQueue
+	journal            *MessageJournal
+	channelTimeout     time.Duration `config:"ChannelTimeoutMs" default:"0" metric:"ms"`
+	journalPath        string        `config:"Journal/Path" default:""`
+	journalSegmentSize int64         `config:"Journal/SegmentSizeMB" default:"64" metric:"mb"`
+	journalSync        bool          `config:"Journal/Sync" default:"false"`
 }
 
 // Configure initializes the standard producer config values.
@@ -47,6 +68,13 @@ func (prod *BufferedProducer) Configure(conf PluginConfigReader) {
 	prod.onPrepareStop = prod.DefaultDrain
 	prod.onStop = prod.DefaultClose
 	prod.messages = NewMessageQueue(int(conf.GetInt("Channel", 8192)))
+
+	if prod.journalPath != "" {
+		journal, err := NewMessageJournal(prod.journalPath, prod.journalSegmentSize, prod.journalSync)
+		if !conf.Errors.Push(err) {
+			prod.journal = journal
+		}
+	}
 }
 
 // GetQueueTimeout returns the duration this producer will block before a
@@ -64,10 +92,12 @@ func (prod *BufferedProducer) Enqueue(msg *Message, timeout time.Duration) {
 
 	// Don't accept messages if we are shutting down
 	if prod.GetState() >= PluginStateStopping {
-		prod.TryFallback(msg)
+		prod.TryFallbackWithError(msg, errProducerStopping)
 		return // ### return, closing down ###
 	}
 
+	StartMessageSpanUntilAck(msg, prod.GetID(), "produce", SpanKindProducer)
+
 	if !prod.HasContinueAfterModulate(msg) {
 		return
 	}
@@ -78,12 +108,19 @@ func (prod *BufferedProducer) Enqueue(msg *Message, timeout time.Duration) {
 		usedTimeout = timeout
 	}
 
+	if prod.journal != nil {
+		if err := prod.journal.Write(msg); err != nil {
+			prod.Logger.WithError(err).Error("Failed to write message to journal")
+		}
+	}
+
 	switch prod.messages.Push(msg, usedTimeout) {
 	case MessageQueueTimeout:
-		prod.TryFallback(msg)
+		prod.TryFallbackWithError(msg, errQueueTimeout)
 		prod.setState(PluginStateWaiting)
 
 	case MessageQueueDiscard:
+		msg.Nack()
 		MetricMessagesDiscarded.Inc(1)
 		prod.setState(PluginStateWaiting)
 
@@ -94,6 +131,18 @@ func (prod *BufferedProducer) Enqueue(msg *Message, timeout time.Duration) {
 	MessageTrace(msg, prod.GetID(), "Enqueued by buffered producer")
 }
 
+// GetNumQueued returns the number of messages currently buffered by this
+// producer.
+func (prod *BufferedProducer) GetNumQueued() int {
+	return prod.messages.GetNumQueued()
+}
+
+// GetQueueCapacity returns the maximum number of messages this producer can
+// buffer.
+func (prod *BufferedProducer) GetQueueCapacity() int {
+	return prod.messages.GetCapacity()
+}
+
 // DefaultDrain is the function registered to onPrepareStop by default.
 // It calls DrainMessageChannel with the message handling function passed to
 // Any of the control functions. If no such call happens, this function does
@@ -111,7 +160,7 @@ func (prod *BufferedProducer) DefaultDrain() {
 func (prod *BufferedProducer) DrainMessageChannel(handleMessage func(*Message), timeout time.Duration) bool {
 	for {
 		if msg, ok := prod.messages.PopWithTimeout(timeout); ok {
-			if !tgo.ReturnAfter(prod.shutdownTimeout, func() { handleMessage(msg) }) {
+			if !tgo.ReturnAfter(prod.shutdownTimeout, func() { prod.handleAndAck(handleMessage, msg) }) {
 				return false // ### return, done ###
 			}
 		} else {
@@ -142,11 +191,16 @@ func (prod *BufferedProducer) CloseMessageChannel(handleMessage func(*Message))
 		if !prod.messages.IsEmpty() {
 			prod.Logger.Errorf("%d messages left after closing.", prod.messages.GetNumQueued())
 		}
+		if prod.journal != nil {
+			if err := prod.journal.Close(); err != nil {
+				prod.Logger.WithError(err).Error("Failed to close journal")
+			}
+		}
 	}()
 
 	for {
 		if msg, ok := prod.messages.Pop(); ok {
-			if !tgo.ReturnAfter(prod.shutdownTimeout, func() { handleMessage(msg) }) {
+			if !tgo.ReturnAfter(prod.shutdownTimeout, func() { prod.handleAndAck(handleMessage, msg) }) {
 				return false // ### return, failed to handle message ###
 			}
 		} else {
@@ -176,10 +230,40 @@ func (prod *BufferedProducer) TickerMessageControlLoop(onMessage func(*Message),
 
 func (prod *BufferedProducer) messageLoop(onMessage func(*Message)) {
 	prod.onMessage = onMessage
+	prod.replayJournal(onMessage)
+
 	for prod.IsActive() {
+		prod.runState.WaitWhilePaused()
 		msg, more := prod.messages.Pop()
 		if more {
-			onMessage(msg)
+			prod.handleAndAck(onMessage, msg)
 		}
 	}
 }
+
+// replayJournal processes all messages left in the journal by a previous run.
+func (prod *BufferedProducer) replayJournal(onMessage func(*Message)) {
+	if prod.journal == nil {
+		return // ### return, no journal ###
+	}
+
+	numReplayed := 0
+	err := prod.journal.Replay(func(msg *Message) {
+		numReplayed++
+		prod.handleAndAck(onMessage, msg)
+	})
+	if err != nil {
+		prod.Logger.WithError(err).Error("Failed to replay journal")
+	}
+	if numReplayed > 0 {
+		prod.Logger.Infof("Replayed %d messages from journal", numReplayed)
+	}
+}
+
+// handleAndAck calls the given message handler and acknowledges the message
+// afterwards unless manual acknowledgement is enabled. Journaled messages are
+// removed from the journal when they are acknowledged.
+func (prod *BufferedProducer) handleAndAck(handleMessage func(*Message), msg *Message) {
+	handleMessage(msg)
+	prod.ackHandled(msg)
+}
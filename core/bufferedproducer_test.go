@@ -15,7 +15,9 @@
 package core
 
 import (
+	"io/ioutil"
 	"math"
+	"os"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -73,6 +75,30 @@ func TestProducerConfigure(t *testing.T) {
 	expect.NoError(err)
 }
 
+type mockRoutingFormatter struct {
+	mockFormatter
+}
+
+func (formatter *mockRoutingFormatter) RoutesMessages() bool {
+	return true
+}
+
+func TestProducerRoutingModulator(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+	TypeRegistry.Register(mockFormatter{})
+	TypeRegistry.Register(mockRoutingFormatter{})
+
+	mockConf := NewPluginConfig("mockRouting", "mockBufferedProducer")
+	mockConf.Override("Modulators", []interface{}{"core.mockFormatter"})
+
+	reader := NewPluginConfigReader(&mockConf)
+	expect.NoError(reader.Configure(&mockBufferedProducer{}))
+
+	mockConf.Override("Modulators", []interface{}{"core.mockFormatter", "core.mockRoutingFormatter"})
+	reader = NewPluginConfigReader(&mockConf)
+	expect.NotNil(reader.Configure(&mockBufferedProducer{}))
+}
+
 func TestProducerState(t *testing.T) {
 	expect := ttesting.NewExpect(t)
 
@@ -155,6 +181,37 @@ func TestProducerEnqueue(t *testing.T) {
 
 }
 
+func TestProducerJournalManualAck(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	dir, err := ioutil.TempDir("", "gollum-journal")
+	expect.NoError(err)
+	defer os.RemoveAll(dir)
+
+	journal, err := NewMessageJournal(dir, 1<<20, false)
+	expect.NoError(err)
+	defer journal.Close()
+
+	mockP := getMockBufferedProducer()
+	mockP.journal = journal
+	mockP.EnableManualAcknowledge()
+	mockP.setState(PluginStateActive)
+
+	msg := NewMessage(nil, []byte("journaled"), nil, 1)
+	mockP.Enqueue(msg, time.Second)
+	expect.Equal(1, journal.GetNumPending())
+
+	// Handling the message does not remove it from the journal as it might
+	// still be in flight.
+	var handled *Message
+	queued, _ := mockP.messages.Pop()
+	mockP.handleAndAck(func(msg *Message) { handled = msg }, queued)
+	expect.Equal(1, journal.GetNumPending())
+
+	handled.Ack()
+	expect.Equal(0, journal.GetNumPending())
+}
+
 func TestProducerCloseMessageChannel(t *testing.T) {
 	expect := ttesting.NewExpect(t)
 	mockP := getMockBufferedProducer()
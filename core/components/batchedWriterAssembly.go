@@ -114,6 +114,19 @@ func (bwa *BatchedWriterAssembly) GetWriter() BatchedWriter {
 	return bwa.writer
 }
 
+// SetDelimiter sets a byte sequence that is written between two messages of
+// a batch.
+func (bwa *BatchedWriterAssembly) SetDelimiter(delimiter []byte) {
+	bwa.assembly.SetDelimiter(delimiter)
+}
+
+// SetErrorHandler sets a callback that is called if the writer returned an
+// error. HandleError needs to return true to prevent messages from being
+// passed to the fallback.
+func (bwa *BatchedWriterAssembly) SetErrorHandler(handleError func(error) bool) {
+	bwa.assembly.SetErrorHandler(handleError)
+}
+
 // Flush flush the batch
 func (bwa *BatchedWriterAssembly) Flush() {
 	if bwa.writer != nil {
@@ -0,0 +1,224 @@
+// Copyright 2015-2018 trivago N.V.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package components
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/trivago/gollum/core"
+)
+
+// tlsVersion13 equals tls.VersionTLS13, which requires Go 1.12
+const tlsVersion13 = 0x0304
+
+// TLSConfig component
+//
+// The TLSConfig is a helper component to secure connections with TLS. It can
+// be used for listeners (servers) and for outgoing connections (clients).
+// Certificates and the CA bundle are read from disk again when Reload is
+// called, e.g. when the plugin receives a roll command (SIGHUP). Established
+// connections are not affected by a reload.
+//
+// Parameters
+//
+// - TLS/Enable: Set to true to use TLS for all connections.
+// By default this parameter is set to "false".
+//
+// - TLS/Certificate: Path to a PEM encoded certificate. Required for servers,
+// optional for clients that want to authenticate themselves.
+// By default this parameter is set to "".
+//
+// - TLS/PrivateKey: Path to the PEM encoded private key of TLS/Certificate.
+// By default this parameter is set to "".
+//
+// - TLS/CA: Path to a PEM encoded CA bundle. Clients use it to verify the
+// server certificate, servers use it to verify client certificates. If not
+// set, clients use the system's CA pool.
+// By default this parameter is set to "".
+//
+// - TLS/ClientAuth: Defines if servers ask for client certificates. Set to
+// "none" to not ask for certificates, "request" to ask for an optional
+// certificate, "require" to require any certificate or "verify" to require
+// a certificate signed by TLS/CA (mutual TLS).
+// By default this parameter is set to "none".
+//
+// - TLS/ServerName: The server name used by clients for SNI and to verify the
+// server certificate. If not set, the host part of the address is used.
+// By default this parameter is set to "".
+//
+// - TLS/InsecureSkipVerify: Set to true to disable the verification of server
+// certificates on clients. Do not use this in production.
+// By default this parameter is set to "false".
+//
+// - TLS/MinVersion: The minimum TLS version to accept. Can be one of "1.0",
+// "1.1", "1.2" or "1.3".
+// By default this parameter is set to "1.2".
+//
+type TLSConfig struct {
+	enabled            bool   `config:"TLS/Enable" default:"false"`
+	certificateFile    string `config:"TLS/Certificate" default:""`
+	privateKeyFile     string `config:"TLS/PrivateKey" default:""`
+	caFile             string `config:"TLS/CA" default:""`
+	clientAuth         string `config:"TLS/ClientAuth" default:"none"`
+	serverName         string `config:"TLS/ServerName" default:""`
+	insecureSkipVerify bool   `config:"TLS/InsecureSkipVerify" default:"false"`
+	minVersion         string `config:"TLS/MinVersion" default:"1.2"`
+	config             *tls.Config
+	guard              sync.RWMutex
+}
+
+// Configure method
+func (t *TLSConfig) Configure(conf core.PluginConfigReader) {
+	if !t.enabled {
+		return // ### return, TLS disabled ###
+	}
+
+	if (t.certificateFile == "") != (t.privateKeyFile == "") {
+		conf.Errors.Pushf("TLS/Certificate and TLS/PrivateKey must be set together")
+		return
+	}
+	if strings.ToLower(t.clientAuth) == "verify" && t.caFile == "" {
+		conf.Errors.Pushf("TLS/ClientAuth \"verify\" requires TLS/CA to be set")
+		return
+	}
+
+	conf.Errors.Push(t.Reload())
+}
+
+// IsEnabled returns true if connections should use TLS
+func (t *TLSConfig) IsEnabled() bool {
+	return t.enabled
+}
+
+// HasCertificate returns true if a certificate has been configured
+func (t *TLSConfig) HasCertificate() bool {
+	return t.certificateFile != ""
+}
+
+// Reload reads certificates and the CA bundle from disk. The current
+// configuration is kept if an error occurs.
+func (t *TLSConfig) Reload() error {
+	if !t.enabled {
+		return nil // ### return, TLS disabled ###
+	}
+
+	config := &tls.Config{
+		ServerName:         t.serverName,
+		InsecureSkipVerify: t.insecureSkipVerify,
+	}
+
+	switch t.minVersion {
+	case "1.0":
+		config.MinVersion = tls.VersionTLS10
+	case "1.1":
+		config.MinVersion = tls.VersionTLS11
+	case "1.2":
+		config.MinVersion = tls.VersionTLS12
+	case "1.3":
+		config.MinVersion = tlsVersion13
+	default:
+		return fmt.Errorf("unknown TLS/MinVersion \"%s\"", t.minVersion)
+	}
+
+	switch strings.ToLower(t.clientAuth) {
+	case "none":
+		config.ClientAuth = tls.NoClientCert
+	case "request":
+		config.ClientAuth = tls.RequestClientCert
+	case "require":
+		config.ClientAuth = tls.RequireAnyClientCert
+	case "verify":
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return fmt.Errorf("unknown TLS/ClientAuth \"%s\"", t.clientAuth)
+	}
+
+	if t.certificateFile != "" {
+		keypair, err := tls.LoadX509KeyPair(t.certificateFile, t.privateKeyFile)
+		if err != nil {
+			return err
+		}
+		config.Certificates = []tls.Certificate{keypair}
+	}
+
+	if t.caFile != "" {
+		caCerts, err := ioutil.ReadFile(t.caFile)
+		if err != nil {
+			return err
+		}
+		caPool := x509.NewCertPool()
+		if !caPool.AppendCertsFromPEM(caCerts) {
+			return fmt.Errorf("no certificates found in %s", t.caFile)
+		}
+		config.RootCAs = caPool
+		config.ClientCAs = caPool
+	}
+
+	t.guard.Lock()
+	t.config = config
+	t.guard.Unlock()
+	return nil
+}
+
+func (t *TLSConfig) getConfig() *tls.Config {
+	t.guard.RLock()
+	defer t.guard.RUnlock()
+	return t.config
+}
+
+// GetServerConfig returns a configuration to be used by TLS listeners.
+// Each handshake uses the configuration of the last successful Reload.
+func (t *TLSConfig) GetServerConfig() *tls.Config {
+	return &tls.Config{
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return t.getConfig(), nil
+		},
+	}
+}
+
+// NewListener wraps the given listener so that all accepted connections use
+// TLS.
+func (t *TLSConfig) NewListener(listener net.Listener) net.Listener {
+	return tls.NewListener(listener, t.GetServerConfig())
+}
+
+// NewClient wraps the given connection as a TLS client and performs the
+// handshake. The address is used to derive the server name if
+// TLS/ServerName is not set.
+func (t *TLSConfig) NewClient(conn net.Conn, address string, timeout time.Duration) (net.Conn, error) {
+	config := t.getConfig().Clone()
+	if config.ServerName == "" {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			host = address
+		}
+		config.ServerName = host
+	}
+
+	client := tls.Client(conn, config)
+	client.SetDeadline(time.Now().Add(timeout))
+	if err := client.Handshake(); err != nil {
+		return nil, err
+	}
+	client.SetDeadline(time.Time{})
+	return client, nil
+}
@@ -0,0 +1,201 @@
+// Copyright 2015-2018 trivago N.V.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package components
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/trivago/gollum/testing/tlstest"
+	"github.com/trivago/tgo/ttesting"
+)
+
+// handshakeTLS connects client to listener and returns the handshake errors
+// of both sides.
+func handshakeTLS(listener net.Listener, client *TLSConfig) (clientErr error, serverErr error) {
+	serverResult := make(chan error, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			serverResult <- err
+			return
+		}
+		defer conn.Close()
+		serverResult <- conn.(*tls.Conn).Handshake()
+	}()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		return err, <-serverResult
+	}
+	defer conn.Close()
+
+	tlsConn, clientErr := client.NewClient(conn, listener.Addr().String(), time.Second)
+	if clientErr == nil {
+		tlsConn.Close()
+	}
+	return clientErr, <-serverResult
+}
+
+func TestTLSConfigHandshake(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+	dir, err := ioutil.TempDir("", "gollum-tls")
+	expect.NoError(err)
+	defer os.RemoveAll(dir)
+
+	files, err := tlstest.WriteCertificates(dir)
+	expect.NoError(err)
+
+	server := &TLSConfig{
+		enabled:         true,
+		certificateFile: files.ServerCertificate,
+		privateKeyFile:  files.ServerKey,
+		clientAuth:      "none",
+		minVersion:      "1.2",
+	}
+	expect.NoError(server.Reload())
+
+	socket, err := net.Listen("tcp", "127.0.0.1:0")
+	expect.NoError(err)
+	listener := server.NewListener(socket)
+	defer listener.Close()
+
+	client := &TLSConfig{
+		enabled:    true,
+		caFile:     files.CA,
+		clientAuth: "none",
+		minVersion: "1.2",
+	}
+	expect.NoError(client.Reload())
+
+	clientErr, serverErr := handshakeTLS(listener, client)
+	expect.NoError(clientErr)
+	expect.NoError(serverErr)
+
+	// Clients without the CA reject the server
+	client.caFile = ""
+	expect.NoError(client.Reload())
+	clientErr, _ = handshakeTLS(listener, client)
+	expect.NotNil(clientErr)
+}
+
+func TestTLSConfigClientAuthVerify(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+	dir, err := ioutil.TempDir("", "gollum-tls")
+	expect.NoError(err)
+	defer os.RemoveAll(dir)
+
+	files, err := tlstest.WriteCertificates(dir)
+	expect.NoError(err)
+
+	server := &TLSConfig{
+		enabled:         true,
+		certificateFile: files.ServerCertificate,
+		privateKeyFile:  files.ServerKey,
+		caFile:          files.CA,
+		clientAuth:      "verify",
+		minVersion:      "1.2",
+	}
+	expect.NoError(server.Reload())
+
+	socket, err := net.Listen("tcp", "127.0.0.1:0")
+	expect.NoError(err)
+	listener := server.NewListener(socket)
+	defer listener.Close()
+
+	client := &TLSConfig{
+		enabled:    true,
+		caFile:     files.CA,
+		clientAuth: "none",
+		minVersion: "1.2",
+	}
+	expect.NoError(client.Reload())
+
+	_, serverErr := handshakeTLS(listener, client)
+	expect.NotNil(serverErr)
+
+	client.certificateFile = files.ClientCertificate
+	client.privateKeyFile = files.ClientKey
+	expect.NoError(client.Reload())
+
+	clientErr, serverErr := handshakeTLS(listener, client)
+	expect.NoError(clientErr)
+	expect.NoError(serverErr)
+}
+
+func TestTLSConfigReload(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+	dir, err := ioutil.TempDir("", "gollum-tls")
+	expect.NoError(err)
+	defer os.RemoveAll(dir)
+
+	files, err := tlstest.WriteCertificates(dir)
+	expect.NoError(err)
+
+	server := &TLSConfig{
+		enabled:         true,
+		certificateFile: files.ServerCertificate,
+		privateKeyFile:  files.ServerKey,
+		clientAuth:      "none",
+		minVersion:      "1.2",
+	}
+	expect.NoError(server.Reload())
+
+	socket, err := net.Listen("tcp", "127.0.0.1:0")
+	expect.NoError(err)
+	listener := server.NewListener(socket)
+	defer listener.Close()
+
+	oldClient := &TLSConfig{
+		enabled:    true,
+		caFile:     files.CA,
+		clientAuth: "none",
+		minVersion: "1.2",
+	}
+	expect.NoError(oldClient.Reload())
+
+	// Replace all certificates with ones signed by a new CA
+	_, err = tlstest.WriteCertificates(dir)
+	expect.NoError(err)
+
+	clientErr, _ := handshakeTLS(listener, oldClient)
+	expect.NoError(clientErr)
+
+	expect.NoError(server.Reload())
+	clientErr, _ = handshakeTLS(listener, oldClient)
+	expect.NotNil(clientErr)
+
+	newClient := &TLSConfig{
+		enabled:    true,
+		caFile:     files.CA,
+		clientAuth: "none",
+		minVersion: "1.2",
+	}
+	expect.NoError(newClient.Reload())
+
+	clientErr, serverErr := handshakeTLS(listener, newClient)
+	expect.NoError(clientErr)
+	expect.NoError(serverErr)
+
+	// A failed reload keeps the current certificate
+	server.privateKeyFile = files.ClientKey
+	expect.NotNil(server.Reload())
+	clientErr, _ = handshakeTLS(listener, newClient)
+	expect.NoError(clientErr)
+}
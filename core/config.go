@@ -16,14 +16,12 @@ package core
 
 import (
 	"fmt"
-	"io/ioutil"
 	"reflect"
 
 	"github.com/sirupsen/logrus"
 	"github.com/trivago/tgo"
 	"github.com/trivago/tgo/tcontainer"
 	"github.com/trivago/tgo/treflect"
-	yaml "gopkg.in/yaml.v2"
 )
 
 const pluginAggregate = "Aggregate"
@@ -40,23 +38,56 @@ type Config struct {
 	Plugins []PluginConfig
 }
 
+// ConfigDiff holds the plugin configs that differ between two configurations.
+// Disabled plugins are treated as if they were not configured at all.
+type ConfigDiff struct {
+	Added   []PluginConfig
+	Removed []PluginConfig
+	Changed []PluginConfig
+}
+
 // ReadConfig creates a config from a yaml byte stream.
+// Included files are resolved relative to the current working directory.
 func ReadConfig(buffer []byte) (*Config, error) {
-	config := new(Config)
-	if err := yaml.Unmarshal(buffer, &config.Values); err != nil {
+	source := newConfigSource()
+	if err := source.read(buffer, ""); err != nil {
 		return nil, err
 	}
+	return newConfigFromSource(source)
+}
+
+// ReadConfigFromFile parses a YAML config file into a new Config struct.
+// Config files may include other files by using the top level key "include".
+// Its value is either a single path or a list of paths that may contain glob
+// patterns. Relative paths are resolved relative to the including file.
+// String values may reference environment variables by using "${env:NAME}"
+// or "${env:NAME:-default}" and the contents of files by using
+// "${file:PATH}". Use "$${env:" or "$${file:" to write these literally.
+// Other uses of "${" are not changed.
+func ReadConfigFromFile(path string) (*Config, error) {
+	source := newConfigSource()
+	if err := source.readFile(path); err != nil {
+		return nil, err
+	}
+	return newConfigFromSource(source)
+}
+
+func newConfigFromSource(source *configSource) (*Config, error) {
+	config := new(Config)
+	config.Values = source.values
 
 	// As there might be multiple instances of the same plugin class we iterate
 	// over an array here.
 	hasError := false
 	for pluginID, configValues := range config.Values {
+		origin := source.origins[pluginID]
+
 		if typeName, _ := configValues.String("Type"); typeName == pluginAggregate {
 			// aggregate behavior
 			aggregateMap, err := configValues.MarshalMap("Plugins")
 			if err != nil {
 				hasError = true
-				logrus.Error("Can't read 'Aggregate' configuration: ", err)
+				logrus.Error(annotateConfigError(origin.locate("Plugins"), fmt.Errorf("Can't read 'Aggregate' configuration: %s", err.Error())))
 				continue
 			}
 
@@ -66,7 +97,7 @@ func ReadConfig(buffer []byte) (*Config, error) {
 				subConfig, err := tcontainer.ConvertToMarshalMap(subConfigValues, nil)
 				if err != nil {
 					hasError = true
-					logrus.Error("Error in plugin config ", subPluginsID, err)
+					logrus.Error(annotateConfigError(origin.locate("Plugins/"+subPluginID), fmt.Errorf("Error in plugin config %s: %s", subPluginsID, err.Error())))
 					continue
 				}
 
@@ -75,6 +106,7 @@ func ReadConfig(buffer []byte) (*Config, error) {
 				delete(configValues, "Plugins")
 
 				pluginConfig := NewPluginConfig(subPluginsID, "")
+				pluginConfig.origin = origin.subOrigin("Plugins/"+subPluginID, origin)
 				pluginConfig.Read(configValues)
 				pluginConfig.Read(subConfig)
 
@@ -83,6 +115,7 @@ func ReadConfig(buffer []byte) (*Config, error) {
 		} else {
 			// default behavior
 			pluginConfig := NewPluginConfig(pluginID, "")
+			pluginConfig.origin = origin
 			pluginConfig.Read(configValues)
 			config.Plugins = append(config.Plugins, pluginConfig)
 		}
@@ -94,16 +127,6 @@ func ReadConfig(buffer []byte) (*Config, error) {
 	return config, nil
 }
 
-// ReadConfigFromFile parses a YAML config file into a new Config struct.
-func ReadConfigFromFile(path string) (*Config, error) {
-	buffer, err := ioutil.ReadFile(path)
-	if err != nil {
-		return nil, err
-	}
-
-	return ReadConfig(buffer)
-}
-
 // Validate checks all plugin configs and plugins on validity. I.e. it checks
 // on mandatory fields and correct implementation of consumer, producer or
 // stream interface. It does NOT call configure for each plugin.
@@ -113,16 +136,16 @@ func (conf *Config) Validate() error {
 
 	for _, config := range conf.Plugins {
 		if config.Typename == "" {
-			errors.Pushf("Plugin type is not set for '%s'", config.ID)
+			errors.Push(config.annotateError("", fmt.Errorf("Plugin type is not set for '%s'", config.ID)))
 			continue
 		}
 
 		pluginType := TypeRegistry.GetTypeOf(config.Typename)
 		if pluginType == nil {
 			if suggestion := suggestType(config.Typename); suggestion != "" {
-				errors.Pushf("Type '%s' used for '%s' not found. Did you mean '%s'?", config.Typename, config.ID, suggestion)
+				errors.Push(config.annotateError("Type", fmt.Errorf("Type '%s' used for '%s' not found. Did you mean '%s'?", config.Typename, config.ID, suggestion)))
 			} else {
-				errors.Pushf("Type '%s' used for '%s' not found", config.Typename, config.ID)
+				errors.Push(config.annotateError("Type", fmt.Errorf("Type '%s' used for '%s' not found", config.Typename, config.ID)))
 			}
 
 			continue // ### continue ###
@@ -139,13 +162,60 @@ func (conf *Config) Validate() error {
 			continue
 		}
 
-		errors.Pushf("Type '%s' used for '%s' does not implement a common interface", config.Typename, config.ID)
+		errors.Push(config.annotateError("Type", fmt.Errorf("Type '%s' used for '%s' does not implement a common interface", config.Typename, config.ID)))
 		getClosestMatch(pluginType, &errors)
 	}
 
 	return errors.OrNil()
 }
 
+// GetPluginConfig returns the config of the plugin with the given ID. The
+// second return value is false if no such plugin is configured.
+func (conf *Config) GetPluginConfig(pluginID string) (PluginConfig, bool) {
+	for _, config := range conf.Plugins {
+		if config.ID == pluginID {
+			return config, true
+		}
+	}
+	return PluginConfig{}, false
+}
+
+// Diff compares this config to a newer version of it. The returned ConfigDiff
+// lists all plugins that were added, removed or changed in next. Changed
+// plugins are returned with their new configuration.
+func (conf *Config) Diff(next *Config) ConfigDiff {
+	diff := ConfigDiff{}
+
+	for _, nextConfig := range next.Plugins {
+		if !nextConfig.Enable {
+			continue // ### continue, disabled ###
+		}
+		prevConfig, exists := conf.GetPluginConfig(nextConfig.ID)
+		switch {
+		case !exists || !prevConfig.Enable:
+			diff.Added = append(diff.Added, nextConfig)
+		case !prevConfig.IsEqual(nextConfig):
+			diff.Changed = append(diff.Changed, nextConfig)
+		}
+	}
+
+	for _, prevConfig := range conf.Plugins {
+		if !prevConfig.Enable {
+			continue // ### continue, disabled ###
+		}
+		if nextConfig, exists := next.GetPluginConfig(prevConfig.ID); !exists || !nextConfig.Enable {
+			diff.Removed = append(diff.Removed, prevConfig)
+		}
+	}
+
+	return diff
+}
+
+// IsEmpty returns true if no plugin has been added, removed or changed.
+func (diff ConfigDiff) IsEmpty() bool {
+	return len(diff.Added) == 0 && len(diff.Removed) == 0 && len(diff.Changed) == 0
+}
+
 // GetConsumers returns all consumer plugins from the config
 func (conf *Config) GetConsumers() []PluginConfig {
 	configs := []PluginConfig{}
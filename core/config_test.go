@@ -15,6 +15,9 @@
 package core
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"strings"
 	"sync"
 	"testing"
@@ -162,3 +165,128 @@ func TestConfigGetPluginTypeMethods(t *testing.T) {
 		expect.Equal("core.TypeMockB", pluginConf.Typename)
 	}
 }
+
+func TestConfigDiff(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	prevConfig := []byte("keep: {Type: core.TypeMockA, Streams: foo}\nchange: {Type: core.TypeMockC, Streams: foo}\nremove: {Type: core.TypeMockC, Streams: bar}")
+	nextConfig := []byte("keep: {Type: core.TypeMockA, Streams: foo}\nchange: {Type: core.TypeMockC, Streams: bar}\nadd: {Type: core.TypeMockB, Stream: foo}")
+
+	prev, err := ReadConfig(prevConfig)
+	expect.NoError(err)
+	next, err := ReadConfig(nextConfig)
+	expect.NoError(err)
+
+	diff := prev.Diff(next)
+	expect.False(diff.IsEmpty())
+
+	expect.Equal(1, len(diff.Added))
+	expect.Equal("add", diff.Added[0].ID)
+
+	expect.Equal(1, len(diff.Removed))
+	expect.Equal("remove", diff.Removed[0].ID)
+
+	expect.Equal(1, len(diff.Changed))
+	expect.Equal("change", diff.Changed[0].ID)
+
+	streams, err := diff.Changed[0].Settings.String("Streams")
+	expect.NoError(err)
+	expect.Equal("bar", streams)
+
+	expect.True(prev.Diff(prev).IsEmpty())
+}
+
+func TestReadConfigFromFileWithIncludes(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+	dir, err := ioutil.TempDir("", "gollum-config")
+	expect.NoError(err)
+	defer os.RemoveAll(dir)
+
+	os.Setenv("GOLLUM_TEST_HOST", "localhost")
+	defer os.Unsetenv("GOLLUM_TEST_HOST")
+
+	expect.NoError(ioutil.WriteFile(filepath.Join(dir, "secret"), []byte("password\n"), 0600))
+	expect.NoError(ioutil.WriteFile(filepath.Join(dir, "producers.yaml"), []byte(
+		"producerId:\n"+
+			"  Type: core.TypeMockC\n"+
+			"  Streams: foo\n"+
+			"  Address: \"${env:GOLLUM_TEST_HOST}:${env:GOLLUM_TEST_PORT:-8080}\"\n"+
+			"  Password: ${file:"+filepath.Join(dir, "secret")+"}\n"+
+			"  Escaped: $${env:NOT_SET}\n"+
+			"  Template: ${1}\n"), 0600))
+	expect.NoError(ioutil.WriteFile(filepath.Join(dir, "gollum.yaml"), []byte(
+		"include: producers.yaml\n"+
+			"consumerId:\n"+
+			"  Type: core.TypeMockA\n"+
+			"  Streams: foo\n"), 0600))
+
+	conf, err := ReadConfigFromFile(filepath.Join(dir, "gollum.yaml"))
+	expect.NoError(err)
+	expect.Equal(2, len(conf.Plugins))
+
+	producer, exists := conf.GetPluginConfig("producerId")
+	expect.True(exists)
+	expect.Equal(filepath.Join(dir, "producers.yaml")+":4", producer.GetLocation("Address"))
+
+	address, _ := producer.Settings.String("Address")
+	expect.Equal("localhost:8080", address)
+	password, _ := producer.Settings.String("Password")
+	expect.Equal("password", password)
+	escaped, _ := producer.Settings.String("Escaped")
+	expect.Equal("${env:NOT_SET}", escaped)
+	template, _ := producer.Settings.String("Template")
+	expect.Equal("${1}", template)
+}
+
+func TestReadConfigErrorLocation(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	_, err := ReadConfig([]byte("consumerId:\n  Type: core.TypeMockA\n  Streams: ${env:GOLLUM_TEST_NOT_SET}\n"))
+	expect.NotNil(err)
+	expect.True(strings.HasPrefix(err.Error(), "line 3: "))
+
+	_, err = ReadConfig([]byte("include: does-not-exist.yaml\n"))
+	expect.NotNil(err)
+	expect.True(strings.HasPrefix(err.Error(), "line 1: "))
+
+	TypeRegistry.Register(TypeMockA{})
+	conf, err := ReadConfig([]byte("consumerId:\n  Type: core.TypeMockA\n  Batch:\n    MaxCont: 10\n"))
+	expect.NoError(err)
+
+	pluginConf, _ := conf.GetPluginConfig("consumerId")
+	err = pluginConf.Validate()
+	expect.NotNil(err)
+	expect.True(strings.HasPrefix(err.Error(), "line 3: "))
+}
+
+func TestReadConfigErrorLocationInList(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	_, err := ReadConfig([]byte(
+		"consumerId:\n" +
+			"  Type: core.TypeMockA\n" +
+			"  Modulators:\n" +
+			"    - format.A:\n" +
+			"        Key: a\n" +
+			"    - format.B:\n" +
+			"        Key: ${env:GOLLUM_TEST_NOT_SET}\n"))
+	expect.NotNil(err)
+	expect.True(strings.HasPrefix(err.Error(), "line 7: "))
+
+	// List items may be indented like their parent key
+	_, err = ReadConfig([]byte(
+		"consumerId:\n" +
+			"  Type: core.TypeMockA\n" +
+			"  Modulators:\n" +
+			"  - format.A\n" +
+			"  - format.B:\n" +
+			"    Key: ${env:GOLLUM_TEST_NOT_SET}\n" +
+			"  Streams: [a, b]\n"))
+	expect.NotNil(err)
+	expect.True(strings.HasPrefix(err.Error(), "line 6: "))
+
+	lines := scanConfigKeyLines([]byte("a:\n  - - b: 1\n    - c: 2\n  - d: 3\n"))
+	expect.Equal(2, lines["a/0/0/b"])
+	expect.Equal(3, lines["a/0/1/c"])
+	expect.Equal(4, lines["a/1/d"])
+}
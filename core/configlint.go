@@ -0,0 +1,344 @@
+// Copyright 2015-2018 trivago N.V.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/trivago/tgo/tcontainer"
+)
+
+// ConfigIssue describes a problem found by Config.Lint.
+type ConfigIssue struct {
+	Location  string
+	PluginID  string
+	Message   string
+	IsWarning bool
+}
+
+// String returns the issue in the format "location: plugin 'ID': message".
+func (issue ConfigIssue) String() string {
+	text := issue.Message
+	if issue.PluginID != "" {
+		text = fmt.Sprintf("plugin '%s': %s", issue.PluginID, text)
+	}
+	if issue.Location != "" {
+		text = fmt.Sprintf("%s: %s", issue.Location, text)
+	}
+	return text
+}
+
+// configStreamRef references the setting of a plugin a stream is used in.
+type configStreamRef struct {
+	config PluginConfig
+	key    string
+}
+
+// configStreamUsage stores which plugins send messages to or receive messages
+// from a stream.
+type configStreamUsage struct {
+	senders   map[string][]configStreamRef
+	receivers map[string][]configStreamRef
+	routers   map[string]configStreamRef
+	wildcard  bool
+}
+
+// Lint checks all enabled plugins for unknown keys, values that do not match
+// the type declared by the plugin and streams that are not connected. Unknown
+// keys are found by configuring each plugin without starting it, i.e. in the
+// same way as when testing a config.
+// Issues about streams are returned as warnings.
+func (conf *Config) Lint() []ConfigIssue {
+	issues := []ConfigIssue{}
+	for _, config := range conf.Plugins {
+		if config.Enable {
+			issues = append(issues, lintPlugin(config)...)
+		}
+	}
+	return append(issues, conf.lintStreams()...)
+}
+
+func lintPlugin(config PluginConfig) (issues []ConfigIssue) {
+	newIssue := func(key string, err error) ConfigIssue {
+		return ConfigIssue{
+			Location: config.GetLocation(key),
+			PluginID: config.ID,
+			Message:  err.Error(),
+		}
+	}
+
+	if !TypeRegistry.IsTypeRegistered(config.Typename) {
+		if suggestion := suggestType(config.Typename); suggestion != "" {
+			return []ConfigIssue{newIssue("Type", fmt.Errorf("unknown type '%s'. Did you mean '%s'?", config.Typename, suggestion))}
+		}
+		return []ConfigIssue{newIssue("Type", fmt.Errorf("unknown type '%s'", config.Typename))}
+	}
+
+	schema, _ := GetPluginSchema(config.Typename)
+	for _, key := range schema.GetKeys() {
+		if value, exists := config.Settings.Value(key); exists {
+			if err := schema[key].CheckValue(key, value); err != nil {
+				issues = append(issues, newIssue(key, err))
+			}
+		}
+	}
+
+	hasTypeErrors := len(issues) > 0
+
+	// Configure the plugin to find unknown keys. Keys read by the Configure
+	// method are not part of the schema.
+	defer func() {
+		if r := recover(); r != nil {
+			issues = append(issues, newIssue("", fmt.Errorf("configure failed: %v", r)))
+		}
+	}()
+
+	obj, err := TypeRegistry.New(config.Typename)
+	if err != nil {
+		return []ConfigIssue{newIssue("Type", err)}
+	}
+	plugin, isPlugin := obj.(Plugin)
+	if !isPlugin {
+		return []ConfigIssue{newIssue("Type", fmt.Errorf("'%s' is not a plugin type", config.Typename))}
+	}
+
+	// Type errors are reported by configure, too
+	reader := NewPluginConfigReader(&config)
+	if err := reader.Configure(plugin); err != nil && !hasTypeErrors {
+		issues = append(issues, ConfigIssue{PluginID: config.ID, Message: err.Error()})
+	}
+
+	for _, key := range getUnknownKeys(config, config.Settings, "") {
+		if suggestion := config.suggestKey(key); suggestion != "" {
+			issues = append(issues, newIssue(key, fmt.Errorf("unknown key '%s'. Did you mean '%s'?", key, suggestion)))
+		} else {
+			issues = append(issues, newIssue(key, fmt.Errorf("unknown key '%s'", key)))
+		}
+	}
+
+	return issues
+}
+
+// getUnknownKeys returns all keys of the given settings that have not been
+// read by the plugin. Nested maps are returned as "/" separated paths and are
+// only checked if the plugin reads keys below the map.
+func getUnknownKeys(config PluginConfig, settings tcontainer.MarshalMap, prefix string) []string {
+	keys := make([]string, 0, len(settings))
+	for key := range settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	unknownKeys := []string{}
+	for _, key := range keys {
+		path := prefix + key
+		if !config.validKeys[path] {
+			unknownKeys = append(unknownKeys, path)
+			continue // ### continue, unknown key ###
+		}
+
+		nested, isMap := settings[key].(tcontainer.MarshalMap)
+		if isMap && config.hasNestedKeys(path) {
+			unknownKeys = append(unknownKeys, getUnknownKeys(config, nested, path+"/")...)
+		}
+	}
+	return unknownKeys
+}
+
+// lintStreams reports streams messages are sent to but no producer or router
+// is listening on, as well as producers and routers listening on streams that
+// no plugin sends messages to.
+func (conf *Config) lintStreams() []ConfigIssue {
+	usage := configStreamUsage{
+		senders:   make(map[string][]configStreamRef),
+		receivers: make(map[string][]configStreamRef),
+		routers:   make(map[string]configStreamRef),
+	}
+
+	for _, config := range conf.Plugins {
+		if !config.Enable {
+			continue // ### continue, disabled ###
+		}
+
+		pluginType := TypeRegistry.GetTypeOf(config.Typename)
+		if pluginType == nil {
+			continue // ### continue, unknown type ###
+		}
+
+		subscriptionKey := ""
+		switch {
+		case pluginType.Implements(producerInterface):
+			subscriptionKey = "Streams"
+		case pluginType.Implements(routerInterface):
+			subscriptionKey = "Stream"
+		}
+
+		for key, streams := range getConfigStreams(config.Typename, config.Settings) {
+			ref := configStreamRef{config, key}
+			for _, stream := range streams {
+				switch {
+				case key != subscriptionKey:
+					usage.senders[stream] = append(usage.senders[stream], ref)
+				case stream == WildcardStream:
+					usage.wildcard = true
+				case subscriptionKey == "Stream":
+					usage.routers[stream] = ref
+				default:
+					usage.receivers[stream] = append(usage.receivers[stream], ref)
+				}
+			}
+		}
+	}
+
+	return usage.getIssues()
+}
+
+func (usage configStreamUsage) getIssues() []ConfigIssue {
+	issues := []ConfigIssue{}
+	newIssue := func(ref configStreamRef, format string, args ...interface{}) ConfigIssue {
+		return ConfigIssue{
+			Location:  ref.config.GetLocation(ref.key),
+			PluginID:  ref.config.ID,
+			Message:   fmt.Sprintf(format, args...),
+			IsWarning: true,
+		}
+	}
+
+	for _, stream := range getSortedStreams(usage.senders) {
+		if isInternalStreamName(stream) || stream == WildcardStream {
+			continue // ### continue, not checked ###
+		}
+		_, hasRouter := usage.routers[stream]
+		if hasRouter || len(usage.receivers[stream]) > 0 || usage.wildcard {
+			continue // ### continue, stream is connected ###
+		}
+		for _, ref := range usage.senders[stream] {
+			issues = append(issues, newIssue(ref, "stream '%s' has no producer", stream))
+		}
+	}
+
+	for _, stream := range getSortedStreams(usage.receivers) {
+		if isInternalStreamName(stream) || len(usage.senders[stream]) > 0 {
+			continue // ### continue, stream is connected ###
+		}
+		for _, ref := range usage.receivers[stream] {
+			issues = append(issues, newIssue(ref, "nothing routes to stream '%s'", stream))
+		}
+	}
+
+	routerStreams := make([]string, 0, len(usage.routers))
+	for stream := range usage.routers {
+		routerStreams = append(routerStreams, stream)
+	}
+	sort.Strings(routerStreams)
+
+	for _, stream := range routerStreams {
+		if !isInternalStreamName(stream) && len(usage.senders[stream]) == 0 {
+			issues = append(issues, newIssue(usage.routers[stream], "nothing routes to stream '%s'", stream))
+		}
+	}
+
+	return issues
+}
+
+func getSortedStreams(streams map[string][]configStreamRef) []string {
+	names := make([]string, 0, len(streams))
+	for name := range streams {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func isInternalStreamName(stream string) bool {
+	return strings.HasPrefix(stream, "_")
+}
+
+// getConfigStreams returns the values of all settings declared as stream or
+// stream array by the schema of the given type. Nested plugins, e.g.
+// modulators, are included. Their streams are returned for the key of the
+// plugin array.
+func getConfigStreams(typename string, settings tcontainer.MarshalMap) map[string][]string {
+	streams := make(map[string][]string)
+	schema, err := GetPluginSchema(typename)
+	if err != nil {
+		return streams
+	}
+
+	for key, setting := range schema {
+		value, exists := settings.Value(key)
+		if !exists {
+			continue // ### continue, not set ###
+		}
+
+		switch setting.Type {
+		case SettingTypeStream, SettingTypeStreamArray:
+			for _, stream := range getStreamNames(value) {
+				streams[key] = append(streams[key], stream)
+			}
+
+		case SettingTypePluginArray:
+			entries, _ := value.([]interface{})
+			for _, entry := range entries {
+				nestedType, nestedSettings := getNestedPluginConfig(entry)
+				for _, nested := range getConfigStreams(nestedType, nestedSettings) {
+					streams[key] = append(streams[key], nested...)
+				}
+			}
+		}
+	}
+	return streams
+}
+
+func getStreamNames(value interface{}) []string {
+	switch typedValue := value.(type) {
+	case string:
+		if typedValue != "" {
+			return []string{typedValue}
+		}
+	case []interface{}:
+		names := []string{}
+		for _, element := range typedValue {
+			if name, isString := element.(string); isString && name != "" {
+				names = append(names, name)
+			}
+		}
+		return names
+	}
+	return nil
+}
+
+// getNestedPluginConfig returns the type and settings of a plugin array entry
+// as read by PluginConfigReader.GetPluginArray.
+func getNestedPluginConfig(entry interface{}) (string, tcontainer.MarshalMap) {
+	if typename, isString := entry.(string); isString {
+		return typename, tcontainer.NewMarshalMap()
+	}
+
+	entryMap, err := tcontainer.ConvertToMarshalMap(entry, nil)
+	if err != nil {
+		return "", nil
+	}
+	for typename, value := range entryMap {
+		settings, err := tcontainer.ConvertToMarshalMap(value, nil)
+		if err != nil {
+			settings = tcontainer.NewMarshalMap()
+		}
+		return typename, settings
+	}
+	return "", nil
+}
@@ -0,0 +1,76 @@
+// Copyright 2015-2018 trivago N.V.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"testing"
+
+	"github.com/trivago/tgo/ttesting"
+)
+
+func TestGetPluginSchema(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+	TypeRegistry.Register(TypeMockC{})
+
+	schema, err := GetPluginSchema("core.TypeMockC")
+	expect.NoError(err)
+
+	expect.Equal(SettingTypeStreamArray, schema["Streams"].Type)
+	expect.Equal(SettingTypeStream, schema["FallbackStream"].Type)
+	expect.Equal(SettingTypePluginArray, schema["Modulators"].Type)
+	expect.Equal(SettingTypeInt, schema["ShutdownTimeoutMs"].Type)
+	expect.Equal("1000", schema["ShutdownTimeoutMs"].Default)
+	expect.Equal("ms", schema["ShutdownTimeoutMs"].Metric)
+
+	expect.NoError(schema["ShutdownTimeoutMs"].CheckValue("ShutdownTimeoutMs", 10))
+	expect.NoError(schema["ShutdownTimeoutMs"].CheckValue("ShutdownTimeoutMs", "10"))
+	expect.NotNil(schema["ShutdownTimeoutMs"].CheckValue("ShutdownTimeoutMs", "ten"))
+	expect.NoError(schema["Streams"].CheckValue("Streams", "foo"))
+	expect.NotNil(schema["Streams"].CheckValue("Streams", []interface{}{1}))
+
+	_, err = GetPluginSchema("core.Unknown")
+	expect.NotNil(err)
+}
+
+func TestConfigLint(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+	TypeRegistry.Register(TypeMockA{})
+	TypeRegistry.Register(TypeMockB{})
+	TypeRegistry.Register(TypeMockC{})
+	registerMockRouter("lintFoo")
+	registerMockRouter("lintNowhere")
+
+	conf, err := ReadConfig([]byte(
+		"consumer:\n" +
+			"  Type: core.TypeMockA\n" +
+			"  Streams: [lintFoo, lintNowhere]\n" +
+			"producer:\n" +
+			"  Type: core.TypeMockC\n" +
+			"  Streams: [lintFoo, lintOrphan]\n" +
+			"  ShutdownTimeoutMs: abc\n" +
+			"  FallbakStream: lintFoo\n"))
+	expect.NoError(err)
+
+	issues := conf.Lint()
+	messages := []string{}
+	for _, issue := range issues {
+		messages = append(messages, issue.String())
+	}
+	expect.Equal(4, len(issues))
+	expect.Contains(messages, "line 7: plugin 'producer': 'ShutdownTimeoutMs' is expected to be of type int")
+	expect.Contains(messages, "line 8: plugin 'producer': unknown key 'FallbakStream'. Did you mean 'FallbackStream'?")
+	expect.Contains(messages, "line 3: plugin 'consumer': stream 'lintNowhere' has no producer")
+	expect.Contains(messages, "line 6: plugin 'producer': nothing routes to stream 'lintOrphan'")
+}
@@ -0,0 +1,220 @@
+// Copyright 2015-2018 trivago N.V.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+
+	"github.com/trivago/tgo/tcontainer"
+	"github.com/trivago/tgo/treflect"
+	"github.com/trivago/tgo/tstrings"
+)
+
+// Setting types used by SettingSchema
+const (
+	SettingTypeBool        = "bool"
+	SettingTypeInt         = "int"
+	SettingTypeUint        = "uint"
+	SettingTypeString      = "string"
+	SettingTypeURL         = "url"
+	SettingTypeStream      = "stream"
+	SettingTypeStringArray = "[]string"
+	SettingTypeStreamArray = "[]stream"
+	SettingTypePluginArray = "[]plugin"
+)
+
+// SettingSchema describes a configuration key declared by a "config" struct
+// tag.
+type SettingSchema struct {
+	Type    string `json:"type"`
+	Default string `json:"default,omitempty"`
+	Metric  string `json:"metric,omitempty"`
+}
+
+// PluginSchema maps all configuration keys declared by the struct tags of a
+// plugin type to their schema. Plugins may read additional keys in their
+// Configure method. These keys are not part of the schema.
+type PluginSchema map[string]SettingSchema
+
+var urlType = reflect.TypeOf(url.URL{})
+
+// GetPluginSchema generates the schema for the given plugin type by
+// reflecting over the "config" struct tags of the type and all embedded or
+// nested structs.
+func GetPluginSchema(typename string) (PluginSchema, error) {
+	pluginType := TypeRegistry.GetTypeOf(typename)
+	if pluginType == nil {
+		return nil, fmt.Errorf("type '%s' is not registered", typename)
+	}
+
+	schema := make(PluginSchema)
+	schema.addStruct(treflect.RemovePtrFromType(pluginType))
+	return schema, nil
+}
+
+// GetPluginSchemas returns the schema of all registered plugin types that
+// start with the given prefix, e.g. "producer.".
+func GetPluginSchemas(prefix string) map[string]PluginSchema {
+	schemas := make(map[string]PluginSchema)
+	for _, typename := range TypeRegistry.GetRegistered(prefix) {
+		if schema, err := GetPluginSchema(typename); err == nil {
+			schemas[typename] = schema
+		}
+	}
+	return schemas
+}
+
+// GetKeys returns all keys of this schema in alphabetical order.
+func (schema PluginSchema) GetKeys() []string {
+	keys := make([]string, 0, len(schema))
+	for key := range schema {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// addStruct follows the same rules as PluginConfigReader.Configure, i.e.
+// tagged fields are added and untagged struct fields are traversed.
+// Pointers to structs are not traversed as they are usually nil before
+// a plugin is configured.
+func (schema PluginSchema) addStruct(structType reflect.Type) {
+	for fieldIdx := 0; fieldIdx < structType.NumField(); fieldIdx++ {
+		field := structType.Field(fieldIdx)
+
+		if key, hasFieldConfig := field.Tag.Lookup("config"); hasFieldConfig {
+			tag := reflect.StructTag(field.Tag)
+			setting := SettingSchema{
+				Type:   getSettingType(field.Type),
+				Metric: tag.Get(PluginStructTagMetric),
+			}
+			setting.Default, _ = tag.Lookup(PluginStructTagDefault)
+			schema[key] = setting
+			continue // ### continue, configured by tag ###
+		}
+
+		if field.Type.Kind() == reflect.Struct {
+			schema.addStruct(field.Type)
+		}
+	}
+}
+
+func getSettingType(fieldType reflect.Type) string {
+	switch fieldType.Kind() {
+	case reflect.Bool:
+		return SettingTypeBool
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return SettingTypeInt
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if fieldType.Name() == "MessageStreamID" {
+			return SettingTypeStream
+		}
+		return SettingTypeUint
+
+	case reflect.Interface:
+		return SettingTypeStream // Router
+
+	case reflect.Ptr:
+		if fieldType.Elem() == urlType {
+			return SettingTypeURL
+		}
+
+	case reflect.Array, reflect.Slice:
+		elementType := fieldType.Elem()
+		switch elementType.Kind() {
+		case reflect.String:
+			return SettingTypeStringArray
+		case reflect.Int8, reflect.Uint8:
+			return SettingTypeString
+		case reflect.Uint64:
+			return SettingTypeStreamArray
+		case reflect.Interface:
+			if elementType.Name() == "Router" {
+				return SettingTypeStreamArray
+			}
+			return SettingTypePluginArray
+		}
+	}
+
+	return SettingTypeString
+}
+
+// CheckValue returns an error if the given value cannot be read as the type
+// defined by this setting.
+func (setting SettingSchema) CheckValue(key string, value interface{}) error {
+	isValid := true
+	switch setting.Type {
+	case SettingTypeBool:
+		switch typedValue := value.(type) {
+		case bool:
+		case string:
+			_, err := strconv.ParseBool(typedValue)
+			isValid = err == nil
+		default:
+			isValid = false
+		}
+
+	case SettingTypeInt, SettingTypeUint:
+		switch typedValue := value.(type) {
+		case int, int64, uint64, float64:
+		case string:
+			_, err := tstrings.AtoI64(typedValue)
+			isValid = err == nil
+		default:
+			isValid = false
+		}
+
+	case SettingTypeStringArray, SettingTypeStreamArray:
+		switch typedValue := value.(type) {
+		case string:
+		case []interface{}:
+			for _, element := range typedValue {
+				if _, isString := element.(string); !isString {
+					isValid = false
+				}
+			}
+		default:
+			isValid = false
+		}
+
+	case SettingTypePluginArray:
+		switch typedValue := value.(type) {
+		case []interface{}:
+			for _, element := range typedValue {
+				switch element.(type) {
+				case string, tcontainer.MarshalMap, map[interface{}]interface{}:
+				default:
+					isValid = false
+				}
+			}
+		default:
+			isValid = false
+		}
+
+	default:
+		_, isValid = value.(string)
+	}
+
+	if !isValid {
+		return fmt.Errorf("'%s' is expected to be of type %s", key, setting.Type)
+	}
+	return nil
+}
@@ -0,0 +1,410 @@
+// Copyright 2015-2018 trivago N.V.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/trivago/tgo/tcontainer"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// configIncludeKey is the top level key used to include other config files.
+// Its value can either be a single path or a list of paths. Paths may contain
+// glob patterns and are relative to the including file.
+const configIncludeKey = "include"
+
+var (
+	configVariable = regexp.MustCompile(`\$?\$\{(env|file):([^}]*)\}`)
+	configKeyLine  = regexp.MustCompile(`^(\s*)("[^"]+"|'[^']+'|[^\s"'#:\-][^#:]*?)\s*:(\s|$)`)
+)
+
+// configOrigin stores where the settings of a plugin have been defined.
+type configOrigin struct {
+	file  string
+	lines map[string]int
+}
+
+// configSource holds the raw plugin values read from a config file and all
+// the files included by it.
+type configSource struct {
+	values  map[string]tcontainer.MarshalMap
+	origins map[string]*configOrigin
+	reading map[string]bool
+}
+
+func newConfigSource() *configSource {
+	return &configSource{
+		values:  make(map[string]tcontainer.MarshalMap),
+		origins: make(map[string]*configOrigin),
+		reading: make(map[string]bool),
+	}
+}
+
+// locate returns "file:line" for the given, "/" separated key. If the key
+// cannot be found, the location of the closest parent key is returned.
+// An empty string is returned if the origin is unknown.
+func (origin *configOrigin) locate(key string) string {
+	if origin == nil {
+		return ""
+	}
+
+	path := strings.ToLower(key)
+	for {
+		if line, exists := origin.lines[path]; exists {
+			return formatConfigLocation(origin.file, line)
+		}
+		if path == "" {
+			return formatConfigLocation(origin.file, 0)
+		}
+
+		if idx := strings.LastIndex(path, "/"); idx > -1 {
+			path = path[:idx]
+		} else {
+			path = ""
+		}
+	}
+}
+
+// subOrigin returns the origin of the settings stored below the given key.
+// If base is not nil, its lines are used for keys not found below key.
+func (origin *configOrigin) subOrigin(key string, base *configOrigin) *configOrigin {
+	sub := &configOrigin{
+		file:  origin.file,
+		lines: make(map[string]int),
+	}
+	if base != nil {
+		for path, line := range base.lines {
+			sub.lines[path] = line
+		}
+	}
+
+	prefix := strings.ToLower(key) + "/"
+	for path, line := range origin.lines {
+		if strings.HasPrefix(path, prefix) {
+			sub.lines[path[len(prefix):]] = line
+		}
+	}
+	if line, exists := origin.lines[strings.ToLower(key)]; exists {
+		sub.lines[""] = line
+	}
+	return sub
+}
+
+func formatConfigLocation(file string, line int) string {
+	switch {
+	case file == "" && line == 0:
+		return ""
+	case file == "":
+		return fmt.Sprintf("line %d", line)
+	case line == 0:
+		return file
+	default:
+		return fmt.Sprintf("%s:%d", file, line)
+	}
+}
+
+// annotateConfigError prefixes err with the given location.
+func annotateConfigError(location string, err error) error {
+	if err == nil || location == "" {
+		return err
+	}
+	return fmt.Errorf("%s: %s", location, err.Error())
+}
+
+// readFile parses the given config file and all files included by it.
+func (source *configSource) readFile(path string) error {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	if source.reading[absPath] {
+		return fmt.Errorf("%s: file is included recursively", path)
+	}
+
+	buffer, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	source.reading[absPath] = true
+	defer delete(source.reading, absPath)
+
+	return source.read(buffer, path)
+}
+
+// read parses a YAML config stored in buffer. The file parameter is used to
+// resolve includes and for error messages. It may be empty.
+func (source *configSource) read(buffer []byte, file string) error {
+	values := make(map[string]interface{})
+	if err := yaml.Unmarshal(buffer, &values); err != nil {
+		return annotateConfigError(file, err)
+	}
+
+	origin := &configOrigin{
+		file:  file,
+		lines: scanConfigKeyLines(buffer),
+	}
+
+	if include, exists := values[configIncludeKey]; exists {
+		delete(values, configIncludeKey)
+		if err := source.include(include, file, origin.locate(configIncludeKey)); err != nil {
+			return err
+		}
+	}
+
+	pluginIDs := make([]string, 0, len(values))
+	for pluginID := range values {
+		pluginIDs = append(pluginIDs, pluginID)
+	}
+	sort.Strings(pluginIDs)
+
+	for _, pluginID := range pluginIDs {
+		pluginOrigin := origin.subOrigin(pluginID, nil)
+		location := pluginOrigin.locate("")
+
+		if prevOrigin, exists := source.origins[pluginID]; exists {
+			return annotateConfigError(location, fmt.Errorf("plugin '%s' is already defined at %s", pluginID, prevOrigin.locate("")))
+		}
+
+		pluginValues, err := tcontainer.ConvertToMarshalMap(values[pluginID], nil)
+		if err != nil {
+			return annotateConfigError(location, fmt.Errorf("plugin '%s' must be a map of settings", pluginID))
+		}
+
+		if err := interpolateConfigMap(pluginValues, "", pluginOrigin); err != nil {
+			return err
+		}
+
+		source.values[pluginID] = pluginValues
+		source.origins[pluginID] = pluginOrigin
+	}
+
+	return nil
+}
+
+// include reads all files referenced by an include directive.
+func (source *configSource) include(include interface{}, file string, location string) error {
+	var patterns []string
+	switch value := include.(type) {
+	case string:
+		patterns = []string{value}
+	case []interface{}:
+		for _, entry := range value {
+			pattern, isString := entry.(string)
+			if !isString {
+				return annotateConfigError(location, fmt.Errorf("'%s' must be a list of file names", configIncludeKey))
+			}
+			patterns = append(patterns, pattern)
+		}
+	default:
+		return annotateConfigError(location, fmt.Errorf("'%s' must be a file name or a list of file names", configIncludeKey))
+	}
+
+	for _, pattern := range patterns {
+		if !filepath.IsAbs(pattern) && file != "" {
+			pattern = filepath.Join(filepath.Dir(file), pattern)
+		}
+
+		files, err := filepath.Glob(pattern)
+		if err != nil {
+			return annotateConfigError(location, err)
+		}
+		if len(files) == 0 {
+			return annotateConfigError(location, fmt.Errorf("included file '%s' not found", pattern))
+		}
+
+		sort.Strings(files)
+		for _, includeFile := range files {
+			if err := source.readFile(includeFile); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// interpolateConfigMap replaces variables in all string values of the given
+// map. See interpolateConfigString.
+func interpolateConfigMap(values tcontainer.MarshalMap, path string, origin *configOrigin) error {
+	for key, value := range values {
+		keyPath := key
+		if path != "" {
+			keyPath = path + "/" + key
+		}
+
+		interpolated, err := interpolateConfigValue(value, keyPath, origin)
+		if err != nil {
+			return err
+		}
+		values[key] = interpolated
+	}
+	return nil
+}
+
+func interpolateConfigValue(value interface{}, path string, origin *configOrigin) (interface{}, error) {
+	switch typedValue := value.(type) {
+	case string:
+		interpolated, err := interpolateConfigString(typedValue)
+		return interpolated, annotateConfigError(origin.locate(path), err)
+
+	case []interface{}:
+		for idx, element := range typedValue {
+			interpolated, err := interpolateConfigValue(element, path+"/"+strconv.Itoa(idx), origin)
+			if err != nil {
+				return nil, err
+			}
+			typedValue[idx] = interpolated
+		}
+		return typedValue, nil
+
+	case tcontainer.MarshalMap:
+		return typedValue, interpolateConfigMap(typedValue, path, origin)
+
+	default:
+		return value, nil
+	}
+}
+
+// interpolateConfigString replaces the following variables in a string:
+// "${env:NAME}" is replaced by the environment variable NAME. An error is
+// returned if this variable is not set. "${env:NAME:-default}" uses "default"
+// if NAME is not set. "${file:PATH}" is replaced by the contents of the given
+// file without trailing line breaks. "$${env:" and "$${file:" are replaced by
+// "${env:" and "${file:". All other uses of "${", e.g. references to regexp
+// groups like "${1}", are not changed.
+func interpolateConfigString(value string) (string, error) {
+	var err error
+	interpolated := configVariable.ReplaceAllStringFunc(value, func(match string) string {
+		if strings.HasPrefix(match, "$$") {
+			return match[1:] // escaped
+		}
+
+		parts := configVariable.FindStringSubmatch(match)
+		namespace, name := parts[1], parts[2]
+		switch {
+		case namespace == "file":
+			content, readErr := ioutil.ReadFile(name)
+			if readErr != nil {
+				err = fmt.Errorf("failed to read '%s': %s", match, readErr.Error())
+				return match
+			}
+			return strings.TrimRight(string(content), "\r\n")
+
+		case strings.Contains(name, ":-"):
+			parts := strings.SplitN(name, ":-", 2)
+			if envValue, exists := os.LookupEnv(parts[0]); exists && envValue != "" {
+				return envValue
+			}
+			return parts[1]
+
+		default:
+			envValue, exists := os.LookupEnv(name)
+			if !exists {
+				err = fmt.Errorf("environment variable '%s' used in '%s' is not set", name, match)
+				return match
+			}
+			return envValue
+		}
+	})
+	return interpolated, err
+}
+
+// scanConfigKeyLines returns the line numbers of all block style YAML keys.
+// The keys are returned as lowercase, "/" separated paths. Items of block
+// style lists are part of the path by their index, e.g. "modulators/0/key".
+func scanConfigKeyLines(buffer []byte) map[string]int {
+	type scanKey struct {
+		indent int
+		name   string
+		item   bool
+	}
+
+	lines := make(map[string]int)
+	items := make(map[string]int)
+	stack := []scanKey{}
+	scanner := bufio.NewScanner(bytes.NewReader(buffer))
+
+	getPath := func() string {
+		path := make([]string, 0, len(stack))
+		for _, key := range stack {
+			path = append(path, key.name)
+		}
+		return strings.Join(path, "/")
+	}
+	addLine := func(path string, lineNum int) {
+		if lines[path] == 0 {
+			lines[path] = lineNum
+		}
+	}
+
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || trimmed[0] == '#' {
+			continue // ### continue, empty or comment ###
+		}
+
+		indent := len(line) - len(strings.TrimLeft(line, " \t"))
+		content := line[indent:]
+		isItem := isConfigListItem(content)
+
+		for len(stack) > 0 {
+			top := stack[len(stack)-1]
+			// List items may be indented like their parent key
+			if top.indent < indent || (isItem && top.indent == indent && !top.item) {
+				break
+			}
+			stack = stack[:len(stack)-1]
+		}
+
+		// A line may start several nested list items, e.g. "- - a"
+		for isConfigListItem(content) {
+			parent := getPath()
+			stack = append(stack, scanKey{indent, strconv.Itoa(items[parent]), true})
+			items[parent]++
+			addLine(getPath(), lineNum)
+
+			rest := strings.TrimLeft(content[1:], " \t")
+			indent += len(content) - len(rest)
+			content = rest
+		}
+
+		match := configKeyLine.FindStringSubmatch(content)
+		if match == nil {
+			continue // ### continue, not a key ###
+		}
+
+		name := strings.ToLower(strings.Trim(match[2], `"'`))
+		stack = append(stack, scanKey{indent, name, false})
+		addLine(getPath(), lineNum)
+	}
+	return lines
+}
+
+// isConfigListItem returns true if the given line content starts a block
+// style list item.
+func isConfigListItem(content string) bool {
+	return content == "-" || strings.HasPrefix(content, "- ") || strings.HasPrefix(content, "-\t")
+}
@@ -0,0 +1,76 @@
+// Copyright 2015-2018 trivago N.V.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"errors"
+	"time"
+)
+
+// Metadata keys set by SetDeadLetterMetadata
+const (
+	// MetadataKeyDeadLetterProducer stores the ID of the producer that failed
+	// to deliver a message.
+	MetadataKeyDeadLetterProducer = "deadletter_producer"
+	// MetadataKeyDeadLetterError stores the error reported by the producer.
+	MetadataKeyDeadLetterError = "deadletter_error"
+	// MetadataKeyDeadLetterAttempts stores the number of failed delivery
+	// attempts.
+	MetadataKeyDeadLetterAttempts = "deadletter_attempts"
+	// MetadataKeyDeadLetterTime stores the time of the last failure in RFC3339
+	// format.
+	MetadataKeyDeadLetterTime = "deadletter_time"
+	// MetadataKeyDeadLetterStream stores the name of the stream the message
+	// was processed on when the producer failed. Messages can be replayed to
+	// this stream.
+	MetadataKeyDeadLetterStream = "deadletter_stream"
+)
+
+var (
+	errProducerStopping = errors.New("producer is shutting down")
+	errQueueTimeout     = errors.New("timeout while waiting for producer queue")
+	errProducerPaused   = errors.New("producer is paused")
+)
+
+// SetDeadLetterMetadata attaches failure information to the metadata of a
+// message. The attempt counter is increased with every call.
+func SetDeadLetterMetadata(msg *Message, producerID string, streamID MessageStreamID, err error) {
+	metadata := msg.GetMetadata()
+	errorText := "unknown error"
+	if err != nil {
+		errorText = err.Error()
+	}
+
+	metadata.Set(MetadataKeyDeadLetterProducer, producerID)
+	metadata.Set(MetadataKeyDeadLetterError, errorText)
+	metadata.Set(MetadataKeyDeadLetterAttempts, GetDeadLetterAttempts(msg)+1)
+	metadata.Set(MetadataKeyDeadLetterTime, time.Now().Format(time.RFC3339))
+	metadata.Set(MetadataKeyDeadLetterStream, StreamRegistry.GetStreamName(streamID))
+}
+
+// GetDeadLetterAttempts returns the number of failed delivery attempts stored
+// by SetDeadLetterMetadata.
+func GetDeadLetterAttempts(msg *Message) int {
+	metadata := msg.TryGetMetadata()
+	if metadata == nil {
+		return 0
+	}
+
+	attempts, err := metadata.Int(MetadataKeyDeadLetterAttempts)
+	if err != nil {
+		return 0
+	}
+	return int(attempts)
+}
@@ -0,0 +1,48 @@
+// Copyright 2015-2018 trivago N.V.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/trivago/tgo/ttesting"
+)
+
+func TestDeadLetterMetadata(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+	streamID := StreamRegistry.GetStreamID("deadLetterTest")
+
+	msg := NewMessage(nil, []byte("test"), nil, InvalidStreamID)
+	expect.Equal(0, GetDeadLetterAttempts(msg))
+
+	SetDeadLetterMetadata(msg, "testProducer", streamID, errors.New("failed"))
+	SetDeadLetterMetadata(msg, "testProducer", streamID, nil)
+	expect.Equal(2, GetDeadLetterAttempts(msg))
+
+	data, err := msg.Serialize()
+	expect.NoError(err)
+	restored, err := DeserializeMessage(data)
+	expect.NoError(err)
+
+	metadata := restored.GetMetadata()
+	expect.Equal(2, GetDeadLetterAttempts(restored))
+	producerID, _ := metadata.String(MetadataKeyDeadLetterProducer)
+	expect.Equal("testProducer", producerID)
+	errorText, _ := metadata.String(MetadataKeyDeadLetterError)
+	expect.Equal("unknown error", errorText)
+	streamName, _ := metadata.String(MetadataKeyDeadLetterStream)
+	expect.Equal("deadLetterTest", streamName)
+}
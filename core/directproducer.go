@@ -15,6 +15,7 @@
 package core
 
 import (
+	"fmt"
 	"time"
 )
 
@@ -35,21 +36,31 @@ func (prod *DirectProducer) Configure(conf PluginConfigReader) {
 
 // Enqueue will add the message to the internal channel so it can be processed
 // by the producer main loop. A timeout value != nil will overwrite the channel
-// timeout value for this call.
+// timeout value for this call. If the producer is paused, the message is sent
+// to the fallback if the producer is not resumed within timeout.
 func (prod *DirectProducer) Enqueue(msg *Message, timeout time.Duration) {
 	defer prod.enqueuePanicHandling(msg)
 
 	// Don't accept messages if we are shutting down
 	if prod.GetState() >= PluginStateStopping {
-		prod.TryFallback(msg)
+		prod.TryFallbackWithError(msg, errProducerStopping)
 		return // ### return, closing down ###
 	}
 
+	StartMessageSpanUntilAck(msg, prod.GetID(), "produce", SpanKindProducer)
+
 	if !prod.HasContinueAfterModulate(msg) {
 		return
 	}
 
+	// Producers without a queue must not block their callers while paused
+	if !prod.runState.WaitWhilePausedTimeout(timeout) {
+		prod.TryFallbackWithError(msg, errProducerPaused)
+		return // ### return, paused ###
+	}
+
 	prod.onMessage(msg)
+	prod.ackHandled(msg)
 	MessageTrace(msg, prod.GetID(), "Enqueued by direct producer")
 }
 
@@ -78,6 +89,6 @@ func (prod *DirectProducer) enqueuePanicHandling(msg *Message) {
 		prod.Logger.Error("Recovered a panic during producer enqueue: ", r)
 		prod.Logger.Error("Producer: ", prod.id, "State: ", prod.GetState(),
 			", Router: ", StreamRegistry.GetStreamName(msg.GetStreamID()))
-		prod.TryFallback(msg)
+		prod.TryFallbackWithError(msg, fmt.Errorf("panic during enqueue: %v", r))
 	}
 }
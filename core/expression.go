@@ -0,0 +1,646 @@
+// Copyright 2015-2018 trivago N.V.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Expression is a boolean expression that is evaluated against a message.
+// Expressions support the following elements:
+//
+//  Values:      payload, stream, prevstream, origstream, time, age, meta.<key>
+//  Literals:    "string", 'string', 42, 1.5, true, false, [list, of, values]
+//  Comparison:  ==, !=, <, <=, >, >=
+//  Regex:       =~, !~ with a string literal on the right hand side
+//  Membership:  in, not in with a list or an inclusive numeric range (1..5)
+//  Logic:       &&, ||, ! or and, or, not and parentheses
+//  Functions:   exists(value), len(value), lower(value), upper(value)
+//
+// Metadata keys can address nested values by using "/" as a separator, e.g.
+// "meta.http/status". The value "time" is the creation time of the message
+// and can be compared to RFC3339 strings or unix timestamps, "age" is the
+// number of seconds since the message was created.
+// Numbers are compared numerically if one side is a number and the other
+// side can be parsed as one. All other values are compared as strings.
+type Expression struct {
+	source   string
+	evaluate exprBoolFunc
+}
+
+type exprValueFunc func(msg *Message) interface{}
+type exprBoolFunc func(msg *Message) bool
+
+type exprTokenType int
+
+const (
+	exprTokenEOF = exprTokenType(iota)
+	exprTokenIdent
+	exprTokenString
+	exprTokenNumber
+	exprTokenOperator
+)
+
+type exprToken struct {
+	kind  exprTokenType
+	value string
+	pos   int
+}
+
+type exprParser struct {
+	tokens []exprToken
+	pos    int
+}
+
+var exprOperators = []string{"==", "!=", "<=", ">=", "=~", "!~", "&&", "||", "..", "<", ">", "!", "(", ")", "[", "]", ","}
+
+// ParseExpression parses the given string into an Expression.
+func ParseExpression(source string) (*Expression, error) {
+	tokens, err := tokenizeExpression(source)
+	if err != nil {
+		return nil, fmt.Errorf("expression \"%s\": %s", source, err.Error())
+	}
+
+	parser := exprParser{tokens: tokens}
+	evaluate, err := parser.parseOr()
+	if err == nil && parser.peek().kind != exprTokenEOF {
+		err = parser.errorf("unexpected \"%s\"", parser.peek().value)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("expression \"%s\": %s", source, err.Error())
+	}
+
+	return &Expression{
+		source:   source,
+		evaluate: evaluate,
+	}, nil
+}
+
+// Evaluate returns true if the given message matches the expression.
+func (expr *Expression) Evaluate(msg *Message) bool {
+	return expr.evaluate(msg)
+}
+
+// String returns the source of the expression.
+func (expr *Expression) String() string {
+	return expr.source
+}
+
+func tokenizeExpression(source string) ([]exprToken, error) {
+	tokens := []exprToken{}
+	runes := []rune(source)
+
+	for pos := 0; pos < len(runes); {
+		char := runes[pos]
+		switch {
+		case unicode.IsSpace(char):
+			pos++
+
+		case char == '"' || char == '\'':
+			value, end, err := readExprString(runes, pos)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, exprToken{exprTokenString, value, pos})
+			pos = end
+
+		case unicode.IsDigit(char) || (char == '-' && pos+1 < len(runes) && unicode.IsDigit(runes[pos+1])):
+			end := pos + 1
+			for end < len(runes) && (unicode.IsDigit(runes[end]) ||
+				(runes[end] == '.' && end+1 < len(runes) && unicode.IsDigit(runes[end+1]))) {
+				end++
+			}
+			tokens = append(tokens, exprToken{exprTokenNumber, string(runes[pos:end]), pos})
+			pos = end
+
+		case unicode.IsLetter(char) || char == '_':
+			end := pos + 1
+			for end < len(runes) && isExprIdentRune(runes[end]) {
+				end++
+			}
+			tokens = append(tokens, exprToken{exprTokenIdent, string(runes[pos:end]), pos})
+			pos = end
+
+		default:
+			operator := ""
+			for _, candidate := range exprOperators {
+				if strings.HasPrefix(string(runes[pos:]), candidate) {
+					operator = candidate
+					break
+				}
+			}
+			if operator == "" {
+				return nil, fmt.Errorf("unexpected character '%c' at position %d", char, pos)
+			}
+			tokens = append(tokens, exprToken{exprTokenOperator, operator, pos})
+			pos += len(operator)
+		}
+	}
+
+	return append(tokens, exprToken{exprTokenEOF, "end of expression", len(runes)}), nil
+}
+
+func isExprIdentRune(char rune) bool {
+	return unicode.IsLetter(char) || unicode.IsDigit(char) || char == '_' || char == '.' || char == '/' || char == '-'
+}
+
+func readExprString(runes []rune, start int) (string, int, error) {
+	quote := runes[start]
+	value := []rune{}
+	for pos := start + 1; pos < len(runes); pos++ {
+		switch runes[pos] {
+		case quote:
+			return string(value), pos + 1, nil // ### return, string complete ###
+		case '\\':
+			if pos+1 < len(runes) {
+				pos++
+			}
+		}
+		value = append(value, runes[pos])
+	}
+	return "", 0, fmt.Errorf("unterminated string starting at position %d", start)
+}
+
+func (parser *exprParser) peek() exprToken {
+	return parser.tokens[parser.pos]
+}
+
+func (parser *exprParser) next() exprToken {
+	token := parser.tokens[parser.pos]
+	if token.kind != exprTokenEOF {
+		parser.pos++
+	}
+	return token
+}
+
+// accept consumes the next token if it is one of the given operators or
+// keywords.
+func (parser *exprParser) accept(values ...string) bool {
+	token := parser.peek()
+	if token.kind != exprTokenOperator && token.kind != exprTokenIdent {
+		return false
+	}
+	for _, value := range values {
+		if token.value == value {
+			parser.pos++
+			return true
+		}
+	}
+	return false
+}
+
+func (parser *exprParser) expect(value string) error {
+	if !parser.accept(value) {
+		return parser.errorf("expected \"%s\" but found \"%s\"", value, parser.peek().value)
+	}
+	return nil
+}
+
+func (parser *exprParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("position %d: %s", parser.peek().pos, fmt.Sprintf(format, args...))
+}
+
+func (parser *exprParser) parseOr() (exprBoolFunc, error) {
+	left, err := parser.parseAnd()
+	for err == nil && parser.accept("||", "or") {
+		var right exprBoolFunc
+		if right, err = parser.parseAnd(); err == nil {
+			lhs := left
+			left = func(msg *Message) bool { return lhs(msg) || right(msg) }
+		}
+	}
+	return left, err
+}
+
+func (parser *exprParser) parseAnd() (exprBoolFunc, error) {
+	left, err := parser.parseNot()
+	for err == nil && parser.accept("&&", "and") {
+		var right exprBoolFunc
+		if right, err = parser.parseNot(); err == nil {
+			lhs := left
+			left = func(msg *Message) bool { return lhs(msg) && right(msg) }
+		}
+	}
+	return left, err
+}
+
+func (parser *exprParser) parseNot() (exprBoolFunc, error) {
+	if parser.accept("!", "not") {
+		inner, err := parser.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return func(msg *Message) bool { return !inner(msg) }, nil
+	}
+	return parser.parseComparison()
+}
+
+func (parser *exprParser) parseComparison() (exprBoolFunc, error) {
+	left, err := parser.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	token := parser.peek()
+	switch {
+	case token.kind == exprTokenOperator && (token.value == "=~" || token.value == "!~"):
+		parser.next()
+		pattern := parser.next()
+		if pattern.kind != exprTokenString {
+			return nil, fmt.Errorf("position %d: %s requires a string literal", pattern.pos, token.value)
+		}
+		regex, err := regexp.Compile(pattern.value)
+		if err != nil {
+			return nil, fmt.Errorf("position %d: %s", pattern.pos, err.Error())
+		}
+		negate := token.value == "!~"
+		return func(msg *Message) bool {
+			return regex.MatchString(exprToString(left(msg))) != negate
+		}, nil
+
+	case token.kind == exprTokenOperator && isExprComparison(token.value):
+		parser.next()
+		right, err := parser.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		return newExprComparison(token.value, left, right), nil
+
+	case token.kind == exprTokenIdent && (token.value == "in" || token.value == "not"):
+		parser.next()
+		negate := token.value == "not"
+		if negate {
+			if err := parser.expect("in"); err != nil {
+				return nil, err
+			}
+		}
+		contains, err := parser.parseSet()
+		if err != nil {
+			return nil, err
+		}
+		return func(msg *Message) bool {
+			return contains(left(msg)) != negate
+		}, nil
+
+	default:
+		return func(msg *Message) bool {
+			return exprIsTrue(left(msg))
+		}, nil
+	}
+}
+
+// parseSet parses the right hand side of an "in" operation, i.e. a list
+// or a numeric range.
+func (parser *exprParser) parseSet() (func(value interface{}) bool, error) {
+	if parser.peek().value == "[" {
+		list, err := parser.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		values := list(nil).([]interface{})
+		return func(value interface{}) bool {
+			for _, item := range values {
+				if exprCompare(value, item) == 0 {
+					return true
+				}
+			}
+			return false
+		}, nil
+	}
+
+	min, err := parser.parseNumber()
+	if err != nil {
+		return nil, err
+	}
+	if err := parser.expect(".."); err != nil {
+		return nil, err
+	}
+	max, err := parser.parseNumber()
+	if err != nil {
+		return nil, err
+	}
+
+	return func(value interface{}) bool {
+		number, isNumber := exprToNumber(value)
+		return isNumber && number >= min && number <= max
+	}, nil
+}
+
+func (parser *exprParser) parseNumber() (float64, error) {
+	token := parser.next()
+	if token.kind != exprTokenNumber {
+		return 0, fmt.Errorf("position %d: expected a number but found \"%s\"", token.pos, token.value)
+	}
+	return strconv.ParseFloat(token.value, 64)
+}
+
+func (parser *exprParser) parseOperand() (exprValueFunc, error) {
+	token := parser.next()
+	switch token.kind {
+	case exprTokenString:
+		return exprConstant(token.value), nil
+
+	case exprTokenNumber:
+		number, err := strconv.ParseFloat(token.value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("position %d: %s", token.pos, err.Error())
+		}
+		return exprConstant(number), nil
+
+	case exprTokenIdent:
+		if parser.peek().value == "(" {
+			return parser.parseFunction(token)
+		}
+		return parseExprIdentifier(token)
+
+	case exprTokenOperator:
+		switch token.value {
+		case "(":
+			inner, err := parser.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if err := parser.expect(")"); err != nil {
+				return nil, err
+			}
+			return func(msg *Message) interface{} { return inner(msg) }, nil
+
+		case "[":
+			values := []interface{}{}
+			for !parser.accept("]") {
+				if len(values) > 0 {
+					if err := parser.expect(","); err != nil {
+						return nil, err
+					}
+				}
+				item := parser.next()
+				switch item.kind {
+				case exprTokenString:
+					values = append(values, item.value)
+				case exprTokenNumber:
+					number, _ := strconv.ParseFloat(item.value, 64)
+					values = append(values, number)
+				case exprTokenIdent:
+					values = append(values, item.value)
+				default:
+					return nil, fmt.Errorf("position %d: lists may only contain literals", item.pos)
+				}
+			}
+			return exprConstant(values), nil
+		}
+	}
+
+	return nil, fmt.Errorf("position %d: unexpected \"%s\"", token.pos, token.value)
+}
+
+func (parser *exprParser) parseFunction(name exprToken) (exprValueFunc, error) {
+	parser.next() // (
+	arg, err := parser.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	if err := parser.expect(")"); err != nil {
+		return nil, err
+	}
+
+	switch name.value {
+	case "exists":
+		return func(msg *Message) interface{} { return arg(msg) != nil }, nil
+	case "len":
+		return func(msg *Message) interface{} {
+			if list, isList := arg(msg).([]interface{}); isList {
+				return float64(len(list))
+			}
+			return float64(len(exprToString(arg(msg))))
+		}, nil
+	case "lower":
+		return func(msg *Message) interface{} { return strings.ToLower(exprToString(arg(msg))) }, nil
+	case "upper":
+		return func(msg *Message) interface{} { return strings.ToUpper(exprToString(arg(msg))) }, nil
+	default:
+		return nil, fmt.Errorf("position %d: unknown function \"%s\"", name.pos, name.value)
+	}
+}
+
+func parseExprIdentifier(token exprToken) (exprValueFunc, error) {
+	switch token.value {
+	case "true":
+		return exprConstant(true), nil
+	case "false":
+		return exprConstant(false), nil
+	case "payload":
+		return func(msg *Message) interface{} { return string(msg.GetPayload()) }, nil
+	case "stream":
+		return func(msg *Message) interface{} { return msg.GetStreamID().GetName() }, nil
+	case "prevstream":
+		return func(msg *Message) interface{} { return msg.GetPrevStreamID().GetName() }, nil
+	case "origstream":
+		return func(msg *Message) interface{} { return msg.GetOrigStreamID().GetName() }, nil
+	case "time":
+		return func(msg *Message) interface{} { return msg.GetCreationTime() }, nil
+	case "age":
+		return func(msg *Message) interface{} { return time.Since(msg.GetCreationTime()).Seconds() }, nil
+	}
+
+	if strings.HasPrefix(token.value, "meta.") && len(token.value) > len("meta.") {
+		key := token.value[len("meta."):]
+		return func(msg *Message) interface{} {
+			metadata := msg.TryGetMetadata()
+			if metadata == nil {
+				return nil
+			}
+			if value, exists := metadata.Value(key); exists {
+				return value
+			}
+			return nil
+		}, nil
+	}
+
+	return nil, fmt.Errorf("position %d: unknown value \"%s\"", token.pos, token.value)
+}
+
+func exprConstant(value interface{}) exprValueFunc {
+	return func(*Message) interface{} { return value }
+}
+
+func isExprComparison(operator string) bool {
+	switch operator {
+	case "==", "!=", "<", "<=", ">", ">=":
+		return true
+	}
+	return false
+}
+
+func newExprComparison(operator string, left, right exprValueFunc) exprBoolFunc {
+	var test func(int) bool
+	switch operator {
+	case "==":
+		test = func(result int) bool { return result == 0 }
+	case "!=":
+		test = func(result int) bool { return result != 0 }
+	case "<":
+		test = func(result int) bool { return result < 0 }
+	case "<=":
+		test = func(result int) bool { return result <= 0 }
+	case ">":
+		test = func(result int) bool { return result > 0 }
+	default:
+		test = func(result int) bool { return result >= 0 }
+	}
+	return func(msg *Message) bool {
+		return test(exprCompare(left(msg), right(msg)))
+	}
+}
+
+// exprCompare returns -1, 0 or 1 if a is less than, equal to or greater
+// than b. Times and numbers are compared as such if possible, all other
+// values are compared as strings.
+func exprCompare(a, b interface{}) int {
+	a, b = exprNormalize(a), exprNormalize(b)
+
+	if timeA, isTime := a.(time.Time); isTime {
+		if timeB, ok := exprToTime(b); ok {
+			return exprCompareFloat(float64(timeA.UnixNano()), float64(timeB.UnixNano()))
+		}
+	}
+	if timeB, isTime := b.(time.Time); isTime {
+		if timeA, ok := exprToTime(a); ok {
+			return exprCompareFloat(float64(timeA.UnixNano()), float64(timeB.UnixNano()))
+		}
+	}
+
+	_, numberA := a.(float64)
+	_, numberB := b.(float64)
+	if numberA || numberB {
+		floatA, okA := exprToNumber(a)
+		floatB, okB := exprToNumber(b)
+		if okA && okB {
+			return exprCompareFloat(floatA, floatB)
+		}
+	}
+
+	return strings.Compare(exprToString(a), exprToString(b))
+}
+
+func exprCompareFloat(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// exprNormalize converts all numeric types to float64 and byte slices to
+// strings.
+func exprNormalize(value interface{}) interface{} {
+	switch typed := value.(type) {
+	case []byte:
+		return string(typed)
+	case int:
+		return float64(typed)
+	case int8:
+		return float64(typed)
+	case int16:
+		return float64(typed)
+	case int32:
+		return float64(typed)
+	case int64:
+		return float64(typed)
+	case uint:
+		return float64(typed)
+	case uint8:
+		return float64(typed)
+	case uint16:
+		return float64(typed)
+	case uint32:
+		return float64(typed)
+	case uint64:
+		return float64(typed)
+	case float32:
+		return float64(typed)
+	default:
+		return value
+	}
+}
+
+func exprToNumber(value interface{}) (float64, bool) {
+	switch typed := exprNormalize(value).(type) {
+	case float64:
+		return typed, true
+	case string:
+		number, err := strconv.ParseFloat(strings.TrimSpace(typed), 64)
+		return number, err == nil && !math.IsNaN(number)
+	default:
+		return 0, false
+	}
+}
+
+func exprToTime(value interface{}) (time.Time, bool) {
+	switch typed := exprNormalize(value).(type) {
+	case time.Time:
+		return typed, true
+	case float64:
+		seconds, fraction := math.Modf(typed)
+		return time.Unix(int64(seconds), int64(fraction*1e9)), true
+	case string:
+		parsed, err := time.Parse(time.RFC3339Nano, typed)
+		return parsed, err == nil
+	default:
+		return time.Time{}, false
+	}
+}
+
+func exprToString(value interface{}) string {
+	switch typed := exprNormalize(value).(type) {
+	case nil:
+		return ""
+	case string:
+		return typed
+	case float64:
+		return strconv.FormatFloat(typed, 'f', -1, 64)
+	case time.Time:
+		return typed.Format(time.RFC3339Nano)
+	default:
+		return ConvertToString(typed)
+	}
+}
+
+func exprIsTrue(value interface{}) bool {
+	switch typed := exprNormalize(value).(type) {
+	case nil:
+		return false
+	case bool:
+		return typed
+	case float64:
+		return typed != 0
+	case string:
+		return typed != ""
+	case []interface{}:
+		return len(typed) > 0
+	case time.Time:
+		return !typed.IsZero()
+	default:
+		return true
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright 2015-2018 trivago N.V.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/trivago/tgo/tcontainer"
+	"github.com/trivago/tgo/ttesting"
+)
+
+func TestExpressionEvaluate(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	metadata := tcontainer.MarshalMap{
+		"level":  "error",
+		"status": "503",
+		"bytes":  1024,
+		"http":   tcontainer.MarshalMap{"method": "GET"},
+	}
+	msg := NewMessage(nil, []byte("java.lang.Exception"), metadata, GetStreamID("access"))
+
+	matching := []string{
+		`meta.level == "error"`,
+		`meta.level != 'info' && stream == "access"`,
+		`meta.status >= 500 and meta.status < 600`,
+		`meta.status in 500..599`,
+		`meta.bytes > 1000.5`,
+		`meta.http/method in ["GET", "HEAD"]`,
+		`meta.http/method not in ["POST"]`,
+		`payload =~ "(?i)exception$"`,
+		`payload !~ "^error"`,
+		`!(meta.level == "info") || false`,
+		`exists(meta.level) && not exists(meta.unknown)`,
+		`len(payload) == 19 && upper(meta.level) == "ERROR"`,
+		`time > "2000-01-01T00:00:00Z" && time > 946684800 && age < 60`,
+		`meta.level`,
+	}
+	for _, source := range matching {
+		expr, err := ParseExpression(source)
+		if expect.NoError(err) {
+			if !expr.Evaluate(msg) {
+				t.Errorf("expected %s to match", source)
+			}
+		}
+	}
+
+	notMatching := []string{
+		`meta.level == "info"`,
+		`meta.status in 400..499`,
+		`meta.status < 6`,
+		`payload =~ "^error"`,
+		`meta.unknown`,
+		`time < "2000-01-01T00:00:00Z"`,
+		`meta.level == "error" && (stream == "other" or meta.bytes < 10)`,
+	}
+	for _, source := range notMatching {
+		expr, err := ParseExpression(source)
+		if expect.NoError(err) {
+			if expr.Evaluate(msg) {
+				t.Errorf("expected %s not to match", source)
+			}
+		}
+	}
+}
+
+func TestExpressionParseErrors(t *testing.T) {
+	invalid := []string{
+		``,
+		`meta.level ==`,
+		`meta.level == "error`,
+		`payload =~ meta.level`,
+		`payload =~ "("`,
+		`unknown == 1`,
+		`foo(payload)`,
+		`meta.status in 1..`,
+		`(meta.level == "error"`,
+		`meta.level == "error" meta.level`,
+		`meta.level # 1`,
+	}
+	for _, source := range invalid {
+		if _, err := ParseExpression(source); err == nil {
+			t.Errorf("expected %s to fail", source)
+		}
+	}
+}
+
+func TestExpressionTimeValues(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	expr, err := ParseExpression(`meta.created < time`)
+	expect.NoError(err)
+
+	past := time.Now().Add(-time.Hour).Format(time.RFC3339)
+	msg := NewMessage(nil, []byte{}, tcontainer.MarshalMap{"created": past}, InvalidStreamID)
+	expect.True(expr.Evaluate(msg))
+}
@@ -0,0 +1,46 @@
+// Copyright 2015-2018 trivago N.V.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"sync"
+
+	"github.com/trivago/tgo/thealthcheck"
+)
+
+var (
+	healthCheckEndpoints      = make(map[string]thealthcheck.CallbackFunc)
+	healthCheckEndpointsGuard = new(sync.RWMutex)
+)
+
+// AddHealthCheckEndpoint registers a health check callback for the given path.
+// In contrast to thealthcheck.AddEndpoint, registering the same path twice
+// replaces the callback instead of panicking. This allows plugins to be
+// recreated with the same ID, e.g. during a configuration reload.
+func AddHealthCheckEndpoint(path string, callback thealthcheck.CallbackFunc) {
+	healthCheckEndpointsGuard.Lock()
+	_, exists := healthCheckEndpoints[path]
+	healthCheckEndpoints[path] = callback
+	healthCheckEndpointsGuard.Unlock()
+
+	if !exists {
+		thealthcheck.AddEndpoint(path, func() (code int, body string) {
+			healthCheckEndpointsGuard.RLock()
+			currentCallback := healthCheckEndpoints[path]
+			healthCheckEndpointsGuard.RUnlock()
+			return currentCallback()
+		})
+	}
+}
@@ -41,6 +41,11 @@ type Message struct {
 	origStreamID MessageStreamID
 	source       MessageSource
 	timestamp    int64
+	ack          *messageAck
+	acked        int32
+	ackHooks     []AcknowledgeFunc
+	trace        TraceContext
+	spans        []*Span
 }
 
 // NewMessage creates a new message from a given data stream by copying data.
@@ -159,7 +164,8 @@ func (msg *Message) StorePayload(data []byte) {
 }
 
 // Clone returns a copy of this message, i.e. the payload is duplicated.
-// The created timestamp is copied, too.
+// The created timestamp is copied, too. If the message carries an acknowledge
+// callback, the clone has to be acknowledged, too.
 func (msg *Message) Clone() *Message {
 	clone := *msg
 
@@ -170,12 +176,14 @@ func (msg *Message) Clone() *Message {
 		clone.data.metadata = msg.data.metadata.Clone()
 	}
 
+	msg.forkAcknowledge(&clone)
 	return &clone
 }
 
 // CloneOriginal returns a copy of this message with the original payload and
 // stream. If FreezeOriginal has not been called before it will be at this point
 // so that all subsequential calls will use the same original.
+// Like Clone, the copy has to be acknowledged separately.
 func (msg *Message) CloneOriginal() *Message {
 	if msg.orig == nil {
 		msg.FreezeOriginal()
@@ -192,6 +200,7 @@ func (msg *Message) CloneOriginal() *Message {
 	}
 
 	clone.SetStreamID(msg.origStreamID)
+	msg.forkAcknowledge(&clone)
 	return &clone
 }
 
@@ -259,3 +259,50 @@ func TestMessageSerialize(t *testing.T) {
 	expect.Equal(testMessage.orig.payload, readMessage.orig.payload)
 	expect.Equal(testMessage.orig.metadata, readMessage.orig.metadata)
 }
+
+func TestMessageAcknowledge(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	numCalls := 0
+	result := false
+	msg := getMockMessage("test")
+	msg.SetAcknowledge(func(success bool) {
+		numCalls++
+		result = success
+	})
+
+	clone := msg.Clone()
+	orig := msg.CloneOriginal()
+
+	msg.Ack()
+	msg.Nack() // ignored, already acknowledged
+	clone.Ack()
+	expect.Equal(0, numCalls)
+
+	orig.Ack()
+	expect.Equal(1, numCalls)
+	expect.True(result)
+
+	// Clones of acknowledged messages are not tracked
+	msg.Clone().Ack()
+	expect.Equal(1, numCalls)
+}
+
+func TestMessageAcknowledgeFailed(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	numCalls := 0
+	result := true
+	msg := getMockMessage("test")
+	msg.SetAcknowledge(func(success bool) {
+		numCalls++
+		result = success
+	})
+
+	clone := msg.Clone()
+	clone.Nack()
+	msg.Ack()
+
+	expect.Equal(1, numCalls)
+	expect.False(result)
+}
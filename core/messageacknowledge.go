@@ -0,0 +1,114 @@
+// Copyright 2015-2018 trivago N.V.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"sync/atomic"
+)
+
+// AcknowledgeFunc is the function signature for callbacks registered by
+// Message.SetAcknowledge. Success is false if at least one copy of the message
+// could not be delivered.
+type AcknowledgeFunc func(success bool)
+
+// messageAck is shared between all copies of a message. It counts the number
+// of copies that have not been acknowledged yet.
+type messageAck struct {
+	pending int32
+	failed  int32
+	onAck   AcknowledgeFunc
+}
+
+func (ack *messageAck) done(success bool) {
+	if !success {
+		atomic.StoreInt32(&ack.failed, 1)
+	}
+	if atomic.AddInt32(&ack.pending, -1) == 0 {
+		ack.onAck(atomic.LoadInt32(&ack.failed) == 0)
+	}
+}
+
+// SetAcknowledge registers a callback that is called once this message and
+// all copies created from it have been acknowledged by Ack or Nack.
+// This function should be called by consumers before a message is routed.
+func (msg *Message) SetAcknowledge(onAck AcknowledgeFunc) {
+	msg.ack = &messageAck{
+		pending: 1,
+		onAck:   onAck,
+	}
+	msg.acked = 0
+}
+
+// HasAcknowledge returns true if an acknowledge callback is registered for
+// this message.
+func (msg *Message) HasAcknowledge() bool {
+	return msg.ack != nil
+}
+
+// Ack marks this copy of the message as processed. A message counts as
+// processed if it has been delivered, routed to a fallback or intentionally
+// discarded. Calls after the first Ack or Nack have no effect.
+// Spans started by StartMessageSpanUntilAck for this copy are finished, too.
+func (msg *Message) Ack() {
+	msg.acknowledge(true)
+}
+
+// Nack marks this copy of the message as not delivered. Calls after the first
+// Ack or Nack have no effect.
+func (msg *Message) Nack() {
+	msg.acknowledge(false)
+}
+
+func (msg *Message) acknowledge(success bool) {
+	if !atomic.CompareAndSwapInt32(&msg.acked, 0, 1) {
+		return // ### return, already acknowledged ###
+	}
+
+	msg.endSpans(success)
+	for _, onAck := range msg.ackHooks {
+		onAck(success)
+	}
+	msg.ackHooks = nil
+
+	if msg.ack != nil {
+		msg.ack.done(success)
+	}
+}
+
+// onAcknowledged registers a callback that is called once this copy of the
+// message has been acknowledged by Ack or Nack. In contrast to SetAcknowledge
+// the callback is not shared with copies created from this message.
+func (msg *Message) onAcknowledged(onAck AcknowledgeFunc) {
+	msg.ackHooks = append(msg.ackHooks, onAck)
+}
+
+// forkAcknowledge registers clone as an additional copy that needs to be
+// acknowledged. Copies of an already acknowledged message are not tracked.
+// Spans and callbacks registered by onAcknowledged stay with the original copy.
+func (msg *Message) forkAcknowledge(clone *Message) {
+	clone.acked = 0
+	clone.spans = nil
+	clone.ackHooks = nil
+	if msg.ack == nil {
+		return // ### return, not tracked ###
+	}
+
+	if atomic.LoadInt32(&msg.acked) != 0 {
+		clone.ack = nil
+		return // ### return, already acknowledged ###
+	}
+
+	atomic.AddInt32(&msg.ack.pending, 1)
+}
@@ -163,6 +163,10 @@ func (batch MessageBatch) IsClosed() bool {
 // The onError callback will be called if the io.Writer returned an error.
 // If onError returns false the buffer will not be resetted (automatic retry).
 // If onError is nil a return value of true is assumed (buffer reset).
+//
+// All flushed messages are acknowledged after assemble returns. Messages that
+// could not be written have to be passed to a fallback or marked by
+// Message.Nack by the assemble function.
 func (batch *MessageBatch) Flush(assemble AssemblyFunc) {
 	if batch.IsEmpty() {
 		return // ### return, nothing to do ###
@@ -190,6 +194,9 @@ func (batch *MessageBatch) Flush(assemble AssemblyFunc) {
 
 		messageCount := tmath.MinI(int(writerCount), len(flushQueue.messages))
 		assemble(flushQueue.messages[:messageCount])
+		for _, msg := range flushQueue.messages[:messageCount] {
+			msg.Ack()
+		}
 		atomic.StoreUint32(flushQueue.doneCount, 0)
 		batch.Touch()
 	})
@@ -2,6 +2,7 @@ package core
 
 import (
 	"reflect"
+	"sync"
 	"testing"
 	"time"
 
@@ -29,12 +30,13 @@ func getMockRouterMessageHelper(streamName string) mockRouterMessageHelper {
 	timeout := time.Second
 	return mockRouterMessageHelper{
 		SimpleRouter: SimpleRouter{
-			id:        streamName,
-			filters:   FilterArray{},
-			Producers: []Producer{},
-			timeout:   timeout,
-			streamID:  StreamRegistry.GetStreamID(streamName),
-			Logger:    logrus.WithField("Scope", "testStreamLogScope"),
+			id:            streamName,
+			filters:       FilterArray{},
+			Producers:     []Producer{},
+			producerGuard: new(sync.RWMutex),
+			timeout:       timeout,
+			streamID:      StreamRegistry.GetStreamID(streamName),
+			Logger:        logrus.WithField("Scope", "testStreamLogScope"),
 		},
 	}
 }
@@ -0,0 +1,400 @@
+// Copyright 2015-2018 trivago N.V.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/trivago/tgo"
+)
+
+const (
+	journalSegmentExt   = ".journal"
+	journalAckFile      = "acknowledged"
+	journalHeaderLength = 16 // sequence (8), length (4), crc32 (4)
+)
+
+// MessageJournal is a disk based write ahead log for messages. Messages are
+// written to the journal before they are buffered and are acknowledged after
+// they have been processed. All messages that have not been acknowledged are
+// returned by Replay, e.g. after a crash.
+// The journal is split into segments. Segments are removed as soon as all
+// messages stored in it have been acknowledged.
+type MessageJournal struct {
+	path           string
+	maxSegmentSize int64
+	syncWrites     bool
+	guard          *sync.Mutex
+	segment        *os.File
+	segmentSize    int64
+	segments       []uint64 // first sequence number of each segment
+	ackFile        *os.File
+	nextSeq        uint64
+	replayEnd      uint64
+	committed      uint64 // all messages <= committed are acknowledged
+	acked          map[uint64]bool
+	pending        map[*Message]uint64
+}
+
+// NewMessageJournal opens or creates a journal in the given directory.
+// Segments are rotated after reaching maxSegmentSize bytes. If syncWrites is
+// set, each write is flushed to disk before returning.
+func NewMessageJournal(path string, maxSegmentSize int64, syncWrites bool) (*MessageJournal, error) {
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return nil, err
+	}
+
+	journal := &MessageJournal{
+		path:           path,
+		maxSegmentSize: maxSegmentSize,
+		syncWrites:     syncWrites,
+		guard:          new(sync.Mutex),
+		acked:          make(map[uint64]bool),
+		pending:        make(map[*Message]uint64),
+	}
+
+	if err := journal.openAckFile(); err != nil {
+		return nil, err
+	}
+	if err := journal.openSegments(); err != nil {
+		journal.ackFile.Close()
+		return nil, err
+	}
+
+	journal.replayEnd = journal.nextSeq
+	journal.removeCommittedSegments()
+	return journal, nil
+}
+
+func (journal *MessageJournal) openAckFile() error {
+	file, err := os.OpenFile(filepath.Join(journal.path, journalAckFile), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+
+	buffer := make([]byte, 8)
+	if _, err := file.ReadAt(buffer, 0); err == nil {
+		journal.committed = binary.BigEndian.Uint64(buffer)
+	} else if err != io.EOF {
+		file.Close()
+		return err
+	}
+
+	journal.ackFile = file
+	return nil
+}
+
+func (journal *MessageJournal) openSegments() error {
+	files, err := ioutil.ReadDir(journal.path)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		name := file.Name()
+		if file.IsDir() || !strings.HasSuffix(name, journalSegmentExt) {
+			continue // ### continue, not a segment ###
+		}
+		firstSeq, err := strconv.ParseUint(strings.TrimSuffix(name, journalSegmentExt), 10, 64)
+		if err != nil {
+			continue // ### continue, not a segment ###
+		}
+		journal.segments = append(journal.segments, firstSeq)
+	}
+	sort.Slice(journal.segments, func(i, j int) bool { return journal.segments[i] < journal.segments[j] })
+
+	if len(journal.segments) == 0 {
+		journal.nextSeq = journal.committed + 1
+		return journal.createSegment()
+	}
+
+	// Find the end of the last segment and cut off incomplete writes
+	lastSeq := journal.segments[len(journal.segments)-1]
+	file, err := os.OpenFile(journal.getSegmentPath(lastSeq), os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+
+	journal.nextSeq = lastSeq
+	validSize := int64(0)
+	err = readJournalSegment(file, func(seq uint64, data []byte, offset int64) bool {
+		journal.nextSeq = seq + 1
+		validSize = offset
+		return true
+	})
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	if err := file.Truncate(validSize); err != nil {
+		file.Close()
+		return err
+	}
+	if _, err := file.Seek(validSize, io.SeekStart); err != nil {
+		file.Close()
+		return err
+	}
+
+	if journal.nextSeq <= journal.committed {
+		journal.nextSeq = journal.committed + 1
+	}
+
+	journal.segment = file
+	journal.segmentSize = validSize
+	return nil
+}
+
+func (journal *MessageJournal) getSegmentPath(firstSeq uint64) string {
+	return filepath.Join(journal.path, fmt.Sprintf("%020d%s", firstSeq, journalSegmentExt))
+}
+
+func (journal *MessageJournal) createSegment() error {
+	file, err := os.OpenFile(journal.getSegmentPath(journal.nextSeq), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	if journal.segment != nil {
+		journal.segment.Close()
+	}
+
+	journal.segment = file
+	journal.segmentSize = 0
+	journal.segments = append(journal.segments, journal.nextSeq)
+	return nil
+}
+
+// readJournalSegment calls onRecord for each valid record in the given file.
+// The offset passed to onRecord points to the end of the record. Reading stops
+// at the first incomplete or corrupted record or if onRecord returns false.
+func readJournalSegment(file *os.File, onRecord func(seq uint64, data []byte, offset int64) bool) error {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	header := make([]byte, journalHeaderLength)
+	offset := int64(0)
+	for {
+		if _, err := io.ReadFull(file, header); err != nil {
+			return nil // ### return, end of segment ###
+		}
+
+		seq := binary.BigEndian.Uint64(header[0:8])
+		length := binary.BigEndian.Uint32(header[8:12])
+		checksum := binary.BigEndian.Uint32(header[12:16])
+
+		data := make([]byte, length)
+		if _, err := io.ReadFull(file, data); err != nil {
+			return nil // ### return, incomplete record ###
+		}
+		if crc32.ChecksumIEEE(data) != checksum {
+			return nil // ### return, corrupted record ###
+		}
+
+		offset += journalHeaderLength + int64(length)
+		if !onRecord(seq, data, offset) {
+			return nil // ### return, stopped by callback ###
+		}
+	}
+}
+
+// Replay calls handleMessage for all messages that were written to the
+// journal before it was opened and have not been acknowledged yet. Replayed
+// messages are removed from the journal once they are acknowledged by
+// Message.Ack or Message.Nack.
+func (journal *MessageJournal) Replay(handleMessage func(*Message)) error {
+	journal.guard.Lock()
+	segments := append([]uint64{}, journal.segments...)
+	journal.guard.Unlock()
+
+	for _, firstSeq := range segments {
+		if firstSeq >= journal.replayEnd {
+			return nil // ### return, reached messages written after open ###
+		}
+
+		file, err := os.Open(journal.getSegmentPath(firstSeq))
+		if os.IsNotExist(err) {
+			continue // ### continue, segment has been removed ###
+		}
+		if err != nil {
+			return err
+		}
+
+		messages := []*Message{}
+		err = readJournalSegment(file, func(seq uint64, data []byte, offset int64) bool {
+			if seq >= journal.replayEnd {
+				return false
+			}
+			if seq <= journal.committed {
+				return true
+			}
+
+			msg, err := DeserializeMessage(data)
+			if err != nil {
+				// Treat broken messages as acknowledged so they are not replayed again
+				journal.guard.Lock()
+				journal.acknowledge(seq)
+				journal.guard.Unlock()
+				return true
+			}
+
+			journal.guard.Lock()
+			journal.pending[msg] = seq
+			journal.guard.Unlock()
+			journal.ackOnAcknowledge(msg)
+			messages = append(messages, msg)
+			return true
+		})
+		file.Close()
+
+		if err != nil {
+			return err
+		}
+
+		for _, msg := range messages {
+			handleMessage(msg)
+		}
+	}
+	return nil
+}
+
+// Write appends the given message to the journal. The message is removed from
+// the journal once it is acknowledged by Message.Ack or Message.Nack, i.e.
+// after the producer has actually delivered or discarded it.
+func (journal *MessageJournal) Write(msg *Message) error {
+	data, err := msg.Serialize()
+	if err != nil {
+		return err
+	}
+
+	journal.guard.Lock()
+	defer journal.guard.Unlock()
+
+	if journal.segmentSize >= journal.maxSegmentSize {
+		if err := journal.createSegment(); err != nil {
+			return err
+		}
+	}
+
+	seq := journal.nextSeq
+	record := make([]byte, journalHeaderLength+len(data))
+	binary.BigEndian.PutUint64(record[0:8], seq)
+	binary.BigEndian.PutUint32(record[8:12], uint32(len(data)))
+	binary.BigEndian.PutUint32(record[12:16], crc32.ChecksumIEEE(data))
+	copy(record[journalHeaderLength:], data)
+
+	if _, err := journal.segment.Write(record); err != nil {
+		return err
+	}
+	if journal.syncWrites {
+		if err := journal.segment.Sync(); err != nil {
+			return err
+		}
+	}
+
+	journal.nextSeq++
+	journal.segmentSize += int64(len(record))
+	journal.pending[msg] = seq
+	journal.ackOnAcknowledge(msg)
+	return nil
+}
+
+// ackOnAcknowledge acknowledges the given message in the journal as soon as
+// the message itself is acknowledged.
+func (journal *MessageJournal) ackOnAcknowledge(msg *Message) {
+	msg.onAcknowledged(func(success bool) {
+		journal.Ack(msg)
+	})
+}
+
+// Ack marks the given message as processed. Messages that have not been
+// written to the journal are ignored.
+func (journal *MessageJournal) Ack(msg *Message) {
+	journal.guard.Lock()
+	defer journal.guard.Unlock()
+
+	seq, exists := journal.pending[msg]
+	if !exists {
+		return // ### return, not journaled ###
+	}
+
+	delete(journal.pending, msg)
+	journal.acknowledge(seq)
+}
+
+// acknowledge moves the committed sequence number forward if possible.
+// The caller is expected to hold the journal guard.
+func (journal *MessageJournal) acknowledge(seq uint64) {
+	if seq != journal.committed+1 {
+		journal.acked[seq] = true
+		return // ### return, acknowledged out of order ###
+	}
+
+	journal.committed = seq
+	for journal.acked[journal.committed+1] {
+		delete(journal.acked, journal.committed+1)
+		journal.committed++
+	}
+
+	buffer := make([]byte, 8)
+	binary.BigEndian.PutUint64(buffer, journal.committed)
+	journal.ackFile.WriteAt(buffer, 0)
+
+	journal.removeCommittedSegments()
+}
+
+// removeCommittedSegments deletes all segments except the active one that
+// only contain acknowledged messages.
+func (journal *MessageJournal) removeCommittedSegments() {
+	for len(journal.segments) > 1 && journal.segments[1]-1 <= journal.committed {
+		os.Remove(journal.getSegmentPath(journal.segments[0]))
+		journal.segments = journal.segments[1:]
+	}
+}
+
+// GetNumPending returns the number of messages written to or replayed from
+// the journal that have not been acknowledged yet.
+func (journal *MessageJournal) GetNumPending() int {
+	journal.guard.Lock()
+	defer journal.guard.Unlock()
+	return len(journal.pending)
+}
+
+// Close flushes and closes all files used by the journal.
+func (journal *MessageJournal) Close() error {
+	journal.guard.Lock()
+	defer journal.guard.Unlock()
+
+	errors := tgo.NewErrorStack()
+	errors.SetFormat(tgo.ErrorStackFormatCSV)
+
+	errors.Push(journal.segment.Sync())
+	errors.Push(journal.segment.Close())
+	errors.Push(journal.ackFile.Sync())
+	errors.Push(journal.ackFile.Close())
+
+	return errors.OrNil()
+}
@@ -0,0 +1,101 @@
+// Copyright 2015-2018 trivago N.V.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/trivago/tgo/ttesting"
+)
+
+func TestMessageJournalReplay(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	dir, err := ioutil.TempDir("", "gollum-journal")
+	expect.NoError(err)
+	defer os.RemoveAll(dir)
+
+	journal, err := NewMessageJournal(dir, 1<<20, false)
+	expect.NoError(err)
+
+	msg1 := NewMessage(nil, []byte("first"), nil, InvalidStreamID)
+	msg2 := NewMessage(nil, []byte("second"), nil, InvalidStreamID)
+	msg3 := NewMessage(nil, []byte("third"), nil, InvalidStreamID)
+
+	expect.NoError(journal.Write(msg1))
+	expect.NoError(journal.Write(msg2))
+	expect.NoError(journal.Write(msg3))
+	expect.Equal(3, journal.GetNumPending())
+
+	journal.Ack(msg1)
+	journal.Ack(msg3)
+	expect.Equal(1, journal.GetNumPending())
+	expect.NoError(journal.Close())
+
+	// Reopen, only the second message should be replayed
+	journal, err = NewMessageJournal(dir, 1<<20, false)
+	expect.NoError(err)
+
+	replayed := []string{}
+	err = journal.Replay(func(msg *Message) {
+		replayed = append(replayed, msg.String())
+		journal.Ack(msg)
+	})
+	expect.NoError(err)
+	expect.Equal(2, len(replayed)) // third was acknowledged out of order
+	expect.Equal("second", replayed[0])
+	expect.Equal("third", replayed[1])
+	expect.Equal(0, journal.GetNumPending())
+	expect.NoError(journal.Close())
+
+	// Reopen, nothing left to replay
+	journal, err = NewMessageJournal(dir, 1<<20, false)
+	expect.NoError(err)
+
+	numReplayed := 0
+	expect.NoError(journal.Replay(func(msg *Message) { numReplayed++ }))
+	expect.Equal(0, numReplayed)
+	expect.NoError(journal.Close())
+}
+
+func TestMessageJournalSegments(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	dir, err := ioutil.TempDir("", "gollum-journal")
+	expect.NoError(err)
+	defer os.RemoveAll(dir)
+
+	// Every message will start a new segment
+	journal, err := NewMessageJournal(dir, 1, false)
+	expect.NoError(err)
+
+	messages := []*Message{}
+	for i := 0; i < 5; i++ {
+		msg := NewMessage(nil, []byte("message"), nil, InvalidStreamID)
+		expect.NoError(journal.Write(msg))
+		messages = append(messages, msg)
+	}
+	expect.Equal(5, len(journal.segments))
+
+	for _, msg := range messages {
+		journal.Ack(msg)
+	}
+
+	// Only the active segment remains
+	expect.Equal(1, len(journal.segments))
+	expect.NoError(journal.Close())
+}
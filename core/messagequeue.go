@@ -103,6 +103,11 @@ func (channel MessageQueue) GetNumQueued() int {
 	return len(channel)
 }
 
+// GetCapacity returns the maximum number of messages that can be queued.
+func (channel MessageQueue) GetCapacity() int {
+	return cap(channel)
+}
+
 // PopWithTimeout returns a message from the buffer with a runtime <= maxDuration.
 // If the channel is empty or the timout hit, the second return value is false.
 func (channel MessageQueue) PopWithTimeout(maxDuration time.Duration) (*Message, bool) {
@@ -0,0 +1,325 @@
+// Copyright 2015-2018 trivago N.V.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// TraceParentMetadataKey is the metadata key used to pass a W3C traceparent
+// value from consumers to the message tracing.
+const TraceParentMetadataKey = "traceparent"
+
+// SpanKind defines the role of a span as used by OpenTelemetry.
+type SpanKind int
+
+const (
+	// SpanKindInternal marks spans for internal processing steps like routing
+	// and modulators.
+	SpanKindInternal = SpanKind(1)
+	// SpanKindProducer marks spans covering the delivery of a message.
+	SpanKindProducer = SpanKind(4)
+	// SpanKindConsumer marks spans covering the reception of a message.
+	SpanKindConsumer = SpanKind(5)
+)
+
+// TraceContext identifies a span inside a trace as defined by the W3C trace
+// context specification.
+type TraceContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Flags   byte
+}
+
+// Span stores the data of a single processing step of a message.
+type Span struct {
+	Context    TraceContext
+	ParentID   [8]byte
+	Name       string
+	Kind       SpanKind
+	Start      time.Time
+	End        time.Time
+	Failed     bool
+	Attributes map[string]string
+	endOnce    sync.Once
+}
+
+// SpanExporter sends finished spans to a tracing backend.
+type SpanExporter interface {
+	Export(spans []*Span) error
+}
+
+// ParseTraceParent parses a W3C traceparent header value, e.g.
+// "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01".
+func ParseTraceParent(value string) (TraceContext, error) {
+	ctx := TraceContext{}
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return ctx, fmt.Errorf("Invalid traceparent: %s", value)
+	}
+
+	if len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return ctx, fmt.Errorf("Invalid traceparent: %s", value)
+	}
+
+	flags := []byte{0}
+	if _, err := hex.Decode(ctx.TraceID[:], []byte(parts[1])); err != nil {
+		return ctx, err
+	}
+	if _, err := hex.Decode(ctx.SpanID[:], []byte(parts[2])); err != nil {
+		return ctx, err
+	}
+	if _, err := hex.Decode(flags, []byte(parts[3])); err != nil {
+		return ctx, err
+	}
+	ctx.Flags = flags[0]
+
+	if !ctx.IsValid() {
+		return ctx, fmt.Errorf("Invalid traceparent: %s", value)
+	}
+	return ctx, nil
+}
+
+// IsValid returns true if trace and span ID are set.
+func (ctx TraceContext) IsValid() bool {
+	return ctx.TraceID != [16]byte{} && ctx.SpanID != [8]byte{}
+}
+
+// TraceParent returns the context formatted as W3C traceparent value.
+func (ctx TraceContext) TraceParent() string {
+	return fmt.Sprintf("00-%s-%s-%02x", hex.EncodeToString(ctx.TraceID[:]), hex.EncodeToString(ctx.SpanID[:]), ctx.Flags)
+}
+
+// GetTraceContext returns the context of the span currently processing this
+// message. The returned context is not valid if tracing is not active.
+func (msg *Message) GetTraceContext() TraceContext {
+	return msg.trace
+}
+
+// SetTraceContext sets the parent context used for the next span started for
+// this message.
+func (msg *Message) SetTraceContext(ctx TraceContext) {
+	msg.trace = ctx
+}
+
+// endSpans ends all spans that are open until this message copy is
+// acknowledged.
+func (msg *Message) endSpans(success bool) {
+	for _, span := range msg.spans {
+		span.Finish(!success)
+	}
+	msg.spans = nil
+}
+
+// messageSpans stores the *spanProcessor for finished spans. If it holds nil,
+// tracing is not active. messageSpansGuard serializes activation and
+// deactivation.
+var (
+	messageSpans      atomic.Value
+	messageSpansGuard = new(sync.Mutex)
+)
+
+// ActivateMessageSpans enables the creation of spans for messages passing
+// through gollum. Finished spans are passed to the given exporter in batches.
+// This function should be called before any plugin is started.
+func ActivateMessageSpans(exporter SpanExporter) {
+	messageSpansGuard.Lock()
+	defer messageSpansGuard.Unlock()
+	messageSpans.Store(newSpanProcessor(exporter, 512, 5*time.Second))
+}
+
+// DeactivateMessageSpans disables message spans and exports all spans that
+// have not been exported yet.
+func DeactivateMessageSpans() {
+	messageSpansGuard.Lock()
+	processor := getMessageSpans()
+	messageSpans.Store((*spanProcessor)(nil))
+	messageSpansGuard.Unlock()
+
+	if processor != nil {
+		processor.close()
+	}
+}
+
+// getMessageSpans returns the active span processor or nil if message spans
+// are not active.
+func getMessageSpans() *spanProcessor {
+	processor, _ := messageSpans.Load().(*spanProcessor)
+	return processor
+}
+
+// StartMessageSpan starts a new span as child of the message's current trace
+// context and makes it the message's current context. If the message has no
+// valid trace context a new trace is started. Nil is returned if message
+// spans are not active. All Span functions can be called on nil.
+func StartMessageSpan(msg *Message, pluginID string, name string, kind SpanKind) *Span {
+	if getMessageSpans() == nil {
+		return nil // ### return, tracing not active ###
+	}
+
+	parent := msg.trace
+	span := &Span{
+		Name:  name,
+		Kind:  kind,
+		Start: time.Now(),
+		Attributes: map[string]string{
+			"gollum.plugin": pluginID,
+			"gollum.stream": StreamRegistry.GetStreamName(msg.GetStreamID()),
+		},
+	}
+
+	if parent.IsValid() {
+		span.Context.TraceID = parent.TraceID
+		span.Context.Flags = parent.Flags
+		span.ParentID = parent.SpanID
+	} else {
+		rand.Read(span.Context.TraceID[:])
+		span.Context.Flags = 0x01 // sampled
+	}
+	rand.Read(span.Context.SpanID[:])
+
+	msg.trace = span.Context
+	return span
+}
+
+// StartMessageSpanUntilAck works like StartMessageSpan but the span is
+// finished when the message is acknowledged by Ack or Nack.
+func StartMessageSpanUntilAck(msg *Message, pluginID string, name string, kind SpanKind) *Span {
+	span := StartMessageSpan(msg, pluginID, name, kind)
+	if span != nil {
+		msg.spans = append(msg.spans, span)
+	}
+	return span
+}
+
+// SetAttribute adds an attribute to the span.
+func (span *Span) SetAttribute(key, value string) {
+	if span != nil {
+		span.Attributes[key] = value
+	}
+}
+
+// Finish ends the span and passes it to the exporter. Additional calls have
+// no effect.
+func (span *Span) Finish(failed bool) {
+	if span == nil {
+		return
+	}
+
+	span.endOnce.Do(func() {
+		span.End = time.Now()
+		span.Failed = failed
+		if processor := getMessageSpans(); processor != nil {
+			processor.push(span)
+		}
+	})
+}
+
+// spanProcessor collects finished spans and exports them in batches.
+type spanProcessor struct {
+	exporter  SpanExporter
+	guard     *sync.RWMutex
+	closed    bool
+	spans     chan *Span
+	batchSize int
+	interval  time.Duration
+	done      chan struct{}
+}
+
+func newSpanProcessor(exporter SpanExporter, batchSize int, interval time.Duration) *spanProcessor {
+	processor := &spanProcessor{
+		exporter:  exporter,
+		guard:     new(sync.RWMutex),
+		spans:     make(chan *Span, batchSize*8),
+		batchSize: batchSize,
+		interval:  interval,
+		done:      make(chan struct{}),
+	}
+	go processor.run()
+	return processor
+}
+
+// push queues a finished span for export. Spans pushed after close are
+// dropped.
+func (processor *spanProcessor) push(span *Span) {
+	processor.guard.RLock()
+	defer processor.guard.RUnlock()
+
+	if processor.closed {
+		MetricSpansDropped.Inc(1)
+		return // ### return, processor closed ###
+	}
+
+	select {
+	case processor.spans <- span:
+	default:
+		MetricSpansDropped.Inc(1)
+	}
+}
+
+func (processor *spanProcessor) run() {
+	defer close(processor.done)
+
+	batch := make([]*Span, 0, processor.batchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := processor.exporter.Export(batch); err != nil {
+			logrus.WithError(err).Warningf("Failed to export %d spans", len(batch))
+			MetricSpansDropped.Inc(int64(len(batch)))
+		}
+		batch = make([]*Span, 0, processor.batchSize)
+	}
+
+	ticker := time.NewTicker(processor.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case span, more := <-processor.spans:
+			if !more {
+				flush()
+				return // ### return, closed ###
+			}
+			batch = append(batch, span)
+			if len(batch) >= processor.batchSize {
+				flush()
+			}
+
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// close exports all queued spans and stops the processor.
+func (processor *spanProcessor) close() {
+	processor.guard.Lock()
+	if !processor.closed {
+		processor.closed = true
+		close(processor.spans)
+	}
+	processor.guard.Unlock()
+	<-processor.done
+}
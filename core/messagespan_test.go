@@ -0,0 +1,103 @@
+// Copyright 2015-2018 trivago N.V.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/trivago/tgo/ttesting"
+)
+
+type mockSpanExporter struct {
+	guard sync.Mutex
+	spans []*Span
+}
+
+func (exporter *mockSpanExporter) Export(spans []*Span) error {
+	exporter.guard.Lock()
+	defer exporter.guard.Unlock()
+	exporter.spans = append(exporter.spans, spans...)
+	return nil
+}
+
+func TestParseTraceParent(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	traceParent := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	ctx, err := ParseTraceParent(traceParent)
+	expect.NoError(err)
+	expect.True(ctx.IsValid())
+	expect.Equal(byte(1), ctx.Flags)
+	expect.Equal(traceParent, ctx.TraceParent())
+
+	_, err = ParseTraceParent("00-00000000000000000000000000000000-00f067aa0ba902b7-01")
+	expect.NotNil(err)
+
+	_, err = ParseTraceParent("garbage")
+	expect.NotNil(err)
+}
+
+func TestMessageSpans(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	exporter := new(mockSpanExporter)
+	ActivateMessageSpans(exporter)
+
+	parent, _ := ParseTraceParent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	msg := NewMessage(nil, []byte("test"), nil, InvalidStreamID)
+	msg.SetTraceContext(parent)
+
+	consumeSpan := StartMessageSpan(msg, "consumer", "consume", SpanKindConsumer)
+	produceSpan := StartMessageSpanUntilAck(msg, "producer", "produce", SpanKindProducer)
+	consumeSpan.Finish(false)
+	msg.Nack()
+
+	DeactivateMessageSpans()
+
+	expect.Equal(2, len(exporter.spans))
+	expect.Equal(parent.TraceID, consumeSpan.Context.TraceID)
+	expect.Equal(parent.SpanID, consumeSpan.ParentID)
+	expect.Equal(consumeSpan.Context.SpanID, produceSpan.ParentID)
+	expect.True(produceSpan.Failed)
+	expect.False(consumeSpan.Failed)
+
+	// Not active
+	expect.Nil(StartMessageSpan(msg, "producer", "produce", SpanKindProducer))
+}
+
+func TestMessageSpansDeactivateConcurrent(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	ActivateMessageSpans(new(mockSpanExporter))
+
+	done := new(sync.WaitGroup)
+	for i := 0; i < 4; i++ {
+		done.Add(1)
+		go func() {
+			defer done.Done()
+			for j := 0; j < 1000; j++ {
+				msg := NewMessage(nil, []byte("test"), nil, InvalidStreamID)
+				StartMessageSpan(msg, "consumer", "consume", SpanKindConsumer).Finish(false)
+			}
+		}()
+	}
+
+	DeactivateMessageSpans()
+	done.Wait()
+
+	msg := NewMessage(nil, []byte("test"), nil, InvalidStreamID)
+	expect.Nil(StartMessageSpan(msg, "producer", "produce", SpanKindProducer))
+}
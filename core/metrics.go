@@ -71,6 +71,9 @@ var (
 	MetricMessagesEnqued metrics.Counter
 	// MetricMessagesDiscarded holds the total number of discarded messages
 	MetricMessagesDiscarded metrics.Counter
+	// MetricSpansDropped holds the total number of message spans that could
+	// not be exported
+	MetricSpansDropped metrics.Counter
 )
 
 func init() {
@@ -84,6 +87,7 @@ func init() {
 	MetricMessagesEnqued = metrics.NewRegisteredCounter("enqueued", MetricsRegistry)
 	MetricMessagesDiscarded = metrics.NewRegisteredCounter("discarded", MetricsRegistry)
 	MetricActiveWorkers = metrics.NewRegisteredCounter("workers", MetricsRegistry)
+	MetricSpansDropped = metrics.NewRegisteredCounter("spans_dropped", MetricsRegistry)
 
 	pluginMetricsRegistry = NewMetricsRegistry("plugins")
 	MetricRouters = metrics.NewRegisteredCounter("routers", pluginMetricsRegistry)
@@ -15,6 +15,8 @@
 package core
 
 import (
+	"reflect"
+
 	"github.com/sirupsen/logrus"
 )
 
@@ -41,6 +43,15 @@ type ScopedModulator interface {
 	SetLogger(logger logrus.FieldLogger)
 }
 
+// RoutingModulator is implemented by modulators that route new messages to
+// the stream of the message they modulate. These modulators cannot be used
+// by producers, as the new messages would be passed to all producers of the
+// stream and modulated again.
+type RoutingModulator interface {
+	// RoutesMessages returns true if the modulator routes new messages.
+	RoutesMessages() bool
+}
+
 // ModulateResult defines a set of results used to control the message flow
 // induced by Modulator actions.
 type ModulateResult int
@@ -61,10 +72,68 @@ const (
 func (modulators ModulatorArray) Modulate(msg *Message) ModulateResult {
 	action := ModulateResultContinue
 	for _, modulator := range modulators {
-		switch modRes := modulator.Modulate(msg); modRes {
+		switch modRes := modulators.modulateOne(modulator, msg); modRes {
 		case ModulateResultDiscard, ModulateResultFallback:
 			return modRes // ### return, break modulator calls ###
 		}
 	}
 	return action
 }
+
+// getRoutingModulators returns the type names of all modulators in the
+// array that route new messages.
+func (modulators ModulatorArray) getRoutingModulators() []string {
+	names := []string{}
+	for _, modulator := range modulators {
+		plugin := interface{}(modulator)
+		if formatterModulator, isFormatter := modulator.(*FormatterModulator); isFormatter {
+			plugin = formatterModulator.Formatter
+		}
+		if router, isRouting := plugin.(RoutingModulator); isRouting && router.RoutesMessages() {
+			names = append(names, reflect.TypeOf(plugin).String())
+		}
+	}
+	return names
+}
+
+// modulateOne calls a single modulator and wraps it into a span if message
+// spans are active.
+func (modulators ModulatorArray) modulateOne(modulator Modulator, msg *Message) ModulateResult {
+	if getMessageSpans() == nil {
+		return modulator.Modulate(msg) // ### return, tracing not active ###
+	}
+
+	parent := msg.GetTraceContext()
+	name := getModulatorName(modulator)
+	span := StartMessageSpan(msg, name, "modulate "+name, SpanKindInternal)
+
+	result := modulator.Modulate(msg)
+	switch result {
+	case ModulateResultDiscard:
+		span.SetAttribute("gollum.result", "discard")
+	case ModulateResultFallback:
+		span.SetAttribute("gollum.result", "fallback")
+	}
+
+	span.Finish(false)
+	msg.SetTraceContext(parent)
+	return result
+}
+
+// getModulatorName returns the type name of a modulator or the filter or
+// formatter wrapped by it.
+func getModulatorName(modulator Modulator) string {
+	var plugin interface{} = modulator
+	switch mod := modulator.(type) {
+	case *FilterModulator:
+		plugin = mod.Filter
+	case *FormatterModulator:
+		plugin = mod.Formatter
+	}
+
+	pluginType := reflect.TypeOf(plugin)
+	if pluginType.Kind() == reflect.Ptr {
+		pluginType = pluginType.Elem()
+	}
+	return pluginType.String()
+}
@@ -0,0 +1,198 @@
+// Copyright 2015-2018 trivago N.V.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const offsetDBVersion = 1
+
+// OffsetEntry stores the read position of a single source, e.g. a file.
+type OffsetEntry struct {
+	Path    string    `json:"path"`
+	Offset  int64     `json:"offset"`
+	Done    bool      `json:"done,omitempty"`
+	Updated time.Time `json:"updated"`
+}
+
+type offsetDBFile struct {
+	Version int                    `json:"version"`
+	Entries map[string]OffsetEntry `json:"entries"`
+}
+
+// OffsetDB is a file based store for offsets of many sources. Entries are
+// kept in memory and written to disk by Flush. Writes are atomic, i.e. the
+// file on disk always contains a complete state, even after a crash.
+type OffsetDB struct {
+	path    string
+	guard   *sync.Mutex
+	entries map[string]OffsetEntry
+	dirty   bool
+}
+
+// OpenOffsetDB reads the offset database stored at the given path. An empty
+// database is returned if the file does not exist.
+func OpenOffsetDB(path string) (*OffsetDB, error) {
+	db := &OffsetDB{
+		path:    path,
+		guard:   new(sync.Mutex),
+		entries: make(map[string]OffsetEntry),
+	}
+
+	data, err := ioutil.ReadFile(path)
+	switch {
+	case os.IsNotExist(err):
+		return db, nil // ### return, new database ###
+	case err != nil:
+		return nil, err
+	}
+
+	file := offsetDBFile{}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+	if file.Entries != nil {
+		db.entries = file.Entries
+	}
+	return db, nil
+}
+
+// Path returns the location of the database file.
+func (db *OffsetDB) Path() string {
+	return db.path
+}
+
+// Get returns the entry stored for the given key.
+func (db *OffsetDB) Get(key string) (OffsetEntry, bool) {
+	db.guard.Lock()
+	defer db.guard.Unlock()
+	entry, exists := db.entries[key]
+	return entry, exists
+}
+
+// Set stores an entry for the given key. The update time is set to now.
+func (db *OffsetDB) Set(key string, entry OffsetEntry) {
+	entry.Updated = time.Now()
+
+	db.guard.Lock()
+	defer db.guard.Unlock()
+	db.entries[key] = entry
+	db.dirty = true
+}
+
+// Delete removes the entry for the given key.
+func (db *OffsetDB) Delete(key string) {
+	db.guard.Lock()
+	defer db.guard.Unlock()
+
+	if _, exists := db.entries[key]; exists {
+		delete(db.entries, key)
+		db.dirty = true
+	}
+}
+
+// Keys returns all keys in the database in sorted order.
+func (db *OffsetDB) Keys() []string {
+	db.guard.Lock()
+	defer db.guard.Unlock()
+
+	keys := make([]string, 0, len(db.entries))
+	for key := range db.entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Cleanup removes all entries for which isStale returns true. The keys of
+// the removed entries are returned.
+func (db *OffsetDB) Cleanup(isStale func(key string, entry OffsetEntry) bool) []string {
+	db.guard.Lock()
+	defer db.guard.Unlock()
+
+	removed := []string{}
+	for key, entry := range db.entries {
+		if isStale(key, entry) {
+			delete(db.entries, key)
+			removed = append(removed, key)
+		}
+	}
+	if len(removed) > 0 {
+		db.dirty = true
+	}
+	return removed
+}
+
+// Flush writes the database to disk if it has been changed. The data is
+// written to a temporary file first, which then replaces the database file.
+func (db *OffsetDB) Flush() error {
+	db.guard.Lock()
+	defer db.guard.Unlock()
+
+	if !db.dirty {
+		return nil // ### return, nothing to write ###
+	}
+
+	data, err := json.Marshal(offsetDBFile{
+		Version: offsetDBVersion,
+		Entries: db.entries,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := WriteFileAtomic(db.path, data); err != nil {
+		return err
+	}
+
+	db.dirty = false
+	return nil
+}
+
+// WriteFileAtomic writes data to a temporary file next to path and renames it
+// to path once all data has been synced. Readers of path will either see the
+// old or the new contents but never a partially written file. Missing
+// directories are created.
+func WriteFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	tempFile, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tempFile.Name())
+
+	if _, err := tempFile.Write(data); err != nil {
+		tempFile.Close()
+		return err
+	}
+	if err := tempFile.Sync(); err != nil {
+		tempFile.Close()
+		return err
+	}
+	if err := tempFile.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tempFile.Name(), path)
+}
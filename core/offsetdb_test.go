@@ -0,0 +1,67 @@
+// Copyright 2015-2018 trivago N.V.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/trivago/tgo/ttesting"
+)
+
+func TestOffsetDB(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+	dir, err := ioutil.TempDir("", "gollum-offsetdb")
+	expect.NoError(err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "offsets.db")
+	db, err := OpenOffsetDB(path)
+	expect.NoError(err)
+	expect.Equal(0, len(db.Keys()))
+
+	db.Set("a", OffsetEntry{Path: "/tmp/a.log", Offset: 10})
+	db.Set("b", OffsetEntry{Path: "/tmp/b.log", Offset: 20, Done: true})
+	expect.NoError(db.Flush())
+
+	db, err = OpenOffsetDB(path)
+	expect.NoError(err)
+	expect.Equal([]string{"a", "b"}, db.Keys())
+
+	entry, exists := db.Get("b")
+	expect.True(exists)
+	expect.Equal(int64(20), entry.Offset)
+	expect.True(entry.Done)
+	expect.False(entry.Updated.IsZero())
+
+	removed := db.Cleanup(func(key string, entry OffsetEntry) bool {
+		return entry.Done
+	})
+	expect.Equal([]string{"b"}, removed)
+
+	db.Delete("a")
+	expect.NoError(db.Flush())
+
+	db, err = OpenOffsetDB(path)
+	expect.NoError(err)
+	expect.Equal(0, len(db.Keys()))
+
+	// No temporary files are left behind
+	files, err := ioutil.ReadDir(dir)
+	expect.NoError(err)
+	expect.Equal(1, len(files))
+}
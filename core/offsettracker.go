@@ -0,0 +1,116 @@
+// Copyright 2015-2018 trivago N.V.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"sort"
+	"sync"
+)
+
+const (
+	offsetPending = offsetState(iota)
+	offsetDelivered
+	offsetFailed
+)
+
+type offsetState int8
+
+type trackedOffset struct {
+	offset int64
+	state  offsetState
+}
+
+// OffsetTracker keeps track of message offsets (e.g. kafka offsets or file
+// positions) that wait for acknowledgement. Offsets may be acknowledged in any
+// order but the commit offset only advances over offsets that have been
+// delivered, i.e. a failed offset blocks all following offsets.
+// Consumers can use this to persist only offsets of delivered messages.
+type OffsetTracker struct {
+	guard   sync.Mutex
+	pending []trackedOffset
+	commit  int64
+}
+
+// NewOffsetTracker creates a new tracker starting at the given commit offset.
+func NewOffsetTracker(commit int64) *OffsetTracker {
+	return &OffsetTracker{
+		pending: []trackedOffset{},
+		commit:  commit,
+	}
+}
+
+// Track registers a new offset waiting for acknowledgement. Offsets have to
+// be tracked in ascending order. Tracking an offset that is not greater than
+// the last tracked offset (e.g. when data is read again after a reconnect)
+// drops all pending offsets.
+func (tracker *OffsetTracker) Track(offset int64) {
+	tracker.guard.Lock()
+	defer tracker.guard.Unlock()
+
+	if numPending := len(tracker.pending); numPending > 0 && offset <= tracker.pending[numPending-1].offset {
+		tracker.pending = []trackedOffset{}
+	}
+	tracker.pending = append(tracker.pending, trackedOffset{offset: offset})
+}
+
+// Done acknowledges a tracked offset. The current commit offset is returned
+// along with a flag that is true if the commit offset has been advanced.
+func (tracker *OffsetTracker) Done(offset int64, success bool) (int64, bool) {
+	tracker.guard.Lock()
+	defer tracker.guard.Unlock()
+
+	idx := sort.Search(len(tracker.pending), func(i int) bool {
+		return tracker.pending[i].offset >= offset
+	})
+
+	if idx == len(tracker.pending) || tracker.pending[idx].offset != offset {
+		return tracker.commit, false // ### return, not tracked ###
+	}
+
+	if success {
+		tracker.pending[idx].state = offsetDelivered
+	} else {
+		tracker.pending[idx].state = offsetFailed
+	}
+
+	numDone := 0
+	for numDone < len(tracker.pending) && tracker.pending[numDone].state == offsetDelivered {
+		numDone++
+	}
+
+	if numDone == 0 {
+		return tracker.commit, false // ### return, blocked by earlier offset ###
+	}
+
+	tracker.commit = tracker.pending[numDone-1].offset
+	tracker.pending = tracker.pending[numDone:]
+	return tracker.commit, true
+}
+
+// GetCommitOffset returns the highest offset that has been delivered without
+// any pending or failed offsets before it.
+func (tracker *OffsetTracker) GetCommitOffset() int64 {
+	tracker.guard.Lock()
+	defer tracker.guard.Unlock()
+	return tracker.commit
+}
+
+// GetNumPending returns the number of offsets that are tracked but could not
+// be committed yet.
+func (tracker *OffsetTracker) GetNumPending() int {
+	tracker.guard.Lock()
+	defer tracker.guard.Unlock()
+	return len(tracker.pending)
+}
@@ -0,0 +1,59 @@
+// Copyright 2015-2018 trivago N.V.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"testing"
+
+	"github.com/trivago/tgo/ttesting"
+)
+
+func TestOffsetTracker(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+	tracker := NewOffsetTracker(9)
+
+	for offset := int64(10); offset < 15; offset++ {
+		tracker.Track(offset)
+	}
+	expect.Equal(5, tracker.GetNumPending())
+
+	commit, advanced := tracker.Done(11, true)
+	expect.False(advanced)
+	expect.Equal(int64(9), commit)
+
+	commit, advanced = tracker.Done(10, true)
+	expect.True(advanced)
+	expect.Equal(int64(11), commit)
+	expect.Equal(3, tracker.GetNumPending())
+
+	// Failed offsets block the commit offset
+	tracker.Done(12, false)
+	_, advanced = tracker.Done(13, true)
+	expect.False(advanced)
+	expect.Equal(int64(11), tracker.GetCommitOffset())
+
+	// Unknown offsets are ignored
+	_, advanced = tracker.Done(42, true)
+	expect.False(advanced)
+
+	// Reading offsets again drops all pending offsets
+	tracker.Track(12)
+	expect.Equal(1, tracker.GetNumPending())
+
+	commit, advanced = tracker.Done(12, true)
+	expect.True(advanced)
+	expect.Equal(int64(12), commit)
+	expect.Equal(0, tracker.GetNumPending())
+}
@@ -0,0 +1,207 @@
+// Copyright 2015-2018 trivago N.V.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	otlpTracesPath      = "/v1/traces"
+	otlpStatusCodeOK    = 1
+	otlpStatusCodeError = 2
+)
+
+// OTLPExporter sends spans to an OpenTelemetry collector by using the
+// OTLP/HTTP protocol with JSON encoding.
+type OTLPExporter struct {
+	endpoint    string
+	serviceName string
+	hostName    string
+	client      *http.Client
+}
+
+type otlpTraces struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+type otlpAttribute struct {
+	Key   string             `json:"key"`
+	Value otlpAttributeValue `json:"value"`
+}
+
+type otlpAttributeValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpStatus struct {
+	Code int `json:"code"`
+}
+
+// NewOTLPExporter creates a new exporter sending spans to the given collector
+// endpoint. If the endpoint does not contain a path, the default path
+// "/v1/traces" is used. The service name is attached to all spans.
+func NewOTLPExporter(endpoint string, serviceName string, timeout time.Duration) (*OTLPExporter, error) {
+	endpointURL, err := url.Parse(endpoint)
+	if err != nil || endpointURL.Host == "" {
+		// Try again for endpoints given as host:port
+		if endpointURL, err = url.Parse("http://" + endpoint); err != nil {
+			return nil, err
+		}
+	}
+
+	if endpointURL.Path == "" || endpointURL.Path == "/" {
+		endpointURL.Path = otlpTracesPath
+	}
+
+	hostName, _ := os.Hostname()
+	return &OTLPExporter{
+		endpoint:    endpointURL.String(),
+		serviceName: serviceName,
+		hostName:    hostName,
+		client:      &http.Client{Timeout: timeout},
+	}, nil
+}
+
+// GetEndpoint returns the URL spans are sent to.
+func (exporter *OTLPExporter) GetEndpoint() string {
+	return exporter.endpoint
+}
+
+// Export sends the given spans to the collector.
+func (exporter *OTLPExporter) Export(spans []*Span) error {
+	data, err := json.Marshal(exporter.newRequest(spans))
+	if err != nil {
+		return err
+	}
+
+	resp, err := exporter.client.Post(exporter.endpoint, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("Collector %s returned %s", exporter.endpoint, resp.Status)
+	}
+	return nil
+}
+
+func (exporter *OTLPExporter) newRequest(spans []*Span) otlpTraces {
+	scopeSpans := otlpScopeSpans{
+		Scope: otlpScope{
+			Name:    "gollum",
+			Version: GetVersionString(),
+		},
+		Spans: make([]otlpSpan, 0, len(spans)),
+	}
+
+	for _, span := range spans {
+		scopeSpans.Spans = append(scopeSpans.Spans, newOTLPSpan(span))
+	}
+
+	resource := otlpResource{
+		Attributes: []otlpAttribute{
+			newOTLPAttribute("service.name", exporter.serviceName),
+		},
+	}
+	if exporter.hostName != "" {
+		resource.Attributes = append(resource.Attributes, newOTLPAttribute("host.name", exporter.hostName))
+	}
+
+	return otlpTraces{
+		ResourceSpans: []otlpResourceSpans{{
+			Resource:   resource,
+			ScopeSpans: []otlpScopeSpans{scopeSpans},
+		}},
+	}
+}
+
+func newOTLPSpan(span *Span) otlpSpan {
+	otlp := otlpSpan{
+		TraceID:           hex.EncodeToString(span.Context.TraceID[:]),
+		SpanID:            hex.EncodeToString(span.Context.SpanID[:]),
+		Name:              span.Name,
+		Kind:              int(span.Kind),
+		StartTimeUnixNano: strconv.FormatInt(span.Start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(span.End.UnixNano(), 10),
+		Status:            otlpStatus{Code: otlpStatusCodeOK},
+	}
+
+	if span.ParentID != [8]byte{} {
+		otlp.ParentSpanID = hex.EncodeToString(span.ParentID[:])
+	}
+	if span.Failed {
+		otlp.Status.Code = otlpStatusCodeError
+	}
+
+	keys := make([]string, 0, len(span.Attributes))
+	for key := range span.Attributes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		otlp.Attributes = append(otlp.Attributes, newOTLPAttribute(key, span.Attributes[key]))
+	}
+	return otlp
+}
+
+func newOTLPAttribute(key, value string) otlpAttribute {
+	return otlpAttribute{
+		Key:   key,
+		Value: otlpAttributeValue{StringValue: value},
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright 2015-2018 trivago N.V.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/trivago/tgo/ttesting"
+)
+
+func TestOTLPExporter(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	var (
+		received otlpTraces
+		path     string
+	)
+	collector := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		path = req.URL.Path
+		body, _ := ioutil.ReadAll(req.Body)
+		json.Unmarshal(body, &received)
+	}))
+	defer collector.Close()
+
+	exporter, err := NewOTLPExporter(collector.URL, "test", time.Second)
+	expect.NoError(err)
+
+	span := &Span{
+		Name:       "route",
+		Kind:       SpanKindInternal,
+		Start:      time.Unix(0, 1000),
+		End:        time.Unix(0, 2000),
+		Failed:     true,
+		Attributes: map[string]string{"gollum.plugin": "router"},
+	}
+	span.Context.TraceID[0] = 0xab
+	span.Context.SpanID[0] = 0xcd
+
+	expect.NoError(exporter.Export([]*Span{span}))
+	expect.Equal(otlpTracesPath, path)
+
+	expect.Equal(1, len(received.ResourceSpans))
+	expect.Equal("service.name", received.ResourceSpans[0].Resource.Attributes[0].Key)
+	expect.Equal("test", received.ResourceSpans[0].Resource.Attributes[0].Value.StringValue)
+
+	spans := received.ResourceSpans[0].ScopeSpans[0].Spans
+	expect.Equal(1, len(spans))
+	expect.Equal("ab000000000000000000000000000000", spans[0].TraceID)
+	expect.Equal("cd00000000000000", spans[0].SpanID)
+	expect.Equal("", spans[0].ParentSpanID)
+	expect.Equal("1000", spans[0].StartTimeUnixNano)
+	expect.Equal(otlpStatusCodeError, spans[0].Status.Code)
+	expect.Equal("gollum.plugin", spans[0].Attributes[0].Key)
+}
@@ -18,6 +18,7 @@ import (
 	"fmt"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	metrics "github.com/rcrowley/go-metrics"
 	"github.com/trivago/tgo/treflect"
@@ -40,6 +41,12 @@ const (
 	PluginControlStopConsumer = PluginControl(iota)
 	// PluginControlRoll notifies the consumer/producer about a reconnect or reopen request
 	PluginControlRoll = PluginControl(iota)
+	// PluginControlPause will cause any consumer/producer to stop processing
+	// messages until PluginControlResume is received.
+	PluginControlPause = PluginControl(iota)
+	// PluginControlResume will cause a paused consumer/producer to continue
+	// processing messages.
+	PluginControlResume = PluginControl(iota)
 )
 
 const (
@@ -84,8 +91,11 @@ var (
 // threading primitives that enable gollum to wait for a plugin top properly
 // shut down.
 type PluginRunState struct {
-	workers *sync.WaitGroup
-	state   int32 // Pluginstate
+	workers    *sync.WaitGroup
+	state      int32 // Pluginstate
+	paused     int32
+	pauseGuard sync.Mutex
+	resumed    chan struct{}
 }
 
 // Plugin is the base class for any runtime class that can be configured and
@@ -116,6 +126,14 @@ func NewPluginRunState() *PluginRunState {
 	}
 }
 
+// String returns a human readable representation of the plugin state
+func (state PluginState) String() string {
+	if state < 0 || int(state) >= len(stateToDescription) {
+		return "Unknown"
+	}
+	return stateToDescription[state]
+}
+
 // GetState returns the current plugin state casted to the correct type
 func (state *PluginRunState) GetState() PluginState {
 	return PluginState(atomic.LoadInt32(&state.state))
@@ -123,7 +141,7 @@ func (state *PluginRunState) GetState() PluginState {
 
 // GetStateString returns the current state as string
 func (state *PluginRunState) GetStateString() string {
-	return stateToDescription[state.GetState()]
+	return state.GetState().String()
 }
 
 // SetState sets a new plugin state casted to the correct type
@@ -136,6 +154,81 @@ func (state *PluginRunState) SetState(nextState PluginState) {
 	}
 }
 
+// Pause marks the plugin as paused. Calls to WaitWhilePaused will block until
+// Resume is called.
+func (state *PluginRunState) Pause() {
+	state.pauseGuard.Lock()
+	defer state.pauseGuard.Unlock()
+
+	if state.resumed == nil {
+		state.resumed = make(chan struct{})
+	}
+	atomic.StoreInt32(&state.paused, 1)
+}
+
+// Resume marks the plugin as not paused and wakes up all go routines blocked
+// by WaitWhilePaused.
+func (state *PluginRunState) Resume() {
+	state.pauseGuard.Lock()
+	defer state.pauseGuard.Unlock()
+
+	atomic.StoreInt32(&state.paused, 0)
+	if state.resumed != nil {
+		close(state.resumed)
+		state.resumed = nil
+	}
+}
+
+// IsPaused returns true if Pause has been called without a following call to
+// Resume.
+func (state *PluginRunState) IsPaused() bool {
+	return atomic.LoadInt32(&state.paused) != 0
+}
+
+// WaitWhilePaused blocks as long as the plugin is paused.
+func (state *PluginRunState) WaitWhilePaused() {
+	for state.IsPaused() {
+		state.pauseGuard.Lock()
+		resumed := state.resumed
+		state.pauseGuard.Unlock()
+
+		if resumed != nil {
+			<-resumed
+		}
+	}
+}
+
+// WaitWhilePausedTimeout blocks as long as the plugin is paused but at most
+// for the given duration. A timeout of 0 or less does not block. False is
+// returned if the plugin is still paused.
+func (state *PluginRunState) WaitWhilePausedTimeout(timeout time.Duration) bool {
+	if !state.IsPaused() {
+		return true // ### return, not paused ###
+	}
+	if timeout <= 0 {
+		return false // ### return, do not wait ###
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for state.IsPaused() {
+		state.pauseGuard.Lock()
+		resumed := state.resumed
+		state.pauseGuard.Unlock()
+
+		if resumed == nil {
+			continue
+		}
+		select {
+		case <-resumed:
+		case <-timer.C:
+			return !state.IsPaused() // ### return, timed out ###
+		}
+	}
+	return true
+}
+
 // SetWorkerWaitGroup sets the WaitGroup used to manage workers
 func (state *PluginRunState) SetWorkerWaitGroup(workers *sync.WaitGroup) {
 	state.workers = workers
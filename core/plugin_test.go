@@ -82,3 +82,45 @@ func TestNewPlugin(t *testing.T) {
 	_, err = NewPluginWithConfig(NewPluginConfig("mockPluginConfig", "core.mockPlugin"))
 	expect.NoError(err)
 }
+
+func TestPluginRunStatePause(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+	pluginState := NewPluginRunState()
+
+	expect.False(pluginState.IsPaused())
+	pluginState.WaitWhilePaused() // must not block
+
+	pluginState.Pause()
+	expect.True(pluginState.IsPaused())
+
+	done := new(int32)
+	go func() {
+		pluginState.WaitWhilePaused()
+		atomic.StoreInt32(done, 1)
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	expect.Equal(int32(0), atomic.LoadInt32(done))
+
+	pluginState.Resume()
+	time.Sleep(100 * time.Millisecond)
+	expect.Equal(int32(1), atomic.LoadInt32(done))
+	expect.False(pluginState.IsPaused())
+}
+
+func TestPluginRunStatePauseTimeout(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+	pluginState := NewPluginRunState()
+
+	expect.True(pluginState.WaitWhilePausedTimeout(0))
+
+	pluginState.Pause()
+	expect.False(pluginState.WaitWhilePausedTimeout(0))
+
+	start := time.Now()
+	expect.False(pluginState.WaitWhilePausedTimeout(50 * time.Millisecond))
+	expect.True(time.Since(start) >= 50*time.Millisecond)
+
+	time.AfterFunc(20*time.Millisecond, pluginState.Resume)
+	expect.True(pluginState.WaitWhilePausedTimeout(5 * time.Second))
+}
@@ -15,11 +15,14 @@
 package core
 
 import (
+	"fmt"
+	"reflect"
+	"strings"
+
 	"github.com/arbovm/levenshtein"
 	"github.com/sirupsen/logrus"
 	"github.com/trivago/tgo"
 	"github.com/trivago/tgo/tcontainer"
-	"strings"
 )
 
 // PluginConfig is a configuration for a specific plugin
@@ -29,6 +32,7 @@ type PluginConfig struct {
 	Enable    bool
 	Settings  tcontainer.MarshalMap
 	validKeys map[string]bool
+	origin    *configOrigin
 }
 
 // NewPluginConfig creates a new plugin config with default values.
@@ -90,6 +94,29 @@ func (conf *PluginConfig) registerKey(key string) string {
 	return key
 }
 
+// GetLocation returns the file and line the given key has been defined at in
+// the format "file:line". If the key is not set, the location of the plugin
+// is returned. An empty string is returned if the location is not known.
+func (conf PluginConfig) GetLocation(key string) string {
+	return conf.origin.locate(key)
+}
+
+// annotateError prefixes the given error with the location of the given key.
+func (conf PluginConfig) annotateError(key string, err error) error {
+	return annotateConfigError(conf.origin.locate(key), err)
+}
+
+// hasNestedKeys returns true if a key below the given path has been read.
+func (conf PluginConfig) hasNestedKeys(path string) bool {
+	prefix := path + string(tcontainer.MarshalMapSeparator)
+	for key := range conf.validKeys {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
 func (conf PluginConfig) suggestKey(searchKey string) string {
 	closestDist := len(searchKey)
 	bestMatch := ""
@@ -119,9 +146,9 @@ func (conf PluginConfig) Validate() error {
 	for key := range conf.Settings {
 		if _, exists := conf.validKeys[key]; !exists {
 			if suggestion := conf.suggestKey(key); suggestion != "" {
-				errors.Pushf("Unknown configuration key '%s' in '%s'. Did you mean '%s'?", key, conf.Typename, suggestion)
+				errors.Push(conf.annotateError(key, fmt.Errorf("Unknown configuration key '%s' in '%s'. Did you mean '%s'?", key, conf.Typename, suggestion)))
 			} else {
-				errors.Pushf("Unknown configuration key '%s' in '%s", key, conf.Typename)
+				errors.Push(conf.annotateError(key, fmt.Errorf("Unknown configuration key '%s' in '%s'", key, conf.Typename)))
 			}
 		}
 	}
@@ -202,6 +229,15 @@ func (conf *PluginConfig) Read(values tcontainer.MarshalMap) error {
 	return errors.OrNil()
 }
 
+// IsEqual returns true if the given config has the same ID, type, enable state
+// and settings as this config.
+func (conf PluginConfig) IsEqual(other PluginConfig) bool {
+	return conf.ID == other.ID &&
+		conf.Typename == other.Typename &&
+		conf.Enable == other.Enable &&
+		reflect.DeepEqual(conf.Settings, other.Settings)
+}
+
 // Override sets or override a configuration value for non-predefined options.
 func (conf *PluginConfig) Override(key string, value interface{}) {
 	key = conf.registerKey(key)
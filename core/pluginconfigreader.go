@@ -90,6 +90,12 @@ func (reader *PluginConfigReader) GetSubLogger(subScope string) logrus.FieldLogg
 	return reader.WithError.GetSubLogger(subScope)
 }
 
+// pushError adds the given error to Errors. The error is prefixed with the
+// location the given key has been defined at, if available.
+func (reader *PluginConfigReader) pushError(key string, err error) {
+	reader.Errors.Push(reader.WithError.config.annotateError(key, err))
+}
+
 // HasValue returns true if the given key has been set as a config option.
 // This function only takes settings into account.
 func (reader *PluginConfigReader) HasValue(key string) bool {
@@ -100,7 +106,7 @@ func (reader *PluginConfigReader) HasValue(key string) bool {
 // If that value is not found defaultValue is returned.
 func (reader *PluginConfigReader) GetString(key string, defaultValue string) string {
 	value, err := reader.WithError.GetString(key, defaultValue)
-	reader.Errors.Push(err)
+	reader.pushError(key, err)
 	return value
 }
 
@@ -108,7 +114,7 @@ func (reader *PluginConfigReader) GetString(key string, defaultValue string) str
 // If that value is not found nil is returned.
 func (reader *PluginConfigReader) GetURL(key string, defaultValue string) *url.URL {
 	value, err := reader.WithError.GetURL(key, defaultValue)
-	reader.Errors.Push(err)
+	reader.pushError(key, err)
 	return value
 }
 
@@ -116,7 +122,7 @@ func (reader *PluginConfigReader) GetURL(key string, defaultValue string) *url.U
 // If that value is not found defaultValue is returned.
 func (reader *PluginConfigReader) GetInt(key string, defaultValue int64) int64 {
 	value, err := reader.WithError.GetInt(key, defaultValue)
-	reader.Errors.Push(err)
+	reader.pushError(key, err)
 	return value
 }
 
@@ -124,7 +130,7 @@ func (reader *PluginConfigReader) GetInt(key string, defaultValue int64) int64 {
 // If that value is not found defaultValue is returned.
 func (reader *PluginConfigReader) GetUint(key string, defaultValue uint64) uint64 {
 	value, err := reader.WithError.GetUint(key, defaultValue)
-	reader.Errors.Push(err)
+	reader.pushError(key, err)
 	return value
 }
 
@@ -132,7 +138,7 @@ func (reader *PluginConfigReader) GetUint(key string, defaultValue uint64) uint6
 // If that value is not found defaultValue is returned.
 func (reader *PluginConfigReader) GetBool(key string, defaultValue bool) bool {
 	value, err := reader.WithError.GetBool(key, defaultValue)
-	reader.Errors.Push(err)
+	reader.pushError(key, err)
 	return value
 }
 
@@ -146,7 +152,7 @@ func (reader *PluginConfigReader) GetValue(key string, defaultValue interface{})
 // If that value is not found defaultValue is returned.
 func (reader *PluginConfigReader) GetStreamID(key string, defaultValue MessageStreamID) MessageStreamID {
 	value, err := reader.WithError.GetStreamID(key, defaultValue)
-	reader.Errors.Push(err)
+	reader.pushError(key, err)
 	return value
 }
 
@@ -157,7 +163,7 @@ func (reader *PluginConfigReader) GetStreamID(key string, defaultValue MessageSt
 // used to override defaultType.
 func (reader *PluginConfigReader) GetPlugin(key string, defaultType string, defaultConfig tcontainer.MarshalMap) Plugin {
 	value, err := reader.WithError.GetPlugin(key, defaultType, defaultConfig)
-	reader.Errors.Push(err)
+	reader.pushError(key, err)
 	return value
 }
 
@@ -165,7 +171,7 @@ func (reader *PluginConfigReader) GetPlugin(key string, defaultType string, defa
 // If that value is not found defaultValue is returned.
 func (reader *PluginConfigReader) GetArray(key string, defaultValue []interface{}) []interface{} {
 	value, err := reader.WithError.GetArray(key, defaultValue)
-	reader.Errors.Push(err)
+	reader.pushError(key, err)
 	return value
 }
 
@@ -173,7 +179,7 @@ func (reader *PluginConfigReader) GetArray(key string, defaultValue []interface{
 // If that value is not found defaultValue is returned.
 func (reader *PluginConfigReader) GetMap(key string, defaultValue tcontainer.MarshalMap) tcontainer.MarshalMap {
 	value, err := reader.WithError.GetMap(key, defaultValue)
-	reader.Errors.Push(err)
+	reader.pushError(key, err)
 	return value
 }
 
@@ -181,28 +187,28 @@ func (reader *PluginConfigReader) GetMap(key string, defaultValue tcontainer.Mar
 // If that value is not found defaultValue is returned.
 func (reader *PluginConfigReader) GetPluginArray(key string, defaultValue []Plugin) []Plugin {
 	value, err := reader.WithError.GetPluginArray(key, defaultValue)
-	reader.Errors.Push(err)
+	reader.pushError(key, err)
 	return value
 }
 
 // GetModulatorArray reads an array of modulator plugins
 func (reader *PluginConfigReader) GetModulatorArray(key string, logger logrus.FieldLogger, defaultValue ModulatorArray) ModulatorArray {
 	modulators, err := reader.WithError.GetModulatorArray(key, logger, defaultValue)
-	reader.Errors.Push(err)
+	reader.pushError(key, err)
 	return modulators
 }
 
 // GetFilterArray returns an array of filter plugins.
 func (reader *PluginConfigReader) GetFilterArray(key string, logger logrus.FieldLogger, defaultValue FilterArray) FilterArray {
 	filters, err := reader.WithError.GetFilterArray(key, logger, defaultValue)
-	reader.Errors.Push(err)
+	reader.pushError(key, err)
 	return filters
 }
 
 // GetFormatterArray returns an array of formatter plugins.
 func (reader *PluginConfigReader) GetFormatterArray(key string, logger logrus.FieldLogger, defaultValue FormatterArray) FormatterArray {
 	formatter, err := reader.WithError.GetFormatterArray(key, logger, defaultValue)
-	reader.Errors.Push(err)
+	reader.pushError(key, err)
 	return formatter
 }
 
@@ -210,7 +216,7 @@ func (reader *PluginConfigReader) GetFormatterArray(key string, logger logrus.Fi
 // PluginConfig. If that value is not found defaultValue is returned.
 func (reader *PluginConfigReader) GetStringArray(key string, defaultValue []string) []string {
 	value, err := reader.WithError.GetStringArray(key, defaultValue)
-	reader.Errors.Push(err)
+	reader.pushError(key, err)
 	return value
 }
 
@@ -218,7 +224,7 @@ func (reader *PluginConfigReader) GetStringArray(key string, defaultValue []stri
 // PluginConfig. If the key is not found defaultValue is returned.
 func (reader *PluginConfigReader) GetStringMap(key string, defaultValue map[string]string) map[string]string {
 	value, err := reader.WithError.GetStringMap(key, defaultValue)
-	reader.Errors.Push(err)
+	reader.pushError(key, err)
 	return value
 }
 
@@ -227,7 +233,7 @@ func (reader *PluginConfigReader) GetStringMap(key string, defaultValue map[stri
 // is returned.
 func (reader *PluginConfigReader) GetStreamArray(key string, defaultValue []MessageStreamID) []MessageStreamID {
 	value, err := reader.WithError.GetStreamArray(key, defaultValue)
-	reader.Errors.Push(err)
+	reader.pushError(key, err)
 	return value
 }
 
@@ -236,7 +242,7 @@ func (reader *PluginConfigReader) GetStreamArray(key string, defaultValue []Mess
 // The target is either defaultValue or a value defined by the config.
 func (reader *PluginConfigReader) GetStreamMap(key string, defaultValue string) map[MessageStreamID]string {
 	value, err := reader.WithError.GetStreamMap(key, defaultValue)
-	reader.Errors.Push(err)
+	reader.pushError(key, err)
 	return value
 }
 
@@ -244,7 +250,7 @@ func (reader *PluginConfigReader) GetStreamMap(key string, defaultValue string)
 // plugin config. If no routes are defined an empty map is returned
 func (reader *PluginConfigReader) GetStreamRoutes(key string, defaultValue map[MessageStreamID][]MessageStreamID) map[MessageStreamID][]MessageStreamID {
 	value, err := reader.WithError.GetStreamRoutes(key, defaultValue)
-	reader.Errors.Push(err)
+	reader.pushError(key, err)
 	return value
 }
 
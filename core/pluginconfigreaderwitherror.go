@@ -21,6 +21,7 @@ import (
 	"github.com/trivago/tgo/tcontainer"
 	"github.com/trivago/tgo/tstrings"
 	"net/url"
+	"strconv"
 )
 
 // PluginConfigReaderWithError is a read-only wrapper on top of a plugin config
@@ -130,6 +131,9 @@ func (reader PluginConfigReaderWithError) GetUint(key string, defaultValue uint6
 func (reader PluginConfigReaderWithError) GetFloat(key string, defaultValue float64) (float64, error) {
 	key = reader.config.registerKey(key)
 	if reader.HasValue(key) {
+		if strVal, err := reader.config.Settings.String(key); err == nil {
+			return strconv.ParseFloat(strVal, 64) // Allow string to number conversion
+		}
 		return reader.config.Settings.Float(key)
 	}
 	return defaultValue, nil
@@ -140,6 +144,9 @@ func (reader PluginConfigReaderWithError) GetFloat(key string, defaultValue floa
 func (reader PluginConfigReaderWithError) GetBool(key string, defaultValue bool) (bool, error) {
 	key = reader.config.registerKey(key)
 	if reader.HasValue(key) {
+		if strVal, err := reader.config.Settings.String(key); err == nil {
+			return strconv.ParseBool(strVal) // Allow string to bool conversion
+		}
 		return reader.config.Settings.Bool(key)
 	}
 	return defaultValue, nil
@@ -43,6 +43,14 @@ func (registry *pluginRegistry) RegisterUnique(plugin Plugin, ID string) bool {
 	return false
 }
 
+// Unregister removes a plugin by its ID. Unknown IDs are ignored.
+// The ID is free to be used by RegisterUnique again after this call.
+func (registry *pluginRegistry) Unregister(ID string) {
+	registry.guard.Lock()
+	delete(registry.plugins, ID)
+	registry.guard.Unlock()
+}
+
 // GetPlugin returns a plugin by name or nil if not found.
 func (registry *pluginRegistry) GetPlugin(ID string) Plugin {
 	registry.guard.RLock()
@@ -55,6 +63,17 @@ func (registry *pluginRegistry) GetPlugin(ID string) Plugin {
 	return nil
 }
 
+// ForEachPlugin calls the given function for all registered plugins.
+// Plugins must not be registered or unregistered from within the callback.
+func (registry *pluginRegistry) ForEachPlugin(callback func(ID string, plugin Plugin)) {
+	registry.guard.RLock()
+	defer registry.guard.RUnlock()
+
+	for ID, plugin := range registry.plugins {
+		callback(ID, plugin)
+	}
+}
+
 // GetPluginWithState returns a plugin by name if it has a state or nil.
 func (registry *pluginRegistry) GetPluginWithState(ID string) PluginWithState {
 	plugin := registry.GetPlugin(ID)
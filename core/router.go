@@ -33,6 +33,10 @@ type Router interface {
 	// listening to messages on this stream.
 	AddProducer(producers ...Producer)
 
+	// RemoveProducer removes one or more producers from this stream. Producers
+	// not listening to this stream are ignored.
+	RemoveProducer(producers ...Producer)
+
 	// Enqueue sends a given message to all registered end points.
 	// This function is called by Route() which should be preferred over this
 	// function when sending messages.
@@ -49,10 +53,20 @@ type Router interface {
 // handles redirections enforced by formatters.
 func Route(msg *Message, router Router) error {
 	if router == nil {
+		msg.Nack()
 		DiscardMessage(msg, "nil", fmt.Sprintf("Router for stream %s is nil", msg.GetStreamID().GetName()))
 		return nil
 	}
 
+	span := StartMessageSpan(msg, router.GetID(), "route", SpanKindInternal)
+	err := routeWithModulate(msg, router)
+	span.Finish(err != nil)
+	return err
+}
+
+// routeWithModulate applies the router's modulators and enqueues the message
+// to the router or the stream set by the modulators.
+func routeWithModulate(msg *Message, router Router) error {
 	action := router.Modulate(msg)
 	streamName := msg.GetStreamID().GetName()
 
@@ -67,12 +81,17 @@ func Route(msg *Message, router Router) error {
 		GetStreamMetric(msg.streamID).Routed.Inc(1)
 		MessageTrace(msg, router.GetID(), "Routed")
 
-		return router.Enqueue(msg)
+		if err := router.Enqueue(msg); err != nil {
+			msg.Nack()
+			return err
+		}
+		return nil
 
 	case ModulateResultFallback:
 		if msg.GetStreamID() == router.GetStreamID() {
 
 			prevStreamName := StreamRegistry.GetStreamName(msg.GetPrevStreamID())
+			msg.Nack()
 			return NewModulateResultError("Routing loop detected for router %s (from %s)", streamName, prevStreamName)
 		}
 
@@ -84,18 +103,23 @@ func Route(msg *Message, router Router) error {
 		return Route(msg, msg.GetRouter())
 	}
 
+	msg.Nack()
 	return NewModulateResultError("Unknown ModulateResult action: %d", action)
 }
 
 // RouteOriginal restores the original message and routes it by using a
-// a given router.
+// a given router. The given message is acknowledged as its acknowledgement
+// is passed on to the restored copy.
 func RouteOriginal(msg *Message, router Router) error {
-	return Route(msg.CloneOriginal(), router)
+	orig := msg.CloneOriginal()
+	msg.Ack()
+	return Route(orig, router)
 }
 
 // DiscardMessage increases the discard statistic and discards the given
-// message.
+// message. Discarded messages are acknowledged as processed.
 func DiscardMessage(msg *Message, pluginID string, comment string) {
+	msg.Ack()
 	GetStreamMetric(msg.GetStreamID()).Discarded.Inc(1)
 	MessageTrace(msg, pluginID, comment)
 }
@@ -15,7 +15,7 @@
 package core
 
 import (
-	//	"sync"
+	"sync"
 	"testing"
 	"time"
 
@@ -42,12 +42,13 @@ func getMockRouter() mockRouter {
 	timeout := time.Second
 	return mockRouter{
 		SimpleRouter: SimpleRouter{
-			id:        "testStream",
-			filters:   FilterArray{},
-			Producers: []Producer{},
-			timeout:   timeout,
-			streamID:  StreamRegistry.GetStreamID("testStream"),
-			Logger:    logrus.WithField("Scope", "testStreamLogScope"),
+			id:            "testStream",
+			filters:       FilterArray{},
+			Producers:     []Producer{},
+			producerGuard: new(sync.RWMutex),
+			timeout:       timeout,
+			streamID:      StreamRegistry.GetStreamID("testStream"),
+			Logger:        logrus.WithField("Scope", "testStreamLogScope"),
 		},
 	}
 }
@@ -147,3 +148,24 @@ func TestRouteOriginal(t *testing.T) {
 	expect.Equal("foo", mockB.lastMessageData)
 
 }
+
+func TestRouteAcknowledge(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	result := true
+	msg := NewMessage(nil, []byte("foo"), nil, InvalidStreamID)
+	msg.SetAcknowledge(func(success bool) {
+		result = success
+	})
+
+	expect.NoError(Route(msg, nil))
+	expect.False(result)
+
+	msg = NewMessage(nil, []byte("foo"), nil, InvalidStreamID)
+	msg.SetAcknowledge(func(success bool) {
+		result = success
+	})
+
+	DiscardMessage(msg, "test", "discarded on purpose")
+	expect.True(result)
+}
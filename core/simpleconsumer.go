@@ -114,7 +114,7 @@ func (cons *SimpleConsumer) GetLogger() logrus.FieldLogger {
 // AddHealthCheckAt adds a health check at a subpath
 // (http://<addr>:<port>/<plugin_id><path>)
 func (cons *SimpleConsumer) AddHealthCheckAt(path string, callback thealthcheck.CallbackFunc) {
-	thealthcheck.AddEndpoint("/"+cons.GetID()+path, callback)
+	AddHealthCheckEndpoint("/"+cons.GetID()+path, callback)
 }
 
 // GetID returns the ID of this consumer
@@ -122,6 +122,20 @@ func (cons *SimpleConsumer) GetID() string {
 	return cons.id
 }
 
+// Streams returns the streams this consumer is sending to.
+func (cons *SimpleConsumer) Streams() []MessageStreamID {
+	streams := make([]MessageStreamID, 0, len(cons.routers))
+	for _, router := range cons.routers {
+		streams = append(streams, router.GetStreamID())
+	}
+	return streams
+}
+
+// GetModulators returns the modulators applied by this consumer
+func (cons *SimpleConsumer) GetModulators() ModulatorArray {
+	return cons.modulators
+}
+
 // GetShutdownTimeout returns the duration gollum will wait for this producer
 // before canceling the shutdown process.
 func (cons *SimpleConsumer) GetShutdownTimeout() time.Duration {
@@ -150,6 +164,11 @@ func (cons *SimpleConsumer) IsActive() bool {
 	return cons.GetState() <= PluginStatePrepareStop
 }
 
+// IsPaused returns true if the plugin has been paused by PluginControlPause
+func (cons *SimpleConsumer) IsPaused() bool {
+	return cons.runState.IsPaused()
+}
+
 // IsStopping returns true if GetState() returns prepareStop, stopping or dead
 func (cons *SimpleConsumer) IsStopping() bool {
 	return cons.GetState() >= PluginStatePrepareStop
@@ -206,10 +225,29 @@ func (cons *SimpleConsumer) Enqueue(data []byte) {
 
 // EnqueueWithMetadata works like EnqueueWithSequence and allows to set meta data directly
 func (cons *SimpleConsumer) EnqueueWithMetadata(data []byte, metaData tcontainer.MarshalMap) {
+	cons.runState.WaitWhilePaused()
 	msg := NewMessage(cons, data, metaData, InvalidStreamID)
 	cons.enqueueMessage(msg)
 }
 
+// EnqueueWithAcknowledge works like EnqueueWithMetadata and registers a
+// callback that is called once the message has been processed by all
+// producers. See Message.SetAcknowledge.
+func (cons *SimpleConsumer) EnqueueWithAcknowledge(data []byte, metaData tcontainer.MarshalMap, onAck AcknowledgeFunc) {
+	cons.runState.WaitWhilePaused()
+	msg := NewMessage(cons, data, metaData, InvalidStreamID)
+	msg.SetAcknowledge(onAck)
+	cons.enqueueMessage(msg)
+}
+
+// EnqueueToStream works like EnqueueWithMetadata but sends the message to
+// the given stream instead of the streams configured for this consumer.
+func (cons *SimpleConsumer) EnqueueToStream(streamID MessageStreamID, data []byte, metaData tcontainer.MarshalMap) {
+	cons.runState.WaitWhilePaused()
+	msg := NewMessage(cons, data, metaData, streamID)
+	cons.enqueueMessage(msg)
+}
+
 func (cons *SimpleConsumer) parallelEnqueue(msg *Message) {
 	cons.modulatorQueue.Push(msg, 0)
 }
@@ -223,6 +261,18 @@ loop:
 }
 
 func (cons *SimpleConsumer) directEnqueue(msg *Message) {
+	// Messages created with a stream are only sent to that stream
+	routers := cons.routers
+	if streamID := msg.GetStreamID(); streamID != InvalidStreamID {
+		routers = []Router{StreamRegistry.GetRouterOrFallback(streamID)}
+	}
+
+	if getMessageSpans() != nil {
+		cons.continueTrace(msg)
+		span := StartMessageSpan(msg, cons.GetID(), "consume", SpanKindConsumer)
+		defer span.Finish(false)
+	}
+
 	// Execute configured modulators
 	switch cons.modulators.Modulate(msg) {
 	case ModulateResultDiscard:
@@ -241,11 +291,11 @@ func (cons *SimpleConsumer) directEnqueue(msg *Message) {
 
 	// Send message to all routers registered to this consumer
 	// Last message will not be cloned.
-	numRouters := len(cons.routers)
+	numRouters := len(routers)
 	lastStreamIdx := numRouters - 1
 
 	for streamIdx := 0; streamIdx < lastStreamIdx; streamIdx++ {
-		router := cons.routers[streamIdx]
+		router := routers[streamIdx]
 		msgClone := msg.Clone()
 		msgClone.SetlStreamIDAsOriginal(router.GetStreamID())
 
@@ -254,7 +304,7 @@ func (cons *SimpleConsumer) directEnqueue(msg *Message) {
 		}
 	}
 
-	router := cons.routers[lastStreamIdx]
+	router := routers[lastStreamIdx]
 	msg.SetlStreamIDAsOriginal(router.GetStreamID())
 
 	if err := Route(msg, router); err != nil {
@@ -262,6 +312,23 @@ func (cons *SimpleConsumer) directEnqueue(msg *Message) {
 	}
 }
 
+// continueTrace uses the traceparent metadata field set by a consumer as the
+// parent of all spans created for this message.
+func (cons *SimpleConsumer) continueTrace(msg *Message) {
+	metadata := msg.TryGetMetadata()
+	if metadata == nil || msg.GetTraceContext().IsValid() {
+		return // ### return, nothing to continue ###
+	}
+
+	if traceParent, err := metadata.String(TraceParentMetadataKey); err == nil {
+		if ctx, err := ParseTraceParent(traceParent); err == nil {
+			msg.SetTraceContext(ctx)
+		} else {
+			cons.Logger.WithError(err).Debug("Ignoring invalid traceparent")
+		}
+	}
+}
+
 // ControlLoop listens to the control channel and triggers callbacks for these
 // messages. Upon stop control message doExit will be set to true.
 func (cons *SimpleConsumer) ControlLoop() {
@@ -278,6 +345,7 @@ func (cons *SimpleConsumer) ControlLoop() {
 
 		case PluginControlStopConsumer:
 			cons.Logger.Debug("Preparing for stop")
+			cons.runState.Resume()
 			cons.setState(PluginStatePrepareStop)
 
 			if cons.onPrepareStop != nil {
@@ -305,6 +373,14 @@ func (cons *SimpleConsumer) ControlLoop() {
 			if cons.onRoll != nil {
 				cons.onRoll()
 			}
+
+		case PluginControlPause:
+			cons.Logger.Info("Pausing")
+			cons.runState.Pause()
+
+		case PluginControlResume:
+			cons.Logger.Info("Resuming")
+			cons.runState.Resume()
 		}
 	}
 }
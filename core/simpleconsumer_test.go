@@ -135,3 +135,27 @@ func TestSimpleConsumerStateMethods(t *testing.T) {
 	expect.True(mockSimpleConsumer.IsActiveOrStopping())
 	expect.True(mockSimpleConsumer.IsStopping())
 }
+
+func TestSimpleConsumerEnqueueToStream(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	boundRouter := getMockRouterMessageHelper("testEnqueueBound")
+	StreamRegistry.Register(&boundRouter, boundRouter.GetStreamID())
+	targetRouter := getMockRouterMessageHelper("testEnqueueTarget")
+	StreamRegistry.Register(&targetRouter, targetRouter.GetStreamID())
+
+	mockConf := NewPluginConfig("mockSimpleConsumerEnqueueToStream", "mockSimpleConsumer")
+	mockConf.Override("Streams", []string{"testEnqueueBound"})
+
+	mockSimpleConsumer, err := getSimpleConsumer(mockConf)
+	expect.NoError(err)
+
+	mockSimpleConsumer.EnqueueToStream(targetRouter.GetStreamID(), []byte("target"), nil)
+	expect.True(targetRouter.messageEnqued)
+	expect.False(boundRouter.messageEnqued)
+	expect.Equal("target", targetRouter.lastMessageData)
+
+	mockSimpleConsumer.Enqueue([]byte("bound"))
+	expect.True(boundRouter.messageEnqued)
+	expect.Equal("bound", boundRouter.lastMessageData)
+}
@@ -51,6 +51,12 @@ func (filter *SimpleFilter) GetLogger() logrus.FieldLogger {
 	return filter.Logger
 }
 
+// GetFilteredStreamID returns the stream filtered messages are sent to.
+// InvalidStreamID is returned if filtered messages are discarded.
+func (filter *SimpleFilter) GetFilteredStreamID() MessageStreamID {
+	return filter.filteredStreamID
+}
+
 // GetFilterResultMessageReject returns a FilterResultMessageReject with the
 // stream set to GetfilteredStreamID()
 func (filter *SimpleFilter) GetFilterResultMessageReject() FilterResult {
@@ -43,6 +43,12 @@ import (
 // Setting this paramater to "" will cause messages to be discared when delivery
 // fails.
 //
+// - FallbackMetadata: When set to true, information about the failed delivery
+// is attached to the metadata of messages routed to the FallbackStream.
+// Messages can be replayed by e.g. writing them with producer.Spooling and
+// reading them with consumer.Replay.
+// By default this parameter is set to "false".
+//
 // - ShutdownTimeoutMs: Defines the maximum time in milliseconds a producer is
 // allowed to take to shut down. After this timeout the producer is always
 // considered to have shut down.  Decreasing this value may lead to lost
@@ -50,8 +56,26 @@ import (
 //
 // - Modulators: Defines a list of modulators to be applied to a message when
 // it arrives at this producer. If a modulator changes the stream of a message
-// the message is NOT routed to this stream anymore.
+// the message is NOT routed to this stream anymore. Modulators routing new
+// messages, e.g. format.JSONSplit, cannot be used by producers.
 // By default this parameter is set to an empty list.
+//
+// Metadata
+//
+// The following metadata fields are set on messages sent to the fallback if
+// FallbackMetadata is set to true.
+//
+// - deadletter_producer: The ID of the producer that failed.
+//
+// - deadletter_error: The error reported by the producer.
+//
+// - deadletter_attempts: The number of failed delivery attempts. The counter
+// is increased every time a message is sent to a fallback.
+//
+// - deadletter_time: The time of the failure in RFC3339 format.
+//
+// - deadletter_stream: The name of the stream the producer received the
+// message from.
 type SimpleProducer struct {
 	id              string
 	control         chan PluginControl
@@ -59,10 +83,12 @@ type SimpleProducer struct {
 	streams         []MessageStreamID `config:"Streams"`
 	modulators      ModulatorArray    `config:"Modulators"`
 	fallbackStream  Router            `config:"FallbackStream" default:""`
+	fallbackMeta    bool              `config:"FallbackMetadata" default:"false"`
 	shutdownTimeout time.Duration     `config:"ShutdownTimeoutMs" default:"1000" metric:"ms"`
 	onRoll          func()
 	onPrepareStop   func()
 	onStop          func()
+	manualAck       bool
 	Logger          logrus.FieldLogger
 }
 
@@ -73,6 +99,10 @@ func (prod *SimpleProducer) Configure(conf PluginConfigReader) {
 	prod.runState = NewPluginRunState()
 	prod.control = make(chan PluginControl, 1)
 
+	for _, name := range prod.modulators.getRoutingModulators() {
+		conf.Errors.Pushf("%s routes messages and can only be used by consumers and routers", name)
+	}
+
 	// Simple health check for the plugin state
 	//   Path: "/<plugin_id>/pluginState"
 	prod.AddHealthCheckAt("/pluginState", func() (code int, body string) {
@@ -97,7 +127,7 @@ func (prod *SimpleProducer) AddHealthCheck(callback thealthcheck.CallbackFunc) {
 
 // AddHealthCheckAt adds a health check at a subpath (http://<addr>:<port>/<plugin_id><path>)
 func (prod *SimpleProducer) AddHealthCheckAt(path string, callback thealthcheck.CallbackFunc) {
-	thealthcheck.AddEndpoint("/"+prod.GetID()+path, callback)
+	AddHealthCheckEndpoint("/"+prod.GetID()+path, callback)
 }
 
 // GetID returns the ID of this producer
@@ -110,6 +140,20 @@ func (prod *SimpleProducer) Streams() []MessageStreamID {
 	return prod.streams
 }
 
+// GetFallbackStreamID returns the stream messages are routed to if delivery
+// fails. InvalidStreamID is returned if no fallback stream is set.
+func (prod *SimpleProducer) GetFallbackStreamID() MessageStreamID {
+	if prod.fallbackStream == nil {
+		return InvalidStreamID
+	}
+	return prod.fallbackStream.GetStreamID()
+}
+
+// GetModulators returns the modulators applied by this producer
+func (prod *SimpleProducer) GetModulators() ModulatorArray {
+	return prod.modulators
+}
+
 // Control returns write access to this producer's control channel.
 // See PluginControl* constants.
 func (prod *SimpleProducer) Control() chan<- PluginControl {
@@ -132,6 +176,11 @@ func (prod *SimpleProducer) IsActive() bool {
 	return prod.GetState() <= PluginStatePrepareStop
 }
 
+// IsPaused returns true if the plugin has been paused by PluginControlPause
+func (prod *SimpleProducer) IsPaused() bool {
+	return prod.runState.IsPaused()
+}
+
 // IsStopping returns true if GetState() returns prepareStop, stopping or dead
 func (prod *SimpleProducer) IsStopping() bool {
 	return prod.GetState() >= PluginStatePrepareStop
@@ -206,6 +255,7 @@ func (prod *SimpleProducer) HasContinueAfterModulate(msg *Message) bool {
 		return false
 
 	case ModulateResultFallback:
+		msg.endSpans(false)
 		if err := Route(msg, msg.GetRouter()); err != nil {
 			prod.Logger.WithError(err).Error("Failed to route to fallback")
 		}
@@ -221,9 +271,48 @@ func (prod *SimpleProducer) HasContinueAfterModulate(msg *Message) bool {
 	}
 }
 
+// EnableManualAcknowledge disables the automatic acknowledgement of messages
+// after they have been passed to the producer's message handler. Producers
+// calling this function have to call Message.Ack or Message.Nack as soon as
+// a message has been delivered or failed to be delivered. Messages flushed
+// by a MessageBatch are acknowledged by the batch.
+func (prod *SimpleProducer) EnableManualAcknowledge() {
+	prod.manualAck = true
+}
+
+// ackHandled acknowledges a message that has been passed to the producer's
+// message handler unless manual acknowledgement is enabled.
+func (prod *SimpleProducer) ackHandled(msg *Message) {
+	if !prod.manualAck {
+		msg.Ack()
+	}
+}
+
 // TryFallback routes the message to the configured fallback stream.
+// The message is acknowledged as processed if it could be routed.
 func (prod *SimpleProducer) TryFallback(msg *Message) {
-	if err := RouteOriginal(msg, prod.fallbackStream); err != nil {
+	prod.TryFallbackWithError(msg, nil)
+}
+
+// TryFallbackWithError routes the message to the configured fallback stream.
+// If FallbackMetadata is enabled, the given error is attached to the message
+// metadata. The message is acknowledged as processed if it could be routed.
+func (prod *SimpleProducer) TryFallbackWithError(msg *Message, err error) {
+	prod.TryFallbackToRouter(msg, prod.fallbackStream, err)
+}
+
+// TryFallbackToRouter works like TryFallbackWithError but routes the message
+// to the given router instead of the configured fallback stream.
+func (prod *SimpleProducer) TryFallbackToRouter(msg *Message, router Router, err error) {
+	msg.endSpans(false)
+	streamID := msg.GetStreamID()
+	orig := msg.CloneOriginal()
+	msg.Ack()
+
+	if prod.fallbackMeta {
+		SetDeadLetterMetadata(orig, prod.id, streamID, err)
+	}
+	if err := Route(orig, router); err != nil {
 		prod.Logger.WithError(err).Error("Failed to route to fallback")
 	}
 }
@@ -244,6 +333,7 @@ func (prod *SimpleProducer) ControlLoop() {
 
 		case PluginControlStopProducer:
 			prod.Logger.Debug("Preparing for stop")
+			prod.runState.Resume()
 			prod.setState(PluginStatePrepareStop)
 
 			if prod.onPrepareStop != nil {
@@ -267,6 +357,14 @@ func (prod *SimpleProducer) ControlLoop() {
 			if prod.onRoll != nil {
 				prod.onRoll()
 			}
+
+		case PluginControlPause:
+			prod.Logger.Info("Pausing")
+			prod.runState.Pause()
+
+		case PluginControlResume:
+			prod.Logger.Info("Resuming")
+			prod.runState.Resume()
 		}
 	}
 }
@@ -18,6 +18,7 @@ import (
 	"github.com/sirupsen/logrus"
 	"github.com/trivago/tgo/thealthcheck"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -39,18 +40,20 @@ import (
 // By default this parameter is set to "0".
 //
 type SimpleRouter struct {
-	id        string
-	Producers []Producer
-	filters   FilterArray     `config:"Filters"`
-	timeout   time.Duration   `config:"TimeoutMs" default:"0" metric:"ms"`
-	streamID  MessageStreamID `config:"Stream"`
-	Logger    logrus.FieldLogger
+	id            string
+	Producers     []Producer
+	producerGuard *sync.RWMutex
+	filters       FilterArray     `config:"Filters"`
+	timeout       time.Duration   `config:"TimeoutMs" default:"0" metric:"ms"`
+	streamID      MessageStreamID `config:"Stream"`
+	Logger        logrus.FieldLogger
 }
 
 // Configure sets up all values required by SimpleRouter.
 func (router *SimpleRouter) Configure(conf PluginConfigReader) {
 	router.id = conf.GetID()
 	router.Logger = conf.GetLogger()
+	router.producerGuard = new(sync.RWMutex)
 
 	if router.streamID == WildcardStreamID && strings.Index(router.id, GeneratedRouterPrefix) != 0 {
 		router.Logger.Info("A wildcard stream configuration only affects the wildcard stream, not all routers")
@@ -69,7 +72,7 @@ func (router *SimpleRouter) AddHealthCheck(callback thealthcheck.CallbackFunc) {
 
 // AddHealthCheckAt adds a health check at a subpath (http://<addr>:<port>/<plugin_id><path>)
 func (router *SimpleRouter) AddHealthCheckAt(path string, callback thealthcheck.CallbackFunc) {
-	thealthcheck.AddEndpoint("/"+router.GetID()+path, callback)
+	AddHealthCheckEndpoint("/"+router.GetID()+path, callback)
 }
 
 // GetID returns the ID of this router
@@ -90,21 +93,53 @@ func (router *SimpleRouter) GetTimeout() time.Duration {
 // AddProducer adds all producers to the list of known producers.
 // Duplicates will be filtered.
 func (router *SimpleRouter) AddProducer(producers ...Producer) {
+	router.producerGuard.Lock()
+	defer router.producerGuard.Unlock()
+
+nextProd:
 	for _, prod := range producers {
 		for _, inListProd := range router.Producers {
 			if inListProd == prod {
-				return // ### return, already in list ###
+				continue nextProd // ### continue, already in list ###
 			}
 		}
 		router.Producers = append(router.Producers, prod)
 	}
 }
 
-// GetProducers returns the producers bound to this stream
+// RemoveProducer removes all given producers from the list of known producers.
+// Producers not in the list are ignored.
+func (router *SimpleRouter) RemoveProducer(producers ...Producer) {
+	router.producerGuard.Lock()
+	defer router.producerGuard.Unlock()
+
+	remaining := make([]Producer, 0, len(router.Producers))
+nextProd:
+	for _, inListProd := range router.Producers {
+		for _, prod := range producers {
+			if inListProd == prod {
+				continue nextProd // ### continue, removed ###
+			}
+		}
+		remaining = append(remaining, inListProd)
+	}
+	router.Producers = remaining
+}
+
+// GetProducers returns the producers bound to this stream. The returned list
+// is not modified by AddProducer or RemoveProducer and can be used without
+// further locking.
 func (router *SimpleRouter) GetProducers() []Producer {
+	router.producerGuard.RLock()
+	defer router.producerGuard.RUnlock()
 	return router.Producers
 }
 
+// GetFilters returns the filters applied by this router
+func (router *SimpleRouter) GetFilters() FilterArray {
+	return router.filters
+}
+
 // Modulate calls all modulators in their order of definition
 func (router *SimpleRouter) Modulate(msg *Message) ModulateResult {
 	mod := NewFilterModulator(router.filters)
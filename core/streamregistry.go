@@ -16,6 +16,7 @@ package core
 
 import (
 	"hash/fnv"
+	"strings"
 	"sync"
 
 	"github.com/sirupsen/logrus"
@@ -29,20 +30,22 @@ var (
 // streamRegistry holds routers mapped by their MessageStreamID as well as a
 // reverse lookup of MessageStreamID to stream name.
 type streamRegistry struct {
-	routers     map[MessageStreamID]Router
-	name        map[MessageStreamID]string
-	nameGuard   *sync.RWMutex
-	streamGuard *sync.RWMutex
-	wildcard    []Producer
+	routers       map[MessageStreamID]Router
+	name          map[MessageStreamID]string
+	nameGuard     *sync.RWMutex
+	streamGuard   *sync.RWMutex
+	wildcardGuard *sync.RWMutex
+	wildcard      []Producer
 }
 
 // StreamRegistry is the global instance of streamRegistry used to store the
 // all registered routers.
 var StreamRegistry = streamRegistry{
-	routers:     make(map[MessageStreamID]Router),
-	streamGuard: new(sync.RWMutex),
-	name:        make(map[MessageStreamID]string),
-	nameGuard:   new(sync.RWMutex),
+	routers:       make(map[MessageStreamID]Router),
+	streamGuard:   new(sync.RWMutex),
+	name:          make(map[MessageStreamID]string),
+	nameGuard:     new(sync.RWMutex),
+	wildcardGuard: new(sync.RWMutex),
 }
 
 // GetStreamID is deprecated
@@ -137,6 +140,8 @@ func (registry streamRegistry) ForEachStream(callback func(streamID MessageStrea
 // WildcardProducersExist returns true if any producer is listening to the
 // wildcard stream.
 func (registry *streamRegistry) WildcardProducersExist() bool {
+	registry.wildcardGuard.RLock()
+	defer registry.wildcardGuard.RUnlock()
 	return len(registry.wildcard) > 0
 }
 
@@ -146,6 +151,9 @@ func (registry *streamRegistry) WildcardProducersExist() bool {
 // Duplicates will be filtered.
 // This state of this list is undefined during the configuration phase.
 func (registry *streamRegistry) RegisterWildcardProducer(producers ...Producer) {
+	registry.wildcardGuard.Lock()
+	defer registry.wildcardGuard.Unlock()
+
 nextProd:
 	for _, prod := range producers {
 		for _, existing := range registry.wildcard {
@@ -157,13 +165,39 @@ nextProd:
 	}
 }
 
+// RemoveProducer removes the given producers from the list of wildcard
+// producers as well as from all registered routers.
+func (registry *streamRegistry) RemoveProducer(producers ...Producer) {
+	registry.wildcardGuard.Lock()
+	remaining := make([]Producer, 0, len(registry.wildcard))
+nextProd:
+	for _, existing := range registry.wildcard {
+		for _, prod := range producers {
+			if existing == prod {
+				continue nextProd
+			}
+		}
+		remaining = append(remaining, existing)
+	}
+	registry.wildcard = remaining
+	registry.wildcardGuard.Unlock()
+
+	registry.ForEachStream(
+		func(streamID MessageStreamID, router Router) {
+			router.RemoveProducer(producers...)
+		})
+}
+
 // AddWildcardProducersToRouter adds all known wildcard producers to a given
 // router. The state of the wildcard list is undefined during the configuration
 // phase.
 func (registry streamRegistry) AddWildcardProducersToRouter(router Router) {
 	streamID := router.GetStreamID()
 	if streamID != LogInternalStreamID {
-		router.AddProducer(registry.wildcard...)
+		registry.wildcardGuard.RLock()
+		wildcard := registry.wildcard
+		registry.wildcardGuard.RUnlock()
+		router.AddProducer(wildcard...)
 	}
 }
 
@@ -197,6 +231,22 @@ func (registry *streamRegistry) Register(router Router, streamID MessageStreamID
 	}
 }
 
+// Unregister removes the router registered to the given stream id.
+// Messages sent to this stream afterwards will use a fallback router unless a
+// new router is registered.
+func (registry *streamRegistry) Unregister(streamID MessageStreamID) {
+	registry.streamGuard.Lock()
+	defer registry.streamGuard.Unlock()
+
+	if router, exists := registry.routers[streamID]; exists {
+		delete(registry.routers, streamID)
+		MetricRouters.Dec(1)
+		if strings.HasPrefix(router.GetID(), GeneratedRouterPrefix) {
+			MetricFallbackRouters.Dec(1)
+		}
+	}
+}
+
 // GetRouterOrFallback returns the router for the given streamID if it is registered.
 // If no router is registered for the given streamID the default router is used.
 // The default router is equivalent to an unconfigured router.Broadcast with
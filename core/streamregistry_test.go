@@ -22,11 +22,12 @@ import (
 
 func getMockStreamRegistry() streamRegistry {
 	return streamRegistry{
-		routers:     map[MessageStreamID]Router{},
-		name:        map[MessageStreamID]string{},
-		streamGuard: new(sync.RWMutex),
-		nameGuard:   new(sync.RWMutex),
-		wildcard:    []Producer{},
+		routers:       map[MessageStreamID]Router{},
+		name:          map[MessageStreamID]string{},
+		streamGuard:   new(sync.RWMutex),
+		nameGuard:     new(sync.RWMutex),
+		wildcardGuard: new(sync.RWMutex),
+		wildcard:      []Producer{},
 	}
 }
 
@@ -118,6 +119,29 @@ func TestStreamRegistryAddWildcardProducersToStream(t *testing.T) {
 
 }
 
+func TestStreamRegistryRemoveProducer(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+	mockSRegistry := getMockStreamRegistry()
+
+	mockRouter := getMockRouter()
+	mockSRegistry.Register(&mockRouter, mockRouter.GetStreamID())
+
+	producer1 := new(mockBufferedProducer)
+	producer2 := new(mockBufferedProducer)
+
+	mockSRegistry.RegisterWildcardProducer(producer1, producer2)
+	mockSRegistry.AddWildcardProducersToRouter(&mockRouter)
+	expect.Equal(2, len(mockRouter.GetProducers()))
+
+	mockSRegistry.RemoveProducer(producer1)
+	expect.Equal(1, len(mockSRegistry.wildcard))
+	expect.Equal(1, len(mockRouter.GetProducers()))
+	expect.Equal(producer2, mockRouter.GetProducers()[0])
+
+	mockSRegistry.Unregister(mockRouter.GetStreamID())
+	expect.False(mockSRegistry.IsStreamRegistered(mockRouter.GetStreamID()))
+}
+
 func TestStreamRegistryRegister(t *testing.T) {
 	expect := ttesting.NewExpect(t)
 	mockSRegistry := getMockStreamRegistry()
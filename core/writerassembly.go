@@ -27,6 +27,7 @@ type WriterAssembly struct {
 	flush       func(*Message)
 	modulator   Modulator
 	buffer      []byte
+	delimiter   []byte
 	validate    func() bool
 	handleError func(error) bool
 	writerGuard *sync.Mutex
@@ -55,6 +56,11 @@ func (asm *WriterAssembly) SetErrorHandler(handleError func(error) bool) {
 	asm.handleError = handleError
 }
 
+// SetDelimiter sets a byte sequence that is written between two messages.
+func (asm *WriterAssembly) SetDelimiter(delimiter []byte) {
+	asm.delimiter = delimiter
+}
+
 // SetWriter changes the writer interface used during Assemble
 func (asm *WriterAssembly) SetWriter(writer io.Writer) {
 	asm.writerGuard.Lock()
@@ -88,7 +94,11 @@ func (asm *WriterAssembly) Write(messages []*Message) {
 
 	// Format all messages
 	contentLen := 0
-	for _, msg := range messages {
+	for idx, msg := range messages {
+		if idx > 0 && len(asm.delimiter) > 0 {
+			asm.buffer = append(asm.buffer[:contentLen], asm.delimiter...)
+			contentLen += len(asm.delimiter)
+		}
 		if contentLen+len(msg.GetPayload()) > len(asm.buffer) {
 			asm.buffer = append(asm.buffer[:contentLen], msg.GetPayload()...)
 		} else {
@@ -122,3 +122,31 @@ func TestWriterAssemblyWrite(t *testing.T) {
 	wa.Write([]*Message{msg1})
 
 }
+
+type recordingIoWrite struct {
+	data []byte
+}
+
+func (iw *recordingIoWrite) Write(data []byte) (n int, err error) {
+	iw.data = append(iw.data[:0], data...)
+	return len(data), nil
+}
+
+func TestWriterAssemblyDelimiter(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+	writer := &recordingIoWrite{}
+	wa := NewWriterAssembly(writer, nil, &mockFormatter{})
+	wa.SetDelimiter([]byte(",\n"))
+
+	msg1 := NewMessage(nil, []byte("abc"), nil, InvalidStreamID)
+	msg2 := NewMessage(nil, []byte("de"), nil, InvalidStreamID)
+
+	wa.Write([]*Message{msg1})
+	expect.Equal("abc", string(writer.data))
+
+	wa.Write([]*Message{msg1, msg2, msg1})
+	expect.Equal("abc,\nde,\nabc", string(writer.data))
+
+	wa.Write([]*Message{msg2, msg2})
+	expect.Equal("de,\nde", string(writer.data))
+}
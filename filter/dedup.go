@@ -0,0 +1,271 @@
+// Copyright 2015-2018 trivago N.V.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filter
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/trivago/gollum/core"
+)
+
+const dedupSnapshotVersion = 1
+
+// Dedup filter plugin
+//
+// This plugin rejects messages that have already been seen within a given
+// time window, e.g. messages sent twice because of retries or because
+// multiple consumers read the same source. Messages are identified by a
+// SHA-256 fingerprint of the payload or of a set of metadata fields. The
+// stream of a message is not part of the fingerprint.
+//
+// Fingerprints are kept in memory. The window starts when a fingerprint is
+// seen for the first time, i.e. duplicates do not extend the window. The
+// number of rejected duplicates is reported per stream by the metric
+// "dedup.<stream>".
+//
+// Parameters
+//
+// - KeyFrom: Defines a list of metadata fields used to build the
+// fingerprint. If empty, the payload is used.
+// By default this parameter is set to an empty list.
+//
+// - WindowSec: Defines the number of seconds a fingerprint is remembered.
+// By default this parameter is set to "300".
+//
+// - MaxEntries: Defines the maximum number of fingerprints to remember. If
+// this number is exceeded, the oldest fingerprint is removed even if its
+// window has not passed yet. Set to 0 to not limit the number of fingerprints.
+// By default this parameter is set to "100000".
+//
+// - SnapshotPath: Defines a file the fingerprints are written to, so that
+// duplicates are detected across restarts. The file is written in the
+// interval given by SnapshotIntervalSec; fingerprints seen since the last
+// snapshot are lost on restart. If empty, no snapshot is written.
+// By default this parameter is set to "".
+//
+// - SnapshotIntervalSec: Defines the minimum number of seconds between two
+// snapshots.
+// By default this parameter is set to "10".
+//
+// Examples
+//
+// This example drops messages with the same "requestId" seen within the last
+// 10 minutes and keeps the state across restarts:
+//
+//  ExampleConsumer:
+//    Type: consumer.Kafka
+//    Streams: events
+//    Modulators:
+//      - filter.Dedup:
+//        KeyFrom:
+//          - requestId
+//        WindowSec: 600
+//        SnapshotPath: /var/lib/gollum/dedup.json
+type Dedup struct {
+	core.SimpleFilter `gollumdoc:"embed_type"`
+	guard             *sync.Mutex
+	seen              *lruCache
+	getters           []core.GetDataAsBytesFunc
+	metricDuplicates  map[core.MessageStreamID]metrics.Counter
+	metricsRegistry   metrics.Registry
+	lastSnapshot      time.Time
+	snapshotPending   *int32
+	keyFields         []string      `config:"KeyFrom"`
+	window            time.Duration `config:"WindowSec" default:"300" metric:"sec"`
+	maxEntries        int           `config:"MaxEntries" default:"100000"`
+	snapshotPath      string        `config:"SnapshotPath"`
+	snapshotInterval  time.Duration `config:"SnapshotIntervalSec" default:"10" metric:"sec"`
+}
+
+type dedupSnapshot struct {
+	Version int              `json:"version"`
+	Seen    map[string]int64 `json:"seen"`
+}
+
+func init() {
+	core.TypeRegistry.Register(Dedup{})
+}
+
+// Configure initializes this filter with values from a plugin config.
+func (filter *Dedup) Configure(conf core.PluginConfigReader) {
+	filter.guard = new(sync.Mutex)
+	filter.seen = newLRUCache(filter.maxEntries)
+	filter.metricDuplicates = make(map[core.MessageStreamID]metrics.Counter)
+	filter.metricsRegistry = core.NewMetricsRegistry("dedup")
+	filter.snapshotPending = new(int32)
+	filter.lastSnapshot = time.Now()
+
+	if filter.window <= 0 {
+		conf.Errors.Pushf("WindowSec must be greater than 0")
+	}
+
+	if len(filter.keyFields) == 0 {
+		filter.getters = []core.GetDataAsBytesFunc{core.NewBytesGetterFor("")}
+	}
+	for _, field := range filter.keyFields {
+		filter.getters = append(filter.getters, core.NewBytesGetterFor(field))
+	}
+
+	if filter.snapshotPath != "" {
+		conf.Errors.Push(filter.loadSnapshot())
+	}
+}
+
+// ApplyFilter check if all Filter wants to reject the message
+func (filter *Dedup) ApplyFilter(msg *core.Message) (core.FilterResult, error) {
+	fingerprint := filter.getFingerprint(msg)
+	now := time.Now()
+
+	filter.guard.Lock()
+	defer filter.guard.Unlock()
+
+	filter.expire(now)
+	// Duplicates must not be moved to the front as entries are expected to be
+	// ordered by the time they were first seen.
+	if value, known := filter.seen.peek(fingerprint); known && now.Sub(value.(time.Time)) < filter.window {
+		filter.getDuplicateMetric(msg.GetStreamID()).Inc(1)
+		return filter.GetFilterResultMessageReject(), nil // ### return, duplicate ###
+	}
+
+	filter.seen.add(fingerprint, now)
+	filter.snapshotIfDue(now)
+	return core.FilterResultMessageAccept, nil
+}
+
+// getFingerprint returns the hex encoded SHA-256 hash of all key fields.
+func (filter *Dedup) getFingerprint(msg *core.Message) string {
+	hash := sha256.New()
+	length := make([]byte, binary.MaxVarintLen64)
+	for _, get := range filter.getters {
+		data := get(msg)
+		// Prefix each field with its length so that field boundaries are
+		// part of the fingerprint.
+		hash.Write(length[:binary.PutUvarint(length, uint64(len(data)))])
+		hash.Write(data)
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// expire removes the oldest fingerprints whose window has passed.
+// This function has to be called while holding guard.
+func (filter *Dedup) expire(now time.Time) {
+	for {
+		fingerprint, value, exists := filter.seen.oldest()
+		if !exists || now.Sub(value.(time.Time)) < filter.window {
+			return // ### return, nothing left to expire ###
+		}
+		filter.seen.remove(fingerprint)
+	}
+}
+
+// getDuplicateMetric returns the counter of duplicates for a stream.
+// This function has to be called while holding guard.
+func (filter *Dedup) getDuplicateMetric(streamID core.MessageStreamID) metrics.Counter {
+	counter, known := filter.metricDuplicates[streamID]
+	if !known {
+		counter = metrics.NewCounter()
+		filter.metricDuplicates[streamID] = counter
+		filter.metricsRegistry.Register(core.StreamRegistry.GetStreamName(streamID), counter)
+	}
+	return counter
+}
+
+// snapshotIfDue writes a snapshot in the background if SnapshotPath is set
+// and the snapshot interval has passed. This function has to be called while
+// holding guard.
+func (filter *Dedup) snapshotIfDue(now time.Time) {
+	if filter.snapshotPath == "" || now.Sub(filter.lastSnapshot) < filter.snapshotInterval {
+		return // ### return, no snapshot required ###
+	}
+	if !atomic.CompareAndSwapInt32(filter.snapshotPending, 0, 1) {
+		return // ### return, snapshot still being written ###
+	}
+
+	filter.lastSnapshot = now
+	snapshot := filter.newSnapshot()
+	go func() {
+		defer atomic.StoreInt32(filter.snapshotPending, 0)
+		if err := filter.writeSnapshot(snapshot); err != nil {
+			filter.Logger.WithError(err).Errorf("Failed to write snapshot to %s", filter.snapshotPath)
+		}
+	}()
+}
+
+// newSnapshot copies all fingerprints to a snapshot.
+// This function has to be called while holding guard.
+func (filter *Dedup) newSnapshot() dedupSnapshot {
+	snapshot := dedupSnapshot{
+		Version: dedupSnapshotVersion,
+		Seen:    make(map[string]int64, filter.seen.len()),
+	}
+	for fingerprint, element := range filter.seen.items {
+		snapshot.Seen[fingerprint] = element.Value.(*lruEntry).value.(time.Time).UnixNano()
+	}
+	return snapshot
+}
+
+// writeSnapshot atomically writes the given snapshot to SnapshotPath.
+func (filter *Dedup) writeSnapshot(snapshot dedupSnapshot) error {
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	return core.WriteFileAtomic(filter.snapshotPath, data)
+}
+
+// loadSnapshot restores all fingerprints from SnapshotPath whose window has
+// not passed yet. A missing snapshot file is not an error.
+func (filter *Dedup) loadSnapshot() error {
+	data, err := ioutil.ReadFile(filter.snapshotPath)
+	if os.IsNotExist(err) {
+		return nil // ### return, nothing to restore ###
+	}
+	if err != nil {
+		return err
+	}
+
+	snapshot := dedupSnapshot{}
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return err
+	}
+
+	// Restore oldest fingerprints first to keep the LRU order
+	fingerprints := make([]string, 0, len(snapshot.Seen))
+	for fingerprint := range snapshot.Seen {
+		fingerprints = append(fingerprints, fingerprint)
+	}
+	sort.Slice(fingerprints, func(i, j int) bool {
+		return snapshot.Seen[fingerprints[i]] < snapshot.Seen[fingerprints[j]]
+	})
+
+	now := time.Now()
+	for _, fingerprint := range fingerprints {
+		seen := time.Unix(0, snapshot.Seen[fingerprint])
+		if now.Sub(seen) < filter.window {
+			filter.seen.add(fingerprint, seen)
+		}
+	}
+	return nil
+}
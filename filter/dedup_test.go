@@ -0,0 +1,139 @@
+// Copyright 2015-2018 trivago N.V.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filter
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/trivago/gollum/core"
+	"github.com/trivago/tgo/tcontainer"
+	"github.com/trivago/tgo/ttesting"
+)
+
+func TestFilterDedupPayload(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+	conf := core.NewPluginConfig("", "filter.Dedup")
+
+	plugin, err := core.NewPluginWithConfig(conf)
+	expect.NoError(err)
+
+	filter, casted := plugin.(*Dedup)
+	expect.True(casted)
+
+	result, _ := filter.ApplyFilter(core.NewMessage(nil, []byte("a"), nil, 1))
+	expect.Equal(core.FilterResultMessageAccept, result)
+	result, _ = filter.ApplyFilter(core.NewMessage(nil, []byte("b"), nil, 1))
+	expect.Equal(core.FilterResultMessageAccept, result)
+
+	// Duplicates are detected across streams
+	result, _ = filter.ApplyFilter(core.NewMessage(nil, []byte("a"), nil, 2))
+	expect.Neq(core.FilterResultMessageAccept, result)
+	expect.Equal(int64(1), filter.metricDuplicates[2].Count())
+
+	// Fingerprints are forgotten after the window has passed
+	filter.window = 20 * time.Millisecond
+	time.Sleep(30 * time.Millisecond)
+	result, _ = filter.ApplyFilter(core.NewMessage(nil, []byte("a"), nil, 1))
+	expect.Equal(core.FilterResultMessageAccept, result)
+	expect.Equal(1, filter.seen.len())
+
+	// Duplicates do not keep older fingerprints from expiring
+	msgA := core.NewMessage(nil, []byte("a"), nil, 1)
+	msgB := core.NewMessage(nil, []byte("b"), nil, 1)
+	now := time.Now()
+
+	filter.window = time.Hour
+	filter.seen = newLRUCache(0)
+	filter.seen.add(filter.getFingerprint(msgA), now.Add(-50*time.Minute))
+	filter.seen.add(filter.getFingerprint(msgB), now.Add(-10*time.Minute))
+
+	result, _ = filter.ApplyFilter(msgA)
+	expect.Neq(core.FilterResultMessageAccept, result)
+	filter.expire(now.Add(20 * time.Minute))
+	expect.Equal(1, filter.seen.len())
+}
+
+func TestFilterDedupKeyFrom(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+	conf := core.NewPluginConfig("", "filter.Dedup")
+
+	conf.Override("KeyFrom", []string{"a", "b"})
+	conf.Override("MaxEntries", 2)
+	plugin, err := core.NewPluginWithConfig(conf)
+	expect.NoError(err)
+
+	filter, casted := plugin.(*Dedup)
+	expect.True(casted)
+
+	newMsg := func(payload, a, b string) *core.Message {
+		return core.NewMessage(nil, []byte(payload), tcontainer.MarshalMap{"a": a, "b": b}, 1)
+	}
+
+	result, _ := filter.ApplyFilter(newMsg("1", "x", "yz"))
+	expect.Equal(core.FilterResultMessageAccept, result)
+	result, _ = filter.ApplyFilter(newMsg("2", "x", "yz"))
+	expect.Neq(core.FilterResultMessageAccept, result)
+
+	// Field boundaries are part of the fingerprint
+	result, _ = filter.ApplyFilter(newMsg("1", "xy", "z"))
+	expect.Equal(core.FilterResultMessageAccept, result)
+
+	// Adding a third fingerprint removes the oldest one
+	result, _ = filter.ApplyFilter(newMsg("1", "new", "z"))
+	expect.Equal(core.FilterResultMessageAccept, result)
+	result, _ = filter.ApplyFilter(newMsg("1", "xy", "z"))
+	expect.Neq(core.FilterResultMessageAccept, result)
+	result, _ = filter.ApplyFilter(newMsg("1", "x", "yz"))
+	expect.Equal(core.FilterResultMessageAccept, result)
+}
+
+func TestFilterDedupSnapshot(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+	dir, err := ioutil.TempDir("", "gollum-dedup")
+	expect.NoError(err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "dedup.json")
+	conf := core.NewPluginConfig("", "filter.Dedup")
+
+	conf.Override("SnapshotPath", path)
+	conf.Override("SnapshotIntervalSec", 0)
+	plugin, err := core.NewPluginWithConfig(conf)
+	expect.NoError(err)
+
+	filter, casted := plugin.(*Dedup)
+	expect.True(casted)
+
+	result, _ := filter.ApplyFilter(core.NewMessage(nil, []byte("a"), nil, 1))
+	expect.Equal(core.FilterResultMessageAccept, result)
+
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(10 * time.Millisecond) {
+		if _, err := os.Stat(path); err == nil && atomic.LoadInt32(filter.snapshotPending) == 0 {
+			break
+		}
+	}
+
+	plugin, err = core.NewPluginWithConfig(conf)
+	expect.NoError(err)
+	restored := plugin.(*Dedup)
+	expect.Equal(1, restored.seen.len())
+	result, _ = restored.ApplyFilter(core.NewMessage(nil, []byte("a"), nil, 1))
+	expect.Neq(core.FilterResultMessageAccept, result)
+}
@@ -0,0 +1,100 @@
+// Copyright 2015-2018 trivago N.V.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filter
+
+import (
+	"container/list"
+)
+
+// lruCache stores a bounded number of values by key. If the cache is full,
+// the least recently used entry is removed. lruCache is not threadsafe.
+type lruCache struct {
+	maxSize int
+	items   map[string]*list.Element
+	order   *list.List
+}
+
+type lruEntry struct {
+	key   string
+	value interface{}
+}
+
+// newLRUCache creates a cache holding at most maxSize entries. A maxSize of
+// 0 or less disables the limit.
+func newLRUCache(maxSize int) *lruCache {
+	return &lruCache{
+		maxSize: maxSize,
+		items:   make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// get returns the value stored for key and marks it as recently used.
+func (cache *lruCache) get(key string) (interface{}, bool) {
+	element, exists := cache.items[key]
+	if !exists {
+		return nil, false // ### return, unknown key ###
+	}
+	cache.order.MoveToFront(element)
+	return element.Value.(*lruEntry).value, true
+}
+
+// peek returns the value stored for key without changing the order of
+// entries.
+func (cache *lruCache) peek(key string) (interface{}, bool) {
+	element, exists := cache.items[key]
+	if !exists {
+		return nil, false // ### return, unknown key ###
+	}
+	return element.Value.(*lruEntry).value, true
+}
+
+// add stores a value for key and marks it as recently used. If this exceeds
+// the size of the cache, the least recently used entry is removed.
+func (cache *lruCache) add(key string, value interface{}) {
+	if element, exists := cache.items[key]; exists {
+		element.Value.(*lruEntry).value = value
+		cache.order.MoveToFront(element)
+		return // ### return, updated ###
+	}
+
+	cache.items[key] = cache.order.PushFront(&lruEntry{key: key, value: value})
+	if cache.maxSize > 0 && cache.order.Len() > cache.maxSize {
+		cache.remove(cache.order.Back().Value.(*lruEntry).key)
+	}
+}
+
+// remove deletes the entry stored for key.
+func (cache *lruCache) remove(key string) {
+	if element, exists := cache.items[key]; exists {
+		cache.order.Remove(element)
+		delete(cache.items, key)
+	}
+}
+
+// oldest returns the key and value of the least recently used entry.
+func (cache *lruCache) oldest() (string, interface{}, bool) {
+	element := cache.order.Back()
+	if element == nil {
+		return "", nil, false // ### return, empty ###
+	}
+	entry := element.Value.(*lruEntry)
+	return entry.key, entry.value, true
+}
+
+// len returns the number of entries stored.
+func (cache *lruCache) len() int {
+	return cache.order.Len()
+}
@@ -16,7 +16,6 @@ package filter
 
 import (
 	"sync"
-	"sync/atomic"
 	"time"
 
 	"github.com/rcrowley/go-metrics"
@@ -25,15 +24,48 @@ import (
 
 // Rate filter plugin
 //
-// This plugin blocks messages after a certain number of messages per second
-// has been reached.
+// This plugin limits the number of messages and bytes per second passing
+// through this filter. Limits are enforced using a token bucket, i.e. tokens
+// are refilled at the configured rate and short bursts are allowed as long as
+// tokens are left in the bucket. Messages exceeding the limit are rejected and
+// sent to FilteredStream if set, otherwise they are dropped.
+//
+// Limits are tracked per stream by default. If KeyFrom is set, limits are
+// tracked per value of the given metadata field instead, e.g. per host or per
+// tenant.
 //
 // Parameters
 //
 // - MessagesPerSec: This value defines the maximum number of messages per second allowed
-// to pass through this filter.
+// to pass through this filter. Set to 0 to not limit the number of messages.
 // By default this parameter is set to "100".
 //
+// - Burst: Defines the maximum number of messages allowed to pass at once if
+// no messages have been passed for a while. If set to 0, MessagesPerSec is
+// used.
+// By default this parameter is set to "0".
+//
+// - BytesPerSec: Defines the maximum number of payload bytes per second
+// allowed to pass through this filter. Set to 0 to not limit the number of
+// bytes.
+// By default this parameter is set to "0".
+//
+// - BurstBytes: Defines the maximum number of payload bytes allowed to pass
+// at once if no messages have been passed for a while. Messages larger than
+// this value pass if the bucket is full. If set to 0, BytesPerSec is used.
+// By default this parameter is set to "0".
+//
+// - KeyFrom: Defines a metadata field whose value is used to track limits
+// separately. Messages without this field share the same limit. If set to an
+// empty string, limits are tracked per stream.
+// By default this parameter is set to "".
+//
+// - MaxKeys: Defines the maximum number of keys to track if KeyFrom is set.
+// If this number is exceeded, the least recently used key is removed and
+// starts with a full bucket when seen again. Set to 0 to not limit the number
+// of keys.
+// By default this parameter is set to "10000".
+//
 // - Ignore:  Defines a list of streams that should not be affected by
 // rate limiting. This is useful for e.g. producers listeing to "*".
 // By default this parameter is set to "empty".
@@ -50,19 +82,42 @@ import (
 //        MessagesPerSec: 10
 //        Ignore:
 //          - noLimit
+//
+// This example limits each host to 1000 messages and 1 MB per second, allows
+// bursts of 5000 messages and sends messages above this limit to "throttled":
+//
+//  ExampleConsumer:
+//    Type: consumer.Syslogd
+//    Streams: logs
+//    Modulators:
+//      - filter.Rate:
+//        KeyFrom: hostname
+//        MessagesPerSec: 1000
+//        Burst: 5000
+//        BytesPerSec: 1048576
+//        FilteredStream: throttled
 type Rate struct {
 	core.SimpleFilter `gollumdoc:"embed_type"`
-	stateGuard        *sync.RWMutex
-	state             map[core.MessageStreamID]*rateState
-	rateLimit         int64 `config:"MessagesPerSec" default:"100"`
+	keyGuard          *sync.Mutex
+	buckets           *lruCache
+	streamBuckets     *sync.Map
+	ignore            map[core.MessageStreamID]bool
+	metricLimit       *sync.Map
+	rateLimit         int64  `config:"MessagesPerSec" default:"100"`
+	burst             int64  `config:"Burst" default:"0"`
+	byteLimit         int64  `config:"BytesPerSec" default:"0"`
+	byteBurst         int64  `config:"BurstBytes" default:"0"`
+	keyField          string `config:"KeyFrom"`
+	maxKeys           int    `config:"MaxKeys" default:"10000"`
 	metricsRegistry   metrics.Registry
 }
 
-type rateState struct {
-	lastReset   time.Time
-	metricLimit metrics.Counter
-	count       *int64
-	ignore      bool
+// rateBucket holds the tokens left for a key
+type rateBucket struct {
+	guard      *sync.Mutex
+	messages   float64
+	bytes      float64
+	lastUpdate time.Time
 }
 
 func init() {
@@ -71,55 +126,149 @@ func init() {
 
 // Configure initializes this filter with values from a plugin config.
 func (filter *Rate) Configure(conf core.PluginConfigReader) {
-	filter.stateGuard = new(sync.RWMutex)
-	filter.state = make(map[core.MessageStreamID]*rateState)
+	filter.keyGuard = new(sync.Mutex)
+	filter.buckets = newLRUCache(filter.maxKeys)
+	filter.streamBuckets = new(sync.Map)
+	filter.ignore = make(map[core.MessageStreamID]bool)
+	filter.metricLimit = new(sync.Map)
 	filter.metricsRegistry = core.NewMetricsRegistry("ratelimit")
 
+	if filter.rateLimit < 0 || filter.burst < 0 || filter.byteLimit < 0 || filter.byteBurst < 0 {
+		conf.Errors.Pushf("Rate limits must not be negative")
+	}
+	if filter.burst == 0 {
+		filter.burst = filter.rateLimit
+	}
+	if filter.byteBurst == 0 {
+		filter.byteBurst = filter.byteLimit
+	}
+
 	ignore := conf.GetStreamArray("Ignore", []core.MessageStreamID{})
 	for _, stream := range ignore {
-		filter.state[stream] = &rateState{
-			ignore: true,
-		}
+		filter.ignore[stream] = true
 	}
 }
 
 // ApplyFilter check if all Filter wants to reject the message
 func (filter *Rate) ApplyFilter(msg *core.Message) (core.FilterResult, error) {
-	filter.stateGuard.RLock()
-	state, known := filter.state[msg.GetStreamID()]
-	filter.stateGuard.RUnlock()
+	streamID := msg.GetStreamID()
+	if filter.ignore[streamID] {
+		return core.FilterResultMessageAccept, nil // ### return, do not limit ###
+	}
 
-	// Add stream if necessary
-	if !known {
-		filter.stateGuard.Lock()
-		state = &rateState{
-			count:       new(int64),
-			ignore:      false,
-			lastReset:   time.Now(),
-			metricLimit: metrics.NewCounter(),
-		}
-		streamName := core.StreamRegistry.GetStreamName(msg.GetStreamID())
-		filter.state[msg.GetStreamID()] = state
-		filter.metricsRegistry.Register(streamName, state.metricLimit)
-		filter.stateGuard.Unlock()
+	var bucket *rateBucket
+	if filter.keyField == "" {
+		bucket = filter.getStreamBucket(streamID)
+	} else {
+		bucket = filter.getKeyBucket(msg)
 	}
 
-	// Ignore if set
-	if state.ignore {
+	bucket.guard.Lock()
+	filter.refill(bucket, time.Now())
+	accepted := filter.take(bucket, float64(len(msg.GetPayload())))
+	bucket.guard.Unlock()
+
+	if accepted {
 		return core.FilterResultMessageAccept, nil // ### return, do not limit ###
 	}
 
-	// Reset if necessary
-	if time.Since(state.lastReset) > time.Second {
-		state.lastReset = time.Now()
-		atomic.SwapInt64(state.count, 0)
+	filter.getLimitMetric(streamID).Inc(1)
+	return filter.GetFilterResultMessageReject(), nil
+}
+
+// newBucket returns a bucket with all tokens available.
+func (filter *Rate) newBucket() *rateBucket {
+	return &rateBucket{
+		guard:      new(sync.Mutex),
+		messages:   float64(filter.burst),
+		bytes:      float64(filter.byteBurst),
+		lastUpdate: time.Now(),
 	}
+}
 
-	// Check if to be filtered
-	if atomic.AddInt64(state.count, 1) <= filter.rateLimit {
-		return core.FilterResultMessageAccept, nil // ### return, do not limit ###
+// getStreamBucket returns the bucket of the given stream. Streams are never
+// removed, so buckets can be looked up without holding a global lock.
+func (filter *Rate) getStreamBucket(streamID core.MessageStreamID) *rateBucket {
+	if value, known := filter.streamBuckets.Load(streamID); known {
+		return value.(*rateBucket) // ### return, known stream ###
 	}
+	value, _ := filter.streamBuckets.LoadOrStore(streamID, filter.newBucket())
+	return value.(*rateBucket)
+}
 
-	state.metricLimit.Inc(1)
-	return filter.GetFilterResultMessageReject(), nil
+// getKeyBucket returns the bucket of the KeyFrom value of the given message.
+// Messages without this field share the same bucket.
+func (filter *Rate) getKeyBucket(msg *core.Message) *rateBucket {
+	key := ""
+	if metadata := msg.TryGetMetadata(); metadata != nil {
+		if value, isSet := metadata.Value(filter.keyField); isSet {
+			key = string(core.ConvertToBytes(value))
+		}
+	}
+
+	filter.keyGuard.Lock()
+	defer filter.keyGuard.Unlock()
+
+	if value, known := filter.buckets.get(key); known {
+		return value.(*rateBucket) // ### return, known key ###
+	}
+	bucket := filter.newBucket()
+	filter.buckets.add(key, bucket)
+	return bucket
+}
+
+// refill adds the tokens gained since the last update to the given bucket.
+// This function has to be called while holding the guard of bucket.
+func (filter *Rate) refill(bucket *rateBucket, now time.Time) {
+	elapsed := now.Sub(bucket.lastUpdate).Seconds()
+	if elapsed <= 0 {
+		return // ### return, no time passed ###
+	}
+	bucket.lastUpdate = now
+
+	bucket.messages += elapsed * float64(filter.rateLimit)
+	if bucket.messages > float64(filter.burst) {
+		bucket.messages = float64(filter.burst)
+	}
+	bucket.bytes += elapsed * float64(filter.byteLimit)
+	if bucket.bytes > float64(filter.byteBurst) {
+		bucket.bytes = float64(filter.byteBurst)
+	}
+}
+
+// take removes the tokens for a message of the given size from the bucket.
+// If not enough tokens are left, false is returned and the bucket is not
+// changed. A message larger than BurstBytes passes if the bucket is full and
+// leaves the byte tokens negative until it is paid off.
+// This function has to be called while holding the guard of bucket.
+func (filter *Rate) take(bucket *rateBucket, size float64) bool {
+	if filter.rateLimit > 0 && bucket.messages < 1 {
+		return false // ### return, message limit reached ###
+	}
+	if filter.byteLimit > 0 {
+		required := size
+		if required > float64(filter.byteBurst) {
+			required = float64(filter.byteBurst)
+		}
+		if bucket.bytes < required {
+			return false // ### return, byte limit reached ###
+		}
+		bucket.bytes -= size
+	}
+	if filter.rateLimit > 0 {
+		bucket.messages--
+	}
+	return true
+}
+
+// getLimitMetric returns the counter of limited messages for a stream.
+func (filter *Rate) getLimitMetric(streamID core.MessageStreamID) metrics.Counter {
+	if counter, known := filter.metricLimit.Load(streamID); known {
+		return counter.(metrics.Counter) // ### return, known stream ###
+	}
+	counter, known := filter.metricLimit.LoadOrStore(streamID, metrics.NewCounter())
+	if !known {
+		filter.metricsRegistry.Register(core.StreamRegistry.GetStreamName(streamID), counter)
+	}
+	return counter.(metrics.Counter)
 }
@@ -15,10 +15,13 @@
 package filter
 
 import (
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/trivago/gollum/core"
+	"github.com/trivago/tgo/tcontainer"
 	"github.com/trivago/tgo/ttesting"
 )
 
@@ -42,14 +45,13 @@ func TestFilterRate(t *testing.T) {
 		if i < 100 {
 			expect.Equal(core.FilterResultMessageAccept, result1)
 			expect.Equal(core.FilterResultMessageAccept, result2)
-			time.Sleep(time.Millisecond)
 		} else {
 			expect.Neq(core.FilterResultMessageAccept, result1)
 			expect.Neq(core.FilterResultMessageAccept, result2)
 		}
 	}
 
-	// Wait for rates to be cleared
+	// Wait for buckets to be refilled
 	time.Sleep(time.Second)
 
 	for i := 0; i < 110; i++ {
@@ -58,7 +60,6 @@ func TestFilterRate(t *testing.T) {
 		if i < 100 {
 			expect.Equal(core.FilterResultMessageAccept, result1)
 			expect.Equal(core.FilterResultMessageAccept, result2)
-			time.Sleep(time.Millisecond)
 		} else {
 			expect.Neq(core.FilterResultMessageAccept, result1)
 			expect.Neq(core.FilterResultMessageAccept, result2)
@@ -88,9 +89,137 @@ func TestFilterRateIgnore(t *testing.T) {
 		expect.Equal(core.FilterResultMessageAccept, result1)
 		if i < 100 {
 			expect.Equal(core.FilterResultMessageAccept, result2)
-			time.Sleep(time.Millisecond)
 		} else {
 			expect.Neq(core.FilterResultMessageAccept, result2)
 		}
 	}
 }
+
+func TestFilterRateBurst(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+	conf := core.NewPluginConfig("", "filter.Rate")
+
+	conf.Override("MessagesPerSec", 100)
+	conf.Override("Burst", 10)
+	plugin, err := core.NewPluginWithConfig(conf)
+	expect.NoError(err)
+
+	filter, casted := plugin.(*Rate)
+	expect.True(casted)
+
+	msg := core.NewMessage(nil, []byte{}, nil, 1)
+	for i := 0; i < 10; i++ {
+		result, _ := filter.ApplyFilter(msg)
+		expect.Equal(core.FilterResultMessageAccept, result)
+	}
+	result, _ := filter.ApplyFilter(msg)
+	expect.Neq(core.FilterResultMessageAccept, result)
+
+	// One token is refilled every 10ms
+	time.Sleep(25 * time.Millisecond)
+	result, _ = filter.ApplyFilter(msg)
+	expect.Equal(core.FilterResultMessageAccept, result)
+	result, _ = filter.ApplyFilter(msg)
+	expect.Equal(core.FilterResultMessageAccept, result)
+	result, _ = filter.ApplyFilter(msg)
+	expect.Neq(core.FilterResultMessageAccept, result)
+}
+
+func TestFilterRateKey(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+	conf := core.NewPluginConfig("", "filter.Rate")
+
+	conf.Override("MessagesPerSec", 2)
+	conf.Override("KeyFrom", "host")
+	conf.Override("MaxKeys", 2)
+	conf.Override("FilteredStream", "throttled")
+	plugin, err := core.NewPluginWithConfig(conf)
+	expect.NoError(err)
+
+	filter, casted := plugin.(*Rate)
+	expect.True(casted)
+
+	newMsg := func(host string, stream core.MessageStreamID) *core.Message {
+		return core.NewMessage(nil, []byte{}, tcontainer.MarshalMap{"host": host}, stream)
+	}
+
+	// Streams share the limit of a key
+	for i := 0; i < 2; i++ {
+		result, _ := filter.ApplyFilter(newMsg("a", core.MessageStreamID(i+1)))
+		expect.Equal(core.FilterResultMessageAccept, result)
+	}
+	result, _ := filter.ApplyFilter(newMsg("a", 1))
+	expect.Equal(core.FilterResultMessageReject(core.GetStreamID("throttled")), result)
+
+	result, _ = filter.ApplyFilter(newMsg("b", 1))
+	expect.Equal(core.FilterResultMessageAccept, result)
+
+	// Adding "c" removes "a" as it is the least recently used key
+	result, _ = filter.ApplyFilter(newMsg("c", 1))
+	expect.Equal(core.FilterResultMessageAccept, result)
+	expect.Equal(2, filter.buckets.len())
+
+	result, _ = filter.ApplyFilter(newMsg("a", 1))
+	expect.Equal(core.FilterResultMessageAccept, result)
+}
+
+func TestFilterRateBytes(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+	conf := core.NewPluginConfig("", "filter.Rate")
+
+	conf.Override("MessagesPerSec", 0)
+	conf.Override("BytesPerSec", 10)
+	conf.Override("BurstBytes", 8)
+	plugin, err := core.NewPluginWithConfig(conf)
+	expect.NoError(err)
+
+	filter, casted := plugin.(*Rate)
+	expect.True(casted)
+
+	result, _ := filter.ApplyFilter(core.NewMessage(nil, []byte("12345"), nil, 1))
+	expect.Equal(core.FilterResultMessageAccept, result)
+	result, _ = filter.ApplyFilter(core.NewMessage(nil, []byte("12345"), nil, 1))
+	expect.Neq(core.FilterResultMessageAccept, result)
+	result, _ = filter.ApplyFilter(core.NewMessage(nil, []byte("123"), nil, 1))
+	expect.Equal(core.FilterResultMessageAccept, result)
+
+	// Messages larger than the burst pass on a full bucket
+	plugin, err = core.NewPluginWithConfig(conf)
+	expect.NoError(err)
+	filter = plugin.(*Rate)
+	result, _ = filter.ApplyFilter(core.NewMessage(nil, []byte("0123456789ABCDEF"), nil, 1))
+	expect.Equal(core.FilterResultMessageAccept, result)
+	result, _ = filter.ApplyFilter(core.NewMessage(nil, []byte("1"), nil, 1))
+	expect.Neq(core.FilterResultMessageAccept, result)
+}
+
+func TestFilterRateConcurrent(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+	conf := core.NewPluginConfig("", "filter.Rate")
+
+	conf.Override("MessagesPerSec", 1)
+	conf.Override("Burst", 100)
+	plugin, err := core.NewPluginWithConfig(conf)
+	expect.NoError(err)
+
+	filter, casted := plugin.(*Rate)
+	expect.True(casted)
+
+	accepted := new(int32)
+	done := new(sync.WaitGroup)
+	for i := 0; i < 4; i++ {
+		done.Add(1)
+		go func() {
+			defer done.Done()
+			for j := 0; j < 50; j++ {
+				if result, _ := filter.ApplyFilter(core.NewMessage(nil, []byte{}, nil, 1)); result == core.FilterResultMessageAccept {
+					atomic.AddInt32(accepted, 1)
+				}
+			}
+		}()
+	}
+	done.Wait()
+
+	expect.Equal(int32(100), atomic.LoadInt32(accepted))
+	expect.Equal(int64(100), filter.getLimitMetric(1).Count())
+}
@@ -27,6 +27,8 @@ var (
 	flagVersion        = tflag.Switch("v", "version", "Print version information and quit.")
 	flagExtVersion     = tflag.Switch("r", "runtime", "Print runtime information and quit.")
 	flagModules        = tflag.Switch("l", "list", "Print plugin information and quit.")
+	flagSchema         = tflag.Switch("s", "schema", "Print the configuration schema of all plugins as JSON and quit.")
+	flagGraph          = tflag.String("g", "graph", "", "Print the stream topology of the configuration passed via -c as \"dot\" or \"json\" and quit.")
 	flagConfigFile     = tflag.String("c", "config", "", "Use a given configuration file.")
 	flagTestConfigFile = tflag.String("tc", "testconfig", "", "Test the given configuration file and exit.")
 	flagLoglevel       = tflag.Int("ll", "loglevel", 2, "Set the loglevel [0-3] as in {0=Error, 1=+Warning, 2=+Info, 3=+Debug}.")
@@ -36,11 +38,14 @@ var (
 	flagMetricsAddress = tflag.String("m", "metrics", "", "Address to use for metric queries. Disabled by default.")
 	flagMetricsType    = tflag.String("mt", "metricstype", "", "Type of metrics to generate. Defaults to \"prometheus\"")
 	flagHealthCheck    = tflag.String("hc", "healthcheck", "", "Listening address ([IP]:PORT) to use for healthcheck HTTP endpoint. Disabled by default.")
+	flagAdminAddress   = tflag.String("a", "admin", "", "Listening address ([IP]:PORT) to use for the admin HTTP API. Binds to 127.0.0.1 if no IP is given. The API can pause plugins and reload the configuration, so only expose it to trusted networks. Disabled by default.")
+	flagAdminToken     = tflag.String("at", "admin-token", "", "Token required as \"Authorization: Bearer <token>\" by the admin HTTP API. Can also be set via GOLLUM_ADMIN_TOKEN. Disabled by default.")
 	flagCPUProfile     = tflag.String("pc", "profilecpu", "", "Write CPU profiler results to a given file.")
 	flagMemProfile     = tflag.String("pm", "profilemem", "", "Write heap profile results to a given file.")
 	flagProfile        = tflag.Switch("ps", "profilespeed", "Write msg/sec measurements to log.")
 	flagProfileTrace   = tflag.String("pt", "profiletrace", "", "Write profile trace results to a given file.")
 	flagTrace          = tflag.Switch("t", "trace", "Write message trace results _TRACE_ stream.")
+	flagOTLPEndpoint   = tflag.String("ot", "otlp", "", "Export message spans to an OpenTelemetry collector via OTLP/HTTP, e.g. \"http://localhost:4318\". Disabled by default.")
 )
 
 func parseFlags() {
@@ -48,7 +53,7 @@ func parseFlags() {
 }
 
 func printFlags() {
-	helpMessageStr := fmt.Sprintf("Usage: gollum [OPTIONS]\n\nGollum - An n:m message multiplexer.\nVersion: %s\n\nOptions:", core.GetVersionString())
+	helpMessageStr := fmt.Sprintf("Usage: gollum [lint] [OPTIONS]\n       gollum offsets DATABASE [list|reset [PATH|KEY]...]\n\nGollum - An n:m message multiplexer.\nVersion: %s\n\nOptions:", core.GetVersionString())
 	tflag.PrintFlags(helpMessageStr)
 }
 
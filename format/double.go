@@ -80,6 +80,10 @@ func (format *Double) ApplyFormatter(msg *core.Message) error {
 	leftMsg := msg.Clone()
 	rightMsg := msg.Clone()
 
+	// The copies are never routed, so they are acknowledged right away
+	defer leftMsg.Ack()
+	defer rightMsg.Ack()
+
 	// apply sub-formatter
 	if err := format.left.ApplyFormatter(leftMsg); err != nil {
 		return err
@@ -0,0 +1,344 @@
+// Copyright 2015-2018 trivago N.V.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package format
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/trivago/gollum/core"
+	"github.com/trivago/tgo/tcontainer"
+)
+
+// jsonPath is a parsed path into a JSON document. Paths use a subset of the
+// JSONPath syntax: an optional root "$", keys separated by "." and array
+// indexes or quoted keys in brackets, e.g. "$.user.tags[0]" or
+// "items[-1]['display.name']". Negative indexes count from the end of an
+// array.
+type jsonPath []jsonPathElement
+
+type jsonPathElement struct {
+	key     string
+	index   int
+	isIndex bool
+}
+
+// parseJSONPath parses a JSONPath or dotted path. An empty path or "$"
+// denotes the document root.
+func parseJSONPath(path string) (jsonPath, error) {
+	parsed := jsonPath{}
+	remain := strings.TrimPrefix(path, "$")
+
+	for len(remain) > 0 {
+		switch remain[0] {
+		case '.':
+			remain = remain[1:]
+			end := strings.IndexAny(remain, ".[")
+			if end == -1 {
+				end = len(remain)
+			}
+			if end == 0 {
+				return nil, fmt.Errorf("empty key in path \"%s\"", path)
+			}
+			parsed = append(parsed, jsonPathElement{key: remain[:end]})
+			remain = remain[end:]
+
+		case '[':
+			end := strings.IndexByte(remain, ']')
+			if end == -1 {
+				return nil, fmt.Errorf("missing \"]\" in path \"%s\"", path)
+			}
+			element, err := parseJSONPathBracket(remain[1:end])
+			if err != nil {
+				return nil, fmt.Errorf("%s in path \"%s\"", err.Error(), path)
+			}
+			parsed = append(parsed, element)
+			remain = remain[end+1:]
+
+		default:
+			if len(parsed) > 0 {
+				return nil, fmt.Errorf("unexpected \"%c\" in path \"%s\"", remain[0], path)
+			}
+			// The first key does not require a leading "."
+			remain = "." + remain
+		}
+	}
+	return parsed, nil
+}
+
+// parseJSONPathBracket parses the contents of a "[...]" path element.
+func parseJSONPathBracket(content string) (jsonPathElement, error) {
+	if len(content) >= 2 && (content[0] == '\'' || content[0] == '"') && content[len(content)-1] == content[0] {
+		return jsonPathElement{key: content[1 : len(content)-1]}, nil
+	}
+	index, err := strconv.Atoi(strings.TrimSpace(content))
+	if err != nil {
+		return jsonPathElement{}, fmt.Errorf("invalid index \"%s\"", content)
+	}
+	return jsonPathElement{index: index, isIndex: true}, nil
+}
+
+// String returns the path in JSONPath notation.
+func (path jsonPath) String() string {
+	result := "$"
+	for _, element := range path {
+		if element.isIndex {
+			result += "[" + strconv.Itoa(element.index) + "]"
+		} else {
+			result += "." + element.key
+		}
+	}
+	return result
+}
+
+// asJSONObject returns node as map if it is a JSON object.
+func asJSONObject(node interface{}) (map[string]interface{}, bool) {
+	switch object := node.(type) {
+	case map[string]interface{}:
+		return object, true
+	case tcontainer.MarshalMap:
+		return map[string]interface{}(object), true
+	}
+	return nil, false
+}
+
+// resolveIndex maps negative indexes to the end of an array of the given
+// length. The result may be out of range.
+func resolveIndex(index int, length int) int {
+	if index < 0 {
+		return length + index
+	}
+	return index
+}
+
+// get returns the value at path inside doc.
+func (path jsonPath) get(doc interface{}) (interface{}, bool) {
+	node := doc
+	for _, element := range path {
+		if element.isIndex {
+			array, isArray := node.([]interface{})
+			if !isArray {
+				return nil, false // ### return, not an array ###
+			}
+			index := resolveIndex(element.index, len(array))
+			if index < 0 || index >= len(array) {
+				return nil, false // ### return, out of range ###
+			}
+			node = array[index]
+		} else {
+			object, isObject := asJSONObject(node)
+			if !isObject {
+				return nil, false // ### return, not an object ###
+			}
+			value, exists := object[element.key]
+			if !exists {
+				return nil, false // ### return, missing key ###
+			}
+			node = value
+		}
+	}
+	return node, true
+}
+
+// set stores value at path inside doc and returns the modified document.
+// Missing objects along the path are created. Array indexes have to exist or
+// point directly behind the last element to append a value.
+func (path jsonPath) set(doc interface{}, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil // ### return, replace root ###
+	}
+
+	element := path[0]
+	if element.isIndex {
+		array, isArray := doc.([]interface{})
+		if !isArray && doc != nil {
+			return nil, fmt.Errorf("cannot index non-array with [%d]", element.index)
+		}
+		index := resolveIndex(element.index, len(array))
+		if index < 0 || index > len(array) {
+			return nil, fmt.Errorf("index [%d] out of range", element.index)
+		}
+
+		var child interface{}
+		if index < len(array) {
+			child = array[index]
+		}
+		child, err := path[1:].set(child, value)
+		if err != nil {
+			return nil, err
+		}
+		if index == len(array) {
+			return append(array, child), nil
+		}
+		array[index] = child
+		return array, nil
+	}
+
+	object, isObject := asJSONObject(doc)
+	if !isObject {
+		if doc != nil {
+			return nil, fmt.Errorf("cannot access key \"%s\" of non-object", element.key)
+		}
+		object = make(map[string]interface{})
+		doc = object
+	}
+
+	child, err := path[1:].set(object[element.key], value)
+	if err != nil {
+		return nil, err
+	}
+	object[element.key] = child
+	return doc, nil
+}
+
+// remove deletes the value at path inside doc and returns the modified
+// document. Array elements are removed, i.e. following elements move up.
+func (path jsonPath) remove(doc interface{}) (interface{}, bool) {
+	if len(path) == 0 {
+		return nil, true // ### return, remove root ###
+	}
+
+	element := path[0]
+	if element.isIndex {
+		array, isArray := doc.([]interface{})
+		if !isArray {
+			return doc, false // ### return, not an array ###
+		}
+		index := resolveIndex(element.index, len(array))
+		if index < 0 || index >= len(array) {
+			return doc, false // ### return, out of range ###
+		}
+		if len(path) == 1 {
+			return append(array[:index], array[index+1:]...), true
+		}
+		child, removed := path[1:].remove(array[index])
+		array[index] = child
+		return array, removed
+	}
+
+	object, isObject := asJSONObject(doc)
+	if !isObject {
+		return doc, false // ### return, not an object ###
+	}
+	child, exists := object[element.key]
+	if !exists {
+		return doc, false // ### return, missing key ###
+	}
+	if len(path) == 1 {
+		delete(object, element.key)
+		return doc, true
+	}
+	child, removed := path[1:].remove(child)
+	object[element.key] = child
+	return doc, removed
+}
+
+// decodeJSON parses data into a generic document. Numbers are kept as
+// json.Number so that they are written back unchanged.
+func decodeJSON(data []byte) (interface{}, error) {
+	var doc interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// getJSONDocument returns the document stored in the source of the given
+// formatter. Metadata fields holding objects or arrays are copied, all other
+// data is parsed as JSON. The second return value is true if the source has
+// been parsed.
+func getJSONDocument(format *core.SimpleFormatter, msg *core.Message) (interface{}, bool, error) {
+	if format.SourceIsMetadata() {
+		switch data := format.GetSourceData(msg).(type) {
+		case map[string]interface{}, tcontainer.MarshalMap, []interface{}:
+			return copyJSONValue(data), false, nil
+		}
+	}
+
+	doc, err := decodeJSON(format.GetSourceDataAsBytes(msg))
+	return doc, true, err
+}
+
+// setJSONDocument stores a document to the target of the given formatter.
+// Documents are written as JSON if the target is the payload or if they
+// have been parsed from JSON. Otherwise they are stored as metadata values.
+func setJSONDocument(format *core.SimpleFormatter, msg *core.Message, doc interface{}, parsed bool) error {
+	if format.TargetIsMetadata() && !parsed {
+		format.SetTargetData(msg, toMetadataValue(doc))
+		return nil
+	}
+
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	format.SetTargetData(msg, data)
+	return nil
+}
+
+// copyJSONValue returns a deep copy of all objects and arrays in value.
+func copyJSONValue(value interface{}) interface{} {
+	if array, isArray := value.([]interface{}); isArray {
+		copied := make([]interface{}, len(array))
+		for i, item := range array {
+			copied[i] = copyJSONValue(item)
+		}
+		return copied
+	}
+	if object, isObject := asJSONObject(value); isObject {
+		copied := make(map[string]interface{}, len(object))
+		for key, item := range object {
+			copied[key] = copyJSONValue(item)
+		}
+		return copied
+	}
+	return value
+}
+
+// toMetadataValue converts a JSON value so that it can be used with the
+// metadata accessors, i.e. numbers become int64 or float64 and objects
+// become MarshalMaps.
+func toMetadataValue(value interface{}) interface{} {
+	switch typed := value.(type) {
+	case json.Number:
+		if intValue, err := typed.Int64(); err == nil {
+			return intValue
+		}
+		floatValue, _ := typed.Float64()
+		return floatValue
+
+	case []interface{}:
+		converted := make([]interface{}, len(typed))
+		for i, item := range typed {
+			converted[i] = toMetadataValue(item)
+		}
+		return converted
+
+	default:
+		if object, isObject := asJSONObject(value); isObject {
+			converted := tcontainer.NewMarshalMap()
+			for key, item := range object {
+				converted[key] = toMetadataValue(item)
+			}
+			return converted
+		}
+		return value
+	}
+}
@@ -0,0 +1,366 @@
+// Copyright 2015-2018 trivago N.V.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package format
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/trivago/gollum/core"
+	"github.com/trivago/tgo/tcontainer"
+)
+
+// JSONPath formatter
+//
+// This formatter modifies a JSON document stored in the payload or in a
+// metadata field by applying a list of operations to it. The document is
+// parsed once, all operations are applied in order and the result is written
+// back once. If Source is a metadata field holding an object or array (e.g.
+// created by format.JSON), it is used without being parsed and the result is
+// stored as object or array, too, unless Target is the payload.
+//
+// Paths use JSONPath notation with an optional root "$", keys separated by
+// "." and array indexes or quoted keys in brackets, e.g. "$.user.tags[0]",
+// "user.tags[-1]" or "labels['app.kubernetes.io/name']". Negative indexes
+// count from the end of an array.
+//
+// Each operation is a map with exactly one of the following keys, holding the
+// path to work on, and additional keys depending on the operation:
+//
+//  Extract: Copies the value at the path to the metadata field given by "To".
+//           Numbers are stored as int64 or float64, objects as maps.
+//  Set:     Stores "Value" at the path or, if set, the metadata field given
+//           by "ValueFrom". Missing objects along the path are created.
+//  Delete:  Removes the value at the path.
+//  Rename:  Moves the value at the path to the path given by "To".
+//  Cast:    Converts the value at the path to "Type", which can be one of
+//           "string", "int", "float", "bool" or "json". Casting a string to
+//           "json" parses it, casting an object to "string" serializes it.
+//
+// Operations on paths that do not exist are skipped. Failing operations
+// cause the message to be discarded.
+//
+// Parameters
+//
+// - Operations: An ordered list of operations as described above.
+// By default this parameter is set to an empty list.
+//
+// Examples
+//
+// This example moves the user id to metadata, removes the password, renames
+// "ts" to "timestamp" and makes sure that "status" is a number:
+//
+//  exampleConsumer:
+//    Type: consumer.Console
+//    Streams: stdin
+//    Modulators:
+//      - format.JSONPath:
+//        Operations:
+//          - Extract: $.user.id
+//            To: userId
+//          - Delete: $.user.password
+//          - Rename: $.ts
+//            To: $.timestamp
+//          - Cast: $.status
+//            Type: int
+//          - Set: $.meta.source
+//            Value: gollum
+type JSONPath struct {
+	core.SimpleFormatter `gollumdoc:"embed_type"`
+	operations           []jsonOperation
+}
+
+type jsonOperation func(doc interface{}, msg *core.Message) (interface{}, error)
+
+func init() {
+	core.TypeRegistry.Register(JSONPath{})
+}
+
+// Configure initializes this formatter with values from a plugin config.
+func (format *JSONPath) Configure(conf core.PluginConfigReader) {
+	for idx, value := range conf.GetArray("Operations", []interface{}{}) {
+		opConfig, err := tcontainer.ConvertToMarshalMap(value, nil)
+		if err != nil {
+			conf.Errors.Pushf("Operations[%d] must be a map", idx)
+			continue
+		}
+
+		operation, err := newJSONOperation(opConfig)
+		if err != nil {
+			conf.Errors.Pushf("Operations[%d]: %s", idx, err.Error())
+			continue
+		}
+		format.operations = append(format.operations, operation)
+	}
+}
+
+// ApplyFormatter update message payload
+func (format *JSONPath) ApplyFormatter(msg *core.Message) error {
+	doc, parsed, err := getJSONDocument(&format.SimpleFormatter, msg)
+	if err != nil {
+		return err
+	}
+
+	for _, operation := range format.operations {
+		if doc, err = operation(doc, msg); err != nil {
+			return err
+		}
+	}
+
+	return setJSONDocument(&format.SimpleFormatter, msg, doc, parsed)
+}
+
+// newJSONOperation creates the operation described by the given config.
+func newJSONOperation(opConfig tcontainer.MarshalMap) (jsonOperation, error) {
+	var (
+		opName string
+		source string
+	)
+	for _, name := range []string{"Extract", "Set", "Delete", "Rename", "Cast"} {
+		if value, err := opConfig.String(name); err == nil {
+			if opName != "" {
+				return nil, fmt.Errorf("only one of %s and %s may be set", opName, name)
+			}
+			opName, source = name, value
+		}
+	}
+	if opName == "" {
+		return nil, fmt.Errorf("one of Extract, Set, Delete, Rename or Cast is required")
+	}
+
+	path, err := parseJSONPath(source)
+	if err != nil {
+		return nil, err
+	}
+
+	switch opName {
+	case "Extract":
+		target, err := opConfig.String("To")
+		if err != nil || target == "" {
+			return nil, fmt.Errorf("Extract requires a metadata field \"To\"")
+		}
+		return newJSONExtract(path, target), nil
+
+	case "Set":
+		if len(path) == 0 {
+			return nil, fmt.Errorf("Set cannot replace the document root")
+		}
+		if valueFrom, err := opConfig.String("ValueFrom"); err == nil {
+			return newJSONSetFrom(path, valueFrom), nil
+		}
+		value, isSet := opConfig.Value("Value")
+		if !isSet {
+			return nil, fmt.Errorf("Set requires \"Value\" or \"ValueFrom\"")
+		}
+		return newJSONSet(path, tcontainer.TryConvertToMarshalMap(value, nil)), nil
+
+	case "Delete":
+		if len(path) == 0 {
+			return nil, fmt.Errorf("Delete cannot remove the document root")
+		}
+		return newJSONDelete(path), nil
+
+	case "Rename":
+		to, err := opConfig.String("To")
+		if err != nil {
+			return nil, fmt.Errorf("Rename requires a path \"To\"")
+		}
+		targetPath, err := parseJSONPath(to)
+		if err != nil {
+			return nil, err
+		}
+		if len(path) == 0 || len(targetPath) == 0 {
+			return nil, fmt.Errorf("Rename cannot move the document root")
+		}
+		return newJSONRename(path, targetPath), nil
+
+	default: // Cast
+		typeName, _ := opConfig.String("Type")
+		cast, err := newJSONCast(strings.ToLower(typeName))
+		if err != nil {
+			return nil, err
+		}
+		return newJSONCastOperation(path, cast), nil
+	}
+}
+
+func newJSONExtract(path jsonPath, target string) jsonOperation {
+	return func(doc interface{}, msg *core.Message) (interface{}, error) {
+		if value, exists := path.get(doc); exists {
+			msg.GetMetadata().Set(target, toMetadataValue(value))
+		}
+		return doc, nil
+	}
+}
+
+func newJSONSet(path jsonPath, value interface{}) jsonOperation {
+	return func(doc interface{}, msg *core.Message) (interface{}, error) {
+		return path.set(doc, value)
+	}
+}
+
+func newJSONSetFrom(path jsonPath, key string) jsonOperation {
+	return func(doc interface{}, msg *core.Message) (interface{}, error) {
+		metadata := msg.TryGetMetadata()
+		if metadata == nil {
+			return doc, nil // ### return, no metadata ###
+		}
+		value, exists := metadata.Value(key)
+		if !exists {
+			return doc, nil // ### return, field not set ###
+		}
+		if bytesValue, isBytes := value.([]byte); isBytes {
+			value = string(bytesValue)
+		}
+		return path.set(doc, value)
+	}
+}
+
+func newJSONDelete(path jsonPath) jsonOperation {
+	return func(doc interface{}, msg *core.Message) (interface{}, error) {
+		doc, _ = path.remove(doc)
+		return doc, nil
+	}
+}
+
+func newJSONRename(path jsonPath, targetPath jsonPath) jsonOperation {
+	return func(doc interface{}, msg *core.Message) (interface{}, error) {
+		value, exists := path.get(doc)
+		if !exists {
+			return doc, nil // ### return, nothing to rename ###
+		}
+		doc, _ = path.remove(doc)
+		return targetPath.set(doc, value)
+	}
+}
+
+func newJSONCastOperation(path jsonPath, cast func(interface{}) (interface{}, error)) jsonOperation {
+	return func(doc interface{}, msg *core.Message) (interface{}, error) {
+		value, exists := path.get(doc)
+		if !exists {
+			return doc, nil // ### return, nothing to cast ###
+		}
+		converted, err := cast(value)
+		if err != nil {
+			return nil, fmt.Errorf("cannot cast %s: %s", path.String(), err.Error())
+		}
+		return path.set(doc, converted)
+	}
+}
+
+// newJSONCast returns a function converting a JSON value to the given type.
+func newJSONCast(typeName string) (func(interface{}) (interface{}, error), error) {
+	switch typeName {
+	case "string":
+		return castJSONToString, nil
+	case "int":
+		return castJSONToInt, nil
+	case "float":
+		return castJSONToFloat, nil
+	case "bool":
+		return castJSONToBool, nil
+	case "json":
+		return castJSONToDocument, nil
+	}
+	return nil, fmt.Errorf("Cast requires a \"Type\" of string, int, float, bool or json")
+}
+
+func castJSONToString(value interface{}) (interface{}, error) {
+	switch typed := value.(type) {
+	case string:
+		return typed, nil
+	case json.Number:
+		return typed.String(), nil
+	case bool:
+		return strconv.FormatBool(typed), nil
+	case nil:
+		return "", nil
+	}
+	data, err := json.Marshal(value)
+	return string(data), err
+}
+
+func castJSONToInt(value interface{}) (interface{}, error) {
+	var text string
+	switch typed := value.(type) {
+	case json.Number:
+		text = typed.String()
+	case string:
+		text = strings.TrimSpace(typed)
+	case bool:
+		if typed {
+			return json.Number("1"), nil
+		}
+		return json.Number("0"), nil
+	case int, int64, float64:
+		text = fmt.Sprint(typed)
+	default:
+		return nil, fmt.Errorf("%T is not a number", value)
+	}
+
+	if intValue, err := strconv.ParseInt(text, 10, 64); err == nil {
+		return json.Number(strconv.FormatInt(intValue, 10)), nil
+	}
+	floatValue, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return nil, err
+	}
+	return json.Number(strconv.FormatInt(int64(floatValue), 10)), nil
+}
+
+func castJSONToFloat(value interface{}) (interface{}, error) {
+	var text string
+	switch typed := value.(type) {
+	case json.Number:
+		text = typed.String()
+	case string:
+		text = strings.TrimSpace(typed)
+	case int, int64, float64:
+		text = fmt.Sprint(typed)
+	default:
+		return nil, fmt.Errorf("%T is not a number", value)
+	}
+
+	floatValue, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return nil, err
+	}
+	return json.Number(strconv.FormatFloat(floatValue, 'f', -1, 64)), nil
+}
+
+func castJSONToBool(value interface{}) (interface{}, error) {
+	switch typed := value.(type) {
+	case bool:
+		return typed, nil
+	case string:
+		return strconv.ParseBool(strings.TrimSpace(typed))
+	case json.Number:
+		floatValue, err := typed.Float64()
+		return floatValue != 0, err
+	case nil:
+		return false, nil
+	}
+	return nil, fmt.Errorf("%T cannot be converted to bool", value)
+}
+
+func castJSONToDocument(value interface{}) (interface{}, error) {
+	text, isString := value.(string)
+	if !isString {
+		return value, nil // ### return, already structured ###
+	}
+	return decodeJSON([]byte(text))
+}
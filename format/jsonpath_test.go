@@ -0,0 +1,135 @@
+// Copyright 2015-2018 trivago N.V.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package format
+
+import (
+	"testing"
+
+	"github.com/trivago/gollum/core"
+	"github.com/trivago/tgo/tcontainer"
+	"github.com/trivago/tgo/ttesting"
+)
+
+func TestParseJSONPath(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	path, err := parseJSONPath("$.a.b[0]['c.d'][-1]")
+	expect.NoError(err)
+	expect.Equal(jsonPath{
+		{key: "a"},
+		{key: "b"},
+		{index: 0, isIndex: true},
+		{key: "c.d"},
+		{index: -1, isIndex: true},
+	}, path)
+
+	path, err = parseJSONPath("a.b")
+	expect.NoError(err)
+	expect.Equal("$.a.b", path.String())
+
+	path, err = parseJSONPath("$")
+	expect.NoError(err)
+	expect.Equal(0, len(path))
+
+	_, err = parseJSONPath("a..b")
+	expect.NotNil(err)
+	_, err = parseJSONPath("a[x]")
+	expect.NotNil(err)
+	_, err = parseJSONPath("a[0")
+	expect.NotNil(err)
+}
+
+func TestJSONPathOperations(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	config := core.NewPluginConfig("", "format.JSONPath")
+
+	config.Override("Operations", []interface{}{
+		tcontainer.MarshalMap{"Extract": "$.user.id", "To": "userId"},
+		tcontainer.MarshalMap{"Extract": "$.tags", "To": "tags"},
+		tcontainer.MarshalMap{"Delete": "$.user.password"},
+		tcontainer.MarshalMap{"Delete": "$.tags[0]"},
+		tcontainer.MarshalMap{"Rename": "$.ts", "To": "$.time.created"},
+		tcontainer.MarshalMap{"Cast": "$.status", "Type": "int"},
+		tcontainer.MarshalMap{"Cast": "$.user.id", "Type": "string"},
+		tcontainer.MarshalMap{"Cast": "$.nested", "Type": "json"},
+		tcontainer.MarshalMap{"Set": "$.source", "Value": "gollum"},
+		tcontainer.MarshalMap{"Set": "$.tags[1]", "ValueFrom": "extra"},
+		tcontainer.MarshalMap{"Delete": "$.missing.key"},
+	})
+
+	plugin, err := core.NewPluginWithConfig(config)
+	expect.NoError(err)
+	formatter, casted := plugin.(*JSONPath)
+	expect.True(casted)
+
+	payload := `{"user":{"id":12345678901,"password":"secret"},"tags":["a","b"],"ts":1.5,"status":"200","nested":"{\"x\":1}"}`
+	msg := core.NewMessage(nil, []byte(payload), tcontainer.MarshalMap{"extra": []byte("c")}, core.InvalidStreamID)
+	expect.NoError(formatter.ApplyFormatter(msg))
+
+	expect.Equal(`{"nested":{"x":1},"source":"gollum","status":200,"tags":["b","c"],"time":{"created":1.5},"user":{"id":"12345678901"}}`, msg.String())
+
+	userID, err := msg.GetMetadata().Int("userId")
+	expect.NoError(err)
+	expect.Equal(int64(12345678901), userID)
+	expect.MapEqual(msg.GetMetadata(), "tags", []interface{}{"a", "b"})
+
+	msg = core.NewMessage(nil, []byte(`{"status":"ok"}`), nil, core.InvalidStreamID)
+	expect.NotNil(formatter.ApplyFormatter(msg))
+
+	invalid := []tcontainer.MarshalMap{
+		{"Delete": "a", "Set": "b", "Value": 1},
+		{"Cast": "a", "Type": "date"},
+		{"Extract": "a"},
+	}
+	for _, operation := range invalid {
+		config := core.NewPluginConfig("", "format.JSONPath")
+		config.Override("Operations", []interface{}{operation})
+		_, err = core.NewPluginWithConfig(config)
+		expect.NotNil(err)
+	}
+}
+
+func TestJSONPathMetadata(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	config := core.NewPluginConfig("", "format.JSONPath")
+
+	config.Override("ApplyTo", "data")
+	config.Override("Operations", []interface{}{
+		tcontainer.MarshalMap{"Set": "$.b.c", "Value": 2},
+		tcontainer.MarshalMap{"Delete": "$.a"},
+	})
+
+	plugin, err := core.NewPluginWithConfig(config)
+	expect.NoError(err)
+	formatter, casted := plugin.(*JSONPath)
+	expect.True(casted)
+
+	data := tcontainer.MarshalMap{"a": 1}
+	msg := core.NewMessage(nil, []byte{}, tcontainer.MarshalMap{"data": data}, core.InvalidStreamID)
+	expect.NoError(formatter.ApplyFormatter(msg))
+
+	result, err := msg.GetMetadata().MarshalMap("data")
+	expect.NoError(err)
+	value, err := result.Int("b/c")
+	expect.NoError(err)
+	expect.Equal(int64(2), value)
+	_, hasA := result.Value("a")
+	expect.False(hasA)
+
+	// The source is copied, not modified
+	expect.MapEqual(data, "a", 1)
+}
@@ -0,0 +1,175 @@
+// Copyright 2015-2018 trivago N.V.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package format
+
+import (
+	"fmt"
+
+	"github.com/trivago/gollum/core"
+)
+
+// JSONSplit formatter
+//
+// This formatter splits a JSON document into one message per element of an
+// array inside this document. The first element is kept in the current
+// message, all other elements are stored in copies of the message which are
+// routed to the current stream of the message or, if used by a consumer, to
+// the streams of the consumer. Copies are acknowledged separately and are
+// passed to the router of the stream, i.e. modulators following this
+// formatter in a consumer are not applied to them.
+// The order of the resulting messages is not guaranteed. This formatter can
+// only be used by consumers and routers, as copies routed by a producer would
+// be sent to all producers of the stream.
+//
+// Messages are not changed if the path does not exist, does not point to an
+// array or if the array is empty.
+//
+// Parameters
+//
+// - Path: Defines the path of the array to split in JSONPath notation (see
+// format.JSONPath), e.g. "$.records". Use "$" if the document itself is an
+// array.
+// By default this parameter is set to "$".
+//
+// - Replace: When set to true, each message holds the complete document with
+// the array replaced by a single element. Otherwise only the element is kept.
+// By default this parameter is set to false.
+//
+// - IndexTo: Defines a metadata field to store the index of the element in.
+// If empty, the index is not stored.
+// By default this parameter is set to "".
+//
+// Examples
+//
+// This example reads batches of events and emits one message per event:
+//
+//  exampleConsumer:
+//    Type: consumer.Console
+//    Streams: stdin
+//    Modulators:
+//      - format.JSONSplit:
+//        Path: $.events
+//        IndexTo: eventIndex
+type JSONSplit struct {
+	core.SimpleFormatter `gollumdoc:"embed_type"`
+	path                 jsonPath
+	replace              bool   `config:"Replace" default:"false"`
+	indexField           string `config:"IndexTo"`
+}
+
+func init() {
+	core.TypeRegistry.Register(JSONSplit{})
+}
+
+// Configure initializes this formatter with values from a plugin config.
+func (format *JSONSplit) Configure(conf core.PluginConfigReader) {
+	path, err := parseJSONPath(conf.GetString("Path", "$"))
+	if err != nil {
+		conf.Errors.Push(fmt.Errorf("Path: %s", err.Error()))
+	}
+	format.path = path
+
+	if format.replace && len(path) == 0 {
+		conf.Errors.Pushf("Replace cannot be used if Path points to the document root")
+	}
+}
+
+// RoutesMessages returns true as copies of the message are routed to its
+// stream.
+func (format *JSONSplit) RoutesMessages() bool {
+	return true
+}
+
+// ApplyFormatter update message payload
+func (format *JSONSplit) ApplyFormatter(msg *core.Message) error {
+	doc, parsed, err := getJSONDocument(&format.SimpleFormatter, msg)
+	if err != nil {
+		return err
+	}
+
+	value, _ := format.path.get(doc)
+	elements, isArray := value.([]interface{})
+	if !isArray || len(elements) == 0 {
+		return nil // ### return, nothing to split ###
+	}
+
+	// Copies have to be created before the document is modified
+	copies := make([]*core.Message, len(elements)-1)
+	for i := range copies {
+		copies[i] = msg.Clone()
+	}
+
+	if err := format.setElement(msg, doc, elements, 0, parsed); err != nil {
+		return err
+	}
+
+	for i, clone := range copies {
+		if err := format.setElement(clone, doc, elements, i+1, parsed); err != nil {
+			clone.Nack()
+			format.Logger.WithError(err).Error("Failed to split message")
+			continue
+		}
+		format.route(clone)
+	}
+	return nil
+}
+
+// route sends a copy to the stream of the message. Messages modulated by a
+// consumer are not yet bound to a stream, so they are sent to all streams of
+// the consumer.
+func (format *JSONSplit) route(msg *core.Message) {
+	streamIDs := []core.MessageStreamID{msg.GetStreamID()}
+	if msg.GetStreamID() == core.InvalidStreamID {
+		if source, hasStreams := msg.GetSource().(interface {
+			Streams() []core.MessageStreamID
+		}); hasStreams {
+			streamIDs = source.Streams()
+		}
+	}
+
+	for idx, streamID := range streamIDs {
+		streamMsg := msg
+		if idx < len(streamIDs)-1 {
+			streamMsg = msg.Clone()
+		}
+		if streamID != msg.GetStreamID() {
+			streamMsg.SetlStreamIDAsOriginal(streamID)
+		}
+		if err := core.Route(streamMsg, core.StreamRegistry.GetRouterOrFallback(streamID)); err != nil {
+			format.Logger.WithError(err).Error("Failed to route split message")
+		}
+	}
+}
+
+// setElement stores the element at index to the target of msg. Documents
+// that are not written as JSON are copied so that messages do not share
+// objects or arrays.
+func (format *JSONSplit) setElement(msg *core.Message, doc interface{}, elements []interface{}, index int, parsed bool) error {
+	result := elements[index]
+	if format.replace {
+		var err error
+		if result, err = format.path.set(doc, elements[index]); err != nil {
+			return err
+		}
+	}
+	if !parsed {
+		result = copyJSONValue(result)
+	}
+
+	if format.indexField != "" {
+		msg.GetMetadata().Set(format.indexField, int64(index))
+	}
+	return setJSONDocument(&format.SimpleFormatter, msg, result, parsed)
+}
@@ -0,0 +1,101 @@
+// Copyright 2015-2018 trivago N.V.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package format
+
+import (
+	"testing"
+
+	"github.com/trivago/gollum/core"
+	"github.com/trivago/tgo/ttesting"
+)
+
+type jsonSplitTestRouter struct {
+	core.SimpleRouter
+	messages []*core.Message
+}
+
+func (router *jsonSplitTestRouter) Enqueue(msg *core.Message) error {
+	router.messages = append(router.messages, msg)
+	return nil
+}
+
+func (router *jsonSplitTestRouter) Start() error {
+	return nil
+}
+
+func TestJSONSplit(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	streamID := core.StreamRegistry.GetStreamID("jsonSplitTest")
+	router := &jsonSplitTestRouter{}
+	core.StreamRegistry.Unregister(streamID)
+	core.StreamRegistry.Register(router, streamID)
+
+	config := core.NewPluginConfig("", "format.JSONSplit")
+	config.Override("Path", "$.events")
+	config.Override("IndexTo", "index")
+	plugin, err := core.NewPluginWithConfig(config)
+	expect.NoError(err)
+	formatter := plugin.(*JSONSplit)
+
+	msg := core.NewMessage(nil, []byte(`{"host":"a","events":[{"id":1},{"id":2},{"id":3}]}`), nil, streamID)
+	expect.NoError(formatter.ApplyFormatter(msg))
+	expect.Equal(`{"id":1}`, msg.String())
+	expect.MapEqual(msg.GetMetadata(), "index", int64(0))
+
+	expect.Equal(2, len(router.messages))
+	expect.Equal(`{"id":2}`, router.messages[0].String())
+	expect.Equal(`{"id":3}`, router.messages[1].String())
+	expect.MapEqual(router.messages[1].GetMetadata(), "index", int64(2))
+
+	// Messages without an array are not changed
+	msg = core.NewMessage(nil, []byte(`{"events":"none"}`), nil, streamID)
+	expect.NoError(formatter.ApplyFormatter(msg))
+	expect.Equal(`{"events":"none"}`, msg.String())
+	expect.Equal(2, len(router.messages))
+}
+
+func TestJSONSplitReplace(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	streamID := core.StreamRegistry.GetStreamID("jsonSplitReplaceTest")
+	router := &jsonSplitTestRouter{}
+	core.StreamRegistry.Unregister(streamID)
+	core.StreamRegistry.Register(router, streamID)
+
+	config := core.NewPluginConfig("", "format.JSONSplit")
+	config.Override("Path", "events")
+	config.Override("Replace", true)
+	plugin, err := core.NewPluginWithConfig(config)
+	expect.NoError(err)
+	formatter := plugin.(*JSONSplit)
+
+	msg := core.NewMessage(nil, []byte(`{"host":"a","events":["x","y"]}`), nil, streamID)
+	expect.NoError(formatter.ApplyFormatter(msg))
+	expect.Equal(`{"events":"x","host":"a"}`, msg.String())
+	expect.Equal(1, len(router.messages))
+	expect.Equal(`{"events":"y","host":"a"}`, router.messages[0].String())
+}
+
+func TestJSONSplitRoutesMessages(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	plugin, err := core.NewPluginWithConfig(core.NewPluginConfig("", "format.JSONSplit"))
+	expect.NoError(err)
+
+	modulator, isRouting := plugin.(core.RoutingModulator)
+	expect.True(isRouting)
+	expect.True(modulator.RoutesMessages())
+}
@@ -67,3 +67,28 @@ func TestFormatterRegExpTarget(t *testing.T) {
 	expect.Equal("PAYLOAD", string(msg.GetPayload()))
 	expect.Equal("test", string(foo))
 }
+
+func TestFormatterRegExpTemplateConfig(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	// Group references must not be taken for config variables
+	config, err := core.ReadConfig([]byte(`
+"regexpTest":
+  Type: format.RegExp
+  Expression: "^([0-9]+) ([a-z]+): "
+  Template: "time: ${1}, host: ${2}"
+`))
+	expect.NoError(err)
+
+	pluginConfig, exists := config.GetPluginConfig("regexpTest")
+	expect.True(exists)
+	plugin, err := core.NewPluginWithConfig(pluginConfig)
+	expect.NoError(err)
+
+	formatter, casted := plugin.(*RegExp)
+	expect.True(casted)
+
+	msg := core.NewMessage(nil, []byte("1500000000 gollum: test"), nil, core.InvalidStreamID)
+	expect.NoError(formatter.ApplyFormatter(msg))
+	expect.Equal("time: 1500000000, host: gollum", string(msg.GetPayload()))
+}
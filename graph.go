@@ -0,0 +1,358 @@
+// Copyright 2015-2018 trivago N.V.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/trivago/gollum/core"
+	"github.com/trivago/tgo/tos"
+)
+
+const (
+	graphFormatDOT  = "dot"
+	graphFormatJSON = "json"
+)
+
+// dynamicRoutingTypes lists plugins that choose the target stream at runtime.
+// These cannot be resolved statically, so the graph shows them as an edge to
+// a "dynamic" node.
+var dynamicRoutingTypes = map[string]bool{
+	"format.StreamRoute": true,
+	"router.Metadata":    true,
+}
+
+type pluginWithFilteredStream interface {
+	GetFilteredStreamID() core.MessageStreamID
+}
+
+type pluginWithTargetStreams interface {
+	GetTargetStreamIDs() []core.MessageStreamID
+}
+
+type graphNode struct {
+	ID        string `json:"id"`
+	Kind      string `json:"kind"`
+	Name      string `json:"name"`
+	Type      string `json:"type,omitempty"`
+	Generated bool   `json:"generated,omitempty"`
+}
+
+type graphEdge struct {
+	From  string `json:"from"`
+	To    string `json:"to"`
+	Label string `json:"label,omitempty"`
+}
+
+// pipelineGraph describes how messages flow between the configured plugins.
+// Streams are nodes of their own so that plugins can be connected without
+// knowing each other.
+type pipelineGraph struct {
+	Nodes []graphNode `json:"nodes"`
+	Edges []graphEdge `json:"edges"`
+	nodes map[string]bool
+	edges map[graphEdge]bool
+}
+
+// printGraph configures all plugins of the given config file and prints the
+// resulting stream topology in the given format.
+func printGraph(configFile string, format string) int {
+	format = strings.ToLower(format)
+	if format != graphFormatDOT && format != graphFormatJSON {
+		fmt.Printf("Unknown graph format \"%s\". Use \"%s\" or \"%s\".\n", format, graphFormatDOT, graphFormatJSON)
+		return tos.ExitError // ### return, unknown format ###
+	}
+
+	config := readConfig(configFile)
+	if config == nil {
+		return tos.ExitError // ### return, config failed to parse ###
+	}
+
+	// Plugins are only configured, never started. Suppress their log output.
+	logrus.SetLevel(logrus.ErrorLevel)
+
+	coordinator := NewCoordinator()
+	defer coordinator.Shutdown()
+
+	if err := coordinator.Configure(config); err != nil {
+		logrus.WithError(err).Error("Configure pass failed.")
+		return tos.ExitError // ### return, config failed to configure ###
+	}
+
+	graph := newPipelineGraph(&coordinator)
+	var err error
+	if format == graphFormatJSON {
+		err = graph.writeJSON(os.Stdout)
+	} else {
+		err = graph.writeDOT(os.Stdout)
+	}
+
+	if err != nil {
+		logrus.WithError(err).Error("Failed to write graph")
+		return tos.ExitError
+	}
+	return tos.ExitSuccess
+}
+
+// newPipelineGraph creates the graph of all plugins known to the given
+// coordinator. Routers are taken from the stream registry so that routers
+// generated for fallback streams and wildcard producers are included.
+func newPipelineGraph(co *Coordinator) *pipelineGraph {
+	graph := &pipelineGraph{
+		Nodes: []graphNode{},
+		Edges: []graphEdge{},
+		nodes: make(map[string]bool),
+		edges: make(map[graphEdge]bool),
+	}
+
+	for _, cons := range co.consumers {
+		consumerID := cons.GetID()
+		if cons == co.logConsumer {
+			// The internal log consumer is only shown if something listens to it
+			if !core.StreamRegistry.IsStreamRegistered(core.LogInternalStreamID) {
+				continue
+			}
+			consumerID = core.LogInternalStream
+		}
+		nodeID := graph.addPlugin("consumer", consumerID, cons)
+		for _, streamID := range getPluginStreams(cons) {
+			graph.addEdge(nodeID, graph.addStream(streamID), "")
+		}
+	}
+
+	for _, prod := range co.producers {
+		nodeID := graph.addPlugin("producer", prod.GetID(), prod)
+		if withFallback, hasFallback := prod.(pluginWithFallback); hasFallback {
+			if streamID := withFallback.GetFallbackStreamID(); streamID != core.InvalidStreamID {
+				graph.addEdge(nodeID, graph.addStream(streamID), "fallback")
+			}
+		}
+	}
+
+	core.StreamRegistry.ForEachStream(func(streamID core.MessageStreamID, router core.Router) {
+		if streamID == core.WildcardStreamID {
+			return // ### return, wildcard producers are shown per stream ###
+		}
+		streamNodeID := graph.addStream(streamID)
+		routerNodeID := graph.addPlugin("router", router.GetID(), router)
+		graph.addEdge(streamNodeID, routerNodeID, "")
+
+		if withTargets, hasTargets := router.(pluginWithTargetStreams); hasTargets {
+			for _, targetID := range withTargets.GetTargetStreamIDs() {
+				graph.addEdge(routerNodeID, graph.addStream(targetID), "")
+			}
+		}
+		if withProducers, hasProducers := router.(pluginWithProducers); hasProducers {
+			for _, prod := range withProducers.GetProducers() {
+				label := ""
+				if isWildcardProducer(prod) {
+					label = "wildcard"
+				}
+				graph.addEdge(routerNodeID, graph.addPlugin("producer", prod.GetID(), prod), label)
+			}
+		}
+	})
+
+	sort.Slice(graph.Nodes, func(i, j int) bool {
+		return graph.Nodes[i].ID < graph.Nodes[j].ID
+	})
+	sort.Slice(graph.Edges, func(i, j int) bool {
+		if graph.Edges[i].From != graph.Edges[j].From {
+			return graph.Edges[i].From < graph.Edges[j].From
+		}
+		return graph.Edges[i].To < graph.Edges[j].To
+	})
+	return graph
+}
+
+// addPlugin adds a plugin node including all edges caused by its filters
+// and modulators and returns the node id.
+func (graph *pipelineGraph) addPlugin(kind string, pluginID string, plugin interface{}) string {
+	nodeID := kind + ":" + pluginID
+	if graph.nodes[nodeID] {
+		return nodeID // ### return, already known ###
+	}
+
+	typeName := getPluginTypeName(plugin)
+	graph.addNode(graphNode{
+		ID:        nodeID,
+		Kind:      kind,
+		Name:      pluginID,
+		Type:      typeName,
+		Generated: strings.HasPrefix(pluginID, core.GeneratedRouterPrefix),
+	})
+
+	if dynamicRoutingTypes[typeName] {
+		graph.addEdge(nodeID, graph.addDynamic(), typeName)
+	}
+
+	if withModulators, hasModulators := plugin.(pluginWithModulators); hasModulators {
+		for _, modulator := range withModulators.GetModulators() {
+			graph.addModulator(nodeID, modulator)
+		}
+	}
+	if withFilters, hasFilters := plugin.(pluginWithFilters); hasFilters {
+		for _, filter := range withFilters.GetFilters() {
+			graph.addFilter(nodeID, filter)
+		}
+	}
+	return nodeID
+}
+
+func (graph *pipelineGraph) addModulator(nodeID string, modulator core.Modulator) {
+	switch wrapper := modulator.(type) {
+	case *core.FilterModulator:
+		graph.addFilter(nodeID, wrapper.Filter)
+	default:
+		if typeName := getModulatorTypeName(modulator); dynamicRoutingTypes[typeName] {
+			graph.addEdge(nodeID, graph.addDynamic(), typeName)
+		}
+	}
+}
+
+func (graph *pipelineGraph) addFilter(nodeID string, filter core.Filter) {
+	if withFiltered, hasFiltered := filter.(pluginWithFilteredStream); hasFiltered {
+		if streamID := withFiltered.GetFilteredStreamID(); streamID != core.InvalidStreamID {
+			graph.addEdge(nodeID, graph.addStream(streamID), "filtered")
+		}
+	}
+}
+
+func (graph *pipelineGraph) addStream(streamID core.MessageStreamID) string {
+	name := streamID.GetName()
+	nodeID := "stream:" + name
+	graph.addNode(graphNode{
+		ID:   nodeID,
+		Kind: "stream",
+		Name: name,
+	})
+	return nodeID
+}
+
+func (graph *pipelineGraph) addDynamic() string {
+	nodeID := "dynamic:*"
+	graph.addNode(graphNode{
+		ID:   nodeID,
+		Kind: "dynamic",
+		Name: "dynamic stream",
+	})
+	return nodeID
+}
+
+func (graph *pipelineGraph) addNode(node graphNode) {
+	if !graph.nodes[node.ID] {
+		graph.nodes[node.ID] = true
+		graph.Nodes = append(graph.Nodes, node)
+	}
+}
+
+func (graph *pipelineGraph) addEdge(from, to, label string) {
+	edge := graphEdge{From: from, To: to, Label: label}
+	if !graph.edges[edge] {
+		graph.edges[edge] = true
+		graph.Edges = append(graph.Edges, edge)
+	}
+}
+
+func (graph *pipelineGraph) writeJSON(out io.Writer) error {
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(graph)
+}
+
+// writeDOT writes the graph in the graphviz DOT format, e.g. to be rendered
+// via "gollum -c config.yaml -g dot | dot -Tpng -o pipeline.png".
+func (graph *pipelineGraph) writeDOT(out io.Writer) error {
+	dot := new(bytes.Buffer)
+	dot.WriteString("digraph gollum {\n\trankdir=LR;\n")
+
+	for _, node := range graph.Nodes {
+		label := node.Name
+		if node.Type != "" && !node.Generated {
+			label += "\\n" + node.Type
+		}
+
+		attributes := []string{fmt.Sprintf("label=%s", dotQuote(label))}
+		switch node.Kind {
+		case "consumer":
+			attributes = append(attributes, "shape=invhouse")
+		case "producer":
+			attributes = append(attributes, "shape=house")
+		case "router":
+			attributes = append(attributes, "shape=diamond")
+		case "stream":
+			attributes = append(attributes, "shape=ellipse")
+		case "dynamic":
+			attributes = append(attributes, "shape=ellipse", "style=dotted")
+		}
+		if node.Generated {
+			attributes = append(attributes, "style=dashed")
+		}
+		fmt.Fprintf(dot, "\t%s [%s];\n", dotQuote(node.ID), strings.Join(attributes, ", "))
+	}
+
+	for _, edge := range graph.Edges {
+		attributes := []string{}
+		if edge.Label != "" {
+			attributes = append(attributes, fmt.Sprintf("label=%s", dotQuote(edge.Label)))
+		}
+		switch edge.Label {
+		case "fallback", "filtered":
+			attributes = append(attributes, "style=dashed")
+		case "wildcard":
+			attributes = append(attributes, "style=dotted")
+		}
+
+		fmt.Fprintf(dot, "\t%s -> %s", dotQuote(edge.From), dotQuote(edge.To))
+		if len(attributes) > 0 {
+			fmt.Fprintf(dot, " [%s]", strings.Join(attributes, ", "))
+		}
+		dot.WriteString(";\n")
+	}
+
+	dot.WriteString("}\n")
+	_, err := dot.WriteTo(out)
+	return err
+}
+
+// getPluginStreams returns the streams a plugin is bound to or nil.
+func getPluginStreams(plugin interface{}) []core.MessageStreamID {
+	if withStreams, hasStreams := plugin.(pluginWithStreams); hasStreams {
+		return withStreams.Streams()
+	}
+	return nil
+}
+
+// isWildcardProducer returns true if the given producer listens to all
+// streams.
+func isWildcardProducer(prod core.Producer) bool {
+	for _, streamID := range getPluginStreams(prod) {
+		if streamID == core.WildcardStreamID {
+			return true
+		}
+	}
+	return false
+}
+
+func dotQuote(value string) string {
+	return "\"" + strings.Replace(value, "\"", "\\\"", -1) + "\""
+}
@@ -0,0 +1,119 @@
+// Copyright 2015-2018 trivago N.V.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/trivago/gollum/core"
+	"github.com/trivago/tgo/ttesting"
+)
+
+const graphTestDOT = `digraph gollum {
+	rankdir=LR;
+	"consumer:graphIn" [label="graphIn\nconsumer.Console", shape=invhouse];
+	"producer:graphOutB" [label="graphOutB\nproducer.Console", shape=house];
+	"producer:graphOutC" [label="graphOutC\nproducer.Console", shape=house];
+	"router:_GENERATED_graphB" [label="_GENERATED_graphB", shape=diamond, style=dashed];
+	"router:_GENERATED_graphC" [label="_GENERATED_graphC", shape=diamond, style=dashed];
+	"router:graphRoute" [label="graphRoute\nrouter.Distribute", shape=diamond];
+	"stream:graphA" [label="graphA", shape=ellipse];
+	"stream:graphB" [label="graphB", shape=ellipse];
+	"stream:graphC" [label="graphC", shape=ellipse];
+	"consumer:graphIn" -> "stream:graphA";
+	"producer:graphOutB" -> "stream:graphC" [label="fallback", style=dashed];
+	"router:_GENERATED_graphB" -> "producer:graphOutB";
+	"router:_GENERATED_graphC" -> "producer:graphOutC";
+	"router:graphRoute" -> "stream:graphB";
+	"router:graphRoute" -> "stream:graphC";
+	"stream:graphA" -> "router:graphRoute";
+	"stream:graphB" -> "router:_GENERATED_graphB";
+	"stream:graphC" -> "router:_GENERATED_graphC";
+}
+`
+
+const graphTestJSON = `{
+"nodes": [
+{"id": "consumer:graphIn", "kind": "consumer", "name": "graphIn", "type": "consumer.Console"},
+{"id": "producer:graphOutB", "kind": "producer", "name": "graphOutB", "type": "producer.Console"},
+{"id": "producer:graphOutC", "kind": "producer", "name": "graphOutC", "type": "producer.Console"},
+{"id": "router:_GENERATED_graphB", "kind": "router", "name": "_GENERATED_graphB", "type": "router.Broadcast", "generated": true},
+{"id": "router:_GENERATED_graphC", "kind": "router", "name": "_GENERATED_graphC", "type": "router.Broadcast", "generated": true},
+{"id": "router:graphRoute", "kind": "router", "name": "graphRoute", "type": "router.Distribute"},
+{"id": "stream:graphA", "kind": "stream", "name": "graphA"},
+{"id": "stream:graphB", "kind": "stream", "name": "graphB"},
+{"id": "stream:graphC", "kind": "stream", "name": "graphC"}
+],
+"edges": [
+{"from": "consumer:graphIn", "to": "stream:graphA"},
+{"from": "producer:graphOutB", "to": "stream:graphC", "label": "fallback"},
+{"from": "router:_GENERATED_graphB", "to": "producer:graphOutB"},
+{"from": "router:_GENERATED_graphC", "to": "producer:graphOutC"},
+{"from": "router:graphRoute", "to": "stream:graphB"},
+{"from": "router:graphRoute", "to": "stream:graphC"},
+{"from": "stream:graphA", "to": "router:graphRoute"},
+{"from": "stream:graphB", "to": "router:_GENERATED_graphB"},
+{"from": "stream:graphC", "to": "router:_GENERATED_graphC"}
+]
+}`
+
+func TestGraphExport(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	config, err := core.ReadConfig([]byte(`
+"graphIn": {Type: consumer.Console, Streams: graphA}
+"graphRoute": {Type: router.Distribute, Stream: graphA, TargetStreams: [graphB, graphC]}
+"graphOutB": {Type: producer.Console, Streams: graphB, FallbackStream: graphC}
+"graphOutC": {Type: producer.Console, Streams: graphC}
+`))
+	expect.NoError(err)
+
+	co := NewCoordinator()
+	defer co.Shutdown()
+	expect.NoError(co.Configure(config))
+
+	graph := newPipelineGraph(&co)
+	// Other tests share the global stream registry, so only keep our own nodes
+	keepGraphNodes(graph, "graph")
+
+	dot := new(bytes.Buffer)
+	expect.NoError(graph.writeDOT(dot))
+	expect.Equal(graphTestDOT, dot.String())
+
+	data := new(bytes.Buffer)
+	expect.NoError(graph.writeJSON(data))
+	expected := new(bytes.Buffer)
+	expect.NoError(json.Indent(expected, []byte(graphTestJSON), "", "  "))
+	expect.Equal(expected.String()+"\n", data.String())
+}
+
+func keepGraphNodes(graph *pipelineGraph, marker string) {
+	nodes := []graphNode{}
+	for _, node := range graph.Nodes {
+		if strings.Contains(node.ID, marker) {
+			nodes = append(nodes, node)
+		}
+	}
+	edges := []graphEdge{}
+	for _, edge := range graph.Edges {
+		if strings.Contains(edge.From, marker) && strings.Contains(edge.To, marker) {
+			edges = append(edges, edge)
+		}
+	}
+	graph.Nodes, graph.Edges = nodes, edges
+}
@@ -0,0 +1,96 @@
+// Copyright 2015-2018 trivago N.V.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/sirupsen/logrus"
+	"github.com/trivago/gollum/core"
+	"github.com/trivago/tgo/tos"
+)
+
+// lintCommand is the first commandline argument that switches gollum into
+// lint mode, e.g. "gollum lint -c config.yaml".
+const lintCommand = "lint"
+
+// isLintCommand returns true if gollum has been started in lint mode. The
+// command is removed from os.Args so that the remaining flags can be parsed.
+func isLintCommand() bool {
+	if len(os.Args) < 2 || os.Args[1] != lintCommand {
+		return false
+	}
+	os.Args = append(os.Args[:1], os.Args[2:]...)
+	return true
+}
+
+// lintConfig checks the given config file and prints all issues found.
+// Warnings do not cause the lint to fail.
+func lintConfig(configFile string) int {
+	if configFile == "" {
+		fmt.Println("Please provide a config file via -c")
+		return tos.ExitError // ### return, no config ###
+	}
+
+	// Plugins are configured during lint, so suppress their log output
+	logrus.SetLevel(logrus.ErrorLevel)
+	fmt.Println("Linting config", configFile)
+
+	config, err := core.ReadConfigFromFile(configFile)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return tos.ExitError // ### return, config cannot be read ###
+	}
+
+	numErrors, numWarnings := 0, 0
+	for _, issue := range config.Lint() {
+		if issue.IsWarning {
+			numWarnings++
+			fmt.Println("Warning:", issue)
+		} else {
+			numErrors++
+			fmt.Println("Error:", issue)
+		}
+	}
+
+	if numErrors > 0 {
+		fmt.Printf("Found %d errors and %d warnings.\n", numErrors, numWarnings)
+		return tos.ExitError
+	}
+
+	fmt.Printf("Config OK (%d warnings).\n", numWarnings)
+	return tos.ExitSuccess
+}
+
+// printSchema prints the configuration schema of all registered plugins as
+// JSON.
+func printSchema() int {
+	schemas := make(map[string]core.PluginSchema)
+	for _, pkg := range []string{"consumer", "producer", "filter", "format", "router", "contrib"} {
+		for typename, schema := range core.GetPluginSchemas(pkg + ".") {
+			schemas[typename] = schema
+		}
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(schemas); err != nil {
+		fmt.Println("Error:", err)
+		return tos.ExitError
+	}
+	return tos.ExitSuccess
+}
@@ -50,6 +50,11 @@ func main() {
 }
 
 func mainWithExitCode() int {
+	if isOffsetsCommand() {
+		return runOffsetsCommand(os.Args[2:]) // ### return, offsets only ###
+	}
+
+	lintMode := isLintCommand()
 	parseFlags()
 
 	if *flagHelp || len(os.Args) == 1 {
@@ -72,6 +77,18 @@ func mainWithExitCode() int {
 		return tos.ExitSuccess // ### return, modules only ###
 	}
 
+	if *flagSchema {
+		return printSchema() // ### return, schema only ###
+	}
+
+	if lintMode {
+		return lintConfig(*flagConfigFile) // ### return, lint only ###
+	}
+
+	if *flagGraph != "" {
+		return printGraph(*flagConfigFile, *flagGraph) // ### return, graph only ###
+	}
+
 	if stop := initLogrus(); stop != nil {
 		defer stop()
 	}
@@ -119,6 +136,10 @@ func mainWithExitCode() int {
 		defer stop()
 	}
 
+	if stop := startSpanExporter(); stop != nil {
+		defer stop()
+	}
+
 	coordinator := NewCoordinator()
 	defer coordinator.Shutdown()
 
@@ -127,6 +148,10 @@ func mainWithExitCode() int {
 		return tos.ExitError // ### exit, config failed to parse ###
 	}
 
+	if stop := startAdminService(&coordinator); stop != nil {
+		defer stop()
+	}
+
 	coordinator.StartPlugins()
 	coordinator.Run()
 	return tos.ExitSuccess
@@ -261,6 +286,24 @@ func startMetricsService() func() {
 	}
 }
 
+// startSpanExporter enables message spans if an OTLP endpoint is given.
+// The returned function should be deferred if not nil.
+func startSpanExporter() func() {
+	if *flagOTLPEndpoint == "" {
+		return nil
+	}
+
+	exporter, err := core.NewOTLPExporter(*flagOTLPEndpoint, "gollum", 10*time.Second)
+	if err != nil {
+		logrus.WithError(err).Error("Failed to parse OTLP endpoint")
+		return nil
+	}
+
+	logrus.WithField("endpoint", exporter.GetEndpoint()).Info("Exporting message spans")
+	core.ActivateMessageSpans(exporter)
+	return core.DeactivateMessageSpans
+}
+
 // startHealthCheckService creates a health check endpoint if requested.
 // The returned function should be deferred if not nil.
 func startHealthCheckService() func() {
@@ -0,0 +1,120 @@
+// Copyright 2015-2018 trivago N.V.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"github.com/trivago/gollum/core"
+	"github.com/trivago/tgo/tos"
+)
+
+// offsetsCommand is the first commandline argument that switches gollum into
+// offset database mode, e.g. "gollum offsets /var/lib/gollum/app.offsets".
+const offsetsCommand = "offsets"
+
+const offsetsUsage = "Usage: gollum offsets DATABASE [list|reset [PATH|KEY]...]"
+
+// isOffsetsCommand returns true if gollum has been started to inspect or
+// modify an offset database.
+func isOffsetsCommand() bool {
+	return len(os.Args) > 1 && os.Args[1] == offsetsCommand
+}
+
+// runOffsetsCommand lists or resets the entries of an offset database as
+// written by consumer.File. The database should not be modified while gollum
+// is running, as changes are overwritten by the next update.
+func runOffsetsCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Println(offsetsUsage)
+		return tos.ExitError // ### return, no database ###
+	}
+
+	if _, err := os.Stat(args[0]); err != nil {
+		fmt.Println("Error:", err)
+		return tos.ExitError // ### return, database does not exist ###
+	}
+
+	db, err := core.OpenOffsetDB(args[0])
+	if err != nil {
+		fmt.Println("Error:", err)
+		return tos.ExitError // ### return, database cannot be read ###
+	}
+
+	command := "list"
+	if len(args) > 1 {
+		command = args[1]
+	}
+
+	switch command {
+	case "list":
+		listOffsets(db)
+		return tos.ExitSuccess
+
+	case "reset":
+		return resetOffsets(db, args[2:])
+
+	default:
+		fmt.Println(offsetsUsage)
+		return tos.ExitError
+	}
+}
+
+func listOffsets(db *core.OffsetDB) {
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "PATH\tOFFSET\tDONE\tUPDATED\tKEY")
+
+	for _, key := range db.Keys() {
+		entry, _ := db.Get(key)
+		fmt.Fprintf(writer, "%s\t%d\t%t\t%s\t%s\n", entry.Path, entry.Offset, entry.Done, entry.Updated.Format(time.RFC3339), key)
+	}
+	writer.Flush()
+}
+
+// resetOffsets removes the entries matching the given paths or keys, or all
+// entries if none are given. Files without an entry are read according to
+// the consumer's DefaultOffset setting.
+func resetOffsets(db *core.OffsetDB, filter []string) int {
+	matches := func(key string, entry core.OffsetEntry) bool {
+		if len(filter) == 0 {
+			return true
+		}
+		for _, pathOrKey := range filter {
+			if absPath, err := filepath.Abs(pathOrKey); err == nil && absPath == entry.Path {
+				return true
+			}
+			if pathOrKey == key || pathOrKey == entry.Path {
+				return true
+			}
+		}
+		return false
+	}
+
+	removed := db.Cleanup(matches)
+	if err := db.Flush(); err != nil {
+		fmt.Println("Error:", err)
+		return tos.ExitError // ### return, database cannot be written ###
+	}
+
+	for _, key := range removed {
+		fmt.Println("Reset", key)
+	}
+	fmt.Printf("Reset %d offsets.\n", len(removed))
+	return tos.ExitSuccess
+}
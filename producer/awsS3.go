@@ -104,6 +104,7 @@ func init() {
 func (prod *AwsS3) Configure(conf core.PluginConfigReader) {
 	prod.SetRollCallback(prod.rotateTargetFiles)
 	prod.SetStopCallback(prod.close)
+	prod.EnableManualAcknowledge()
 
 	prod.filesByStream = make(map[core.MessageStreamID]*components.BatchedWriterAssembly)
 	prod.files = make(map[string]*components.BatchedWriterAssembly)
@@ -98,6 +98,7 @@ func (prod *File) Configure(conf core.PluginConfigReader) {
 
 	prod.SetRollCallback(prod.rotateLog)
 	prod.SetStopCallback(prod.close)
+	prod.EnableManualAcknowledge()
 
 	prod.filesByStream = make(map[core.MessageStreamID]*components.BatchedWriterAssembly)
 	prod.files = make(map[string]*components.BatchedWriterAssembly)
@@ -17,21 +17,37 @@ package producer
 import (
 	"bufio"
 	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
 	"sync"
+	"text/template"
+	"time"
 
 	"github.com/trivago/gollum/core"
+	"github.com/trivago/gollum/core/components"
 	"github.com/trivago/tgo/thealthcheck"
 )
 
+const (
+	httpBatchNone   = "none"
+	httpBatchNDJSON = "ndjson"
+	httpBatchJSON   = "json"
+)
+
+var errHTTPBatchFull = errors.New("message could not be added to the batch")
+
 // HTTPRequest producer
 //
 // The HTTPRequest producer sends messages as HTTP requests to a given webserver.
+// If message spans are exported (see the "-otlp" flag), the trace context is
+// passed on as "traceparent" request header.
 //
 // In RawData mode, incoming messages are expected to contain complete
 // HTTP requests in "wire format", such as:
@@ -50,20 +66,81 @@ import (
 // host and port components of the "Address" URL are used; any path and query
 // parameters are ignored. The "Encoding" parameter is ignored.
 //
-// If RawData mode is off, a POST request is made to the destination server
-// for each incoming message, using the complete URL in "Address". The
-// incoming message's contents are delivered in the POST request's body
-// and Content-type is set to the value of "Encoding"
+// If RawData mode is off, a request using "Method" is made to the destination
+// server for each incoming message, using the complete URL in "Address". The
+// incoming message's contents are delivered in the request's body and
+// Content-type is set to the value of "Encoding". If "Batch/Format" is set,
+// multiple messages are sent as one request instead.
+//
+// Connections are kept alive and reused between requests.
+//
+// Requests that fail with a network error, status 429 or a 5xx status are
+// retried with an exponential backoff. A "Retry-After" header sent by the
+// server overrides the backoff delay. Messages that could not be delivered are
+// sent to the fallback stream, or to the stream configured for the returned
+// status code in "FallbackStreams".
 //
 // Parameters
 //
 // - Address: defines the URL to send http requests to. If the value doesn't
 // contain "://",  it is prepended with "http://", so short forms like
-// "localhost:8088" are accepted. The default value is "http://localhost:80".
+// "localhost:8088" are accepted. The address may contain go template actions
+// that are resolved using the message metadata, e.g. "http://host/{{.tenant}}".
+// The default value is "http://localhost:80".
 //
 // - RawData: Turns "RawData" mode on. See the description above.
 //
 // - Encoding: Defines the payload encoding when RawData is set to false.
+// If Batch/Format is set and this parameter is not, the encoding matching
+// the batch format is used.
+//
+// - Method: Defines the HTTP method used when RawData is set to false.
+// By default this parameter is set to "POST".
+//
+// - Headers: Defines a map of additional headers that are set on every
+// request.
+// By default this parameter is set to an empty map.
+//
+// - TimeoutSec: Defines the maximum number of seconds a single request may
+// take, including reading the response.
+// By default this parameter is set to "30".
+//
+// - MaxIdleConnections: Defines the number of idle connections per host that
+// are kept open for reuse.
+// By default this parameter is set to "16".
+//
+// - IdleConnectionTimeoutSec: Defines the number of seconds after which an
+// idle connection is closed.
+// By default this parameter is set to "90".
+//
+// - MaxConcurrentRequests: Defines the maximum number of requests that are
+// sent in parallel when Batch/Format is not set.
+// By default this parameter is set to "32".
+//
+// - Retry/Count: Defines the number of retries for a failed request. Set to
+// "0" to disable retries.
+// By default this parameter is set to "3".
+//
+// - Retry/DelayMs: Defines the delay before the first retry in milliseconds.
+// The delay is doubled with every retry.
+// By default this parameter is set to "500".
+//
+// - Retry/MaxDelaySec: Defines the maximum delay between two retries in
+// seconds. This also limits delays requested via "Retry-After".
+// By default this parameter is set to "30".
+//
+// - FallbackStreams: Maps response status codes to the streams messages are
+// sent to if delivery failed with this status. Keys can be a status code like
+// "404", a status class like "4xx" or "error" for network errors. Exact status
+// codes take precedence over classes. Failures not matching any key are sent
+// to the fallback stream.
+// By default this parameter is set to an empty map.
+//
+// - Batch/Format: Enables batching if set to "ndjson" (one message per line)
+// or "json" (a JSON array of messages). Messages are expected to be valid
+// JSON in these modes. Messages are batched per target URL. Set to "none" to
+// send one request per message. Batching cannot be used with RawData.
+// By default this parameter is set to "none".
 //
 // Examples
 //
@@ -73,13 +150,63 @@ import (
 //    Address: "http://localhost:8099/test"
 //    RawData: true
 //
+// This example sends JSON messages in batches of up to 500 messages to a
+// tenant specific URL. Messages rejected with 400 are sent to "http_invalid",
+// all other failures go to "http_retry".
+//
+//  HttpOut02:
+//    Type: producer.HTTPRequest
+//    Streams: events
+//    FallbackStream: http_retry
+//    Address: "https://sink.example.com/{{.tenant}}/events"
+//    RawData: false
+//    Headers:
+//      Authorization: "Bearer s3cr3t"
+//    Batch:
+//      Format: ndjson
+//      MaxCount: 1000
+//      FlushCount: 500
+//      TimeoutSec: 1
+//    Retry:
+//      Count: 5
+//    FallbackStreams:
+//      "400": http_invalid
+//
 type HTTPRequest struct {
 	core.BufferedProducer `gollumdoc:"embed_type"`
+	// BatchConfig is public to make BatchedWriterConfig.Configure() callable
+	BatchConfig components.BatchedWriterConfig `gollumdoc:"embed_type"`
 
 	destinationURL *url.URL
-	encoding       string `config:"Encoding" default:"text/plain; charset=utf-8"`
-	rawPackets     bool   `config:"RawData" default:"true"`
+	urlTemplate    *template.Template
+	encoding       string        `config:"Encoding" default:"text/plain; charset=utf-8"`
+	rawPackets     bool          `config:"RawData" default:"true"`
+	method         string        `config:"Method" default:"POST"`
+	timeout        time.Duration `config:"TimeoutSec" default:"30" metric:"sec"`
+	maxIdleConns   int           `config:"MaxIdleConnections" default:"16"`
+	idleTimeout    time.Duration `config:"IdleConnectionTimeoutSec" default:"90" metric:"sec"`
+	maxConcurrent  int           `config:"MaxConcurrentRequests" default:"32"`
+	retryCount     int           `config:"Retry/Count" default:"3"`
+	retryDelay     time.Duration `config:"Retry/DelayMs" default:"500" metric:"ms"`
+	retryMaxDelay  time.Duration `config:"Retry/MaxDelaySec" default:"30" metric:"sec"`
+	batchFormat    string        `config:"Batch/Format" default:"none"`
+	headers        map[string]string
+	fallbacks      map[string]core.Router
+	client         *http.Client
+	requests       chan struct{}
+	batches        map[string]*components.BatchedWriterAssembly
+	batchGuard     *sync.RWMutex
 	lastError      error
+	lastErrorGuard sync.Mutex
+}
+
+// httpBatchWriter is the components.BatchedWriter used to send a batch of
+// messages as a single request.
+type httpBatchWriter struct {
+	prod   *HTTPRequest
+	url    string
+	status int
+	err    error
 }
 
 func init() {
@@ -90,15 +217,74 @@ func init() {
 func (prod *HTTPRequest) Configure(conf core.PluginConfigReader) {
 	var err error
 	prod.SetStopCallback(prod.close)
+	prod.EnableManualAcknowledge()
 
 	address := conf.GetString("Address", "http://localhost:80")
 
 	if !strings.Contains(address, "://") {
 		address = "http://" + address
 	}
+	if strings.Contains(address, "{{") {
+		prod.urlTemplate, err = template.New("Address").Parse(address)
+		conf.Errors.Push(err)
+		address = address[:strings.Index(address, "{{")]
+	}
 	prod.destinationURL, err = url.Parse(address)
 	conf.Errors.Push(err)
 
+	prod.method = strings.ToUpper(prod.method)
+	prod.headers = conf.GetStringMap("Headers", map[string]string{})
+
+	prod.batchFormat = strings.ToLower(prod.batchFormat)
+	switch prod.batchFormat {
+	case httpBatchNone:
+	case httpBatchNDJSON, httpBatchJSON:
+		if prod.rawPackets {
+			conf.Errors.Pushf("Batch/Format \"%s\" cannot be used in RawData mode", prod.batchFormat)
+		}
+		if !conf.HasValue("Encoding") {
+			prod.encoding = "application/json"
+			if prod.batchFormat == httpBatchNDJSON {
+				prod.encoding = "application/x-ndjson"
+			}
+		}
+		prod.batches = make(map[string]*components.BatchedWriterAssembly)
+		prod.batchGuard = new(sync.RWMutex)
+	default:
+		conf.Errors.Pushf("Unknown Batch/Format \"%s\"", prod.batchFormat)
+	}
+
+	prod.fallbacks = make(map[string]core.Router)
+	for key, value := range conf.GetStringMap("FallbackStreams", map[string]string{}) {
+		key = strings.ToLower(key)
+		if !isHTTPStatusPattern(key) {
+			conf.Errors.Pushf("FallbackStreams: \"%s\" is not a status code, status class or \"error\"", key)
+			continue
+		}
+		prod.fallbacks[key] = core.StreamRegistry.GetRouterOrFallback(core.GetStreamID(value))
+	}
+
+	if prod.maxConcurrent < 1 {
+		prod.maxConcurrent = 1
+	}
+	prod.requests = make(chan struct{}, prod.maxConcurrent)
+
+	prod.client = &http.Client{
+		Timeout: prod.timeout,
+		Transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			DialContext: (&net.Dialer{
+				Timeout:   prod.timeout,
+				KeepAlive: 30 * time.Second,
+			}).DialContext,
+			MaxIdleConns:          prod.maxIdleConns,
+			MaxIdleConnsPerHost:   prod.maxIdleConns,
+			IdleConnTimeout:       prod.idleTimeout,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ExpectContinueTimeout: 1 * time.Second,
+		},
+	}
+
 	// Default health check to ping the backend with an HTTP GET
 	prod.AddHealthCheck(prod.healthcheckPingBackend)
 
@@ -106,15 +292,47 @@ func (prod *HTTPRequest) Configure(conf core.PluginConfigReader) {
 	// TBD: This may be meaningless in a high-traffic environment; a statistics
 	// based check could make more sense.
 	prod.AddHealthCheckAt("/lastError", func() (int, string) {
-		if prod.lastError == nil {
+		lastError := prod.getLastError()
+		if lastError == nil {
 			return thealthcheck.StatusOK, "OK"
 		}
-		return thealthcheck.StatusServiceUnavailable, fmt.Sprintf("ERROR: %s", prod.lastError)
+		return thealthcheck.StatusServiceUnavailable, fmt.Sprintf("ERROR: %s", lastError)
 	})
 }
 
+// setLastError stores the result of the last request. Requests are sent
+// concurrently so access has to be synchronized.
+func (prod *HTTPRequest) setLastError(err error) {
+	prod.lastErrorGuard.Lock()
+	prod.lastError = err
+	prod.lastErrorGuard.Unlock()
+}
+
+// getLastError returns the result of the last request.
+func (prod *HTTPRequest) getLastError() error {
+	prod.lastErrorGuard.Lock()
+	defer prod.lastErrorGuard.Unlock()
+	return prod.lastError
+}
+
+// isHTTPStatusPattern returns true if the given key is a valid key for the
+// FallbackStreams setting.
+func isHTTPStatusPattern(key string) bool {
+	if key == "error" {
+		return true
+	}
+	if len(key) != 3 || key[0] < '1' || key[0] > '5' {
+		return false
+	}
+	if key[1:] == "xx" {
+		return true
+	}
+	_, err := strconv.Atoi(key)
+	return err == nil
+}
+
 func (prod *HTTPRequest) healthcheckPingBackend() (int, string) {
-	code, body, err := httpRequestWrapper(http.Get(prod.destinationURL.String()))
+	code, body, err := httpRequestWrapper(prod.client.Get(prod.destinationURL.String()))
 	if err != nil {
 		return code, strconv.Quote(err.Error())
 	}
@@ -132,6 +350,7 @@ func httpRequestWrapper(resp *http.Response, err error) (int, string, error) {
 		// Fail
 		return thealthcheck.StatusServiceUnavailable, "", err
 	}
+	defer resp.Body.Close()
 
 	respBody, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
@@ -149,67 +368,339 @@ func httpRequestWrapper(resp *http.Response, err error) (int, string, error) {
 }
 
 func (prod *HTTPRequest) isHostUp() bool {
-	resp, err := http.Get(prod.destinationURL.String())
-	return err != nil && resp != nil && resp.StatusCode < 400
+	resp, err := prod.client.Get(prod.destinationURL.String())
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode < 400
 }
 
-// The onMessage callback
-func (prod *HTTPRequest) sendReq(msg *core.Message) {
+// getURL returns the target URL for the given message.
+func (prod *HTTPRequest) getURL(msg *core.Message) (string, error) {
+	if prod.urlTemplate == nil {
+		return prod.destinationURL.String(), nil
+	}
+
+	targetURL := bytes.Buffer{}
+	if err := prod.urlTemplate.Execute(&targetURL, msg.TryGetMetadata()); err != nil {
+		return "", err
+	}
+	return targetURL.String(), nil
+}
+
+// newRequest creates a request without body. The body is set for every
+// attempt by send.
+func (prod *HTTPRequest) newRequest(targetURL string) (*http.Request, error) {
+	req, err := http.NewRequest(prod.method, targetURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-type", prod.encoding)
+	for key, value := range prod.headers {
+		req.Header.Set(key, value)
+	}
+	return req, nil
+}
+
+// newMessageRequest creates the request and the request body for a single
+// message.
+func (prod *HTTPRequest) newMessageRequest(msg *core.Message) (*http.Request, []byte, error) {
 	var (
-		req *http.Request
-		err error
+		req  *http.Request
+		body []byte
+		err  error
 	)
 
-	requestData := bytes.NewBuffer(msg.GetPayload())
-
 	if prod.rawPackets {
 		// Assume the message already contains an HTTP request in wire format.
 		// Create a Request object, override host, port and scheme, and send it out.
-		req, err = http.ReadRequest(bufio.NewReader(requestData))
-		if req != nil {
-			req.URL.Host = prod.destinationURL.Host
-			req.URL.Scheme = prod.destinationURL.Scheme
-			req.RequestURI = ""
+		req, err = http.ReadRequest(bufio.NewReader(bytes.NewBuffer(msg.GetPayload())))
+		if err != nil {
+			return nil, nil, err
+		}
+		if body, err = ioutil.ReadAll(req.Body); err != nil {
+			return nil, nil, err
+		}
+		req.URL.Host = prod.destinationURL.Host
+		req.URL.Scheme = prod.destinationURL.Scheme
+		req.RequestURI = ""
+		for key, value := range prod.headers {
+			req.Header.Set(key, value)
 		}
 	} else {
-		// Encapsulate the message in a POST request
-		req, err = http.NewRequest("POST", prod.destinationURL.String(), requestData)
-		if req != nil {
-			req.Header.Add("Content-type", prod.encoding)
+		targetURL, err := prod.getURL(msg)
+		if err != nil {
+			return nil, nil, err
+		}
+		if req, err = prod.newRequest(targetURL); err != nil {
+			return nil, nil, err
+		}
+		body = msg.GetPayload()
+	}
+
+	if traceCtx := msg.GetTraceContext(); traceCtx.IsValid() {
+		req.Header.Set(core.TraceParentMetadataKey, traceCtx.TraceParent())
+	}
+	return req, body, nil
+}
+
+// send sends the given request and retries it on network errors, status 429
+// and 5xx status codes. The status code of the last attempt is returned or 0
+// if the last attempt failed without a response.
+func (prod *HTTPRequest) send(req *http.Request, body []byte) (int, error) {
+	for attempt := 0; ; attempt++ {
+		status, retryAfter := 0, time.Duration(0)
+		resp, err := prod.sendAttempt(req, body)
+		if err == nil {
+			status = resp.StatusCode
+			retryAfter = parseRetryAfter(resp.Header.Get("Retry-After"))
+
+			// Drain the body so that the connection can be reused
+			io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64<<10))
+			resp.Body.Close()
+
+			if status >= 200 && status < 300 {
+				return status, nil // ### return, success ###
+			}
+			err = fmt.Errorf("%d %s", status, http.StatusText(status))
+		}
+
+		retryable := status == 0 || status == http.StatusTooManyRequests || status >= 500
+		if !retryable || attempt >= prod.retryCount {
+			return status, err // ### return, failed ###
+		}
+
+		delay := retryAfter
+		if delay <= 0 {
+			delay = prod.retryDelay << uint(attempt)
 		}
+		if delay > prod.retryMaxDelay || delay < 0 {
+			delay = prod.retryMaxDelay
+		}
+
+		prod.Logger.WithError(err).Warningf("Request failed, retrying in %v", delay)
+		time.Sleep(delay)
 	}
+}
 
+// sendAttempt sends a copy of the given request with the given body. A new
+// request is created for every attempt as requests cannot be sent twice.
+func (prod *HTTPRequest) sendAttempt(req *http.Request, body []byte) (*http.Response, error) {
+	attemptReq, err := http.NewRequest(req.Method, req.URL.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range req.Header {
+		attemptReq.Header[key] = values
+	}
+	attemptReq.Host = req.Host
+	return prod.client.Do(attemptReq)
+}
+
+// parseRetryAfter parses the value of a Retry-After header. Both, seconds and
+// HTTP dates are supported. If the header is not set or invalid, 0 is returned.
+func parseRetryAfter(value string) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return time.Until(date)
+	}
+	return 0
+}
+
+// tryFallback sends a message to the fallback stream configured for the
+// given status or to the default fallback stream.
+func (prod *HTTPRequest) tryFallback(msg *core.Message, status int, err error) {
+	key := "error"
+	if status > 0 {
+		key = strconv.Itoa(status)
+	}
+
+	router, found := prod.fallbacks[key]
+	if !found && status > 0 {
+		router, found = prod.fallbacks[key[:1]+"xx"]
+	}
+
+	if found {
+		prod.TryFallbackToRouter(msg, router, err)
+	} else {
+		prod.TryFallbackWithError(msg, err)
+	}
+}
+
+// The onMessage callback
+func (prod *HTTPRequest) sendReq(msg *core.Message) {
+	req, body, err := prod.newMessageRequest(msg)
 	if err != nil {
 		prod.Logger.Error("Invalid request: ", err)
-		prod.TryFallback(msg)
-		prod.lastError = err
+		prod.TryFallbackWithError(msg, err)
+		prod.setLastError(err)
 		return // ### return, malformed request ###
 	}
 
+	prod.requests <- struct{}{}
 	go func() {
-		_, _, err := httpRequestWrapper(http.DefaultClient.Do(req))
-		prod.lastError = err
+		defer func() { <-prod.requests }()
+
+		status, err := prod.send(req, body)
+		prod.setLastError(err)
 		if err != nil {
 			// Fail
 			prod.Logger.WithError(err).Error("Send failed")
-			if !prod.isHostUp() {
+			if status == 0 && !prod.isHostUp() {
 				prod.Logger.Error("Host is down")
 			}
-			prod.TryFallback(msg)
+			prod.tryFallback(msg, status, err)
 			return
 		}
 		// Success
-		// TBD: health check? (ex-fuse breaker)
+		msg.Ack()
 	}()
 }
 
+// getBatch returns the batch for the given target URL.
+func (prod *HTTPRequest) getBatch(targetURL string) *components.BatchedWriterAssembly {
+	prod.batchGuard.RLock()
+	batch, exists := prod.batches[targetURL]
+	prod.batchGuard.RUnlock()
+	if exists {
+		return batch // ### return, batch exists ###
+	}
+
+	prod.batchGuard.Lock()
+	defer prod.batchGuard.Unlock()
+
+	// check again to avoid race conditions
+	if batch, exists = prod.batches[targetURL]; exists {
+		return batch // ### return, batch exists ###
+	}
+
+	writer := &httpBatchWriter{prod: prod, url: targetURL}
+	batch = components.NewBatchedWriterAssembly(
+		prod.BatchConfig,
+		prod,
+		func(msg *core.Message) { prod.tryFallback(msg, writer.status, writer.err) },
+		prod.Logger,
+	)
+
+	delimiter := []byte{'\n'}
+	if prod.batchFormat == httpBatchJSON {
+		delimiter = []byte{','}
+	}
+	batch.SetDelimiter(delimiter)
+	batch.SetErrorHandler(func(err error) bool {
+		prod.Logger.WithError(err).Errorf("Failed to send batch to %s", targetURL)
+		return false
+	})
+	batch.SetWriter(writer)
+
+	prod.batches[targetURL] = batch
+	return batch
+}
+
+// The onMessage callback used in batch mode
+func (prod *HTTPRequest) batchMessage(msg *core.Message) {
+	targetURL, err := prod.getURL(msg)
+	if err != nil {
+		prod.Logger.Error("Invalid request: ", err)
+		prod.TryFallbackWithError(msg, err)
+		prod.setLastError(err)
+		return // ### return, malformed request ###
+	}
+
+	batch := prod.getBatch(targetURL)
+	batch.Batch.AppendOrFlush(msg, batch.Flush, prod.IsActiveOrStopping,
+		func(msg *core.Message) { prod.tryFallback(msg, 0, errHTTPBatchFull) })
+}
+
+func (prod *HTTPRequest) flushBatchesOnTimeOut() {
+	prod.batchGuard.RLock()
+	defer prod.batchGuard.RUnlock()
+
+	for _, batch := range prod.batches {
+		batch.FlushOnTimeOut()
+	}
+}
+
+// Write sends the given batch as a single request.
+func (writer *httpBatchWriter) Write(data []byte) (int, error) {
+	prod := writer.prod
+	writer.status, writer.err = 0, nil
+
+	req, err := prod.newRequest(writer.url)
+	if err != nil {
+		writer.err = err
+		return 0, err
+	}
+
+	body := data
+	switch prod.batchFormat {
+	case httpBatchNDJSON:
+		body = append(body, '\n')
+	case httpBatchJSON:
+		body = make([]byte, 0, len(data)+2)
+		body = append(append(append(body, '['), data...), ']')
+	}
+
+	writer.status, writer.err = prod.send(req, body)
+	prod.setLastError(writer.err)
+	if writer.err != nil {
+		return 0, writer.err
+	}
+	return len(data), nil
+}
+
+// Close is part of the components.BatchedWriter interface.
+func (writer *httpBatchWriter) Close() error {
+	return nil
+}
+
+// Name returns the URL this writer sends to.
+func (writer *httpBatchWriter) Name() string {
+	return writer.url
+}
+
+// Size is part of the components.BatchedWriter interface and always returns 0.
+func (writer *httpBatchWriter) Size() int64 {
+	return 0
+}
+
+// IsAccessible is part of the components.BatchedWriter interface and always
+// returns true.
+func (writer *httpBatchWriter) IsAccessible() bool {
+	return true
+}
+
 func (prod *HTTPRequest) close() {
 	defer prod.WorkerDone()
 	prod.DefaultClose()
+
+	if prod.batches != nil {
+		prod.batchGuard.Lock()
+		for _, batch := range prod.batches {
+			batch.Close()
+		}
+		prod.batchGuard.Unlock()
+	}
+
+	// Wait for running requests
+	for i := 0; i < cap(prod.requests); i++ {
+		prod.requests <- struct{}{}
+	}
 }
 
-// Produce writes to stdout or stderr.
+// Produce sends messages as HTTP requests.
 func (prod *HTTPRequest) Produce(workers *sync.WaitGroup) {
 	prod.AddMainWorker(workers)
-	prod.MessageControlLoop(prod.sendReq)
+	if prod.batches != nil {
+		prod.TickerMessageControlLoop(prod.batchMessage, prod.BatchConfig.BatchTimeout, prod.flushBatchesOnTimeOut)
+	} else {
+		prod.MessageControlLoop(prod.sendReq)
+	}
 }
@@ -0,0 +1,115 @@
+// Copyright 2015-2018 trivago N.V.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package producer
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/trivago/gollum/core"
+	"github.com/trivago/tgo/ttesting"
+)
+
+func TestHTTPRequestRetry(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	var requestCount int32
+	server := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		switch atomic.AddInt32(&requestCount, 1) {
+		case 1:
+			resp.WriteHeader(http.StatusServiceUnavailable)
+		case 2:
+			resp.Header().Set("Retry-After", "0")
+			resp.WriteHeader(http.StatusTooManyRequests)
+		case 3:
+			body, _ := ioutil.ReadAll(req.Body)
+			expect.Equal("payload", string(body))
+			resp.WriteHeader(http.StatusOK)
+		default:
+			resp.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	conf := core.NewPluginConfig("httpRequestRetry", "producer.HTTPRequest")
+	conf.Override("Address", server.URL)
+	conf.Override("RawData", false)
+	conf.Override("Retry/DelayMs", 1)
+	plugin, err := core.NewPluginWithConfig(conf)
+	expect.NoError(err)
+
+	prod, casted := plugin.(*HTTPRequest)
+	expect.True(casted)
+
+	req, err := prod.newRequest(server.URL)
+	expect.NoError(err)
+	status, err := prod.send(req, []byte("payload"))
+	expect.NoError(err)
+	expect.Equal(http.StatusOK, status)
+	expect.Equal(int32(3), atomic.LoadInt32(&requestCount))
+
+	// 4xx responses are not retried
+	status, err = prod.send(req, []byte("payload"))
+	expect.NotNil(err)
+	expect.Equal(http.StatusBadRequest, status)
+	expect.Equal(int32(4), atomic.LoadInt32(&requestCount))
+}
+
+func TestHTTPRequestBatchWriter(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	bodies := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		bodies <- req.Header.Get("Content-Type") + " " + string(body)
+	}))
+	defer server.Close()
+
+	conf := core.NewPluginConfig("httpRequestBatch", "producer.HTTPRequest")
+	conf.Override("Address", server.URL+"/{{.tenant}}")
+	conf.Override("RawData", false)
+	conf.Override("Batch/Format", "json")
+	plugin, err := core.NewPluginWithConfig(conf)
+	expect.NoError(err)
+
+	prod, casted := plugin.(*HTTPRequest)
+	expect.True(casted)
+
+	msg := core.NewMessage(nil, []byte("{}"), nil, core.InvalidStreamID)
+	msg.GetMetadata().Set("tenant", "a")
+	targetURL, err := prod.getURL(msg)
+	expect.NoError(err)
+	expect.Equal(server.URL+"/a", targetURL)
+
+	writer := &httpBatchWriter{prod: prod, url: targetURL}
+	_, err = writer.Write([]byte("{\"a\":1},{\"b\":2}"))
+	expect.NoError(err)
+	expect.Equal("application/json [{\"a\":1},{\"b\":2}]", <-bodies)
+}
+
+func TestHTTPRequestParseRetryAfter(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	expect.Equal(time.Duration(0), parseRetryAfter(""))
+	expect.Equal(time.Duration(0), parseRetryAfter("soon"))
+	expect.Equal(3*time.Second, parseRetryAfter("3"))
+
+	delay := parseRetryAfter(time.Now().Add(time.Minute).UTC().Format(http.TimeFormat))
+	expect.True(delay > 50*time.Second)
+}
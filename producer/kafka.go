@@ -17,6 +17,7 @@ package producer
 import (
 	"crypto/tls"
 	"crypto/x509"
+	"fmt"
 	"io/ioutil"
 	"strconv"
 	"strings"
@@ -42,6 +43,8 @@ const (
 // This producer writes messages to a kafka cluster. This producer is backed by
 // the sarama library (https://github.com/Shopify/sarama) so most settings
 // directly relate to the settings of that library.
+// If message spans are exported (see the "-otlp" flag) and Version is at least
+// 0.11, the trace context is passed on as "traceparent" record header.
 //
 // Parameters
 //
@@ -75,6 +78,23 @@ const (
 // the key passed to kafka. When set to an empty string no key is used.
 // By default this parameter is set to "".
 //
+// - HeadersFrom: Defines a list of metadata fields that are passed to kafka as
+// record headers. The name of the metadata field is used as header name after
+// removing HeaderPrefix. Fields that are not set are skipped. This setting
+// requires Kafka version >= 0.11.
+// By default this parameter is set to an empty list.
+//
+// - HeaderPrefix: Defines a prefix that is removed from the metadata fields
+// listed in HeadersFrom to get the header name. Set this to the HeaderPrefix
+// of consumer.Kafka to pass record headers read by a consumer unchanged.
+// By default this parameter is set to "".
+//
+// - TimestampFrom: Defines the metadata field that contains the timestamp of
+// the record. The field may contain milliseconds since epoch or an RFC3339
+// formatted string. If this field is empty or not set, the creation time of
+// the message is used. Record timestamps require Kafka version >= 0.10.
+// By default this parameter is set to "".
+//
 // - Compression: Defines the compression algorithm to use.
 // Possible values are "none", "zip" and "snappy".
 // By default this parameter is set to "none".
@@ -205,8 +225,11 @@ type Kafka struct {
 	client                kafka.Client
 	config                *kafka.Config
 	producer              kafka.AsyncProducer
-	nilValueAllowed       bool   `config:"AllowNilValue" default:"false"`
-	keyField              string `config:"KeyFrom"`
+	nilValueAllowed       bool     `config:"AllowNilValue" default:"false"`
+	keyField              string   `config:"KeyFrom"`
+	headerFields          []string `config:"HeadersFrom"`
+	headerPrefix          string   `config:"HeaderPrefix" default:""`
+	timestampField        string   `config:"TimestampFrom"`
 	metricsRegistry       metrics.Registry
 }
 
@@ -226,6 +249,7 @@ func init() {
 // Configure initializes this producer with values from a plugin config.
 func (prod *Kafka) Configure(conf core.PluginConfigReader) {
 	prod.SetStopCallback(prod.close)
+	prod.EnableManualAcknowledge()
 
 	kafka.Logger = prod.Logger.WithField("Scope", "Sarama")
 
@@ -252,6 +276,16 @@ func (prod *Kafka) Configure(conf core.PluginConfigReader) {
 		prod.config.Version = kafka.V0_9_0_1
 	case "0.10", "0.10.0", "0.10.0.0":
 		prod.config.Version = kafka.V0_10_0_0
+	case "0.10.0.1":
+		prod.config.Version = kafka.V0_10_0_1
+	case "0.10.1", "0.10.1.0":
+		prod.config.Version = kafka.V0_10_1_0
+	case "0.10.2", "0.10.2.0":
+		prod.config.Version = kafka.V0_10_2_0
+	case "0.11", "0.11.0", "0.11.0.0":
+		prod.config.Version = kafka.V0_11_0_0
+	case "1", "1.0", "1.0.0", "1.0.0.0":
+		prod.config.Version = kafka.V1_0_0_0
 	default:
 		prod.Logger.Warning("Unknown kafka version given: ", ver)
 		parts := strings.Split(ver, ".")
@@ -270,6 +304,10 @@ func (prod *Kafka) Configure(conf core.PluginConfigReader) {
 		}
 	}
 
+	if len(prod.headerFields) > 0 && !prod.config.Version.IsAtLeast(kafka.V0_11_0_0) {
+		prod.Logger.Warning("HeadersFrom requires kafka version >= 0.11. Headers will not be sent.")
+	}
+
 	prod.config.Net.MaxOpenRequests = int(conf.GetInt("MaxOpenRequests", 5))
 	prod.config.Net.DialTimeout = time.Duration(int(conf.GetInt("ServerTimeoutSec", 30))) * time.Second
 	prod.config.Net.ReadTimeout = prod.config.Net.DialTimeout
@@ -375,9 +413,13 @@ func (prod *Kafka) Configure(conf core.PluginConfigReader) {
 
 func (prod *Kafka) onMsgReturned(msg *core.Message) {
 	prod.topicGuard.RLock()
-	topic := prod.topic[msg.GetStreamID()]
+	topic, isRegistered := prod.topic[msg.GetStreamID()]
 	prod.topicGuard.RUnlock()
 
+	if !isRegistered {
+		return // ### return, unknown topic ###
+	}
+
 	topic.metricsRoundtrip.UpdateSince(msg.GetCreationTime())
 	topic.metricsDelivered.Inc(1)
 }
@@ -390,21 +432,23 @@ func (prod *Kafka) pollResults() {
 		select {
 		case result, hasMore := <-prod.producer.Successes():
 			if hasMore {
-				if msg, hasMsg := result.Metadata.(core.Message); hasMsg {
-					prod.onMsgReturned(&msg)
+				if msg, hasMsg := result.Metadata.(*core.Message); hasMsg {
+					prod.onMsgReturned(msg)
+					msg.Ack()
 				}
 			}
 
 		case err, hasMore := <-prod.producer.Errors():
 			if hasMore {
-				if msg, hasMsg := err.Msg.Metadata.(core.Message); hasMsg {
+				if msg, hasMsg := err.Msg.Metadata.(*core.Message); hasMsg {
 					prod.Logger.WithError(err).Warning("Kafka producer error on return: ")
-					prod.onMsgReturned(&msg)
-					if err == kafka.ErrMessageTooLarge {
+					prod.onMsgReturned(msg)
+					if err.Err == kafka.ErrMessageTooLarge {
 						prod.Logger.Error("Message discarded as too large.")
 						core.MetricMessagesDiscarded.Inc(1)
+						msg.Nack()
 					} else {
-						prod.TryFallback(&msg)
+						prod.TryFallbackWithError(msg, err.Err)
 					}
 				}
 			}
@@ -448,6 +492,7 @@ func (prod *Kafka) produceMessage(msg *core.Message) {
 		streamName := core.StreamRegistry.GetStreamName(msg.GetStreamID())
 		prod.Logger.Errorf("0 byte message detected on %s. Discarded", streamName)
 		core.MetricMessagesDiscarded.Inc(1)
+		msg.Ack()
 		return // ### return, invalid data ###
 	}
 
@@ -467,7 +512,7 @@ func (prod *Kafka) produceMessage(msg *core.Message) {
 	}
 
 	if isConnected, err := prod.isConnected(topic.name); !isConnected {
-		prod.TryFallback(msg)
+		prod.TryFallbackWithError(msg, fmt.Errorf("topic %s is not connected", topic.name))
 		if err != nil {
 			prod.Logger.WithError(err).Errorf("Topic %s is not connected", topic.name)
 		}
@@ -478,7 +523,15 @@ func (prod *Kafka) produceMessage(msg *core.Message) {
 	kafkaMsg := &kafka.ProducerMessage{
 		Topic:    topic.name,
 		Value:    kafka.ByteEncoder(msg.GetPayload()),
-		Metadata: &msg,
+		Metadata: msg,
+	}
+
+	if prod.config.Version.IsAtLeast(kafka.V0_10_0_0) {
+		kafkaMsg.Timestamp = prod.getKafkaMsgTimestamp(msg)
+	}
+
+	if prod.config.Version.IsAtLeast(kafka.V0_11_0_0) {
+		kafkaMsg.Headers = prod.getKafkaMsgHeaders(msg)
 	}
 
 	kafkaKey := prod.getKafkaMsgKey(msg)
@@ -496,11 +549,65 @@ func (prod *Kafka) produceMessage(msg *core.Message) {
 
 	case <-timeout.C:
 		// Sarama channels are full -> fallback
-		prod.TryFallback(msg)
+		prod.TryFallbackWithError(msg, fmt.Errorf("timeout while sending to topic %s", topic.name))
 		topic.metricsTimeout.Inc(1)
 	}
 }
 
+func (prod *Kafka) getKafkaMsgTimestamp(msg *core.Message) time.Time {
+	if len(prod.timestampField) > 0 {
+		if metadata := msg.TryGetMetadata(); metadata != nil {
+			if millis, err := metadata.Int(prod.timestampField); err == nil {
+				return time.Unix(0, millis*int64(time.Millisecond))
+			}
+			if value, err := metadata.String(prod.timestampField); err == nil {
+				if timestamp, err := time.Parse(time.RFC3339Nano, value); err == nil {
+					return timestamp
+				}
+			}
+		}
+	}
+
+	return msg.GetCreationTime()
+}
+
+func (prod *Kafka) getKafkaMsgHeaders(msg *core.Message) []kafka.RecordHeader {
+	var headers []kafka.RecordHeader
+	traceCtx := msg.GetTraceContext()
+	if traceCtx.IsValid() {
+		headers = append(headers, kafka.RecordHeader{
+			Key:   []byte(core.TraceParentMetadataKey),
+			Value: []byte(traceCtx.TraceParent()),
+		})
+	}
+
+	if len(prod.headerFields) == 0 {
+		return headers // ### return, no headers configured ###
+	}
+
+	metadata := msg.TryGetMetadata()
+	if metadata == nil {
+		return headers // ### return, no metadata ###
+	}
+
+	for _, field := range prod.headerFields {
+		name := field
+		if len(field) > len(prod.headerPrefix) {
+			name = strings.TrimPrefix(field, prod.headerPrefix)
+		}
+		if name == core.TraceParentMetadataKey && traceCtx.IsValid() {
+			continue // already set from the trace context
+		}
+		if value, isSet := metadata.Value(field); isSet {
+			headers = append(headers, kafka.RecordHeader{
+				Key:   []byte(name),
+				Value: core.ConvertToBytes(value),
+			})
+		}
+	}
+	return headers
+}
+
 func (prod *Kafka) getKafkaMsgKey(msg *core.Message) []byte {
 	if len(prod.keyField) > 0 {
 		if metadata := msg.TryGetMetadata(); metadata != nil {
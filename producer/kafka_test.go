@@ -0,0 +1,113 @@
+// Copyright 2015-2018 trivago N.V.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package producer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/trivago/gollum/core"
+	"github.com/trivago/tgo/tcontainer"
+	"github.com/trivago/tgo/ttesting"
+)
+
+func TestKafkaMsgHeaders(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+	conf := core.NewPluginConfig("", "producer.Kafka")
+
+	conf.Override("Servers", []string{"localhost:9092"})
+	conf.Override("Version", "0.11")
+	conf.Override("HeadersFrom", []string{"schema", "trace", "missing"})
+	plugin, err := core.NewPluginWithConfig(conf)
+	expect.NoError(err)
+
+	prod, casted := plugin.(*Kafka)
+	expect.True(casted)
+
+	metadata := tcontainer.MarshalMap{
+		"schema": "42",
+		"trace":  []byte("abc"),
+		"other":  "ignored",
+	}
+	msg := core.NewMessage(nil, []byte("test"), metadata, core.InvalidStreamID)
+
+	headers := prod.getKafkaMsgHeaders(msg)
+	expect.Equal(2, len(headers))
+	expect.Equal("schema", string(headers[0].Key))
+	expect.Equal("42", string(headers[0].Value))
+	expect.Equal("trace", string(headers[1].Key))
+	expect.Equal("abc", string(headers[1].Value))
+
+	msg = core.NewMessage(nil, []byte("test"), nil, core.InvalidStreamID)
+	expect.Equal(0, len(prod.getKafkaMsgHeaders(msg)))
+}
+
+func TestKafkaMsgHeaderPrefix(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+	conf := core.NewPluginConfig("", "producer.Kafka")
+
+	conf.Override("Servers", []string{"localhost:9092"})
+	conf.Override("Version", "0.11")
+	conf.Override("HeadersFrom", []string{"header_schema", "header_traceparent", "header_", "host"})
+	conf.Override("HeaderPrefix", "header_")
+	plugin, err := core.NewPluginWithConfig(conf)
+	expect.NoError(err)
+
+	prod, casted := plugin.(*Kafka)
+	expect.True(casted)
+
+	// Metadata as written by consumer.Kafka with the default HeaderPrefix
+	metadata := tcontainer.MarshalMap{
+		"header_schema":      "42",
+		"header_traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"header_":            "empty",
+		"host":               "gollum",
+	}
+	msg := core.NewMessage(nil, []byte("test"), metadata, core.InvalidStreamID)
+
+	headers := prod.getKafkaMsgHeaders(msg)
+	expect.Equal(4, len(headers))
+	expect.Equal("schema", string(headers[0].Key))
+	expect.Equal("42", string(headers[0].Value))
+	expect.Equal("traceparent", string(headers[1].Key))
+	expect.Equal("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", string(headers[1].Value))
+	expect.Equal("header_", string(headers[2].Key))
+	expect.Equal("host", string(headers[3].Key))
+}
+
+func TestKafkaMsgTimestamp(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+	conf := core.NewPluginConfig("", "producer.Kafka")
+
+	conf.Override("Servers", []string{"localhost:9092"})
+	conf.Override("Version", "0.11")
+	conf.Override("TimestampFrom", "ts")
+	plugin, err := core.NewPluginWithConfig(conf)
+	expect.NoError(err)
+
+	prod, casted := plugin.(*Kafka)
+	expect.True(casted)
+
+	metadata := tcontainer.MarshalMap{"ts": int64(1500000000123)}
+	msg := core.NewMessage(nil, []byte("test"), metadata, core.InvalidStreamID)
+	expect.Equal(int64(1500000000123), prod.getKafkaMsgTimestamp(msg).UnixNano()/int64(time.Millisecond))
+
+	metadata = tcontainer.MarshalMap{"ts": "2017-07-14T02:40:00Z"}
+	msg = core.NewMessage(nil, []byte("test"), metadata, core.InvalidStreamID)
+	expect.Equal(int64(1500000000), prod.getKafkaMsgTimestamp(msg).Unix())
+
+	msg = core.NewMessage(nil, []byte("test"), nil, core.InvalidStreamID)
+	expect.Equal(msg.GetCreationTime(), prod.getKafkaMsgTimestamp(msg))
+}
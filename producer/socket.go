@@ -20,6 +20,7 @@ import (
 	"time"
 
 	"github.com/trivago/gollum/core"
+	"github.com/trivago/gollum/core/components"
 	"github.com/trivago/tgo/tmath"
 	"github.com/trivago/tgo/tnet"
 )
@@ -27,7 +28,10 @@ import (
 // Socket producer plugin
 //
 // The socket producer connects to a service over TCP, UDP or a UNIX domain
-// socket.
+// socket. TCP and UNIX domain socket connections can be secured with TLS, see
+// the TLS parameters below. Certificates are reloaded when the producer
+// receives a roll command, e.g. via SIGHUP. The new certificates are used for
+// the next connection.
 //
 // Parameters
 //
@@ -74,6 +78,18 @@ import (
 //      TimeoutSec: 3
 //    AckTimeoutMs: 1000
 //
+// This example connects to a TLS secured socket consumer using a client
+// certificate:
+//
+//  SocketOut:
+//    Type: producer.Socket
+//    Address: logs.example.com:5880
+//    TLS:
+//      Enable: true
+//      Certificate: /etc/gollum/client.crt
+//      PrivateKey: /etc/gollum/client.key
+//      CA: /etc/gollum/ca.crt
+//
 type Socket struct {
 	core.BufferedProducer `gollumdoc:"embed_type"`
 	connection            net.Conn
@@ -81,12 +97,13 @@ type Socket struct {
 	assembly              core.WriterAssembly
 	protocol              string
 	address               string
-	ackTimeout            time.Duration `config:"AckTimeoutMs" default:"2000" metric:"ms"`
-	bufferSizeByte        int           `config:"ConnectionBufferSizeKB" default:"1024" metric:"kb"`
-	acknowledge           string        `config:"Acknowledge"`
-	batchTimeout          time.Duration `config:"Batch/TimeoutSec" default:"5" metric:"sec"`
-	batchMaxCount         int           `config:"Batch/MaxCount" default:"8192"`
-	batchFlushCount       int           `config:"Batch/FlushCount" default:"4096"`
+	ackTimeout            time.Duration        `config:"AckTimeoutMs" default:"2000" metric:"ms"`
+	bufferSizeByte        int                  `config:"ConnectionBufferSizeKB" default:"1024" metric:"kb"`
+	acknowledge           string               `config:"Acknowledge"`
+	batchTimeout          time.Duration        `config:"Batch/TimeoutSec" default:"5" metric:"sec"`
+	batchMaxCount         int                  `config:"Batch/MaxCount" default:"8192"`
+	batchFlushCount       int                  `config:"Batch/FlushCount" default:"4096"`
+	TLSConfig             components.TLSConfig `gollumdoc:"embed_type"`
 }
 
 type bufferedConn interface {
@@ -100,6 +117,7 @@ func init() {
 // Configure initializes this producer with values from a plugin config.
 func (prod *Socket) Configure(conf core.PluginConfigReader) {
 	prod.SetStopCallback(prod.close)
+	prod.EnableManualAcknowledge()
 
 	prod.protocol, prod.address = tnet.ParseAddress(conf.GetString("Address", ":5880"), "tcp")
 	prod.batchFlushCount = tmath.MinI(prod.batchFlushCount, prod.batchMaxCount)
@@ -116,6 +134,13 @@ func (prod *Socket) Configure(conf core.PluginConfigReader) {
 		prod.protocol = "tcp"
 	}
 
+	if prod.TLSConfig.IsEnabled() {
+		if prod.protocol == "udp" {
+			conf.Errors.Pushf("UDP connections do not support TLS.")
+		}
+		prod.SetRollCallback(prod.reloadTLS)
+	}
+
 	prod.batch = core.NewMessageBatch(prod.batchMaxCount)
 	prod.assembly = core.NewWriterAssembly(nil, prod.TryFallback, prod)
 	prod.assembly.SetValidator(prod.validate)
@@ -135,11 +160,29 @@ func (prod *Socket) tryConnect() bool {
 	}
 
 	conn.(bufferedConn).SetWriteBuffer(prod.bufferSizeByte)
+	if prod.TLSConfig.IsEnabled() {
+		tlsConn, err := prod.TLSConfig.NewClient(conn, prod.address, prod.ackTimeout)
+		if err != nil {
+			prod.Logger.Error("TLS handshake error: ", err)
+			conn.Close()
+			return false // ### return, handshake failed ###
+		}
+		conn = tlsConn
+	}
+
 	prod.assembly.SetWriter(conn)
 	prod.connection = conn
 	return true
 }
 
+func (prod *Socket) reloadTLS() {
+	if err := prod.TLSConfig.Reload(); err != nil {
+		prod.Logger.WithError(err).Error("Failed to reload TLS certificates")
+		return
+	}
+	prod.Logger.Info("Reloaded TLS certificates")
+}
+
 func (prod *Socket) closeConnection() error {
 	prod.assembly.SetWriter(nil)
 	if prod.connection != nil {
@@ -0,0 +1,121 @@
+// Copyright 2015-2018 trivago N.V.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package producer
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/trivago/gollum/core"
+	"github.com/trivago/gollum/testing/tlstest"
+	"github.com/trivago/tgo/ttesting"
+)
+
+// listenSocketTLS starts a TLS server requiring client certificates signed by
+// the CA in files. The result of each server handshake is sent to the
+// returned channel.
+func listenSocketTLS(t *testing.T, files tlstest.Certificates) (net.Listener, chan error) {
+	keypair, err := tls.LoadX509KeyPair(files.ServerCertificate, files.ServerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caCerts, err := ioutil.ReadFile(files.CA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{keypair},
+		ClientCAs:    x509.NewCertPool(),
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+	config.ClientCAs.AppendCertsFromPEM(caCerts)
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handshakes := make(chan error, 16)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			handshakes <- conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+	return listener, handshakes
+}
+
+func TestSocketTLS(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+	dir, err := ioutil.TempDir("", "gollum-socket")
+	expect.NoError(err)
+	defer os.RemoveAll(dir)
+
+	files, err := tlstest.WriteCertificates(dir)
+	expect.NoError(err)
+
+	listener, handshakes := listenSocketTLS(t, files)
+	defer listener.Close()
+
+	conf := core.NewPluginConfig("", "producer.Socket")
+	conf.Override("Address", "tcp://"+listener.Addr().String())
+	conf.Override("TLS/Enable", true)
+	conf.Override("TLS/CA", files.CA)
+	plugin, err := core.NewPluginWithConfig(conf)
+	expect.NoError(err)
+
+	prod, casted := plugin.(*Socket)
+	expect.True(casted)
+
+	// Producers without a certificate are rejected
+	if prod.tryConnect() {
+		prod.closeConnection()
+	}
+	expect.NotNil(<-handshakes)
+
+	conf.Override("TLS/Certificate", files.ClientCertificate)
+	conf.Override("TLS/PrivateKey", files.ClientKey)
+	plugin, err = core.NewPluginWithConfig(conf)
+	expect.NoError(err)
+	prod = plugin.(*Socket)
+
+	expect.True(prod.tryConnect())
+	prod.closeConnection()
+	expect.NoError(<-handshakes)
+
+	// Replace all certificates with ones signed by a new CA
+	_, err = tlstest.WriteCertificates(dir)
+	expect.NoError(err)
+	newListener, newHandshakes := listenSocketTLS(t, files)
+	defer newListener.Close()
+
+	prod.address = newListener.Addr().String()
+	expect.False(prod.tryConnect())
+	<-newHandshakes
+
+	// The new certificates are used after a roll
+	prod.reloadTLS()
+	expect.True(prod.tryConnect())
+	prod.closeConnection()
+	expect.NoError(<-newHandshakes)
+}
@@ -74,7 +74,9 @@ func newSpoolFile(prod *Spooling, streamName string, source core.MessageSource)
 	prod.metricsRegistry.Register("read", spool.metricRead)
 	prod.metricsRegistry.Register("write", spool.metricWrite)
 
-	go spool.read()
+	if prod.respool {
+		go spool.read()
+	}
 	return spool
 }
 
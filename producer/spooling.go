@@ -62,6 +62,11 @@ import (
 // resources before putting additionl load on it.
 // By default this parameter is set to 10.
 //
+// - Respool: When set to false, spooled messages are not read back into the
+// system. Spool files are kept on disk and can be replayed by consumer.Replay.
+// This allows using this producer as a dead-letter queue.
+// By default this parameter is set to true.
+//
 // - RevertStreamOnFallback: This allows the spooling fallback to handle the
 // messages that would have been sent back by the spooler if it would have
 // handled the message. When set to true it will revert the stream of the
@@ -102,6 +107,7 @@ type Spooling struct {
 	maxFileSize           int64                   `config:"MaxFileSizeMB" default:"512" metric:"mb"`
 	maxFileAge            time.Duration           `config:"MaxFileAgeMin" default:"1" metric:"min"`
 	respoolDuration       time.Duration           `config:"RespoolDelaySec" default:"10" metric:"sec"`
+	respool               bool                    `config:"Respool" default:"true"`
 	revertOnDrop          bool                    `config:"RevertStreamOnFallback" default:"false"`
 	bufferSizeByte        int                     `config:"BufferSizeByte" default:"8192"`
 	batchMaxCount         int                     `config:"Batch/MaxCount" default:"100"`
@@ -120,6 +126,7 @@ func (prod *Spooling) Configure(conf core.PluginConfigReader) {
 	prod.SetPrepareStopCallback(prod.waitForReader)
 	prod.SetStopCallback(prod.close)
 	prod.SetRollCallback(prod.onRoll)
+	prod.EnableManualAcknowledge()
 	prod.metricsRegistry = core.NewMetricsRegistryForPlugin(prod)
 
 	prod.outfileGuard = new(sync.RWMutex)
@@ -233,6 +240,9 @@ func (prod *Spooling) onRoll() {
 	outfiles := prod.outfile
 	prod.outfileGuard.RUnlock()
 
+	if !prod.respool {
+		return // ### return, no reader running ###
+	}
 	for _, file := range outfiles {
 		file.triggerRoll()
 	}
@@ -90,6 +90,7 @@ func init() {
 // Configure initializes this producer with values from a plugin config.
 func (prod *StatsdMetrics) Configure(conf core.PluginConfigReader) {
 	prod.SetStopCallback(prod.close)
+	prod.EnableManualAcknowledge()
 
 	prod.streamMap = conf.GetStreamMap("StreamMapping", "")
 	prod.batch = core.NewMessageBatch(int(conf.GetInt("Batch/MaxMessages", 500)))
@@ -0,0 +1,385 @@
+// Copyright 2015-2018 trivago N.V.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package producer
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/trivago/gollum/core"
+	"github.com/trivago/gollum/core/components"
+	"github.com/trivago/tgo/tcontainer"
+	"github.com/trivago/tgo/tnet"
+)
+
+const (
+	syslogTimestampFormat = "2006-01-02T15:04:05.000000Z07:00"
+	syslogFramingOctet    = "octet-counting"
+	syslogFramingNewline  = "newline"
+)
+
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5,
+	"lpr": 6, "news": 7, "uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"ntp": 12, "security": 13, "console": 14, "solaris-cron": 15,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+var syslogSeverities = map[string]int{
+	"emerg": 0, "alert": 1, "crit": 2, "err": 3, "error": 3,
+	"warning": 4, "warn": 4, "notice": 5, "info": 6, "debug": 7,
+}
+
+// Syslog producer plugin
+//
+// The syslog producer sends messages to a syslog server in the RFC5424
+// format. The payload of a message is used as the syslog message. Messages
+// can be sent over UDP, TCP, TLS (RFC5425) or UNIX domain sockets, so that
+// gollum can be used as a syslog relay in combination with consumer.Syslogd.
+// Certificates are reloaded when the producer receives a roll command, e.g.
+// via SIGHUP. The new certificates are used for the next connection.
+//
+// Parameters
+//
+// - Address: Defines the address to send messages to. This can be any
+// ip address and port like "udp://localhost:514", "tcp://localhost:6514" or a
+// file like "unix:///dev/log" or "unixgram:///dev/log".
+// By default this parameter is set to "udp://localhost:514".
+//
+// - Framing: Defines how messages are separated on stream based connections
+// (TCP and unix). Set to "octet-counting" to prefix each message with its
+// length as required by RFC5425 or to "newline" to terminate each message
+// with a newline. UDP and unixgram sockets send one message per datagram.
+// By default this parameter is set to "octet-counting".
+//
+// - Facility: The syslog facility used for all messages. Can be a number or
+// a name like "user", "daemon" or "local0".
+// By default this parameter is set to "user".
+//
+// - Severity: The syslog severity used for all messages. Can be a number or a
+// name like "err", "warning", "notice" or "info".
+// By default this parameter is set to "info".
+//
+// - Hostname: The hostname sent with each message. If not set, the hostname
+// of the machine is used.
+// By default this parameter is set to "".
+//
+// - AppName: The application name sent with each message.
+// By default this parameter is set to "gollum".
+//
+// - ProcID: The process id sent with each message.
+// By default this parameter is set to "-".
+//
+// - MsgID: The message id sent with each message.
+// By default this parameter is set to "-".
+//
+// - StructuredData/ID: The SD-ID used to render metadata fields as
+// structured data, e.g. "meta@32473". Structured data is only sent if this
+// value is set.
+// By default this parameter is set to "".
+//
+// - StructuredData/Keys: A list of metadata keys to add as parameters of the
+// structured data element. Keys not set for a message are skipped.
+// By default this parameter is set to an empty list.
+//
+// - UseMetadata: When set to true, the metadata fields "facility", "severity",
+// "hostname", "app_name", "proc_id", "msg_id" and "structured_data" override
+// the configured values if they are set. These are the fields set by
+// consumer.Syslogd when "SetMetadata" is enabled.
+// By default this parameter is set to "true".
+//
+// - TimeoutMs: Defines the time in milliseconds to wait for a connection to
+// be established or a message to be written.
+// By default this parameter is set to "2000".
+//
+// Examples
+//
+// This example relays syslog messages received by gollum to a central syslog
+// server using TLS:
+//
+//  SyslogIn:
+//    Type: consumer.Syslogd
+//    Streams: syslog
+//    Address: "udp://0.0.0.0:514"
+//    Format: RFC5424
+//    SetMetadata: true
+//
+//  SyslogOut:
+//    Type: producer.Syslog
+//    Streams: syslog
+//    Address: "tcp://syslog.example.com:6514"
+//    TLS:
+//      Enable: true
+//      CA: /etc/gollum/ca.crt
+//
+type Syslog struct {
+	core.BufferedProducer `gollumdoc:"embed_type"`
+	TLSConfig             components.TLSConfig `gollumdoc:"embed_type"`
+	connection            net.Conn
+	protocol              string
+	address               string
+	facility              int
+	severity              int
+	framing               string        `config:"Framing" default:"octet-counting"`
+	hostname              string        `config:"Hostname" default:""`
+	appName               string        `config:"AppName" default:"gollum"`
+	procID                string        `config:"ProcID" default:"-"`
+	msgID                 string        `config:"MsgID" default:"-"`
+	sdID                  string        `config:"StructuredData/ID" default:""`
+	sdKeys                []string      `config:"StructuredData/Keys"`
+	useMetadata           bool          `config:"UseMetadata" default:"true"`
+	timeout               time.Duration `config:"TimeoutMs" default:"2000" metric:"ms"`
+}
+
+func init() {
+	core.TypeRegistry.Register(Syslog{})
+}
+
+// Configure initializes this producer with values from a plugin config.
+func (prod *Syslog) Configure(conf core.PluginConfigReader) {
+	prod.SetStopCallback(prod.close)
+	prod.EnableManualAcknowledge()
+
+	prod.protocol, prod.address = tnet.ParseAddress(conf.GetString("Address", "udp://localhost:514"), "udp")
+	switch prod.protocol {
+	case "udp", "tcp", "unix", "unixgram":
+	default:
+		conf.Errors.Pushf("Unknown protocol type %s", prod.protocol)
+	}
+
+	switch prod.framing {
+	case syslogFramingOctet, syslogFramingNewline:
+	default:
+		conf.Errors.Pushf("Framing must be \"%s\" or \"%s\"", syslogFramingOctet, syslogFramingNewline)
+	}
+
+	var err error
+	if prod.facility, err = parseSyslogValue(conf.GetString("Facility", "user"), syslogFacilities, 23); err != nil {
+		conf.Errors.Pushf("Facility: %s", err.Error())
+	}
+	if prod.severity, err = parseSyslogValue(conf.GetString("Severity", "info"), syslogSeverities, 7); err != nil {
+		conf.Errors.Pushf("Severity: %s", err.Error())
+	}
+
+	if prod.hostname == "" {
+		prod.hostname, _ = os.Hostname()
+	}
+	if len(prod.sdKeys) > 0 && prod.sdID == "" {
+		conf.Errors.Pushf("StructuredData/Keys requires StructuredData/ID to be set")
+	}
+
+	if prod.TLSConfig.IsEnabled() {
+		if prod.protocol != "tcp" {
+			conf.Errors.Pushf("TLS is only supported for TCP connections")
+		}
+		prod.SetRollCallback(prod.reloadTLS)
+	}
+}
+
+// parseSyslogValue converts a facility or severity given as number or name.
+func parseSyslogValue(value interface{}, names map[string]int, max int) (int, error) {
+	var number int
+	switch typed := value.(type) {
+	case int:
+		number = typed
+	case int64:
+		number = int(typed)
+	case float64:
+		number = int(typed)
+	default:
+		name := strings.ToLower(strings.TrimSpace(core.ConvertToString(value)))
+		if named, isKnown := names[name]; isKnown {
+			return named, nil // ### return, known name ###
+		}
+		parsed, err := strconv.Atoi(name)
+		if err != nil {
+			return 0, fmt.Errorf("unknown value \"%s\"", name)
+		}
+		number = parsed
+	}
+
+	if number < 0 || number > max {
+		return 0, fmt.Errorf("value %d is out of range", number)
+	}
+	return number, nil
+}
+
+// syslogHeaderField converts a value to a RFC5424 header field, i.e. a
+// string of printable ASCII characters without spaces or "-" if empty.
+func syslogHeaderField(value string, maxLength int) string {
+	field := strings.Map(func(r rune) rune {
+		if r < 33 || r > 126 {
+			return '_'
+		}
+		return r
+	}, value)
+
+	if len(field) > maxLength {
+		field = field[:maxLength]
+	}
+	if field == "" {
+		return "-"
+	}
+	return field
+}
+
+func (prod *Syslog) getMetadataString(metadata tcontainer.MarshalMap, key string, defaultValue string) string {
+	if !prod.useMetadata || metadata == nil {
+		return defaultValue
+	}
+	if value, exists := metadata.Value(key); exists {
+		if str := core.ConvertToString(value); str != "" {
+			return str
+		}
+	}
+	return defaultValue
+}
+
+func (prod *Syslog) getMetadataCode(metadata tcontainer.MarshalMap, key string, names map[string]int, max int, defaultValue int) int {
+	if !prod.useMetadata || metadata == nil {
+		return defaultValue
+	}
+	if value, exists := metadata.Value(key); exists {
+		if code, err := parseSyslogValue(value, names, max); err == nil {
+			return code
+		}
+	}
+	return defaultValue
+}
+
+func (prod *Syslog) formatStructuredData(metadata tcontainer.MarshalMap) string {
+	if raw := prod.getMetadataString(metadata, "structured_data", ""); raw != "" {
+		return raw // ### return, relay structured data ###
+	}
+	if prod.sdID == "" || metadata == nil {
+		return "-"
+	}
+
+	escape := strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "]", "\\]")
+	element := "[" + syslogHeaderField(prod.sdID, 32)
+	for _, key := range prod.sdKeys {
+		if value, exists := metadata.Value(key); exists {
+			name := strings.NewReplacer("=", "_", "]", "_", "\"", "_").Replace(syslogHeaderField(key, 32))
+			element += fmt.Sprintf(" %s=\"%s\"", name, escape.Replace(core.ConvertToString(value)))
+		}
+	}
+	return element + "]"
+}
+
+// formatMessage renders a message in the RFC5424 format without framing.
+func (prod *Syslog) formatMessage(msg *core.Message) []byte {
+	metadata := msg.TryGetMetadata()
+	facility := prod.getMetadataCode(metadata, "facility", syslogFacilities, 23, prod.facility)
+	severity := prod.getMetadataCode(metadata, "severity", syslogSeverities, 7, prod.severity)
+
+	header := fmt.Sprintf("<%d>1 %s %s %s %s %s %s ",
+		facility*8+severity,
+		msg.GetCreationTime().Format(syslogTimestampFormat),
+		syslogHeaderField(prod.getMetadataString(metadata, "hostname", prod.hostname), 255),
+		syslogHeaderField(prod.getMetadataString(metadata, "app_name", prod.appName), 48),
+		syslogHeaderField(prod.getMetadataString(metadata, "proc_id", prod.procID), 128),
+		syslogHeaderField(prod.getMetadataString(metadata, "msg_id", prod.msgID), 32),
+		prod.formatStructuredData(metadata))
+
+	return append([]byte(header), msg.GetPayload()...)
+}
+
+// frameMessage adds the configured framing to a formatted message.
+func (prod *Syslog) frameMessage(data []byte) []byte {
+	switch {
+	case prod.protocol == "udp" || prod.protocol == "unixgram":
+		return data
+	case prod.framing == syslogFramingNewline:
+		return append(data, '\n')
+	default:
+		return append([]byte(strconv.Itoa(len(data))+" "), data...)
+	}
+}
+
+func (prod *Syslog) tryConnect() error {
+	if prod.connection != nil {
+		return nil // ### return, connection active ###
+	}
+
+	conn, err := net.DialTimeout(prod.protocol, prod.address, prod.timeout)
+	if err != nil {
+		return err // ### return, connection failed ###
+	}
+
+	if prod.TLSConfig.IsEnabled() {
+		tlsConn, err := prod.TLSConfig.NewClient(conn, prod.address, prod.timeout)
+		if err != nil {
+			conn.Close()
+			return err // ### return, handshake failed ###
+		}
+		conn = tlsConn
+	}
+
+	prod.connection = conn
+	return nil
+}
+
+func (prod *Syslog) closeConnection() {
+	if prod.connection != nil {
+		prod.connection.Close()
+		prod.connection = nil
+	}
+}
+
+func (prod *Syslog) reloadTLS() {
+	if err := prod.TLSConfig.Reload(); err != nil {
+		prod.Logger.WithError(err).Error("Failed to reload TLS certificates")
+		return
+	}
+	prod.Logger.Info("Reloaded TLS certificates")
+}
+
+func (prod *Syslog) sendMessage(msg *core.Message) {
+	if err := prod.tryConnect(); err != nil {
+		prod.Logger.WithError(err).Error("Failed to connect to ", prod.address)
+		prod.TryFallbackWithError(msg, err)
+		return // ### return, not connected ###
+	}
+
+	prod.connection.SetWriteDeadline(time.Now().Add(prod.timeout))
+	if _, err := prod.connection.Write(prod.frameMessage(prod.formatMessage(msg))); err != nil {
+		prod.Logger.WithError(err).Error("Failed to write to ", prod.address)
+		prod.closeConnection()
+		prod.TryFallbackWithError(msg, err)
+		return // ### return, write failed ###
+	}
+
+	msg.Ack()
+}
+
+func (prod *Syslog) close() {
+	defer func() {
+		prod.closeConnection()
+		prod.WorkerDone()
+	}()
+	prod.DefaultClose()
+}
+
+// Produce writes syslog messages to the given address.
+func (prod *Syslog) Produce(workers *sync.WaitGroup) {
+	prod.AddMainWorker(workers)
+	prod.MessageControlLoop(prod.sendMessage)
+}
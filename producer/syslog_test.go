@@ -0,0 +1,198 @@
+// Copyright 2015-2018 trivago N.V.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package producer
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/trivago/gollum/core"
+	"github.com/trivago/tgo/tcontainer"
+	"github.com/trivago/tgo/ttesting"
+)
+
+func TestSyslogFormatMessage(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+	conf := core.NewPluginConfig("", "producer.Syslog")
+
+	conf.Override("Facility", "local0")
+	conf.Override("Severity", "warning")
+	conf.Override("Hostname", "my host")
+	conf.Override("AppName", "gollum")
+	plugin, err := core.NewPluginWithConfig(conf)
+	expect.NoError(err)
+
+	prod, casted := plugin.(*Syslog)
+	expect.True(casted)
+
+	msg := core.NewMessage(nil, []byte("test"), nil, core.InvalidStreamID)
+	timestamp := msg.GetCreationTime().Format(syslogTimestampFormat)
+
+	// PRI is facility * 8 + severity, spaces in header fields are replaced
+	expect.Equal("<132>1 "+timestamp+" my_host gollum - - - test", string(prod.formatMessage(msg)))
+}
+
+func TestSyslogFormatMessageTruncate(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+	conf := core.NewPluginConfig("", "producer.Syslog")
+
+	conf.Override("Hostname", "host")
+	conf.Override("AppName", strings.Repeat("a", 60))
+	conf.Override("MsgID", "id\twithä")
+	plugin, err := core.NewPluginWithConfig(conf)
+	expect.NoError(err)
+
+	prod, casted := plugin.(*Syslog)
+	expect.True(casted)
+
+	msg := core.NewMessage(nil, []byte("test"), nil, core.InvalidStreamID)
+	fields := strings.Split(string(prod.formatMessage(msg)), " ")
+	expect.Equal(8, len(fields))
+	expect.Equal(strings.Repeat("a", 48), fields[3])
+	expect.Equal("id_with_", fields[5])
+
+	expect.Equal("-", syslogHeaderField("", 10))
+	expect.Equal("abc", syslogHeaderField("abcdef", 3))
+}
+
+func TestSyslogStructuredData(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+	conf := core.NewPluginConfig("", "producer.Syslog")
+
+	conf.Override("Hostname", "host")
+	conf.Override("StructuredData/ID", "meta@32473")
+	conf.Override("StructuredData/Keys", []string{"quote", "key=name", "missing"})
+	plugin, err := core.NewPluginWithConfig(conf)
+	expect.NoError(err)
+
+	prod, casted := plugin.(*Syslog)
+	expect.True(casted)
+
+	metadata := tcontainer.MarshalMap{
+		"quote":    "a \"b\" [c] \\d",
+		"key=name": "value",
+	}
+	expect.Equal(`[meta@32473 quote="a \"b\" [c\] \\d" key_name="value"]`, prod.formatStructuredData(metadata))
+	expect.Equal("-", prod.formatStructuredData(nil))
+}
+
+func TestSyslogUseMetadata(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+	conf := core.NewPluginConfig("", "producer.Syslog")
+
+	conf.Override("Hostname", "host")
+	conf.Override("StructuredData/ID", "meta@32473")
+	conf.Override("StructuredData/Keys", []string{"hostname"})
+	plugin, err := core.NewPluginWithConfig(conf)
+	expect.NoError(err)
+
+	prod, casted := plugin.(*Syslog)
+	expect.True(casted)
+
+	metadata := tcontainer.MarshalMap{
+		"facility":        "local7",
+		"severity":        3,
+		"hostname":        "relayed",
+		"app_name":        "app",
+		"proc_id":         "42",
+		"msg_id":          "ID1",
+		"structured_data": "[origin ip=\"10.0.0.1\"]",
+	}
+	msg := core.NewMessage(nil, []byte("test"), metadata, core.InvalidStreamID)
+	timestamp := msg.GetCreationTime().Format(syslogTimestampFormat)
+	expect.Equal("<187>1 "+timestamp+" relayed app 42 ID1 [origin ip=\"10.0.0.1\"] test", string(prod.formatMessage(msg)))
+
+	// Invalid values fall back to the configured ones
+	metadata = tcontainer.MarshalMap{
+		"facility": "unknown",
+		"severity": 8,
+	}
+	msg = core.NewMessage(nil, []byte("test"), metadata, core.InvalidStreamID)
+	timestamp = msg.GetCreationTime().Format(syslogTimestampFormat)
+	expect.Equal("<14>1 "+timestamp+" host gollum - - [meta@32473] test", string(prod.formatMessage(msg)))
+
+	// Metadata is ignored if UseMetadata is disabled
+	conf.Override("UseMetadata", false)
+	plugin, err = core.NewPluginWithConfig(conf)
+	expect.NoError(err)
+	prod = plugin.(*Syslog)
+
+	msg = core.NewMessage(nil, []byte("test"), tcontainer.MarshalMap{"hostname": "relayed", "severity": "err"}, core.InvalidStreamID)
+	timestamp = msg.GetCreationTime().Format(syslogTimestampFormat)
+	expect.Equal("<14>1 "+timestamp+" host gollum - - [meta@32473 hostname=\"relayed\"] test", string(prod.formatMessage(msg)))
+}
+
+func TestSyslogFrameMessage(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+	conf := core.NewPluginConfig("", "producer.Syslog")
+
+	conf.Override("Address", "tcp://localhost:6514")
+	plugin, err := core.NewPluginWithConfig(conf)
+	expect.NoError(err)
+
+	prod, casted := plugin.(*Syslog)
+	expect.True(casted)
+	expect.Equal("4 test", string(prod.frameMessage([]byte("test"))))
+
+	conf.Override("Framing", "newline")
+	plugin, err = core.NewPluginWithConfig(conf)
+	expect.NoError(err)
+	prod = plugin.(*Syslog)
+	expect.Equal("test\n", string(prod.frameMessage([]byte("test"))))
+
+	// Datagrams are never framed
+	conf.Override("Address", "udp://localhost:514")
+	plugin, err = core.NewPluginWithConfig(conf)
+	expect.NoError(err)
+	prod = plugin.(*Syslog)
+	expect.Equal("test", string(prod.frameMessage([]byte("test"))))
+
+	conf.Override("Framing", "unknown")
+	_, err = core.NewPluginWithConfig(conf)
+	expect.NotNil(err)
+}
+
+func TestSyslogParseValue(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	value, err := parseSyslogValue("local0", syslogFacilities, 23)
+	expect.NoError(err)
+	expect.Equal(16, value)
+
+	value, err = parseSyslogValue(" WARN ", syslogSeverities, 7)
+	expect.NoError(err)
+	expect.Equal(4, value)
+
+	value, err = parseSyslogValue("23", syslogFacilities, 23)
+	expect.NoError(err)
+	expect.Equal(23, value)
+
+	value, err = parseSyslogValue(int64(7), syslogSeverities, 7)
+	expect.NoError(err)
+	expect.Equal(7, value)
+
+	value, err = parseSyslogValue(float64(3), syslogSeverities, 7)
+	expect.NoError(err)
+	expect.Equal(3, value)
+
+	_, err = parseSyslogValue(24, syslogFacilities, 23)
+	expect.NotNil(err)
+
+	_, err = parseSyslogValue("-1", syslogSeverities, 7)
+	expect.NotNil(err)
+
+	_, err = parseSyslogValue("unknown", syslogSeverities, 7)
+	expect.NotNil(err)
+}
@@ -0,0 +1,249 @@
+// Copyright 2015-2018 trivago N.V.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/trivago/gollum/core"
+)
+
+// Reload applies a new configuration to the running pipeline. Plugins that
+// did not change keep running. Removed or changed consumers are stopped first,
+// removed or changed producers are detached from all routers and drained
+// before they are stopped. New and changed plugins are started afterwards.
+// Consumers and producers referencing a stream whose router changed are
+// restarted, too, as they would otherwise keep a reference to the old router.
+func (co *Coordinator) Reload(conf *core.Config) {
+	diff := co.config.Diff(conf)
+	if diff.IsEmpty() {
+		logrus.Info("Configuration did not change")
+		return // ### return, nothing to do ###
+	}
+
+	restart, affectedStreams := getReloadRestarts(co.config, conf, &diff)
+
+	co.stopReloadedConsumers(restart)
+	co.stopReloadedProducers(restart)
+	co.unregisterReloadedRouters(restart, affectedStreams)
+
+	next := &core.Config{
+		Values:  conf.Values,
+		Plugins: append(diff.Added, diff.Changed...),
+	}
+
+	routers, routersOk := co.configureRouters(next.GetRouters())
+	for _, router := range routers {
+		core.StreamRegistry.AddWildcardProducersToRouter(router)
+	}
+
+	// Producers that keep running have to be bound to the routers replacing
+	// the ones they have been listening to.
+	for _, prod := range co.producers {
+		for _, streamID := range prod.Streams() {
+			if streamID != core.WildcardStreamID && affectedStreams[streamID] {
+				core.StreamRegistry.GetRouterOrFallback(streamID).AddProducer(prod)
+			}
+		}
+	}
+
+	producers, producersOk := co.configureProducers(next.GetProducers())
+	consumers, consumersOk := co.configureConsumers(next.GetConsumers())
+
+	// New wildcard producers need to be added to all existing routers.
+	core.StreamRegistry.AddAllWildcardProducersToAllRouters()
+
+	co.routers = append(co.routers, routers...)
+	co.producers = append(co.producers, producers...)
+	co.consumers = append(co.consumers, consumers...)
+	co.config = conf
+
+	co.startRouters(routers)
+	co.startProducers(producers)
+	co.startConsumers(consumers)
+
+	if !routersOk || !producersOk || !consumersOk {
+		logrus.Error("Configuration reloaded with errors. At least one plugin failed to be configured")
+		return // ### return, reloaded with errors ###
+	}
+
+	logrus.Infof("Configuration reloaded (%d added, %d removed, %d restarted)",
+		len(diff.Added), len(diff.Removed), len(diff.Changed))
+}
+
+// getReloadRestarts returns the IDs of all plugins that have to be stopped
+// when reloading from prev to next as well as the streams that will be bound
+// to a different router afterwards. Unchanged consumers and producers that
+// reference such a stream are appended to diff.Changed so that they are
+// restarted, too.
+func getReloadRestarts(prev, next *core.Config, diff *core.ConfigDiff) (map[string]bool, map[core.MessageStreamID]bool) {
+	added := make(map[string]bool)
+	for _, config := range diff.Added {
+		added[config.ID] = true
+	}
+
+	restart := make(map[string]bool)
+	for _, config := range diff.Removed {
+		restart[config.ID] = true
+	}
+	for _, config := range diff.Changed {
+		restart[config.ID] = true
+	}
+
+	// Collect the streams that will be bound to a different router after the
+	// reload and restart all plugins holding a reference to such a stream.
+	affectedStreams := make(map[core.MessageStreamID]bool)
+	for _, config := range prev.GetRouters() {
+		if restart[config.ID] {
+			affectedStreams[getStreamFromConfig(config, "Stream")] = true
+		}
+	}
+	for _, config := range next.GetRouters() {
+		if added[config.ID] || restart[config.ID] {
+			affectedStreams[getStreamFromConfig(config, "Stream")] = true
+		}
+	}
+	delete(affectedStreams, core.InvalidStreamID)
+
+	for _, config := range next.GetConsumers() {
+		if added[config.ID] || restart[config.ID] {
+			continue // ### continue, new or already restarted ###
+		}
+		for _, streamID := range getStreamsFromConfig(config, "Streams") {
+			if affectedStreams[streamID] {
+				restart[config.ID] = true
+				diff.Changed = append(diff.Changed, config)
+				break
+			}
+		}
+	}
+
+	for _, config := range next.GetProducers() {
+		if added[config.ID] || restart[config.ID] {
+			continue // ### continue, new or already restarted ###
+		}
+		if affectedStreams[getStreamFromConfig(config, "FallbackStream")] {
+			restart[config.ID] = true
+			diff.Changed = append(diff.Changed, config)
+		}
+	}
+
+	return restart, affectedStreams
+}
+
+// stopReloadedConsumers stops and removes all consumers marked for restart.
+func (co *Coordinator) stopReloadedConsumers(restart map[string]bool) {
+	remaining := make([]core.Consumer, 0, len(co.consumers))
+	for _, cons := range co.consumers {
+		if cons == co.logConsumer || !restart[cons.GetID()] {
+			remaining = append(remaining, cons)
+			continue // ### continue, keep running ###
+		}
+
+		logrus.Debugf("Stopping consumer '%s'", cons.GetID())
+		cons.Control() <- core.PluginControlStopConsumer
+		if !waitForPluginStop(cons, cons.GetShutdownTimeout()*10) {
+			logrus.Errorf("Consumer '%s' found to be blocking", cons.GetID())
+		}
+
+		core.PluginRegistry.Unregister(cons.GetID())
+		core.MetricConsumers.Dec(1)
+	}
+	co.consumers = remaining
+}
+
+// stopReloadedProducers detaches all producers marked for restart from their
+// routers and stops them. Messages still queued by these producers are
+// processed during shutdown.
+func (co *Coordinator) stopReloadedProducers(restart map[string]bool) {
+	remaining := make([]core.Producer, 0, len(co.producers))
+	stopped := []core.Producer{}
+	for _, prod := range co.producers {
+		if restart[prod.GetID()] {
+			stopped = append(stopped, prod)
+		} else {
+			remaining = append(remaining, prod)
+		}
+	}
+
+	// Detach first so that no new messages reach the producers while draining
+	core.StreamRegistry.RemoveProducer(stopped...)
+
+	for _, prod := range stopped {
+		logrus.Debugf("Stopping producer '%s'", prod.GetID())
+		prod.Control() <- core.PluginControlStopProducer
+		if !waitForPluginStop(prod, prod.GetShutdownTimeout()*10) {
+			logrus.Errorf("Producer '%s' found to be blocking", prod.GetID())
+		}
+
+		core.PluginRegistry.Unregister(prod.GetID())
+		core.MetricProducers.Dec(1)
+	}
+	co.producers = remaining
+}
+
+// unregisterReloadedRouters removes all routers marked for restart as well as
+// generated fallback routers bound to an affected stream. The latter will be
+// replaced by the newly configured routers.
+func (co *Coordinator) unregisterReloadedRouters(restart map[string]bool, affectedStreams map[core.MessageStreamID]bool) {
+	remaining := make([]core.Router, 0, len(co.routers))
+	for _, router := range co.routers {
+		if !restart[router.GetID()] {
+			remaining = append(remaining, router)
+			continue // ### continue, keep running ###
+		}
+		core.StreamRegistry.Unregister(router.GetStreamID())
+		core.PluginRegistry.Unregister(router.GetID())
+	}
+	co.routers = remaining
+
+	for streamID := range affectedStreams {
+		if router := core.StreamRegistry.GetRouter(streamID); router != nil {
+			core.StreamRegistry.Unregister(streamID)
+			core.PluginRegistry.Unregister(router.GetID())
+		}
+	}
+}
+
+// waitForPluginStop blocks until the given plugin reached the dead state or
+// the timeout is hit. False is returned if the plugin did not stop in time.
+func waitForPluginStop(plugin core.PluginWithState, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for plugin.GetState() != core.PluginStateDead {
+		if time.Now().After(deadline) {
+			return false // ### return, timeout ###
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return true
+}
+
+// getStreamsFromConfig returns the streams stored in the given key of a
+// plugin config.
+func getStreamsFromConfig(config core.PluginConfig, key string) []core.MessageStreamID {
+	reader := core.NewPluginConfigReader(&config)
+	return reader.GetStreamArray(key, []core.MessageStreamID{})
+}
+
+// getStreamFromConfig returns the stream stored in the given key of a plugin
+// config or core.InvalidStreamID if the key is not set.
+func getStreamFromConfig(config core.PluginConfig, key string) core.MessageStreamID {
+	reader := core.NewPluginConfigReader(&config)
+	if !reader.HasValue(key) {
+		return core.InvalidStreamID
+	}
+	return reader.GetStreamID(key, core.InvalidStreamID)
+}
@@ -0,0 +1,84 @@
+// Copyright 2015-2018 trivago N.V.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/trivago/gollum/core"
+	"github.com/trivago/tgo/ttesting"
+)
+
+func TestReloadRestarts(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	prevConfig := []byte(`
+"routeA": {Type: router.Broadcast, Stream: a}
+"inA": {Type: consumer.Console, Streams: a}
+"inB": {Type: consumer.Console, Streams: b}
+"outA": {Type: producer.Console, Streams: a}
+"outB": {Type: producer.Console, Streams: b, FallbackStream: a}
+"outC": {Type: producer.Console, Streams: c}
+"removed": {Type: producer.Console, Streams: c}
+`)
+	nextConfig := []byte(`
+"routeA": {Type: router.Broadcast, Stream: a, TimeoutMs: 100}
+"inA": {Type: consumer.Console, Streams: a}
+"inB": {Type: consumer.Console, Streams: b}
+"outA": {Type: producer.Console, Streams: a}
+"outB": {Type: producer.Console, Streams: b, FallbackStream: a}
+"outC": {Type: producer.Console, Streams: d}
+"added": {Type: consumer.Console, Streams: a}
+`)
+
+	prev, err := core.ReadConfig(prevConfig)
+	expect.NoError(err)
+	next, err := core.ReadConfig(nextConfig)
+	expect.NoError(err)
+
+	diff := prev.Diff(next)
+	restart, affectedStreams := getReloadRestarts(prev, next, &diff)
+
+	// Changed and removed plugins
+	expect.True(restart["routeA"])
+	expect.True(restart["outC"])
+	expect.True(restart["removed"])
+
+	// Unchanged plugins referencing the changed router
+	expect.True(restart["inA"])
+	expect.True(restart["outB"])
+
+	// Unchanged plugins not affected by the change
+	expect.False(restart["inB"])
+	expect.False(restart["outA"])
+	expect.False(restart["added"])
+
+	expect.Equal(1, len(affectedStreams))
+	expect.True(affectedStreams[core.GetStreamID("a")])
+
+	changed := make(map[string]bool)
+	for _, config := range diff.Changed {
+		changed[config.ID] = true
+	}
+	expect.Equal(4, len(diff.Changed))
+	expect.True(changed["inA"])
+	expect.True(changed["outB"])
+
+	// Reloading the same config does not restart anything
+	diff = next.Diff(next)
+	expect.True(diff.IsEmpty())
+	restart, _ = getReloadRestarts(next, next, &diff)
+	expect.Equal(0, len(restart))
+}
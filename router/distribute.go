@@ -50,16 +50,16 @@ import (
 type Distribute struct {
 	Broadcast      `gollumdoc:"embed_type"`
 	routers        []core.Router
-	boundStreamIDs []core.MessageStreamID
+	boundStreamIDs []core.MessageStreamID `config:"TargetStreams"`
 }
 
 func init() {
 	core.TypeRegistry.Register(Distribute{})
 }
 
-// Configure initializes this distributor with values from a plugin config.
-func (router *Distribute) Configure(conf core.PluginConfigReader) {
-	router.boundStreamIDs = conf.GetStreamArray("TargetStreams", []core.MessageStreamID{})
+// GetTargetStreamIDs returns the streams this router distributes to.
+func (router *Distribute) GetTargetStreamIDs() []core.MessageStreamID {
+	return router.boundStreamIDs
 }
 
 // Start the router
@@ -0,0 +1,179 @@
+// Copyright 2015-2018 trivago N.V.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package router
+
+import (
+	"hash/fnv"
+
+	"github.com/trivago/gollum/core"
+)
+
+// Hash router
+//
+// This router routes each message to exactly one of the streams listed in
+// TargetStreams. The target is chosen by hashing a key taken from the
+// metadata or the payload of the message, so messages with the same key are
+// always routed to the same stream. This preserves e.g. the order of messages
+// per user when fanning out to multiple producers.
+//
+// Targets are chosen by rendezvous hashing: every target is scored by a hash
+// of the key and the target's stream name and the target with the highest
+// score wins. When a target is added or removed, only the keys of that target
+// are remapped; reordering TargetStreams does not remap any key.
+//
+// Parameters
+//
+// - TargetStreams: List of streams to route the incoming messages to.
+//
+// - KeyFrom: Defines the metadata field to use as key. If empty, the payload
+// is used. Messages without this field all share the same (empty) key.
+// By default this parameter is set to "".
+//
+// - KeyOffset: Defines the index of the first byte of the key data to hash.
+// If the key is shorter, an empty key is used.
+// By default this parameter is set to "0".
+//
+// - KeyLength: Defines the number of bytes of the key data to hash, starting
+// at KeyOffset. Set to 0 to use all remaining bytes.
+// By default this parameter is set to "0".
+//
+// Examples
+//
+// This example routes all messages of a user to the same of three streams:
+//
+//  userAffinity:
+//    Type: router.Hash
+//    Stream: events
+//    KeyFrom: userId
+//    TargetStreams:
+//      - events_0
+//      - events_1
+//      - events_2
+//
+// This example uses the first 8 bytes of the payload as key:
+//
+//  prefixAffinity:
+//    Type: router.Hash
+//    Stream: events
+//    KeyLength: 8
+//    TargetStreams:
+//      - events_0
+//      - events_1
+//
+type Hash struct {
+	Broadcast `gollumdoc:"embed_type"`
+	targets   []hashTarget
+	getKey    core.GetDataAsBytesFunc
+	keyField  string                 `config:"KeyFrom"`
+	keyOffset int                    `config:"KeyOffset" default:"0"`
+	keyLength int                    `config:"KeyLength" default:"0"`
+	streamIDs []core.MessageStreamID `config:"TargetStreams"`
+}
+
+type hashTarget struct {
+	streamID core.MessageStreamID
+	seed     uint64
+}
+
+func init() {
+	core.TypeRegistry.Register(Hash{})
+}
+
+// Configure initializes this router with values from a plugin config.
+func (router *Hash) Configure(conf core.PluginConfigReader) {
+	router.getKey = core.NewBytesGetterFor(router.keyField)
+
+	if router.keyOffset < 0 || router.keyLength < 0 {
+		conf.Errors.Pushf("KeyOffset and KeyLength must not be negative")
+	}
+
+	for _, streamID := range router.streamIDs {
+		router.targets = append(router.targets, hashTarget{
+			streamID: streamID,
+			seed:     hashBytes([]byte(streamID.GetName())),
+		})
+	}
+}
+
+// GetTargetStreamIDs returns all streams this router may route to.
+func (router *Hash) GetTargetStreamIDs() []core.MessageStreamID {
+	return router.streamIDs
+}
+
+// Start the router
+func (router *Hash) Start() error {
+	return nil
+}
+
+// Enqueue enques a message to the router
+func (router *Hash) Enqueue(msg *core.Message) error {
+	if len(router.targets) == 0 {
+		return core.NewModulateResultError(
+			"Router %s: no streams configured", router.GetID())
+	}
+
+	targetID := router.getTarget(router.getKeyData(msg))
+	if targetID == router.GetStreamID() {
+		return router.Broadcast.Enqueue(msg)
+	}
+
+	targetRouter := core.StreamRegistry.GetRouterOrFallback(targetID)
+
+	msg.SetStreamID(targetID)
+	return core.Route(msg, targetRouter)
+}
+
+// getKeyData returns the slice of the key data to hash.
+func (router *Hash) getKeyData(msg *core.Message) []byte {
+	key := router.getKey(msg)
+	if router.keyOffset >= len(key) {
+		return []byte{} // ### return, key too short ###
+	}
+
+	key = key[router.keyOffset:]
+	if router.keyLength > 0 && router.keyLength < len(key) {
+		key = key[:router.keyLength]
+	}
+	return key
+}
+
+// getTarget returns the stream with the highest score for the given key.
+func (router *Hash) getTarget(key []byte) core.MessageStreamID {
+	keyHash := hashBytes(key)
+	bestIdx := 0
+	bestScore := uint64(0)
+
+	for idx, target := range router.targets {
+		if score := mixHash(keyHash ^ target.seed); idx == 0 || score > bestScore {
+			bestIdx, bestScore = idx, score
+		}
+	}
+	return router.targets[bestIdx].streamID
+}
+
+// hashBytes returns the 64 bit FNV-1a hash of data.
+func hashBytes(data []byte) uint64 {
+	hash := fnv.New64a()
+	hash.Write(data)
+	return hash.Sum64()
+}
+
+// mixHash is the finalizer of SplitMix64. It spreads the bits of the combined
+// key and target hashes so that scores are uniformly distributed.
+func mixHash(value uint64) uint64 {
+	value = (value ^ (value >> 30)) * 0xbf58476d1ce4e5b9
+	value = (value ^ (value >> 27)) * 0x94d049bb133111eb
+	return value ^ (value >> 31)
+}
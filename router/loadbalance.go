@@ -0,0 +1,213 @@
+// Copyright 2015-2018 trivago N.V.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package router
+
+import (
+	"sync"
+
+	"github.com/trivago/gollum/core"
+	"github.com/trivago/tgo/tcontainer"
+)
+
+// LoadBalance router
+//
+// This router routes each message to exactly one of the streams listed in
+// TargetStreams. Messages are distributed by smooth weighted round robin,
+// i.e. a target with weight 3 receives three times as many messages as a
+// target with weight 1 and messages to a target are spread evenly instead of
+// being sent in bursts.
+//
+// If SkipUnhealthy is set, targets whose producers are all blocked or inactive
+// are skipped until at least one of their producers recovers. A target
+// without producers, e.g. a stream forwarding to another router, is always
+// considered healthy. If no target is healthy, messages are distributed
+// across all targets.
+//
+// Parameters
+//
+// - TargetStreams: List of streams to route the incoming messages to.
+//
+// - Weights: Defines a map of stream names to weights. Weights have to be
+// greater than 0. Targets not listed here have a weight of 1.
+// By default this parameter is set to an empty map.
+//
+// - SkipUnhealthy: When set to true, targets without an active and unblocked
+// producer are skipped.
+// By default this parameter is set to "true".
+//
+// Examples
+//
+// This example sends three of four messages to "primary" and one to
+// "secondary" as long as the producers of both streams are healthy:
+//
+//  loadBalancer:
+//    Type: router.LoadBalance
+//    Stream: logs
+//    TargetStreams:
+//      - primary
+//      - secondary
+//    Weights:
+//      primary: 3
+//      secondary: 1
+//
+type LoadBalance struct {
+	Broadcast     `gollumdoc:"embed_type"`
+	guard         *sync.Mutex
+	targets       []*loadBalanceTarget
+	streamIDs     []core.MessageStreamID `config:"TargetStreams"`
+	skipUnhealthy bool                   `config:"SkipUnhealthy" default:"true"`
+}
+
+type loadBalanceTarget struct {
+	streamID  core.MessageStreamID
+	weight    int64
+	current   int64
+	unhealthy bool
+}
+
+func init() {
+	core.TypeRegistry.Register(LoadBalance{})
+}
+
+// Configure initializes this router with values from a plugin config.
+func (router *LoadBalance) Configure(conf core.PluginConfigReader) {
+	router.guard = new(sync.Mutex)
+	weights := conf.GetMap("Weights", tcontainer.MarshalMap{})
+
+	for _, streamID := range router.streamIDs {
+		weight := int64(1)
+		if _, isSet := weights[streamID.GetName()]; isSet {
+			var err error
+			if weight, err = weights.Int(streamID.GetName()); err != nil || weight <= 0 {
+				conf.Errors.Pushf("Weight of stream %s must be a number greater than 0", streamID.GetName())
+				continue
+			}
+		}
+		router.targets = append(router.targets, &loadBalanceTarget{
+			streamID: streamID,
+			weight:   weight,
+		})
+	}
+
+	for name := range weights {
+		if !router.isTarget(core.GetStreamID(name)) {
+			conf.Errors.Pushf("Weights contains stream %s which is not listed in TargetStreams", name)
+		}
+	}
+}
+
+// GetTargetStreamIDs returns all streams this router may route to.
+func (router *LoadBalance) GetTargetStreamIDs() []core.MessageStreamID {
+	return router.streamIDs
+}
+
+// Start the router
+func (router *LoadBalance) Start() error {
+	return nil
+}
+
+// Enqueue enques a message to the router
+func (router *LoadBalance) Enqueue(msg *core.Message) error {
+	if len(router.targets) == 0 {
+		return core.NewModulateResultError(
+			"Router %s: no streams configured", router.GetID())
+	}
+
+	targetID := router.nextTarget()
+	if targetID == router.GetStreamID() {
+		return router.Broadcast.Enqueue(msg)
+	}
+
+	targetRouter := core.StreamRegistry.GetRouterOrFallback(targetID)
+
+	msg.SetStreamID(targetID)
+	return core.Route(msg, targetRouter)
+}
+
+// nextTarget returns the stream to route the next message to.
+func (router *LoadBalance) nextTarget() core.MessageStreamID {
+	healthy := make([]bool, len(router.targets))
+	for i, target := range router.targets {
+		healthy[i] = !router.skipUnhealthy || router.isHealthy(target.streamID)
+	}
+
+	router.guard.Lock()
+	defer router.guard.Unlock()
+
+	candidates := make([]*loadBalanceTarget, 0, len(router.targets))
+	for i, target := range router.targets {
+		if !healthy[i] {
+			target.unhealthy = true
+			continue // ### continue, skip unhealthy target ###
+		}
+		if target.unhealthy {
+			// Targets rejoining start over instead of keeping the value
+			// they had when they were skipped.
+			target.unhealthy = false
+			target.current = 0
+		}
+		candidates = append(candidates, target)
+	}
+	if len(candidates) == 0 {
+		candidates = router.targets
+	}
+
+	// Smooth weighted round robin: every candidate gains its weight, the
+	// candidate with the highest value is chosen and loses the total weight.
+	var best *loadBalanceTarget
+	total := int64(0)
+	for _, target := range candidates {
+		target.current += target.weight
+		total += target.weight
+		if best == nil || target.current > best.current {
+			best = target
+		}
+	}
+	best.current -= total
+	return best.streamID
+}
+
+// isHealthy returns true if at least one producer of the given stream is
+// active and not blocked or if the stream has no known producers.
+func (router *LoadBalance) isHealthy(streamID core.MessageStreamID) bool {
+	var producers []core.Producer
+	if streamID == router.GetStreamID() {
+		producers = router.GetProducers()
+	} else if target, hasProducers := core.StreamRegistry.GetRouter(streamID).(interface {
+		GetProducers() []core.Producer
+	}); hasProducers {
+		producers = target.GetProducers()
+	}
+
+	if len(producers) == 0 {
+		return true // ### return, nothing to check ###
+	}
+	for _, producer := range producers {
+		if producer.IsActive() && !producer.IsBlocked() {
+			return true // ### return, found a healthy producer ###
+		}
+	}
+	return false
+}
+
+// isTarget returns true if the given stream is listed in TargetStreams.
+func (router *LoadBalance) isTarget(streamID core.MessageStreamID) bool {
+	for _, targetID := range router.streamIDs {
+		if targetID == streamID {
+			return true
+		}
+	}
+	return false
+}
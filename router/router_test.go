@@ -15,11 +15,16 @@
 package router
 
 import (
+	"fmt"
+	"runtime/debug"
+	"testing"
+	"time"
+
 	"github.com/trivago/gollum/core"
 	_ "github.com/trivago/gollum/filter"
 	_ "github.com/trivago/gollum/format"
-	"runtime/debug"
-	"testing"
+	"github.com/trivago/tgo/tcontainer"
+	"github.com/trivago/tgo/ttesting"
 )
 
 func TestStreamInterface(t *testing.T) {
@@ -44,3 +49,207 @@ func TestStreamInterface(t *testing.T) {
 		}
 	}
 }
+
+func TestSwitchRouting(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	conf := core.NewPluginConfig("", "router.Switch")
+	conf.Override("Stream", "switchIn")
+	conf.Override("Default", "switchDefault")
+	conf.Override("Cases", []interface{}{
+		tcontainer.MarshalMap{"If": `meta.level == "error"`, "Then": "switchErrors"},
+		tcontainer.MarshalMap{"If": `payload =~ "^warn"`, "Then": "switchWarnings"},
+	})
+
+	plugin, err := core.NewPluginWithConfig(conf)
+	expect.NoError(err)
+	router, isSwitch := plugin.(*Switch)
+	expect.True(isSwitch)
+	expect.Equal(3, len(router.GetTargetStreamIDs()))
+
+	msg := core.NewMessage(nil, []byte("warning"), tcontainer.MarshalMap{"level": "error"}, router.GetStreamID())
+	router.Enqueue(msg)
+	expect.Equal("switchErrors", msg.GetStreamID().GetName())
+
+	msg = core.NewMessage(nil, []byte("warning"), nil, router.GetStreamID())
+	router.Enqueue(msg)
+	expect.Equal("switchWarnings", msg.GetStreamID().GetName())
+
+	msg = core.NewMessage(nil, []byte("info"), nil, router.GetStreamID())
+	router.Enqueue(msg)
+	expect.Equal("switchDefault", msg.GetStreamID().GetName())
+
+	conf.Override("Cases", []interface{}{
+		tcontainer.MarshalMap{"If": `meta.level ==`, "Then": "switchErrors"},
+	})
+	_, err = core.NewPluginWithConfig(conf)
+	expect.NotNil(err)
+}
+
+func TestHashRouting(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	targets := []string{"hash0", "hash1", "hash2", "hash3"}
+	conf := core.NewPluginConfig("", "router.Hash")
+	conf.Override("Stream", "hashIn")
+	conf.Override("KeyFrom", "user")
+	conf.Override("TargetStreams", targets)
+
+	plugin, err := core.NewPluginWithConfig(conf)
+	expect.NoError(err)
+	router, isHash := plugin.(*Hash)
+	expect.True(isHash)
+	expect.Equal(4, len(router.GetTargetStreamIDs()))
+
+	route := func(router *Hash, user string) core.MessageStreamID {
+		msg := core.NewMessage(nil, []byte(user), tcontainer.MarshalMap{"user": user}, router.GetStreamID())
+		router.Enqueue(msg)
+		return msg.GetStreamID()
+	}
+
+	// Keys are routed to the same stream and spread over all streams
+	assigned := make(map[string]core.MessageStreamID)
+	counts := make(map[core.MessageStreamID]int)
+	for i := 0; i < 1000; i++ {
+		user := fmt.Sprintf("user%d", i)
+		assigned[user] = route(router, user)
+		counts[assigned[user]]++
+		expect.Equal(assigned[user], route(router, user))
+	}
+	expect.Equal(4, len(counts))
+	for _, count := range counts {
+		expect.Greater(count, 150)
+	}
+
+	// Removing a stream only remaps the keys of this stream
+	conf.Override("TargetStreams", []string{"hash3", "hash0", "hash2"})
+	plugin, err = core.NewPluginWithConfig(conf)
+	expect.NoError(err)
+	reduced := plugin.(*Hash)
+
+	for user, streamID := range assigned {
+		if streamID.GetName() != "hash1" {
+			expect.Equal(streamID, route(reduced, user))
+		} else {
+			expect.Neq(streamID, route(reduced, user))
+		}
+	}
+}
+
+func TestHashKeySlice(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	conf := core.NewPluginConfig("", "router.Hash")
+	conf.Override("KeyOffset", 2)
+	conf.Override("KeyLength", 3)
+
+	plugin, err := core.NewPluginWithConfig(conf)
+	expect.NoError(err)
+	router := plugin.(*Hash)
+
+	msg := core.NewMessage(nil, []byte("abcdefgh"), nil, core.InvalidStreamID)
+	expect.Equal("cde", string(router.getKeyData(msg)))
+	msg = core.NewMessage(nil, []byte("abcd"), nil, core.InvalidStreamID)
+	expect.Equal("cd", string(router.getKeyData(msg)))
+	msg = core.NewMessage(nil, []byte("ab"), nil, core.InvalidStreamID)
+	expect.Equal("", string(router.getKeyData(msg)))
+}
+
+type loadBalanceTestProducer struct {
+	core.Producer
+	state    core.PluginState
+	messages int
+}
+
+func (prod *loadBalanceTestProducer) Enqueue(msg *core.Message, timeout time.Duration) {
+	prod.messages++
+}
+
+func (prod *loadBalanceTestProducer) GetState() core.PluginState {
+	return prod.state
+}
+
+func (prod *loadBalanceTestProducer) IsActive() bool {
+	return prod.state <= core.PluginStatePrepareStop
+}
+
+func (prod *loadBalanceTestProducer) IsBlocked() bool {
+	return prod.state == core.PluginStateWaiting
+}
+
+func TestLoadBalanceRouting(t *testing.T) {
+	expect := ttesting.NewExpect(t)
+
+	producers := make(map[string]*loadBalanceTestProducer)
+	for _, name := range []string{"balanceA", "balanceB", "balanceC"} {
+		conf := core.NewPluginConfig("", "router.Broadcast")
+		conf.Override("Stream", name)
+		plugin, err := core.NewPluginWithConfig(conf)
+		expect.NoError(err)
+
+		target := plugin.(*Broadcast)
+		producers[name] = &loadBalanceTestProducer{state: core.PluginStateActive}
+		target.AddProducer(producers[name])
+		core.StreamRegistry.Unregister(target.GetStreamID())
+		core.StreamRegistry.Register(target, target.GetStreamID())
+	}
+
+	conf := core.NewPluginConfig("", "router.LoadBalance")
+	conf.Override("Stream", "balanceIn")
+	conf.Override("TargetStreams", []string{"balanceA", "balanceB", "balanceC"})
+	conf.Override("Weights", tcontainer.MarshalMap{"balanceA": 3, "balanceB": 2})
+
+	plugin, err := core.NewPluginWithConfig(conf)
+	expect.NoError(err)
+	router, isLoadBalance := plugin.(*LoadBalance)
+	expect.True(isLoadBalance)
+
+	// Weights are applied smoothly
+	sequence := ""
+	for i := 0; i < 6; i++ {
+		sequence += router.nextTarget().GetName()[len("balance"):]
+	}
+	expect.Equal("ABACBA", sequence)
+
+	// Targets rejoining start over instead of keeping their previous value
+	expect.Equal("balanceA", router.nextTarget().GetName())
+	producers["balanceA"].state = core.PluginStateWaiting
+	expect.Equal("balanceB", router.nextTarget().GetName())
+	producers["balanceA"].state = core.PluginStateActive
+	expect.Equal("balanceA", router.nextTarget().GetName())
+
+	// Blocked and inactive targets are skipped until they recover
+	producers["balanceA"].state = core.PluginStateWaiting
+	producers["balanceB"].state = core.PluginStateDead
+	for i := 0; i < 3; i++ {
+		expect.Equal("balanceC", router.nextTarget().GetName())
+	}
+
+	producers["balanceA"].state = core.PluginStateActive
+	counts := make(map[string]int)
+	for i := 0; i < 8; i++ {
+		counts[router.nextTarget().GetName()]++
+	}
+	expect.Equal(6, counts["balanceA"])
+	expect.Equal(2, counts["balanceC"])
+
+	// Without healthy targets all targets are used
+	producers["balanceA"].state = core.PluginStateDead
+	producers["balanceC"].state = core.PluginStateDead
+	counts = make(map[string]int)
+	for i := 0; i < 6; i++ {
+		counts[router.nextTarget().GetName()]++
+	}
+	expect.Equal(3, len(counts))
+
+	msg := core.NewMessage(nil, []byte("test"), nil, router.GetStreamID())
+	expect.NoError(router.Enqueue(msg))
+	expect.Equal(1, producers[msg.GetStreamID().GetName()].messages)
+
+	conf.Override("Weights", tcontainer.MarshalMap{"balanceA": 0})
+	_, err = core.NewPluginWithConfig(conf)
+	expect.NotNil(err)
+	conf.Override("Weights", tcontainer.MarshalMap{"unknown": 1})
+	_, err = core.NewPluginWithConfig(conf)
+	expect.NotNil(err)
+}
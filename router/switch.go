@@ -0,0 +1,155 @@
+// Copyright 2015-2018 trivago N.V.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package router
+
+import (
+	"fmt"
+
+	"github.com/trivago/gollum/core"
+	"github.com/trivago/tgo/tcontainer"
+)
+
+// Switch router
+//
+// This router evaluates an ordered list of conditions and routes each message
+// to the target stream of the first condition that matches. Conditions are
+// boolean expressions over the payload, metadata fields, the stream names and
+// the creation time of a message. If no condition matches, the message is
+// routed to the default stream. If no default stream is set, the message is
+// passed along to the producers listening to this router's stream.
+//
+// Expressions support the following elements:
+//
+//  Values:      payload, stream, prevstream, origstream, time, age, meta.<key>
+//  Literals:    "string", 'string', 42, 1.5, true, false, [list, of, values]
+//  Comparison:  ==, !=, <, <=, >, >=
+//  Regex:       =~, !~ with a string literal on the right hand side
+//  Membership:  in, not in with a list or an inclusive numeric range (1..5)
+//  Logic:       &&, ||, ! or and, or, not and parentheses
+//  Functions:   exists(value), len(value), lower(value), upper(value)
+//
+// Nested metadata fields are addressed by using "/" as a separator, e.g.
+// "meta.http/status". "time" can be compared to RFC3339 strings or unix
+// timestamps, "age" is the number of seconds since the message was created.
+//
+// Parameters
+//
+// - Cases: An ordered list of conditions. Each entry requires the keys "If",
+// holding the expression to evaluate, and "Then", holding the name of the
+// stream to route to.
+// By default this parameter is set to an empty list.
+//
+// - Default: The stream to route messages to if no condition matches.
+// By default this parameter is set to "".
+//
+// Examples
+//
+// This example routes server errors and slow requests to dedicated streams
+// and everything else to the "access" stream.
+//
+//  accessSwitch:
+//    Type: router.Switch
+//    Stream: requests
+//    Default: access
+//    Cases:
+//      - If: 'meta.status in 500..599 || payload =~ "(?i)exception"'
+//        Then: errors
+//      - If: 'meta.duration > 2.5 && meta.method in ["GET", "HEAD"]'
+//        Then: slow
+//
+type Switch struct {
+	Broadcast       `gollumdoc:"embed_type"`
+	cases           []switchCase
+	defaultStreamID core.MessageStreamID `config:"Default"`
+}
+
+type switchCase struct {
+	condition *core.Expression
+	streamID  core.MessageStreamID
+}
+
+func init() {
+	core.TypeRegistry.Register(Switch{})
+}
+
+// Configure initializes this router with values from a plugin config.
+func (router *Switch) Configure(conf core.PluginConfigReader) {
+	for idx, value := range conf.GetArray("Cases", []interface{}{}) {
+		caseConfig, err := tcontainer.ConvertToMarshalMap(value, nil)
+		if err != nil {
+			conf.Errors.Pushf("Cases[%d] must be a map with the keys \"If\" and \"Then\"", idx)
+			continue
+		}
+
+		source, err := caseConfig.String("If")
+		if err != nil {
+			conf.Errors.Pushf("Cases[%d] requires a string \"If\"", idx)
+			continue
+		}
+		target, err := caseConfig.String("Then")
+		if err != nil || target == "" {
+			conf.Errors.Pushf("Cases[%d] requires a string \"Then\"", idx)
+			continue
+		}
+
+		condition, err := core.ParseExpression(source)
+		if err != nil {
+			conf.Errors.Push(fmt.Errorf("Cases[%d]: %s", idx, err.Error()))
+			continue
+		}
+
+		router.cases = append(router.cases, switchCase{
+			condition: condition,
+			streamID:  core.GetStreamID(target),
+		})
+	}
+}
+
+// GetTargetStreamIDs returns all streams this router may route to.
+func (router *Switch) GetTargetStreamIDs() []core.MessageStreamID {
+	streamIDs := make([]core.MessageStreamID, 0, len(router.cases)+1)
+	for _, switchCase := range router.cases {
+		streamIDs = append(streamIDs, switchCase.streamID)
+	}
+	if router.defaultStreamID != core.InvalidStreamID {
+		streamIDs = append(streamIDs, router.defaultStreamID)
+	}
+	return streamIDs
+}
+
+// Start the router
+func (router *Switch) Start() error {
+	return nil
+}
+
+// Enqueue enques a message to the router
+func (router *Switch) Enqueue(msg *core.Message) error {
+	targetID := router.defaultStreamID
+	for _, switchCase := range router.cases {
+		if switchCase.condition.Evaluate(msg) {
+			targetID = switchCase.streamID
+			break
+		}
+	}
+
+	if targetID == core.InvalidStreamID || targetID == router.GetStreamID() {
+		return router.Broadcast.Enqueue(msg)
+	}
+
+	targetRouter := core.StreamRegistry.GetRouterOrFallback(targetID)
+
+	msg.SetStreamID(targetID)
+	return core.Route(msg, targetRouter)
+}
@@ -0,0 +1,121 @@
+// Copyright 2015-2018 trivago N.V.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package tlstest creates certificates for tests of TLS connections.
+package tlstest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"path/filepath"
+	"time"
+)
+
+// Certificates holds the paths of the PEM files written by
+// WriteCertificates.
+type Certificates struct {
+	CA                string
+	ServerCertificate string
+	ServerKey         string
+	ClientCertificate string
+	ClientKey         string
+}
+
+// WriteCertificates creates a CA in memory and uses it to sign a server
+// certificate for "localhost" and 127.0.0.1 and a client certificate. All
+// certificates and keys are written to PEM files in dir. Every call creates a
+// new CA.
+func WriteCertificates(dir string) (Certificates, error) {
+	files := Certificates{
+		CA:                filepath.Join(dir, "ca.crt"),
+		ServerCertificate: filepath.Join(dir, "server.crt"),
+		ServerKey:         filepath.Join(dir, "server.key"),
+		ClientCertificate: filepath.Join(dir, "client.crt"),
+		ClientKey:         filepath.Join(dir, "client.key"),
+	}
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return files, err
+	}
+	caTemplate := newTemplate(1, "gollum test CA")
+	caTemplate.IsCA = true
+	caTemplate.BasicConstraintsValid = true
+	caTemplate.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		return files, err
+	}
+	caCert, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		return files, err
+	}
+	if err := writePEM(files.CA, "CERTIFICATE", caDER); err != nil {
+		return files, err
+	}
+
+	serverTemplate := newTemplate(2, "localhost")
+	serverTemplate.DNSNames = []string{"localhost"}
+	serverTemplate.IPAddresses = []net.IP{net.ParseIP("127.0.0.1")}
+	serverTemplate.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	if err := writeKeyPair(files.ServerCertificate, files.ServerKey, serverTemplate, caCert, caKey); err != nil {
+		return files, err
+	}
+
+	clientTemplate := newTemplate(3, "gollum test client")
+	clientTemplate.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	err = writeKeyPair(files.ClientCertificate, files.ClientKey, clientTemplate, caCert, caKey)
+	return files, err
+}
+
+func newTemplate(serial int64, commonName string) *x509.Certificate {
+	return &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+}
+
+func writeKeyPair(certFile, keyFile string, template, caCert *x509.Certificate, caKey *ecdsa.PrivateKey) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, caKey)
+	if err != nil {
+		return err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	if err := writePEM(certFile, "CERTIFICATE", certDER); err != nil {
+		return err
+	}
+	return writePEM(keyFile, "EC PRIVATE KEY", keyDER)
+}
+
+func writePEM(path, blockType string, data []byte) error {
+	return ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: data}), 0600)
+}